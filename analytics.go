@@ -41,7 +41,10 @@ type AnalyticsRecord struct {
 	Tags          []string
 	Alias         string
 	TrackPath     bool
-	ExpireAt      time.Time `bson:"expireAt" json:"expireAt"`
+	AuthMethod    string
+	Cost          int64
+	State         map[string]string // Request state set by hooks (if detailed recording turned on)
+	ExpireAt      time.Time         `bson:"expireAt" json:"expireAt"`
 }
 
 type GeoData struct {
@@ -17,10 +17,10 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
 	"github.com/satori/go.uuid"
-	"golang.org/x/crypto/bcrypt"
 
 	"github.com/TykTechnologies/tyk/apidef"
 	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/ctx"
 	"github.com/TykTechnologies/tyk/storage"
 	"github.com/TykTechnologies/tyk/user"
 )
@@ -134,7 +134,7 @@ func doAddOrUpdate(keyName string, newSession *user.SessionState, dontReset bool
 				// Reset quote by default
 				if !dontReset {
 					apiSpec.SessionManager.ResetQuota(keyName, newSession)
-					newSession.QuotaRenews = time.Now().Unix() + newSession.QuotaRenewalRate
+					newSession.QuotaRenews = newSession.NextQuotaRenewal(time.Now()).Unix()
 				}
 
 				err := apiSpec.SessionManager.UpdateSession(keyName, newSession, newSession.Lifetime(apiSpec.SessionLifetime))
@@ -155,7 +155,7 @@ func doAddOrUpdate(keyName string, newSession *user.SessionState, dontReset bool
 		for _, spec := range apisByID {
 			if !dontReset {
 				spec.SessionManager.ResetQuota(keyName, newSession)
-				newSession.QuotaRenews = time.Now().Unix() + newSession.QuotaRenewalRate
+				newSession.QuotaRenews = newSession.NextQuotaRenewal(time.Now()).Unix()
 			}
 			checkAndApplyTrialPeriod(keyName, spec.APIID, newSession)
 			err := spec.SessionManager.UpdateSession(keyName, newSession, newSession.Lifetime(spec.SessionLifetime))
@@ -191,16 +191,17 @@ func obfuscateKey(keyName string) string {
 // remove from all stores, update to all stores, stores handle quotas separately though because they are localised! Keys will
 // need to be managed by API, but only for GetDetail, GetList, UpdateKey and DeleteKey
 
-func setSessionPassword(session *user.SessionState) {
-	session.BasicAuthData.Hash = user.HashBCrypt
-	newPass, err := bcrypt.GenerateFromPassword([]byte(session.BasicAuthData.Password), 10)
+func setSessionPassword(session *user.SessionState) error {
+	hashType := user.DefaultHashType()
+	newPass, err := user.HashPassword(hashType, session.BasicAuthData.Password)
 	if err != nil {
-		log.Error("Could not hash password, setting to plaintext, error was: ", err)
-		session.BasicAuthData.Hash = user.HashPlainText
-		return
+		log.Error("Could not hash password: ", err)
+		return err
 	}
 
-	session.BasicAuthData.Password = string(newPass)
+	session.BasicAuthData.Hash = hashType
+	session.BasicAuthData.Password = newPass
+	return nil
 }
 
 func getKeyDetail(key, apiID string) (user.SessionState, bool) {
@@ -227,7 +228,9 @@ func handleAddOrUpdate(keyName string, r *http.Request) (interface{}, int) {
 		case "POST":
 			keyName = newSession.OrgID + keyName
 			// It's a create, so lets hash the password
-			setSessionPassword(&newSession)
+			if err := setSessionPassword(&newSession); err != nil {
+				return apiError("Could not hash password"), 500
+			}
 		case "PUT":
 			// Ge the session
 			var originalKey user.SessionState
@@ -247,7 +250,9 @@ func handleAddOrUpdate(keyName string, r *http.Request) (interface{}, int) {
 				log.Debug("Passwords dont match, original: ", originalKey.BasicAuthData.Password)
 				log.Debug("New: newSession.BasicAuthData.Password")
 				log.Debug("Changing password")
-				setSessionPassword(&newSession)
+				if err := setSessionPassword(&newSession); err != nil {
+					return apiError("Could not hash password"), 500
+				}
 			}
 		}
 	}
@@ -730,7 +735,7 @@ func handleOrgAddOrUpdate(keyName string, r *http.Request) (interface{}, int) {
 
 	if r.URL.Query().Get("reset_quota") == "1" {
 		sessionManager.ResetQuota(keyName, newSession)
-		newSession.QuotaRenews = time.Now().Unix() + newSession.QuotaRenewalRate
+		newSession.QuotaRenews = newSession.NextQuotaRenewal(time.Now()).Unix()
 		rawKey := QuotaKeyPrefix + storage.HashKey(keyName)
 
 		// manage quotas separately
@@ -897,7 +902,7 @@ func createKeyHandler(w http.ResponseWriter, r *http.Request) {
 				if !apiSpec.DontSetQuotasOnCreate {
 					// Reset quota by default
 					apiSpec.SessionManager.ResetQuota(newKey, newSession)
-					newSession.QuotaRenews = time.Now().Unix() + newSession.QuotaRenewalRate
+					newSession.QuotaRenews = newSession.NextQuotaRenewal(time.Now()).Unix()
 				}
 				err := apiSpec.SessionManager.UpdateSession(newKey, newSession, newSession.Lifetime(apiSpec.SessionLifetime))
 				if err != nil {
@@ -907,7 +912,7 @@ func createKeyHandler(w http.ResponseWriter, r *http.Request) {
 			} else {
 				// Use fallback
 				sessionManager := FallbackKeySesionManager
-				newSession.QuotaRenews = time.Now().Unix() + newSession.QuotaRenewalRate
+				newSession.QuotaRenews = newSession.NextQuotaRenewal(time.Now()).Unix()
 				sessionManager.ResetQuota(newKey, newSession)
 				err := sessionManager.UpdateSession(newKey, newSession, -1)
 				if err != nil {
@@ -937,7 +942,7 @@ func createKeyHandler(w http.ResponseWriter, r *http.Request) {
 				if !spec.DontSetQuotasOnCreate {
 					// Reset quote by default
 					spec.SessionManager.ResetQuota(newKey, newSession)
-					newSession.QuotaRenews = time.Now().Unix() + newSession.QuotaRenewalRate
+					newSession.QuotaRenews = newSession.NextQuotaRenewal(time.Now()).Unix()
 				}
 				err := spec.SessionManager.UpdateSession(newKey, newSession, newSession.Lifetime(spec.SessionLifetime))
 				if err != nil {
@@ -1331,6 +1336,8 @@ func userRatesCheck(w http.ResponseWriter, r *http.Request) {
 	returnSession.Quota.QuotaRenews = session.QuotaRenews
 	returnSession.Quota.QuotaRemaining = session.QuotaRemaining
 	returnSession.Quota.QuotaMax = session.QuotaMax
+	returnSession.Quota.QuotaSchedule = string(session.QuotaSchedule)
+	returnSession.Quota.QuotaTimezone = session.QuotaTimezone
 	returnSession.RateLimit.Rate = session.Rate
 	returnSession.RateLimit.Per = session.Per
 
@@ -1383,9 +1390,24 @@ func setCtxValue(r *http.Request, key, val interface{}) {
 	setContext(r, context.WithValue(r.Context(), key, val))
 }
 
+// ctxGetData returns a copy of the context variables, with the request's
+// state under state_ keys. The state is kept apart, as hooks that don't
+// know about context variables change it, so any state_ keys that were
+// stored are left out for the current ones.
 func ctxGetData(r *http.Request) map[string]interface{} {
 	if v := r.Context().Value(ContextData); v != nil {
-		return v.(map[string]interface{})
+		stored := v.(map[string]interface{})
+		state := ctxGetState(r)
+		m := make(map[string]interface{}, len(stored)+len(state))
+		for k, val := range stored {
+			if !strings.HasPrefix(k, "state_") {
+				m[k] = val
+			}
+		}
+		for k, val := range state {
+			m["state_"+k] = val
+		}
+		return m
 	}
 	return nil
 }
@@ -1398,7 +1420,7 @@ func ctxSetData(r *http.Request, m map[string]interface{}) {
 }
 
 func ctxGetSession(r *http.Request) *user.SessionState {
-	if v := r.Context().Value(SessionData); v != nil {
+	if v := r.Context().Value(ctx.SessionData); v != nil {
 		return v.(*user.SessionState)
 	}
 	return nil
@@ -1408,11 +1430,11 @@ func ctxSetSession(r *http.Request, s *user.SessionState) {
 	if s == nil {
 		panic("setting a nil context SessionData")
 	}
-	setCtxValue(r, SessionData, s)
+	setCtxValue(r, ctx.SessionData, s)
 }
 
 func ctxGetAuthToken(r *http.Request) string {
-	if v := r.Context().Value(AuthHeaderValue); v != nil {
+	if v := r.Context().Value(ctx.AuthToken); v != nil {
 		return v.(string)
 	}
 	return ""
@@ -1422,7 +1444,79 @@ func ctxSetAuthToken(r *http.Request, t string) {
 	if t == "" {
 		panic("setting a nil context AuthHeaderValue")
 	}
-	setCtxValue(r, AuthHeaderValue, t)
+	setCtxValue(r, ctx.AuthToken, t)
+}
+
+func ctxGetState(r *http.Request) map[string]string {
+	return ctx.GetState(r)
+}
+
+func ctxSetState(r *http.Request, state map[string]string) {
+	ctx.SetState(r, state)
+}
+
+func ctxGetAuthMethod(r *http.Request) string {
+	if v := r.Context().Value(AuthMethodUsed); v != nil {
+		return v.(string)
+	}
+	return ""
+}
+
+func ctxSetAuthMethod(r *http.Request, m string) {
+	setCtxValue(r, AuthMethodUsed, m)
+}
+
+func ctxGetRateLimitState(r *http.Request) *rateLimitState {
+	if v := r.Context().Value(RateLimitStateData); v != nil {
+		return v.(*rateLimitState)
+	}
+	return nil
+}
+
+func ctxSetRateLimitState(r *http.Request, s *rateLimitState) {
+	setCtxValue(r, RateLimitStateData, s)
+}
+
+func ctxGetRequestCharge(r *http.Request) *requestCharge {
+	if v := r.Context().Value(RequestCostData); v != nil {
+		return v.(*requestCharge)
+	}
+	return nil
+}
+
+func ctxSetRequestCharge(r *http.Request, c *requestCharge) {
+	setCtxValue(r, RequestCostData, c)
+}
+
+func ctxGetConcurrencyLeases(r *http.Request) []*concurrencyLease {
+	if v := r.Context().Value(ConcurrencyLeases); v != nil {
+		return v.([]*concurrencyLease)
+	}
+	return nil
+}
+
+func ctxSetConcurrencyLeases(r *http.Request, leases []*concurrencyLease) {
+	setCtxValue(r, ConcurrencyLeases, leases)
+}
+
+func ctxGetSOAPMediation(r *http.Request) *apidef.SOAPMeta {
+	if v := r.Context().Value(SOAPMediationData); v != nil {
+		return v.(*apidef.SOAPMeta)
+	}
+	return nil
+}
+
+func ctxSetSOAPMediation(r *http.Request, meta *apidef.SOAPMeta) {
+	setCtxValue(r, SOAPMediationData, meta)
+}
+
+// ctxGetRequestCost returns what the request was charged against the
+// key's limits, or zero if it wasn't.
+func ctxGetRequestCost(r *http.Request) int64 {
+	if c := ctxGetRequestCharge(r); c != nil {
+		return c.cost
+	}
+	return 0
 }
 
 func ctxGetTrackedPath(r *http.Request) string {
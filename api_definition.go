@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -16,6 +17,7 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/itchyny/gojq"
 	"github.com/rubyist/circuitbreaker"
 
 	"github.com/TykTechnologies/tyk/apidef"
@@ -51,6 +53,8 @@ const (
 	MethodTransformed
 	RequestTracked
 	RequestNotTracked
+	RequestCost
+	SOAPMediated
 )
 
 // RequestStatus is a custom type to avoid collisions
@@ -79,6 +83,8 @@ const (
 	StatusRequestSizeControlled    RequestStatus = "Request Size Limited"
 	StatusRequesTracked            RequestStatus = "Request Tracked"
 	StatusRequestNotTracked        RequestStatus = "Request Not Tracked"
+	StatusRequestCost              RequestStatus = "Request Cost Set"
+	StatusSOAPMediated             RequestStatus = "SOAP Mediated"
 )
 
 // URLSpec represents a flattened specification for URLs, used to check if a proxy URL
@@ -100,11 +106,14 @@ type URLSpec struct {
 	MethodTransform         apidef.MethodTransformMeta
 	TrackEndpoint           apidef.TrackEndpointMeta
 	DoNotTrackEndpoint      apidef.TrackEndpointMeta
+	RequestCost             apidef.RequestCostMeta
+	SOAP                    apidef.SOAPMeta
 }
 
 type TransformSpec struct {
 	apidef.TemplateMeta
 	Template *template.Template
+	JQ       *gojq.Code
 }
 
 type ExtendedCircuitBreakerMeta struct {
@@ -123,6 +132,8 @@ type APISpec struct {
 	AuthManager              AuthorisationHandler
 	SessionManager           SessionHandler
 	OAuthManager             *OAuthManager
+	OIDCRelyingParty         *oidcRelyingParty
+	CertIdentityRules        []certIdentityRule
 	OrgSessionManager        SessionHandler
 	EventPaths               map[apidef.TykEvent][]config.TykEventHandler
 	Health                   HealthChecker
@@ -136,6 +147,8 @@ type APISpec struct {
 	HasRun                   bool
 	ServiceRefreshInProgress bool
 	HTTPTransport            http.RoundTripper
+	wasmPlugins              map[string]*wasmPlugin
+	bundleDir                string
 }
 
 // APIDefinitionLoader will load an Api definition from a storage
@@ -162,6 +175,8 @@ func (a APIDefinitionLoader) MakeSpec(def *apidef.APIDefinition) *APISpec {
 	spec.SessionManager = &DefaultSessionManager{}
 	spec.OrgSessionManager = &DefaultSessionManager{}
 
+	spec.CertIdentityRules = compileCertIdentityRules(spec)
+
 	// Create and init the virtual Machine
 	if config.Global.EnableJSVM {
 		spec.JSVM.Init(spec)
@@ -442,12 +457,7 @@ func (a APIDefinitionLoader) compileCachedPathSpec(paths []string) []URLSpec {
 	return urlSpec
 }
 
-var apiTemplate = template.New("").Funcs(map[string]interface{}{
-	"jsonMarshal": func(v interface{}) (string, error) {
-		bs, err := json.Marshal(v)
-		return string(bs), err
-	},
-})
+var apiTemplate = template.New("").Funcs(templateFuncs)
 
 func (a APIDefinitionLoader) loadFileTemplate(path string) (*template.Template, error) {
 	log.Debug("-- Loading template: ", path)
@@ -463,6 +473,27 @@ func (a APIDefinitionLoader) loadBlobTemplate(blob string) (*template.Template,
 	return apiTemplate.New("").Parse(string(uDec))
 }
 
+// loadJQ compiles a jq transform, so that an API with a broken
+// expression fails to load it rather than every request it's used on.
+func (a APIDefinitionLoader) loadJQ(mode apidef.TemplateMode, source string) (*gojq.Code, error) {
+	var src []byte
+	var err error
+	switch mode {
+	case apidef.UseFile:
+		log.Debug("-- Loading jq transform: ", source)
+		src, err = ioutil.ReadFile(source)
+	case apidef.UseBlob:
+		log.Debug("-- Loading jq blob")
+		src, err = base64.StdEncoding.DecodeString(source)
+	default:
+		return nil, errors.New("No valid template mode defined, must be either 'file' or 'blob'")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return compileJQ(string(src))
+}
+
 func (a APIDefinitionLoader) compileTransformPathSpec(paths []apidef.TemplateMeta, stat URLStatus) []URLSpec {
 	// transform an extended configuration URL into an array of URLSpecs
 	// This way we can iterate the whole array once, on match we break with status
@@ -480,16 +511,23 @@ func (a APIDefinitionLoader) compileTransformPathSpec(paths []apidef.TemplateMet
 		// Load the templates
 		var err error
 
-		switch stringSpec.TemplateData.Mode {
-		case apidef.UseFile:
-			log.Debug("-- Using File mode")
-			newTransformSpec.Template, err = a.loadFileTemplate(stringSpec.TemplateData.TemplateSource)
-		case apidef.UseBlob:
-			log.Debug("-- Blob mode")
-			newTransformSpec.Template, err = a.loadBlobTemplate(stringSpec.TemplateData.TemplateSource)
+		switch stringSpec.TemplateData.Engine {
+		case apidef.GoTemplateEngine:
+			switch stringSpec.TemplateData.Mode {
+			case apidef.UseFile:
+				log.Debug("-- Using File mode")
+				newTransformSpec.Template, err = a.loadFileTemplate(stringSpec.TemplateData.TemplateSource)
+			case apidef.UseBlob:
+				log.Debug("-- Blob mode")
+				newTransformSpec.Template, err = a.loadBlobTemplate(stringSpec.TemplateData.TemplateSource)
+			default:
+				log.Warning("[Transform Templates] No template mode defined! Found: ", stringSpec.TemplateData.Mode)
+				err = errors.New("No valid template mode defined, must be either 'file' or 'blob'")
+			}
+		case apidef.JQTemplateEngine:
+			newTransformSpec.JQ, err = a.loadJQ(stringSpec.TemplateData.Mode, stringSpec.TemplateData.TemplateSource)
 		default:
-			log.Warning("[Transform Templates] No template mode defined! Found: ", stringSpec.TemplateData.Mode)
-			err = errors.New("No valid template mode defined, must be either 'file' or 'blob'")
+			err = fmt.Errorf("Unknown template engine: %q", stringSpec.TemplateData.Engine)
 		}
 
 		if stat == Transformed {
@@ -581,6 +619,38 @@ func (a APIDefinitionLoader) compileRequestSizePathSpec(paths []apidef.RequestSi
 	return urlSpec
 }
 
+func (a APIDefinitionLoader) compileRequestCostPathSpec(paths []apidef.RequestCostMeta, stat URLStatus) []URLSpec {
+	urlSpec := []URLSpec{}
+
+	for _, stringSpec := range paths {
+		newSpec := URLSpec{}
+		a.generateRegex(stringSpec.Path, &newSpec, stat)
+		newSpec.RequestCost = stringSpec
+
+		urlSpec = append(urlSpec, newSpec)
+	}
+
+	return urlSpec
+}
+
+func (a APIDefinitionLoader) compileSOAPPathSpec(paths []apidef.SOAPMeta, stat URLStatus) []URLSpec {
+	urlSpec := []URLSpec{}
+
+	for _, stringSpec := range paths {
+		newSpec := URLSpec{}
+		if !xmlNameRegex.MatchString(stringSpec.Operation) {
+			log.Error("Invalid SOAP operation name, skipping: ", stringSpec.Operation)
+			continue
+		}
+		a.generateRegex(stringSpec.Path, &newSpec, stat)
+		newSpec.SOAP = stringSpec
+
+		urlSpec = append(urlSpec, newSpec)
+	}
+
+	return urlSpec
+}
+
 func (a APIDefinitionLoader) compileCircuitBreakerPathSpec(paths []apidef.CircuitBreakerMeta, stat URLStatus, apiSpec *APISpec) []URLSpec {
 	// transform an extended configuration URL into an array of URLSpecs
 	// This way we can iterate the whole array once, on match we break with status
@@ -732,6 +802,8 @@ func (a APIDefinitionLoader) getExtendedPathSpecs(apiVersionDef apidef.VersionIn
 	methodTransforms := a.compileMethodTransformSpec(apiVersionDef.ExtendedPaths.MethodTransforms, MethodTransformed)
 	trackedPaths := a.compileTrackedEndpointPathspathSpec(apiVersionDef.ExtendedPaths.TrackEndpoints, RequestTracked)
 	unTrackedPaths := a.compileUnTrackedEndpointPathspathSpec(apiVersionDef.ExtendedPaths.DoNotTrackEndpoints, RequestNotTracked)
+	requestCosts := a.compileRequestCostPathSpec(apiVersionDef.ExtendedPaths.RequestCost, RequestCost)
+	soapPaths := a.compileSOAPPathSpec(apiVersionDef.ExtendedPaths.SOAP, SOAPMediated)
 
 	combinedPath := []URLSpec{}
 	combinedPath = append(combinedPath, ignoredPaths...)
@@ -750,6 +822,8 @@ func (a APIDefinitionLoader) getExtendedPathSpecs(apiVersionDef apidef.VersionIn
 	combinedPath = append(combinedPath, methodTransforms...)
 	combinedPath = append(combinedPath, trackedPaths...)
 	combinedPath = append(combinedPath, unTrackedPaths...)
+	combinedPath = append(combinedPath, requestCosts...)
+	combinedPath = append(combinedPath, soapPaths...)
 
 	return combinedPath, len(whiteListPaths) > 0
 }
@@ -795,6 +869,10 @@ func (a *APISpec) getURLStatus(stat URLStatus) RequestStatus {
 		return StatusRequesTracked
 	case RequestNotTracked:
 		return StatusRequestNotTracked
+	case RequestCost:
+		return StatusRequestCost
+	case SOAPMediated:
+		return StatusSOAPMediated
 	default:
 		log.Error("URL Status was not one of Ignored, Blacklist or WhiteList! Blocking.")
 		return EndPointNotAllowed
@@ -928,6 +1006,14 @@ func (a *APISpec) CheckSpecMatchesStatus(r *http.Request, rxPaths []URLSpec, mod
 			if r.Method == v.DoNotTrackEndpoint.Method {
 				return true, &v.DoNotTrackEndpoint
 			}
+		case RequestCost:
+			if r.Method == v.RequestCost.Method {
+				return true, &v.RequestCost
+			}
+		case SOAPMediated:
+			if r.Method == v.SOAP.Method {
+				return true, &v.SOAP
+			}
 		}
 	}
 	return false, nil
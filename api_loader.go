@@ -195,12 +195,14 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 
 	var mwDriver apidef.MiddlewareDriver
 
-	if EnableCoProcess {
+	if EnableCoProcess || config.Global.WasmOptions.EnableWasm {
 		loadBundle(spec)
 	}
 
+	goPlugins := spec.CustomMiddleware.Driver == apidef.GoPluginDriver
+
 	// TODO: use config.Global.EnableCoProcess
-	if config.Global.EnableJSVM || EnableCoProcess {
+	if config.Global.EnableJSVM || EnableCoProcess || config.Global.WasmOptions.EnableWasm || goPlugins {
 		log.WithFields(logrus.Fields{
 			"prefix":   "main",
 			"api_name": spec.Name,
@@ -212,12 +214,16 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 		if config.Global.EnableJSVM && mwDriver == apidef.OttoDriver {
 			var pathPrefix string
 			if spec.CustomMiddlewareBundle != "" {
-				pathPrefix = spec.APIID + "-" + spec.CustomMiddlewareBundle
+				pathPrefix = spec.loadedBundleDir()
 			}
 			spec.JSVM.LoadJSPaths(mwPaths, pathPrefix)
 		}
 	}
 
+	if config.Global.EnableJSVM {
+		spec.JSVM.Warm()
+	}
+
 	if spec.EnableBatchRequestSupport {
 		addBatchEndpoint(spec, subrouter)
 	}
@@ -235,6 +241,11 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 		}
 	}
 
+	if spec.UseOpenID && spec.OpenIDOptions.RelyingParty.Enabled {
+		log.Debug("Loading OpenID Connect relying party")
+		spec.OIDCRelyingParty = addOpenIDCallbackHandler(spec, subrouter)
+	}
+
 	enableVersionOverrides := false
 	for _, versionData := range spec.VersionData.Versions {
 		if versionData.OverrideTarget != "" {
@@ -293,7 +304,7 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 					"prefix":   "coprocess",
 					"api_name": spec.Name,
 				}).Debug("Registering coprocess middleware, hook name: ", obj.Name, "hook type: Pre", ", driver: ", mwDriver)
-				mwAppendEnabled(&chainArray, &CoProcessMiddleware{baseMid, coprocess.HookType_Pre, obj.Name, mwDriver})
+				mwAppendEnabled(&chainArray, pluginMiddleware(baseMid, coprocess.HookType_Pre, obj, mwDriver))
 			} else {
 				chainArray = append(chainArray, createDynamicMiddleware(obj.Name, true, obj.RequireSession, baseMid))
 			}
@@ -301,9 +312,12 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 
 		mwAppendEnabled(&chainArray, &RateCheckMW{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &IPWhiteListMiddleware{BaseMiddleware: baseMid})
+		mwAppendEnabled(&chainArray, &IPBlackListMiddleware{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &CertificateCheckMW{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &OrganizationMonitor{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &RateLimitForAPI{BaseMiddleware: baseMid})
+		mwAppendEnabled(&chainArray, &IPRateLimitMiddleware{BaseMiddleware: baseMid})
+		mwAppendEnabled(&chainArray, &ConcurrencyLimitMiddleware{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &MiddlewareContextVars{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &VersionCheck{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &RequestSizeLimitMiddleware{baseMid})
@@ -311,6 +325,7 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 
 		mwAppendEnabled(&chainArray, &TransformMiddleware{baseMid})
 		mwAppendEnabled(&chainArray, &TransformHeaders{BaseMiddleware: baseMid})
+		mwAppendEnabled(&chainArray, &SOAPMediationMiddleware{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &RedisCacheMiddleware{BaseMiddleware: baseMid, CacheStore: cacheStore})
 		mwAppendEnabled(&chainArray, &VirtualEndpoint{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &URLRewriteMiddleware{BaseMiddleware: baseMid})
@@ -322,7 +337,7 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 					"prefix":   "coprocess",
 					"api_name": spec.Name,
 				}).Debug("Registering coprocess middleware, hook name: ", obj.Name, "hook type: Post", ", driver: ", mwDriver)
-				mwAppendEnabled(&chainArray, &CoProcessMiddleware{baseMid, coprocess.HookType_Post, obj.Name, mwDriver})
+				mwAppendEnabled(&chainArray, pluginMiddleware(baseMid, coprocess.HookType_Post, obj, mwDriver))
 			} else {
 				chainArray = append(chainArray, createDynamicMiddleware(obj.Name, false, obj.RequireSession, baseMid))
 			}
@@ -344,7 +359,7 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 					"prefix":   "coprocess",
 					"api_name": spec.Name,
 				}).Debug("Registering coprocess middleware, hook name: ", obj.Name, "hook type: Pre", ", driver: ", mwDriver)
-				mwAppendEnabled(&chainArray, &CoProcessMiddleware{baseMid, coprocess.HookType_Pre, obj.Name, mwDriver})
+				mwAppendEnabled(&chainArray, pluginMiddleware(baseMid, coprocess.HookType_Pre, obj, mwDriver))
 			} else {
 				chainArray = append(chainArray, createDynamicMiddleware(obj.Name, true, obj.RequireSession, baseMid))
 			}
@@ -352,6 +367,7 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 
 		mwAppendEnabled(&chainArray, &RateCheckMW{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &IPWhiteListMiddleware{BaseMiddleware: baseMid})
+		mwAppendEnabled(&chainArray, &IPBlackListMiddleware{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &CertificateCheckMW{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &OrganizationMonitor{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &VersionCheck{BaseMiddleware: baseMid})
@@ -359,43 +375,71 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 		mwAppendEnabled(&chainArray, &MiddlewareContextVars{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &TrackEndpointMiddleware{baseMid})
 
+		// In "any of" mode the auth middlewares are tried one by one
+		// rather than chained, and whichever one authenticates the
+		// request provides the identity.
+		var anyAuth *AnyOfAuth
+		if spec.AuthMode == apidef.AuthModeAnyOf {
+			if spec.BaseIdentityProvidedBy != apidef.UnsetAuth {
+				log.WithFields(logrus.Fields{
+					"prefix":   "main",
+					"api_name": spec.Name,
+				}).Warning("base_identity_provided_by is ignored in any_of auth mode")
+				spec.BaseIdentityProvidedBy = apidef.UnsetAuth
+			}
+			anyAuth = &AnyOfAuth{BaseMiddleware: baseMid}
+		}
+		authCount := 0
+		appendAuth := func(mw TykMiddleware) bool {
+			var added bool
+			if anyAuth != nil {
+				added = anyAuth.add(mw)
+			} else {
+				added = mwAppendEnabled(&authArray, mw)
+			}
+			if added {
+				authCount++
+			}
+			return added
+		}
+
 		// Select the keying method to use for setting session states
-		if mwAppendEnabled(&authArray, &Oauth2KeyExists{baseMid}) {
+		if appendAuth(&Oauth2KeyExists{baseMid}) {
 			log.WithFields(logrus.Fields{
 				"prefix":   "main",
 				"api_name": spec.Name,
 			}).Info("Checking security policy: OAuth")
 		}
 
-		if mwAppendEnabled(&authArray, &BasicAuthKeyIsValid{baseMid}) {
+		if appendAuth(&BasicAuthKeyIsValid{baseMid}) {
 			log.WithFields(logrus.Fields{
 				"prefix":   "main",
 				"api_name": spec.Name,
 			}).Info("Checking security policy: Basic")
 		}
 
-		if mwAppendEnabled(&authArray, &HMACMiddleware{BaseMiddleware: baseMid}) {
+		if appendAuth(&HMACMiddleware{BaseMiddleware: baseMid}) {
 			log.WithFields(logrus.Fields{
 				"prefix":   "main",
 				"api_name": spec.Name,
 			}).Info("Checking security policy: HMAC")
 		}
 
-		if mwAppendEnabled(&authArray, &JWTMiddleware{baseMid}) {
+		if appendAuth(&JWTMiddleware{baseMid}) {
 			log.WithFields(logrus.Fields{
 				"prefix":   "main",
 				"api_name": spec.Name,
 			}).Info("Checking security policy: JWT")
 		}
 
-		if mwAppendEnabled(&authArray, &OpenIDMW{BaseMiddleware: baseMid}) {
+		if appendAuth(&OpenIDMW{BaseMiddleware: baseMid}) {
 			log.WithFields(logrus.Fields{
 				"prefix":   "main",
 				"api_name": spec.Name,
 			}).Info("Checking security policy: OpenID")
 		}
 
-		coprocessAuth := EnableCoProcess && mwDriver != apidef.OttoDriver && spec.EnableCoProcessAuth
+		coprocessAuth := (EnableCoProcess || mwDriver == apidef.WasmDriver || mwDriver == apidef.GoPluginDriver) && mwDriver != apidef.OttoDriver && spec.EnableCoProcessAuth
 		ottoAuth := !coprocessAuth && mwDriver == apidef.OttoDriver && spec.EnableCoProcessAuth
 
 		if coprocessAuth {
@@ -406,7 +450,7 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 			}).Debug("Registering coprocess middleware, hook name: ", mwAuthCheckFunc.Name, "hook type: CustomKeyCheck", ", driver: ", mwDriver)
 
 			newExtractor(spec, baseMid)
-			mwAppendEnabled(&authArray, &CoProcessMiddleware{baseMid, coprocess.HookType_CustomKeyCheck, mwAuthCheckFunc.Name, mwDriver})
+			appendAuth(pluginMiddleware(baseMid, coprocess.HookType_CustomKeyCheck, mwAuthCheckFunc, mwDriver))
 		}
 
 		if ottoAuth {
@@ -414,15 +458,23 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 				"prefix": "main",
 			}).Info("----> Checking security policy: JS Plugin")
 
-			authArray = append(authArray, createDynamicMiddleware(mwAuthCheckFunc.Name, true, false, baseMid))
+			appendAuth(&DynamicMiddleware{
+				BaseMiddleware:      baseMid,
+				MiddlewareClassName: mwAuthCheckFunc.Name,
+				Pre:                 true,
+			})
 		}
 
-		if spec.UseStandardAuth || len(authArray) == 0 {
+		if spec.UseStandardAuth || authCount == 0 {
 			log.WithFields(logrus.Fields{
 				"prefix":   "main",
 				"api_name": spec.Name,
 			}).Info("Checking security policy: Token")
-			authArray = append(authArray, createMiddleware(&AuthKey{baseMid}))
+			appendAuth(&AuthKey{baseMid})
+		}
+
+		if anyAuth != nil {
+			authArray = append(authArray, createMiddleware(anyAuth))
 		}
 
 		chainArray = append(chainArray, authArray...)
@@ -432,17 +484,20 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 				"prefix":   "coprocess",
 				"api_name": spec.Name,
 			}).Debug("Registering coprocess middleware, hook name: ", obj.Name, "hook type: Pre", ", driver: ", mwDriver)
-			mwAppendEnabled(&chainArray, &CoProcessMiddleware{baseMid, coprocess.HookType_PostKeyAuth, obj.Name, mwDriver})
+			mwAppendEnabled(&chainArray, pluginMiddleware(baseMid, coprocess.HookType_PostKeyAuth, obj, mwDriver))
 		}
 
 		mwAppendEnabled(&chainArray, &StripAuth{baseMid})
 		mwAppendEnabled(&chainArray, &KeyExpired{baseMid})
 		mwAppendEnabled(&chainArray, &AccessRightsCheck{baseMid})
+		mwAppendEnabled(&chainArray, &ExternalAuthzMiddleware{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &RateLimitForAPI{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &RateLimitAndQuotaCheck{baseMid})
+		mwAppendEnabled(&chainArray, &ConcurrencyLimitMiddleware{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &GranularAccessMiddleware{baseMid})
 		mwAppendEnabled(&chainArray, &TransformMiddleware{baseMid})
 		mwAppendEnabled(&chainArray, &TransformHeaders{BaseMiddleware: baseMid})
+		mwAppendEnabled(&chainArray, &SOAPMediationMiddleware{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &URLRewriteMiddleware{BaseMiddleware: baseMid})
 		mwAppendEnabled(&chainArray, &RedisCacheMiddleware{BaseMiddleware: baseMid, CacheStore: cacheStore})
 		mwAppendEnabled(&chainArray, &TransformMethod{BaseMiddleware: baseMid})
@@ -454,7 +509,7 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 					"prefix":   "coprocess",
 					"api_name": spec.Name,
 				}).Debug("Registering coprocess middleware, hook name: ", obj.Name, "hook type: Post", ", driver: ", mwDriver)
-				mwAppendEnabled(&chainArray, &CoProcessMiddleware{baseMid, coprocess.HookType_Post, obj.Name, mwDriver})
+				mwAppendEnabled(&chainArray, pluginMiddleware(baseMid, coprocess.HookType_Post, obj, mwDriver))
 			} else {
 				chainArray = append(chainArray, createDynamicMiddleware(obj.Name, false, obj.RequireSession, baseMid))
 			}
@@ -472,6 +527,7 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 
 		var simpleArray []alice.Constructor
 		mwAppendEnabled(&simpleArray, &IPWhiteListMiddleware{baseMid})
+		mwAppendEnabled(&simpleArray, &IPBlackListMiddleware{baseMid})
 		mwAppendEnabled(&simpleArray, &OrganizationMonitor{BaseMiddleware: baseMid})
 		mwAppendEnabled(&simpleArray, &VersionCheck{BaseMiddleware: baseMid})
 		simpleArray = append(simpleArray, authArray...)
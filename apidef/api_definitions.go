@@ -17,11 +17,14 @@ type TykEventHandlerName string // A type for handler codes in API definitions
 
 type EndpointMethodAction string
 type TemplateMode string
+type TemplateEngine string
 
 type MiddlewareDriver string
 type IdExtractorSource string
 type IdExtractorType string
 type AuthTypeEnum string
+type AuthModeEnum string
+type RateLimiterType string
 type RoutingTriggerOnType string
 
 const (
@@ -31,13 +34,21 @@ const (
 	UseBlob TemplateMode = "blob"
 	UseFile TemplateMode = "file"
 
+	// For transform engines. Go templates render the body data as
+	// text, while jq expressions are run against it to build a new
+	// JSON body.
+	GoTemplateEngine TemplateEngine = ""
+	JQTemplateEngine TemplateEngine = "jq"
+
 	RequestXML  RequestInputType = "xml"
 	RequestJSON RequestInputType = "json"
 
-	OttoDriver   MiddlewareDriver = "otto"
-	PythonDriver MiddlewareDriver = "python"
-	LuaDriver    MiddlewareDriver = "lua"
-	GrpcDriver   MiddlewareDriver = "grpc"
+	OttoDriver     MiddlewareDriver = "otto"
+	PythonDriver   MiddlewareDriver = "python"
+	LuaDriver      MiddlewareDriver = "lua"
+	GrpcDriver     MiddlewareDriver = "grpc"
+	WasmDriver     MiddlewareDriver = "wasm"
+	GoPluginDriver MiddlewareDriver = "goplugin"
 
 	BodySource        IdExtractorSource = "body"
 	HeaderSource      IdExtractorSource = "header"
@@ -57,6 +68,20 @@ const (
 	OAuthKey      AuthTypeEnum = "oauth_key"
 	UnsetAuth     AuthTypeEnum = ""
 
+	// For auth modes. In any_of mode the first enabled auth method
+	// accepting the request's credentials authenticates it, and the
+	// identity comes from that method.
+	AuthModeAll   AuthModeEnum = ""
+	AuthModeAnyOf AuthModeEnum = "any_of"
+
+	// For rate limiters. The default is whichever limiter is set up
+	// in the gateway config; gcra counts every request in Redis, and
+	// gcra_hybrid lets requests through from a local copy of the
+	// Redis state, which it syncs with every SyncInterval.
+	RateLimiterDefault    RateLimiterType = ""
+	RateLimiterGCRA       RateLimiterType = "gcra"
+	RateLimiterGCRAHybrid RateLimiterType = "gcra_hybrid"
+
 	// For routing triggers
 	All    RoutingTriggerOnType = "all"
 	Any    RoutingTriggerOnType = "any"
@@ -81,6 +106,7 @@ type TemplateMeta struct {
 	TemplateData struct {
 		Input          RequestInputType `bson:"input_type" json:"input_type"`
 		Mode           TemplateMode     `bson:"template_mode" json:"template_mode"`
+		Engine         TemplateEngine   `bson:"engine" json:"engine,omitempty"`
 		EnableSession  bool             `bson:"enable_session" json:"enable_session"`
 		TemplateSource string           `bson:"template_source" json:"template_source"`
 	} `bson:"template_data" json:"template_data"`
@@ -113,6 +139,41 @@ type RequestSizeMeta struct {
 	SizeLimit int64  `bson:"size_limit" json:"size_limit"`
 }
 
+// RequestCostMeta sets how much of a key's quota and rate limit
+// allowance a request to an endpoint uses. Source is "" for a fixed Cost,
+// "body_size" for a cost of one per BodyUnit bytes of request body, or
+// "header" for the cost the upstream sets in the HeaderName response
+// header. Cost is also the least a request costs, and with a header it
+// is what is charged before the upstream responds.
+type RequestCostMeta struct {
+	Path       string `bson:"path" json:"path"`
+	Method     string `bson:"method" json:"method"`
+	Cost       int64  `bson:"cost" json:"cost"`
+	Source     string `bson:"source" json:"source"`
+	HeaderName string `bson:"header_name" json:"header_name"`
+	BodyUnit   int64  `bson:"body_unit" json:"body_unit"`
+}
+
+// SOAPMeta exposes a SOAP operation as a JSON endpoint. The gateway
+// wraps the request's JSON body, or its query string if it has no body,
+// in a SOAP envelope for the Operation element in Namespace, and turns
+// the upstream's XML response back into JSON. Namespaces maps namespace
+// URIs to the prefixes their elements get in the JSON keys, which are
+// otherwise left out, and ArrayElements lists the elements which are
+// always made arrays, even if there's just one of them. FaultCodes maps
+// SOAP fault codes, without their prefix, to the status codes clients get.
+type SOAPMeta struct {
+	Path          string            `bson:"path" json:"path"`
+	Method        string            `bson:"method" json:"method"`
+	Operation     string            `bson:"operation" json:"operation"`
+	Namespace     string            `bson:"namespace" json:"namespace"`
+	SOAPAction    string            `bson:"soap_action" json:"soap_action"`
+	Version       string            `bson:"version" json:"version"`
+	Namespaces    map[string]string `bson:"namespaces" json:"namespaces,omitempty"`
+	ArrayElements []string          `bson:"array_elements" json:"array_elements,omitempty"`
+	FaultCodes    map[string]int    `bson:"fault_codes" json:"fault_codes,omitempty"`
+}
+
 type CircuitBreakerMeta struct {
 	Path                 string  `bson:"path" json:"path"`
 	Method               string  `bson:"method" json:"method"`
@@ -180,6 +241,8 @@ type ExtendedPathsSet struct {
 	MethodTransforms        []MethodTransformMeta `bson:"method_transforms" json:"method_transforms,omitempty"`
 	TrackEndpoints          []TrackEndpointMeta   `bson:"track_endpoints" json:"track_endpoints,omitempty"`
 	DoNotTrackEndpoints     []TrackEndpointMeta   `bson:"do_not_track_endpoints" json:"do_not_track_endpoints,omitempty"`
+	RequestCost             []RequestCostMeta     `bson:"request_cost" json:"request_cost,omitempty"`
+	SOAP                    []SOAPMeta            `bson:"soap" json:"soap,omitempty"`
 }
 
 type VersionInfo struct {
@@ -219,10 +282,16 @@ type EventHandlerMetaConfig struct {
 	Events map[TykEvent][]EventHandlerTriggerConfig `bson:"events" json:"events"`
 }
 
+// MiddlewareDefinition is a custom middleware hook. Timeout is how many
+// milliseconds a gRPC hook may take, overriding the gateway's default.
+// FailOpen lets requests through a coprocess hook that fails, rather
+// than failing them; auth checks always fail closed.
 type MiddlewareDefinition struct {
 	Name           string `bson:"name" json:"name"`
 	Path           string `bson:"path" json:"path"`
 	RequireSession bool   `bson:"require_session" json:"require_session"`
+	Timeout        int64  `bson:"timeout" json:"timeout"`
+	FailOpen       bool   `bson:"fail_open" json:"fail_open"`
 }
 
 type MiddlewareIdExtractor struct {
@@ -279,11 +348,28 @@ type ServiceDiscoveryConfiguration struct {
 type OIDProviderConfig struct {
 	Issuer    string            `bson:"issuer" json:"issuer"`
 	ClientIDs map[string]string `bson:"client_ids" json:"client_ids"`
+	// PolicyID is applied to tokens from this issuer when ClientIDs
+	// is empty, in which case the token audience is not restricted.
+	PolicyID string `bson:"policy_id" json:"policy_id"`
+}
+
+// OIDRelyingPartyConfig enables the authorization code flow for
+// browser requests that arrive without an ID token.
+type OIDRelyingPartyConfig struct {
+	Enabled      bool     `bson:"enabled" json:"enabled"`
+	Issuer       string   `bson:"issuer" json:"issuer"`
+	ClientID     string   `bson:"client_id" json:"client_id"`
+	ClientSecret string   `bson:"client_secret" json:"client_secret"`
+	Scopes       []string `bson:"scopes" json:"scopes"`
+	RedirectURL  string   `bson:"redirect_url" json:"redirect_url"`
+	CookieName   string   `bson:"cookie_name" json:"cookie_name"`
+	CookieSecret string   `bson:"cookie_secret" json:"cookie_secret"`
 }
 
 type OpenIDOptions struct {
-	Providers         []OIDProviderConfig `bson:"providers" json:"providers"`
-	SegregateByClient bool                `bson:"segregate_by_client" json:"segregate_by_client"`
+	Providers         []OIDProviderConfig   `bson:"providers" json:"providers"`
+	SegregateByClient bool                  `bson:"segregate_by_client" json:"segregate_by_client"`
+	RelyingParty      OIDRelyingPartyConfig `bson:"relying_party" json:"relying_party"`
 }
 
 // APIDefinition represents the configuration for a single proxied API and it's versions.
@@ -304,12 +390,15 @@ type APIDefinition struct {
 	} `bson:"oauth_meta" json:"oauth_meta"`
 	Auth                    Auth                 `bson:"auth" json:"auth"`
 	UseBasicAuth            bool                 `bson:"use_basic_auth" json:"use_basic_auth"`
+	LDAPBind                LDAPBindConfig       `bson:"ldap_bind" json:"ldap_bind"`
 	UseMutualTLSAuth        bool                 `bson:"use_mutual_tls_auth" json:"use_mutual_tls_auth"`
 	ClientCertificates      []string             `bson:"client_certificates" json:"client_certificates"`
+	CertificateRevocation   CertRevocationConfig `bson:"certificate_revocation" json:"certificate_revocation"`
 	UpstreamCertificates    map[string]string    `bson:"upstream_certificates" json:"upstream_certificates"`
 	EnableJWT               bool                 `bson:"enable_jwt" json:"enable_jwt"`
 	UseStandardAuth         bool                 `bson:"use_standard_auth" json:"use_standard_auth"`
 	EnableCoProcessAuth     bool                 `bson:"enable_coprocess_auth" json:"enable_coprocess_auth"`
+	ExternalAuthz           ExternalAuthzConfig  `bson:"external_authz" json:"external_authz"`
 	JWTSigningMethod        string               `bson:"jwt_signing_method" json:"jwt_signing_method"`
 	JWTSource               string               `bson:"jwt_source" json:"jwt_source"`
 	JWTIdentityBaseField    string               `bson:"jwt_identit_base_field" json:"jwt_identity_base_field"`
@@ -319,6 +408,7 @@ type APIDefinition struct {
 	EnableSignatureChecking bool                 `bson:"enable_signature_checking" json:"enable_signature_checking"`
 	HmacAllowedClockSkew    float64              `bson:"hmac_allowed_clock_skew" json:"hmac_allowed_clock_skew"`
 	BaseIdentityProvidedBy  AuthTypeEnum         `bson:"base_identity_provided_by" json:"base_identity_provided_by"`
+	AuthMode                AuthModeEnum         `bson:"auth_mode" json:"auth_mode"`
 	VersionDefinition       struct {
 		Location string `bson:"location" json:"location"`
 		Key      string `bson:"key" json:"key"`
@@ -346,23 +436,29 @@ type APIDefinition struct {
 		CheckHostAgainstUptimeTests bool                          `bson:"check_host_against_uptime_tests" json:"check_host_against_uptime_tests"`
 		ServiceDiscovery            ServiceDiscoveryConfiguration `bson:"service_discovery" json:"service_discovery"`
 	} `bson:"proxy" json:"proxy"`
-	DisableRateLimit          bool                   `bson:"disable_rate_limit" json:"disable_rate_limit"`
-	DisableQuota              bool                   `bson:"disable_quota" json:"disable_quota"`
-	CustomMiddleware          MiddlewareSection      `bson:"custom_middleware" json:"custom_middleware"`
-	CustomMiddlewareBundle    string                 `bson:"custom_middleware_bundle" json:"custom_middleware_bundle"`
-	CacheOptions              CacheOptions           `bson:"cache_options" json:"cache_options"`
-	SessionLifetime           int64                  `bson:"session_lifetime" json:"session_lifetime"`
-	Active                    bool                   `bson:"active" json:"active"`
-	AuthProvider              AuthProviderMeta       `bson:"auth_provider" json:"auth_provider"`
-	SessionProvider           SessionProviderMeta    `bson:"session_provider" json:"session_provider"`
-	EventHandlers             EventHandlerMetaConfig `bson:"event_handlers" json:"event_handlers"`
-	EnableBatchRequestSupport bool                   `bson:"enable_batch_request_support" json:"enable_batch_request_support"`
-	EnableIpWhiteListing      bool                   `mapstructure:"enable_ip_whitelisting" bson:"enable_ip_whitelisting" json:"enable_ip_whitelisting"`
-	AllowedIPs                []string               `mapstructure:"allowed_ips" bson:"allowed_ips" json:"allowed_ips"`
-	DontSetQuotasOnCreate     bool                   `mapstructure:"dont_set_quota_on_create" bson:"dont_set_quota_on_create" json:"dont_set_quota_on_create"`
-	ExpireAnalyticsAfter      int64                  `mapstructure:"expire_analytics_after" bson:"expire_analytics_after" json:"expire_analytics_after"` // must have an expireAt TTL index set (http://docs.mongodb.org/manual/tutorial/expire-data/)
-	ResponseProcessors        []ResponseProcessor    `bson:"response_processors" json:"response_processors"`
-	CORS                      struct {
+	DisableRateLimit              bool                   `bson:"disable_rate_limit" json:"disable_rate_limit"`
+	DisableQuota                  bool                   `bson:"disable_quota" json:"disable_quota"`
+	DisableRateLimitHeaders       bool                   `bson:"disable_rate_limit_headers" json:"disable_rate_limit_headers"`
+	RateLimiter                   RateLimiterConfig      `bson:"rate_limiter" json:"rate_limiter"`
+	ConcurrencyLimit              ConcurrencyLimitConfig `bson:"concurrency_limit" json:"concurrency_limit"`
+	CustomMiddleware              MiddlewareSection      `bson:"custom_middleware" json:"custom_middleware"`
+	CustomMiddlewareBundle        string                 `bson:"custom_middleware_bundle" json:"custom_middleware_bundle"`
+	CustomMiddlewareBundleVersion string                 `bson:"custom_middleware_bundle_version" json:"custom_middleware_bundle_version"`
+	CacheOptions                  CacheOptions           `bson:"cache_options" json:"cache_options"`
+	SessionLifetime               int64                  `bson:"session_lifetime" json:"session_lifetime"`
+	Active                        bool                   `bson:"active" json:"active"`
+	AuthProvider                  AuthProviderMeta       `bson:"auth_provider" json:"auth_provider"`
+	SessionProvider               SessionProviderMeta    `bson:"session_provider" json:"session_provider"`
+	EventHandlers                 EventHandlerMetaConfig `bson:"event_handlers" json:"event_handlers"`
+	EnableBatchRequestSupport     bool                   `bson:"enable_batch_request_support" json:"enable_batch_request_support"`
+	EnableIpWhiteListing          bool                   `mapstructure:"enable_ip_whitelisting" bson:"enable_ip_whitelisting" json:"enable_ip_whitelisting"`
+	AllowedIPs                    []string               `mapstructure:"allowed_ips" bson:"allowed_ips" json:"allowed_ips"`
+	EnableIpBlacklisting          bool                   `mapstructure:"enable_ip_blacklisting" bson:"enable_ip_blacklisting" json:"enable_ip_blacklisting"`
+	BlacklistedIPs                []string               `mapstructure:"blacklisted_ips" bson:"blacklisted_ips" json:"blacklisted_ips"`
+	DontSetQuotasOnCreate         bool                   `mapstructure:"dont_set_quota_on_create" bson:"dont_set_quota_on_create" json:"dont_set_quota_on_create"`
+	ExpireAnalyticsAfter          int64                  `mapstructure:"expire_analytics_after" bson:"expire_analytics_after" json:"expire_analytics_after"` // must have an expireAt TTL index set (http://docs.mongodb.org/manual/tutorial/expire-data/)
+	ResponseProcessors            []ResponseProcessor    `bson:"response_processors" json:"response_processors"`
+	CORS                          struct {
 		Enable             bool     `bson:"enable" json:"enable"`
 		AllowedOrigins     []string `bson:"allowed_origins" json:"allowed_origins"`
 		AllowedMethods     []string `bson:"allowed_methods" json:"allowed_methods"`
@@ -380,6 +476,8 @@ type APIDefinition struct {
 	ConfigData        map[string]interface{} `bson:"config_data" json:"config_data"`
 	TagHeaders        []string               `bson:"tag_headers" json:"tag_headers"`
 	GlobalRateLimit   GlobalRateLimit        `bson:"global_rate_limit" json:"global_rate_limit"`
+	IPRateLimit       IPRateLimit            `bson:"ip_rate_limit" json:"ip_rate_limit"`
+	Compression       CompressionConfig      `bson:"compression" json:"compression"`
 	StripAuthData     bool                   `bson:"strip_auth_data" json:"strip_auth_data"`
 }
 
@@ -390,6 +488,97 @@ type Auth struct {
 	CookieName     string `mapstructure:"cookie_name" bson:"cookie_name" json:"cookie_name"`
 	AuthHeaderName string `mapstructure:"auth_header_name" bson:"auth_header_name" json:"auth_header_name"`
 	UseCertificate bool   `mapstructure:"use_certificate" bson:"use_certificate" json:"use_certificate"`
+
+	CertificateIdentityRules []CertIdentityRule `mapstructure:"certificate_identity_rules" bson:"certificate_identity_rules" json:"certificate_identity_rules"`
+}
+
+// CertIdentityRule resolves a key from a client certificate field. The
+// field is one of subject.cn, subject.o, subject.ou,
+// subject.serial_number, serial, san.dns, san.email or san.ip. If a
+// pattern is set the value must match it, and its first capture group,
+// if any, is used instead of the whole value. Rules only apply to
+// certificates issued by one of the API's client certificates.
+type CertIdentityRule struct {
+	Field   string `mapstructure:"field" bson:"field" json:"field"`
+	Pattern string `mapstructure:"pattern" bson:"pattern" json:"pattern"`
+}
+
+// CertRevocationConfig enables revocation checks of client certificates.
+// CRL and OCSP locations default to the ones in the certificate.
+type CertRevocationConfig struct {
+	CheckCRL      bool     `bson:"check_crl" json:"check_crl"`
+	CRLURLs       []string `bson:"crl_urls" json:"crl_urls"`
+	CheckOCSP     bool     `bson:"check_ocsp" json:"check_ocsp"`
+	OCSPResponder string   `bson:"ocsp_responder" json:"ocsp_responder"`
+	FailOpen      bool     `bson:"fail_open" json:"fail_open"`
+	CacheTTL      int64    `bson:"cache_ttl" json:"cache_ttl"`
+}
+
+// RateLimiterConfig picks the algorithm key rate limits are enforced
+// with. SyncInterval is in milliseconds.
+type RateLimiterConfig struct {
+	Type         RateLimiterType `bson:"type" json:"type"`
+	SyncInterval int64           `bson:"sync_interval" json:"sync_interval"`
+}
+
+// ConcurrencyLimitConfig caps the requests to an API in flight at once,
+// across the cluster, in all and for each key. A key's own MaxInFlight
+// takes precedence over MaxInFlightPerKey. Requests over a limit wait
+// for up to QueueTimeout milliseconds, with at most MaxQueued waiting on
+// each node, and those from keys with one of PriorityTags are let in
+// first. LeaseTTL is how many seconds a slot outlives a node that dies
+// holding it.
+type ConcurrencyLimitConfig struct {
+	MaxInFlight       int64    `bson:"max_in_flight" json:"max_in_flight"`
+	MaxInFlightPerKey int64    `bson:"max_in_flight_per_key" json:"max_in_flight_per_key"`
+	QueueTimeout      int64    `bson:"queue_timeout" json:"queue_timeout"`
+	MaxQueued         int64    `bson:"max_queued" json:"max_queued"`
+	LeaseTTL          int64    `bson:"lease_ttl" json:"lease_ttl"`
+	PriorityTags      []string `bson:"priority_tags" json:"priority_tags"`
+}
+
+// ExternalAuthzConfig hands authorization decisions for authenticated
+// requests to an external service. Protocol is "http" (the default),
+// where URL is the endpoint to POST to, or "grpc", where URL is the
+// address of a coprocess Dispatcher service. Decisions are cached for
+// CacheTTL seconds, for requests that are the same in all that is sent
+// to the service, headers and client IP included.
+type ExternalAuthzConfig struct {
+	Enabled  bool   `bson:"enabled" json:"enabled"`
+	Protocol string `bson:"protocol" json:"protocol"`
+	URL      string `bson:"url" json:"url"`
+	Timeout  int64  `bson:"timeout" json:"timeout"`
+	CacheTTL int64  `bson:"cache_ttl" json:"cache_ttl"`
+	FailOpen bool   `bson:"fail_open" json:"fail_open"`
+}
+
+// LDAPBindConfig makes basic auth verify credentials by binding to an
+// LDAP server as the user, instead of looking up a stored key.
+type LDAPBindConfig struct {
+	Enabled               bool   `bson:"enabled" json:"enabled"`
+	Server                string `bson:"server" json:"server"`
+	Port                  uint16 `bson:"port" json:"port"`
+	UseSSL                bool   `bson:"use_ssl" json:"use_ssl"`
+	StartTLS              bool   `bson:"start_tls" json:"start_tls"`
+	SSLInsecureSkipVerify bool   `bson:"ssl_insecure_skip_verify" json:"ssl_insecure_skip_verify"`
+	// UserDNTemplate builds the bind DN, with TYKUSERNAME replaced
+	// by the escaped user name, e.g. "uid=TYKUSERNAME,ou=people,dc=example,dc=com"
+	UserDNTemplate string `bson:"user_dn_template" json:"user_dn_template"`
+	// SearchBindDN and SearchBindPassword are used to look up group
+	// memberships; if empty, the search runs as the user.
+	SearchBindDN       string `bson:"search_bind_dn" json:"search_bind_dn"`
+	SearchBindPassword string `bson:"search_bind_password" json:"search_bind_password"`
+	BaseDN             string `bson:"base_dn" json:"base_dn"`
+	// UserFilter finds the user's entry, with TYKUSERNAME replaced as
+	// above, e.g. "(uid=TYKUSERNAME)"
+	UserFilter     string `bson:"user_filter" json:"user_filter"`
+	GroupAttribute string `bson:"group_attribute" json:"group_attribute"`
+	// GroupPolicies maps group DNs to the policy IDs granted to
+	// their members.
+	GroupPolicies   map[string]string `bson:"group_policies" json:"group_policies"`
+	DefaultPolicyID string            `bson:"default_policy_id" json:"default_policy_id"`
+	CacheTTL        int64             `bson:"cache_ttl" json:"cache_ttl"`
+	MaxConnections  int               `bson:"max_connections" json:"max_connections"`
 }
 
 type GlobalRateLimit struct {
@@ -397,11 +586,41 @@ type GlobalRateLimit struct {
 	Per  float64 `bson:"per" json:"per"`
 }
 
+// IPRateLimit holds each client IP of a keyless API to a rate limit and
+// quota of its own. IPs that are turned away BanThreshold times within
+// BanWindow seconds are banned for BanDuration seconds. The client IP is
+// the peer's unless the gateway has trusted proxies configured.
+type IPRateLimit struct {
+	Rate             float64 `bson:"rate" json:"rate"`
+	Per              float64 `bson:"per" json:"per"`
+	QuotaMax         int64   `bson:"quota_max" json:"quota_max"`
+	QuotaRenewalRate int64   `bson:"quota_renewal_rate" json:"quota_renewal_rate"`
+	BanThreshold     int64   `bson:"ban_threshold" json:"ban_threshold"`
+	BanWindow        int64   `bson:"ban_window" json:"ban_window"`
+	BanDuration      int64   `bson:"ban_duration" json:"ban_duration"`
+}
+
+// CompressionConfig has the gateway compress responses for clients that
+// accept it, with brotli, gzip or deflate. Only responses of at least
+// MinSize bytes and of one of ContentTypes are compressed, where types
+// ending in "/" match any subtype; the default is text, JSON, XML and
+// JavaScript. Level is the compression level, or the encoding's default
+// if 0.
+type CompressionConfig struct {
+	Enabled      bool     `bson:"enabled" json:"enabled"`
+	ContentTypes []string `bson:"content_types" json:"content_types"`
+	MinSize      int64    `bson:"min_size" json:"min_size"`
+	Level        int      `bson:"level" json:"level"`
+}
+
+// BundleManifest describes a custom middleware bundle. If the API pins a
+// bundle version, the manifest's Version has to match it.
 type BundleManifest struct {
 	FileList         []string          `bson:"file_list" json:"file_list"`
 	CustomMiddleware MiddlewareSection `bson:"custom_middleware" json:"custom_middleware"`
 	Checksum         string            `bson:"checksum" json:"checksum"`
 	Signature        string            `bson:"signature" json:"signature"`
+	Version          string            `bson:"version" json:"version"`
 }
 
 // Clean will URL encode map[string]struct variables for saving
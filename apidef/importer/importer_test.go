@@ -196,3 +196,82 @@ var petstoreJSON string = `{
     }
   }
 }`
+
+func TestToAPIDefinition_WSDL(t *testing.T) {
+	imp, err := GetImporterForSource(WSDLSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := imp.LoadFrom(bytes.NewBufferString(usersWSDL)); err != nil {
+		t.Fatal(err)
+	}
+
+	def, err := imp.ToAPIDefinition("testOrg", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if def.Name != "Users" || def.Proxy.TargetURL != "http://users.example.com/service" {
+		t.Fatalf("Wanted the API named and targeted after the service, got %q at %q", def.Name, def.Proxy.TargetURL)
+	}
+
+	v, ok := def.VersionData.Versions["Default"]
+	if !ok {
+		t.Fatal("Version could not be found")
+	}
+
+	if len(v.ExtendedPaths.SOAP) != 2 {
+		t.Fatalf("Expected 2 operations, found %v\n", len(v.ExtendedPaths.SOAP))
+	}
+	op := v.ExtendedPaths.SOAP[0]
+	if op.Path != "GetUser" || op.Operation != "GetUserRequest" || op.Namespace != "http://example.com/users/types" {
+		t.Fatalf("Wanted the operation sent as its message element, got %+v", op)
+	}
+	if op.SOAPAction != "http://example.com/users/GetUser" || op.Version != "1.1" {
+		t.Fatalf("Wanted the operation's SOAP action, got %+v", op)
+	}
+
+	if len(v.ExtendedPaths.URLRewrite) != 2 || v.ExtendedPaths.URLRewrite[1].RewriteTo != "http://users.example.com/service" {
+		t.Fatal("Wanted the operations rewritten to the service's address")
+	}
+}
+
+var usersWSDL = `<?xml version="1.0" encoding="UTF-8"?>
+<definitions name="Users"
+	targetNamespace="http://example.com/users"
+	xmlns="http://schemas.xmlsoap.org/wsdl/"
+	xmlns:soap="http://schemas.xmlsoap.org/wsdl/soap/"
+	xmlns:tns="http://example.com/users"
+	xmlns:types="http://example.com/users/types">
+	<message name="GetUserInput">
+		<part name="body" element="types:GetUserRequest"/>
+	</message>
+	<message name="DeleteUserInput">
+		<part name="body" element="types:DeleteUserRequest"/>
+	</message>
+	<portType name="UsersPortType">
+		<operation name="GetUser">
+			<input message="tns:GetUserInput"/>
+		</operation>
+		<operation name="DeleteUser">
+			<input message="tns:DeleteUserInput"/>
+		</operation>
+	</portType>
+	<binding name="UsersBinding" type="tns:UsersPortType">
+		<soap:binding style="document" transport="http://schemas.xmlsoap.org/soap/http"/>
+		<operation name="GetUser">
+			<soap:operation soapAction="http://example.com/users/GetUser"/>
+			<input><soap:body use="literal"/></input>
+		</operation>
+		<operation name="DeleteUser">
+			<soap:operation soapAction="http://example.com/users/DeleteUser"/>
+			<input><soap:body use="literal"/></input>
+		</operation>
+	</binding>
+	<service name="UsersService">
+		<port name="UsersPort" binding="tns:UsersBinding">
+			<soap:address location="http://users.example.com/service"/>
+		</port>
+	</service>
+</definitions>`
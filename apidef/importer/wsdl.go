@@ -0,0 +1,255 @@
+package importer
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const WSDLSource APIImporterSource = "wsdl"
+
+type WSDLPartAST struct {
+	Name    string `xml:"name,attr"`
+	Element string `xml:"element,attr"`
+	Type    string `xml:"type,attr"`
+}
+
+type WSDLMessageAST struct {
+	Name  string        `xml:"name,attr"`
+	Parts []WSDLPartAST `xml:"part"`
+}
+
+type WSDLPortTypeAST struct {
+	Name       string `xml:"name,attr"`
+	Operations []struct {
+		Name  string `xml:"name,attr"`
+		Input struct {
+			Message string `xml:"message,attr"`
+		} `xml:"input"`
+	} `xml:"operation"`
+}
+
+type WSDLSOAPOperationAST struct {
+	SOAPAction string `xml:"soapAction,attr"`
+}
+
+type WSDLSOAPBodyAST struct {
+	Namespace string `xml:"namespace,attr"`
+}
+
+type WSDLBindingAST struct {
+	Name       string    `xml:"name,attr"`
+	Type       string    `xml:"type,attr"`
+	SOAP       *struct{} `xml:"http://schemas.xmlsoap.org/wsdl/soap/ binding"`
+	SOAP12     *struct{} `xml:"http://schemas.xmlsoap.org/wsdl/soap12/ binding"`
+	Operations []struct {
+		Name       string               `xml:"name,attr"`
+		SOAP       WSDLSOAPOperationAST `xml:"http://schemas.xmlsoap.org/wsdl/soap/ operation"`
+		SOAP12     WSDLSOAPOperationAST `xml:"http://schemas.xmlsoap.org/wsdl/soap12/ operation"`
+		InputBody  WSDLSOAPBodyAST      `xml:"input>http://schemas.xmlsoap.org/wsdl/soap/ body"`
+		InputBody2 WSDLSOAPBodyAST      `xml:"input>http://schemas.xmlsoap.org/wsdl/soap12/ body"`
+	} `xml:"operation"`
+}
+
+type WSDLServiceAST struct {
+	Name  string `xml:"name,attr"`
+	Ports []struct {
+		Name    string `xml:"name,attr"`
+		Binding string `xml:"binding,attr"`
+		Address struct {
+			Location string `xml:"location,attr"`
+		} `xml:"http://schemas.xmlsoap.org/wsdl/soap/ address"`
+		Address12 struct {
+			Location string `xml:"location,attr"`
+		} `xml:"http://schemas.xmlsoap.org/wsdl/soap12/ address"`
+	} `xml:"port"`
+}
+
+// WSDLAST is a WSDL 1.1 document. Its SOAP operations are imported as
+// JSON endpoints mediated by the gateway.
+type WSDLAST struct {
+	Name            string            `xml:"name,attr"`
+	TargetNamespace string            `xml:"targetNamespace,attr"`
+	Attrs           []xml.Attr        `xml:",any,attr"`
+	Messages        []WSDLMessageAST  `xml:"message"`
+	PortTypes       []WSDLPortTypeAST `xml:"portType"`
+	Bindings        []WSDLBindingAST  `xml:"binding"`
+	Services        []WSDLServiceAST  `xml:"service"`
+}
+
+func (s *WSDLAST) LoadFrom(r io.Reader) error {
+	return xml.NewDecoder(r).Decode(&s)
+}
+
+// localName strips the prefix off a qualified name.
+func localName(qname string) string {
+	return qname[strings.LastIndex(qname, ":")+1:]
+}
+
+// namespaceOf resolves the prefix of a qualified name with the namespaces
+// declared on the document.
+func (s *WSDLAST) namespaceOf(qname string) string {
+	i := strings.Index(qname, ":")
+	if i < 0 {
+		return s.TargetNamespace
+	}
+	for _, attr := range s.Attrs {
+		if attr.Name.Space == "xmlns" && attr.Name.Local == qname[:i] {
+			return attr.Value
+		}
+	}
+	return s.TargetNamespace
+}
+
+// soapBinding returns the first binding of the document that is over
+// SOAP, and its SOAP version.
+func (s *WSDLAST) soapBinding() (*WSDLBindingAST, string) {
+	for i := range s.Bindings {
+		switch b := &s.Bindings[i]; {
+		case b.SOAP != nil:
+			return b, "1.1"
+		case b.SOAP12 != nil:
+			return b, "1.2"
+		}
+	}
+	return nil, ""
+}
+
+func (s *WSDLAST) inputMessage(portType, operation string) *WSDLMessageAST {
+	for _, pt := range s.PortTypes {
+		if pt.Name != localName(portType) {
+			continue
+		}
+		for _, op := range pt.Operations {
+			if op.Name != operation {
+				continue
+			}
+			for i, msg := range s.Messages {
+				if msg.Name == localName(op.Input.Message) {
+					return &s.Messages[i]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *WSDLAST) ConvertIntoApiVersion(asMock bool) (apidef.VersionInfo, error) {
+	if asMock {
+		return apidef.VersionInfo{}, errors.New("WSDL mocks not supported")
+	}
+	return s.convert(s.address())
+}
+
+// convert makes an endpoint for each operation of the WSDL's SOAP binding.
+// As SOAP services take all their requests on the one URL, the endpoints
+// are rewritten to the service's URL, target, if there is one.
+func (s *WSDLAST) convert(target string) (apidef.VersionInfo, error) {
+	versionInfo := apidef.VersionInfo{}
+
+	binding, version := s.soapBinding()
+	if binding == nil {
+		return versionInfo, errors.New("no SOAP binding defined in WSDL file")
+	}
+	if len(binding.Operations) == 0 {
+		return versionInfo, errors.New("no operations defined in WSDL file")
+	}
+
+	versionInfo.UseExtendedPaths = true
+	versionInfo.Name = "Default"
+	for _, op := range binding.Operations {
+		meta := apidef.SOAPMeta{
+			Path:       op.Name,
+			Method:     "POST",
+			Operation:  op.Name,
+			Namespace:  s.TargetNamespace,
+			SOAPAction: op.SOAP.SOAPAction,
+			Version:    version,
+		}
+		if version == "1.2" {
+			meta.SOAPAction = op.SOAP12.SOAPAction
+		}
+
+		// document style operations are sent as the element of their
+		// message, while rpc style ones are named after the operation
+		if msg := s.inputMessage(binding.Type, op.Name); msg != nil && len(msg.Parts) == 1 && msg.Parts[0].Element != "" {
+			meta.Operation = localName(msg.Parts[0].Element)
+			meta.Namespace = s.namespaceOf(msg.Parts[0].Element)
+		} else if ns := op.InputBody.Namespace + op.InputBody2.Namespace; ns != "" {
+			meta.Namespace = ns
+		}
+		versionInfo.ExtendedPaths.SOAP = append(versionInfo.ExtendedPaths.SOAP, meta)
+		if target != "" {
+			versionInfo.ExtendedPaths.URLRewrite = append(versionInfo.ExtendedPaths.URLRewrite, apidef.URLRewriteMeta{
+				Path:         op.Name,
+				Method:       "POST",
+				MatchPattern: op.Name,
+				RewriteTo:    target,
+			})
+		}
+	}
+
+	return versionInfo, nil
+}
+
+func (s *WSDLAST) InsertIntoAPIDefinitionAsVersion(version apidef.VersionInfo, def *apidef.APIDefinition, versionName string) error {
+	def.VersionData.NotVersioned = false
+	def.VersionData.Versions[versionName] = version
+	return nil
+}
+
+// address returns the location of the service's SOAP port.
+func (s *WSDLAST) address() string {
+	for _, service := range s.Services {
+		for _, port := range service.Ports {
+			if port.Address.Location != "" {
+				return port.Address.Location
+			}
+			if port.Address12.Location != "" {
+				return port.Address12.Location
+			}
+		}
+	}
+	return ""
+}
+
+// ToAPIDefinition creates an API for the WSDL's SOAP service. The upstream
+// is the service's address if upstreamURL is empty.
+func (s *WSDLAST) ToAPIDefinition(orgId, upstreamURL string, as_mock bool) (*apidef.APIDefinition, error) {
+	ad := apidef.APIDefinition{
+		Name:             s.Name,
+		Active:           true,
+		UseKeylessAccess: true,
+		APIID:            uuid.NewV4().String(),
+		OrgID:            orgId,
+	}
+	if ad.Name == "" && len(s.Services) > 0 {
+		ad.Name = s.Services[0].Name
+	}
+	if upstreamURL == "" {
+		upstreamURL = s.address()
+	}
+	ad.VersionDefinition.Key = "version"
+	ad.VersionDefinition.Location = "header"
+	ad.VersionData.Versions = make(map[string]apidef.VersionInfo)
+	ad.Proxy.ListenPath = "/" + ad.APIID + "/"
+	ad.Proxy.StripListenPath = true
+	ad.Proxy.TargetURL = upstreamURL
+
+	if as_mock {
+		log.Warning("Mocks not supported for WSDL definitions, ignoring option")
+	}
+	versionData, err := s.convert(upstreamURL)
+	if err != nil {
+		return nil, err
+	}
+
+	s.InsertIntoAPIDefinitionAsVersion(versionData, &ad, versionData.Name)
+
+	return &ad, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/TykTechnologies/tyk/certs"
+	"github.com/TykTechnologies/tyk/config"
+)
+
+// Reasons given in auth failure events for client certificates.
+const (
+	CertFailureMissing               = "missing"
+	CertFailureUnknown               = "unknown"
+	CertFailureExpired               = "expired"
+	CertFailureNotYetValid           = "not_yet_valid"
+	CertFailureRevoked               = "revoked"
+	CertFailureRevocationUnavailable = "revocation_unavailable"
+)
+
+// certIdentityRule is a compiled apidef.CertIdentityRule.
+type certIdentityRule struct {
+	field   string
+	pattern *regexp.Regexp
+}
+
+func compileCertIdentityRules(spec *APISpec) []certIdentityRule {
+	var rules []certIdentityRule
+	for _, rule := range spec.Auth.CertificateIdentityRules {
+		compiled := certIdentityRule{field: rule.Field}
+		if rule.Pattern != "" {
+			var err error
+			if compiled.pattern, err = regexp.Compile(rule.Pattern); err != nil {
+				log.WithFields(logrus.Fields{
+					"prefix":   "main",
+					"api_name": spec.Name,
+				}).Error("Skipping invalid certificate identity rule: ", err)
+				continue
+			}
+		}
+		rules = append(rules, compiled)
+	}
+	return rules
+}
+
+func certFieldValues(cert *x509.Certificate, field string) []string {
+	switch field {
+	case "subject.cn":
+		return []string{cert.Subject.CommonName}
+	case "subject.o":
+		return cert.Subject.Organization
+	case "subject.ou":
+		return cert.Subject.OrganizationalUnit
+	case "subject.serial_number":
+		return []string{cert.Subject.SerialNumber}
+	case "serial":
+		return []string{cert.SerialNumber.String()}
+	case "san.dns":
+		return cert.DNSNames
+	case "san.email":
+		return cert.EmailAddresses
+	case "san.ip":
+		values := make([]string, len(cert.IPAddresses))
+		for i, ip := range cert.IPAddresses {
+			values[i] = ip.String()
+		}
+		return values
+	}
+	return nil
+}
+
+// match returns the identities a rule derives from a certificate.
+func (c certIdentityRule) match(cert *x509.Certificate) []string {
+	var ids []string
+	for _, value := range certFieldValues(cert, c.field) {
+		if value == "" {
+			continue
+		}
+		if c.pattern == nil {
+			ids = append(ids, value)
+			continue
+		}
+		m := c.pattern.FindStringSubmatch(value)
+		switch {
+		case m == nil:
+		case len(m) > 1 && m[1] != "":
+			ids = append(ids, m[1])
+		case len(m) == 1:
+			ids = append(ids, m[0])
+		}
+	}
+	return ids
+}
+
+// verifyClientCertificate verifies the request's client certificate
+// against the certificates the API trusts, returning the verified
+// chain. The rest of the chain sent by the client is only used for
+// intermediates, never as roots. Chains verified in the TLS handshake
+// aren't used, as they were built from the CAs of whichever API on the
+// domain was found first.
+func verifyClientCertificate(spec *APISpec, r *http.Request) ([]*x509.Certificate, error) {
+	certIDs := append([]string{}, spec.ClientCertificates...)
+	certIDs = append(certIDs, config.Global.Security.Certificates.API...)
+	opts := x509.VerifyOptions{
+		Roots:         CertificateManager.CertPool(certIDs),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range r.TLS.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	chains, err := r.TLS.PeerCertificates[0].Verify(opts)
+	if err != nil {
+		return nil, err
+	}
+	return chains[0], nil
+}
+
+// certificateKeyCandidates lists the keys a client certificate could
+// be mapped to, in order: those from the identity rules, then the one
+// bound to the certificate hash. Anyone can put any name in a
+// certificate, so the identity rules are only used for certificates
+// issued by one the API trusts.
+func certificateKeyCandidates(spec *APISpec, r *http.Request) []string {
+	cert := r.TLS.PeerCertificates[0]
+	var keys []string
+	if len(spec.CertIdentityRules) > 0 {
+		if _, err := verifyClientCertificate(spec, r); err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix":   "certs",
+				"api_name": spec.Name,
+			}).Debug("Not applying certificate identity rules to unverified certificate: ", err)
+		} else {
+			for _, rule := range spec.CertIdentityRules {
+				for _, id := range rule.match(cert) {
+					keys = append(keys, spec.OrgID+id)
+				}
+			}
+		}
+	}
+	return append(keys, spec.OrgID+certs.HexSHA256(cert.Raw))
+}
+
+// certificateIssuer finds the certificate that signed cert, from its
+// verified chain. Self-signed certificates are their own issuer.
+func certificateIssuer(spec *APISpec, r *http.Request, cert *x509.Certificate) *x509.Certificate {
+	if chain, err := verifyClientCertificate(spec, r); err == nil && len(chain) > 1 {
+		return chain[1]
+	}
+	if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil {
+		return cert
+	}
+	return nil
+}
+
+// checkClientCertificate checks the validity period and revocation
+// status of the request's client certificate. On failure it returns
+// the reason, to be used in events, and a client facing error message.
+func checkClientCertificate(spec *APISpec, r *http.Request) (reason, message string) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return CertFailureMissing, "Client TLS certificate is required"
+	}
+	cert := r.TLS.PeerCertificates[0]
+
+	now := time.Now()
+	if now.After(cert.NotAfter) {
+		return CertFailureExpired, "Client TLS certificate has expired"
+	}
+	if now.Before(cert.NotBefore) {
+		return CertFailureNotYetValid, "Client TLS certificate is not yet valid"
+	}
+
+	conf := spec.CertificateRevocation
+	err := checkCertRevocation(conf, cert, certificateIssuer(spec, r, cert))
+	switch {
+	case err == nil:
+	case err == errCertRevoked:
+		return CertFailureRevoked, "Client TLS certificate has been revoked"
+	case conf.FailOpen:
+		log.WithFields(logrus.Fields{
+			"prefix":   "certs",
+			"api_name": spec.Name,
+		}).Warning("Allowing certificate with unknown revocation status: ", err)
+	default:
+		log.WithFields(logrus.Fields{
+			"prefix":   "certs",
+			"api_name": spec.Name,
+		}).Error("Could not check certificate revocation status: ", err)
+		return CertFailureRevocationUnavailable, "Client TLS certificate status could not be verified"
+	}
+	return "", ""
+}
+
+// CertificateAuthFailed fires an auth failure event for a rejected
+// client certificate, saying why it was rejected.
+func CertificateAuthFailed(m TykMiddleware, r *http.Request, key, reason string) {
+	meta := EventCertificateFailureMeta{
+		EventKeyFailureMeta: EventKeyFailureMeta{
+			EventMetaDefault: EventMetaDefault{Message: "Certificate Auth Failure: " + reason, OriginatingRequest: EncodeRequestToEvent(r)},
+			Path:             r.URL.Path,
+			Origin:           requestIP(r),
+			Key:              key,
+		},
+		Reason: reason,
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		cert := r.TLS.PeerCertificates[0]
+		meta.CertID = certs.HexSHA256(cert.Raw)
+		meta.Subject = cert.Subject.CommonName
+	}
+	m.Base().FireEvent(EventAuthFailure, meta)
+}
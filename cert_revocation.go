@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	cache "github.com/pmylund/go-cache"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/certs"
+)
+
+const (
+	certRevocationDefaultTTL = 300
+	certRevocationTimeout    = 5 * time.Second
+	certRevocationMaxBody    = 10 << 20
+)
+
+var (
+	errCertRevoked            = errors.New("certificate has been revoked")
+	errCertIssuerUnknown      = errors.New("certificate issuer not found")
+	errCertRevocationNoSource = errors.New("no CRL or OCSP responder for certificate")
+)
+
+// CertRevocationCache holds parsed CRLs and OCSP responses, so that
+// revocation sources aren't queried on every request. Entries never
+// outlive the next update time given by the source.
+var CertRevocationCache = cache.New(certRevocationDefaultTTL*time.Second, 10*time.Minute)
+
+var certRevocationClient = &http.Client{Timeout: certRevocationTimeout}
+
+// certRevocationTTL picks how long a revocation answer can be cached.
+func certRevocationTTL(conf apidef.CertRevocationConfig, nextUpdate time.Time) time.Duration {
+	ttl := time.Duration(conf.CacheTTL) * time.Second
+	if ttl <= 0 {
+		ttl = certRevocationDefaultTTL * time.Second
+	}
+	if !nextUpdate.IsZero() {
+		if until := time.Until(nextUpdate); until < ttl {
+			ttl = until
+		}
+	}
+	return ttl
+}
+
+func certRevocationGet(url string) ([]byte, error) {
+	resp, err := certRevocationClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, certRevocationMaxBody))
+}
+
+// fetchCRL downloads a CRL, verifying that it was signed by the issuer
+// and is still current.
+func fetchCRL(conf apidef.CertRevocationConfig, url string, issuer *x509.Certificate) (*pkix.CertificateList, error) {
+	key := "crl-" + url + "-" + certs.HexSHA256(issuer.Raw)
+	if cached, found := CertRevocationCache.Get(key); found {
+		return cached.(*pkix.CertificateList), nil
+	}
+	body, err := certRevocationGet(url)
+	if err != nil {
+		return nil, err
+	}
+	crl, err := x509.ParseCRL(body)
+	if err != nil {
+		return nil, err
+	}
+	if err := issuer.CheckCRLSignature(crl); err != nil {
+		return nil, err
+	}
+	if crl.HasExpired(time.Now()) {
+		return nil, errors.New("CRL " + url + " has expired")
+	}
+	CertRevocationCache.Set(key, crl, certRevocationTTL(conf, crl.TBSCertList.NextUpdate))
+	return crl, nil
+}
+
+func checkCRL(conf apidef.CertRevocationConfig, cert, issuer *x509.Certificate) error {
+	urls := conf.CRLURLs
+	if len(urls) == 0 {
+		urls = cert.CRLDistributionPoints
+	}
+	if len(urls) == 0 {
+		return errCertRevocationNoSource
+	}
+	for _, url := range urls {
+		crl, err := fetchCRL(conf, url, issuer)
+		if err != nil {
+			return err
+		}
+		for _, revoked := range crl.TBSCertList.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return errCertRevoked
+			}
+		}
+	}
+	return nil
+}
+
+// ocspStatus asks the responder about a certificate, returning one of
+// ocsp.Good, ocsp.Revoked or ocsp.Unknown.
+func ocspStatus(conf apidef.CertRevocationConfig, url string, cert, issuer *x509.Certificate) (int, error) {
+	key := "ocsp-" + url + "-" + certs.HexSHA256(cert.Raw)
+	if cached, found := CertRevocationCache.Get(key); found {
+		return cached.(int), nil
+	}
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := certRevocationClient.Post(url, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("OCSP responder %s returned status %d", url, resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, certRevocationMaxBody))
+	if err != nil {
+		return 0, err
+	}
+	ocspResp, err := ocsp.ParseResponseForCert(body, cert, issuer)
+	if err != nil {
+		return 0, err
+	}
+	if !ocspResp.NextUpdate.IsZero() && time.Now().After(ocspResp.NextUpdate) {
+		return 0, errors.New("OCSP response from " + url + " is stale")
+	}
+	CertRevocationCache.Set(key, ocspResp.Status, certRevocationTTL(conf, ocspResp.NextUpdate))
+	return ocspResp.Status, nil
+}
+
+func checkOCSP(conf apidef.CertRevocationConfig, cert, issuer *x509.Certificate) error {
+	urls := cert.OCSPServer
+	if conf.OCSPResponder != "" {
+		urls = []string{conf.OCSPResponder}
+	}
+	if len(urls) == 0 {
+		return errCertRevocationNoSource
+	}
+	var lastErr error
+	for _, url := range urls {
+		status, err := ocspStatus(conf, url, cert, issuer)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		switch status {
+		case ocsp.Good:
+			return nil
+		case ocsp.Revoked:
+			return errCertRevoked
+		}
+		lastErr = errors.New("OCSP responder " + url + " does not know the certificate")
+	}
+	return lastErr
+}
+
+// checkCertRevocation runs the configured revocation checks. It only
+// returns errCertRevoked if a source says the certificate is revoked;
+// any other error means the status couldn't be established.
+func checkCertRevocation(conf apidef.CertRevocationConfig, cert, issuer *x509.Certificate) error {
+	if !conf.CheckCRL && !conf.CheckOCSP {
+		return nil
+	}
+	if issuer == nil {
+		return errCertIssuerUnknown
+	}
+	if conf.CheckOCSP {
+		if err := checkOCSP(conf, cert, issuer); err != nil {
+			return err
+		}
+	}
+	if conf.CheckCRL {
+		if err := checkCRL(conf, cert, issuer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
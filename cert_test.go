@@ -7,6 +7,7 @@ import (
 	"crypto/tls"
 	_ "crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"encoding/pem"
 	"io/ioutil"
@@ -17,9 +18,13 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"golang.org/x/crypto/ocsp"
+
 	"github.com/TykTechnologies/tyk/apidef"
 	"github.com/TykTechnologies/tyk/certs"
 	"github.com/TykTechnologies/tyk/config"
@@ -790,3 +795,262 @@ func TestCertificateHandlerTLS(t *testing.T) {
 		}
 	})
 }
+
+// testCertAuthority issues client certificates and serves their
+// revocation status over OCSP and as a CRL.
+type testCertAuthority struct {
+	*httptest.Server
+	cert    *x509.Certificate
+	key     *rsa.PrivateKey
+	revoked map[string]bool
+
+	ocspHits, crlHits int32
+}
+
+func newTestCertAuthority(t *testing.T) *testCertAuthority {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCertAuthority{key: key, revoked: map[string]bool{}}
+	ca.cert, _ = x509.ParseCertificate(der)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ocsp", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&ca.ocspHits, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		status := ocsp.Good
+		if ca.revoked[req.SerialNumber.String()] {
+			status = ocsp.Revoked
+		}
+		resp, _ := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now().Add(-time.Minute),
+		}, ca.key)
+		w.Write(resp)
+	})
+	mux.HandleFunc("/crl", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&ca.crlHits, 1)
+		var revoked []pkix.RevokedCertificate
+		for serial := range ca.revoked {
+			n, _ := new(big.Int).SetString(serial, 10)
+			revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: n, RevocationTime: time.Now()})
+		}
+		crl, _ := ca.cert.CreateCRL(rand.Reader, ca.key, revoked, time.Now(), time.Now().Add(time.Hour))
+		w.Write(crl)
+	})
+	ca.Server = httptest.NewServer(mux)
+	return ca
+}
+
+func (ca *testCertAuthority) issue(t *testing.T, cn string, notAfter time.Time) tls.Certificate {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+// pem returns the CA certificate in PEM format.
+func (ca *testCertAuthority) pem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+func TestCertificateIdentityAndRevocation(t *testing.T) {
+	_, _, combinedPEM, _ := genServerCertificate()
+	serverCertID, _ := CertificateManager.Add(combinedPEM, "")
+	defer CertificateManager.Delete(serverCertID)
+
+	config.Global.HttpServerOptions.UseSSL = true
+	config.Global.ListenPort = 0
+	config.Global.HttpServerOptions.SSLCertificates = []string{serverCertID}
+
+	ln, _ := generateListener(0)
+	listen(ln, nil, nil)
+
+	ca := newTestCertAuthority(t)
+	caID, _ := CertificateManager.Add(ca.pem(), "")
+	defer CertificateManager.Delete(caID)
+
+	// a CA the API doesn't trust, which sends itself along with the
+	// certificates it issues
+	rogue := newTestCertAuthority(t)
+
+	defer func() {
+		ln.Close()
+		ca.Close()
+		rogue.Close()
+		CertRevocationCache.Flush()
+		config.Global.HttpServerOptions.SSLCertificates = nil
+		config.Global.HttpServerOptions.UseSSL = false
+		config.Global.ListenPort = defaultListenPort
+	}()
+
+	alice := ca.issue(t, "alice", time.Now().Add(time.Hour))
+	mallory := ca.issue(t, "mallory", time.Now().Add(time.Hour))
+	bob := ca.issue(t, "bob", time.Now().Add(time.Hour))
+	expired := ca.issue(t, "alice", time.Now().Add(-time.Hour))
+	malloryLeaf, _ := x509.ParseCertificate(mallory.Certificate[0])
+	ca.revoked[malloryLeaf.SerialNumber.String()] = true
+
+	_, _, _, selfSigned := genCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "alice"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	rogueAlice := rogue.issue(t, "alice", time.Now().Add(time.Hour))
+	rogueAlice.Certificate = append(rogueAlice.Certificate, rogue.cert.Raw)
+
+	baseURL := "https://" + strings.Replace(ln.Addr().String(), "[::]", "localhost", -1)
+
+	var reasons []string
+	var reasonsMu sync.Mutex
+	var apiID string
+	loadAPI := func(revocation apidef.CertRevocationConfig) {
+		specs := buildAndLoadAPI(func(spec *APISpec) {
+			spec.UseKeylessAccess = false
+			spec.Auth.UseCertificate = true
+			spec.ClientCertificates = []string{caID}
+			spec.Auth.CertificateIdentityRules = []apidef.CertIdentityRule{
+				{Field: "subject.cn", Pattern: "^(alice|bob)$"},
+			}
+			spec.CertificateRevocation = revocation
+			spec.Proxy.ListenPath = "/"
+		})
+		apiID = specs[0].APIID
+		getApiSpec(apiID).EventPaths = map[apidef.TykEvent][]config.TykEventHandler{
+			EventAuthFailure: {&testAuthFailEventHandler{func(em config.EventMessage) {
+				reasonsMu.Lock()
+				reasons = append(reasons, em.Meta.(EventCertificateFailureMeta).Reason)
+				reasonsMu.Unlock()
+			}}},
+		}
+		CertRevocationCache.Flush()
+	}
+
+	// loading the API sets up the key store
+	loadAPI(apidef.CertRevocationConfig{})
+	aliceSession := createParamAuthSession("test")
+	aliceSession.AccessRights = nil
+	FallbackKeySesionManager.UpdateSession("alice", aliceSession, 60)
+	defer FallbackKeySesionManager.RemoveSession("alice")
+
+	check := func(t *testing.T, cert tls.Certificate, code int, reason string) {
+		reasonsMu.Lock()
+		reasons = nil
+		reasonsMu.Unlock()
+
+		resp, err := getTLSClient(&cert, nil).Get(baseURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("Wanted status %d, got %d", code, resp.StatusCode)
+		}
+		if reason == "" {
+			return
+		}
+		for i := 0; i < 100; i++ {
+			reasonsMu.Lock()
+			got := reasons
+			reasonsMu.Unlock()
+			if len(got) > 0 {
+				if got[0] != reason {
+					t.Fatalf("Wanted failure reason %q, got %q", reason, got[0])
+				}
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("No auth failure event fired")
+	}
+
+	t.Run("Untrusted", func(t *testing.T) {
+		loadAPI(apidef.CertRevocationConfig{})
+
+		check(t, alice, 200, "")
+		check(t, selfSigned, 403, CertFailureUnknown)
+		check(t, rogueAlice, 403, CertFailureUnknown)
+	})
+
+	t.Run("OtherAPIsCA", func(t *testing.T) {
+		loadAPI(apidef.CertRevocationConfig{})
+		spec := getApiSpec(apiID)
+
+		// as if the handshake verified it against the CAs of another
+		// API on the same domain
+		leaf, _ := x509.ParseCertificate(rogueAlice.Certificate[0])
+		r := httptest.NewRequest("GET", "/", nil)
+		r.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{leaf},
+			VerifiedChains:   [][]*x509.Certificate{{leaf, rogue.cert}},
+		}
+		keys := certificateKeyCandidates(spec, r)
+		if len(keys) != 1 || keys[0] != spec.OrgID+certs.HexSHA256(leaf.Raw) {
+			t.Fatalf("Identity rules should not apply to certificates from another API's CA, got keys %v", keys)
+		}
+	})
+
+	t.Run("OCSP", func(t *testing.T) {
+		loadAPI(apidef.CertRevocationConfig{CheckOCSP: true, OCSPResponder: ca.URL + "/ocsp"})
+
+		check(t, alice, 200, "")
+		check(t, alice, 200, "")
+		if hits := atomic.LoadInt32(&ca.ocspHits); hits != 1 {
+			t.Error("OCSP responses should be cached, got", hits, "requests")
+		}
+		check(t, mallory, 403, CertFailureRevoked)
+		check(t, expired, 403, CertFailureExpired)
+		check(t, bob, 403, CertFailureUnknown)
+	})
+
+	t.Run("CRL", func(t *testing.T) {
+		loadAPI(apidef.CertRevocationConfig{CheckCRL: true, CRLURLs: []string{ca.URL + "/crl"}})
+
+		check(t, alice, 200, "")
+		check(t, mallory, 403, CertFailureRevoked)
+		if hits := atomic.LoadInt32(&ca.crlHits); hits != 1 {
+			t.Error("CRL should be cached, got", hits, "requests")
+		}
+	})
+
+	t.Run("ResponderDown", func(t *testing.T) {
+		down := apidef.CertRevocationConfig{CheckOCSP: true, OCSPResponder: "http://127.0.0.1:1/ocsp"}
+		loadAPI(down)
+		check(t, alice, 403, CertFailureRevocationUnavailable)
+
+		down.FailOpen = true
+		loadAPI(down)
+		check(t, alice, 200, "")
+	})
+}
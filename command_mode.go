@@ -13,6 +13,7 @@ import (
 var commandModeOptions = []interface{}{
 	importBlueprint,
 	importSwagger,
+	importWSDL,
 	createAPI,
 	orgID,
 	upstreamTarget,
@@ -34,6 +35,12 @@ func handleCommandModeArgs() {
 			log.Error(err)
 		}
 	}
+
+	if *importWSDL != "" {
+		if err := handleWSDLMode(); err != nil {
+			log.Error(err)
+		}
+	}
 }
 
 func handleBluePrintMode() error {
@@ -143,6 +150,54 @@ func handleSwaggerMode() error {
 	return nil
 }
 
+func handleWSDLMode() error {
+	w, err := wsdlLoadFile(*importWSDL)
+	if err != nil {
+		return fmt.Errorf("File load error: %v", err)
+	}
+
+	if *createAPI {
+		if *orgID == "" {
+			return fmt.Errorf("No org ID defined, this is required")
+		}
+		// The upstream target defaults to the service's address
+		def, err := w.ToAPIDefinition(*orgID, *upstreamTarget, *asMock)
+		if err != nil {
+			return fmt.Errorf("Failed to create API Definition from file: %v", err)
+		}
+
+		printDef(def)
+		return nil
+	}
+
+	// Different branch, here we need an API Definition to modify
+	if *forAPI == "" {
+		return fmt.Errorf("If adding to an API, the path to the definition must be listed")
+	}
+
+	if *asVersion == "" {
+		return fmt.Errorf("No version defined for this import operation, please set an import ID using the --as-version flag")
+	}
+
+	defFromFile, err := apiDefLoadFile(*forAPI)
+	if err != nil {
+		return fmt.Errorf("failed to load and decode file data for API Definition: %v", err)
+	}
+
+	versionData, err := w.ConvertIntoApiVersion(*asMock)
+	if err != nil {
+		return fmt.Errorf("Conversion into API Def failed: %v", err)
+	}
+
+	if err := w.InsertIntoAPIDefinitionAsVersion(versionData, defFromFile, *asVersion); err != nil {
+		return fmt.Errorf("Insertion failed: %v", err)
+	}
+
+	printDef(defFromFile)
+
+	return nil
+}
+
 func printDef(def *apidef.APIDefinition) {
 	asJSON, err := json.MarshalIndent(def, "", "    ")
 	if err != nil {
@@ -191,6 +246,25 @@ func bluePrintLoadFile(path string) (*importer.BluePrintAST, error) {
 	return blueprint.(*importer.BluePrintAST), nil
 }
 
+func wsdlLoadFile(path string) (*importer.WSDLAST, error) {
+	wsdl, err := importer.GetImporterForSource(importer.WSDLSource)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := wsdl.LoadFrom(f); err != nil {
+		return nil, err
+	}
+
+	return wsdl.(*importer.WSDLAST), nil
+}
+
 func apiDefLoadFile(path string) (*apidef.APIDefinition, error) {
 	f, err := os.Open(path)
 	if err != nil {
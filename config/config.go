@@ -83,11 +83,14 @@ type MonitorConfig struct {
 }
 
 type WebHookHandlerConf struct {
-	Method       string            `bson:"method" json:"method"`
-	TargetPath   string            `bson:"target_path" json:"target_path"`
-	TemplatePath string            `bson:"template_path" json:"template_path"`
-	HeaderList   map[string]string `bson:"header_map" json:"header_map"`
-	EventTimeout int64             `bson:"event_timeout" json:"event_timeout"`
+	Method         string            `bson:"method" json:"method"`
+	TargetPath     string            `bson:"target_path" json:"target_path"`
+	TemplatePath   string            `bson:"template_path" json:"template_path"`
+	HeaderList     map[string]string `bson:"header_map" json:"header_map"`
+	EventTimeout   int64             `bson:"event_timeout" json:"event_timeout"`
+	Secret         string            `bson:"secret" json:"secret"`
+	MaxRetryAge    int64             `bson:"max_retry_age" json:"max_retry_age"`
+	RequestTimeout int64             `bson:"request_timeout" json:"request_timeout"`
 }
 
 type SlaveOptionsConfig struct {
@@ -112,6 +115,20 @@ type LocalSessionCacheConf struct {
 	CacheSessionEviction     int  `json:"cached_session_eviction"`
 }
 
+// BasicAuthHashConf sets how basic auth passwords are hashed. Cost
+// parameters left at zero use the package defaults.
+type BasicAuthHashConf struct {
+	HashType             string `json:"hash_type"`
+	BCryptCost           int    `json:"bcrypt_cost"`
+	Argon2Time           uint32 `json:"argon2_time"`
+	Argon2Memory         uint32 `json:"argon2_memory"`
+	Argon2Threads        uint8  `json:"argon2_threads"`
+	ScryptN              int    `json:"scrypt_n"`
+	ScryptR              int    `json:"scrypt_r"`
+	ScryptP              int    `json:"scrypt_p"`
+	DisableRehashOnLogin bool   `json:"disable_rehash_on_login"`
+}
+
 type HttpServerOptionsConfig struct {
 	OverrideDefaults      bool       `json:"override_defaults"`
 	ReadTimeout           int        `json:"read_timeout"`
@@ -154,7 +171,62 @@ type ServiceDiscoveryConf struct {
 type CoProcessConfig struct {
 	EnableCoProcess     bool   `json:"enable_coprocess"`
 	CoProcessGRPCServer string `json:"coprocess_grpc_server"`
-	PythonPathPrefix    string `json:"python_path_prefix"`
+	// CoProcessGRPCServers are more gRPC servers to fail over to, in
+	// order, when coprocess_grpc_server is down.
+	CoProcessGRPCServers []string `json:"coprocess_grpc_servers"`
+	// GRPCTimeout is the number of milliseconds a gRPC hook may take,
+	// unless the hook sets its own. It defaults to 5000.
+	GRPCTimeout int64 `json:"grpc_timeout"`
+	// GRPCPoolSize is the number of connections kept to each gRPC
+	// server. It defaults to 1.
+	GRPCPoolSize int `json:"grpc_pool_size"`
+	// GRPCHealthCheckInterval is the number of seconds between checks
+	// of whether gRPC servers that went down are back. It defaults to
+	// 5.
+	GRPCHealthCheckInterval int64  `json:"grpc_health_check_interval"`
+	PythonPathPrefix        string `json:"python_path_prefix"`
+}
+
+// JSVM engines. Otto only supports ES5, goja supports ES2015 and later.
+const (
+	OttoJSVMEngine = "otto"
+	GojaJSVMEngine = "goja"
+)
+
+type JSVMOptionsConfig struct {
+	// Timeout is the number of seconds a JS call may run for. It
+	// defaults to 5.
+	Timeout int `json:"timeout"`
+	// The rest only apply to the goja engine.
+	MaxCallStackSize int `json:"max_call_stack_size"`
+	// MaxMemoryMB is a best-effort guard against runaway scripts: a JS
+	// call is stopped if the heap of the whole process grows by this
+	// many megabytes while it runs. It isn't a limit on each call, as
+	// other work may trip it and a call's memory may go unnoticed while
+	// the rest of the process frees its own. The number of instructions
+	// a call runs isn't limited either, only its Timeout.
+	MaxMemoryMB int `json:"max_memory_mb"`
+	// PoolSize is the number of VMs kept ready per API. It defaults to
+	// the number of CPUs.
+	PoolSize int `json:"pool_size"`
+	// MaxVMCalls is the number of calls a VM is reused for before it's
+	// replaced with a fresh one. Globals that a call sets are seen by
+	// the later calls on the same VM, so this bounds how long they
+	// linger. It defaults to 1000, and 1 gives every call a new VM.
+	MaxVMCalls int `json:"max_vm_calls"`
+}
+
+type WasmOptionsConfig struct {
+	EnableWasm bool `json:"enable_wasm"`
+	// MaxMemoryMB is the most memory a WASM plugin instance may have.
+	// It defaults to 16.
+	MaxMemoryMB int `json:"max_memory_mb"`
+	// Fuel is the number of WASM instructions a single hook call may
+	// run. It defaults to 50 million, and -1 means no limit.
+	Fuel int64 `json:"fuel"`
+	// PoolSize is the number of instances kept ready per module. It
+	// defaults to the number of CPUs.
+	PoolSize int `json:"pool_size"`
 }
 
 type CertificatesConfig struct {
@@ -199,6 +271,7 @@ type Config struct {
 	UseAsyncSessionWrite              bool                                  `json:"optimisations_use_async_session_write"`
 	AllowMasterKeys                   bool                                  `json:"allow_master_keys"`
 	HashKeys                          bool                                  `json:"hash_keys"`
+	BasicAuthHashing                  BasicAuthHashConf                     `json:"basic_auth_hashing"`
 	SuppressRedisSignalReload         bool                                  `json:"suppress_redis_signal_reload"`
 	SupressDefaultOrgStore            bool                                  `json:"suppress_default_org_store"`
 	UseRedisLog                       bool                                  `json:"use_redis_log"`
@@ -240,7 +313,10 @@ type Config struct {
 	ControlAPIPort                    int                                   `json:"control_api_port"`
 	EnableCustomDomains               bool                                  `json:"enable_custom_domains"`
 	EnableJSVM                        bool                                  `json:"enable_jsvm"`
+	JSVMEngine                        string                                `json:"jsvm_engine"`
+	JSVMOptions                       JSVMOptionsConfig                     `json:"jsvm_options"`
 	CoProcessOptions                  CoProcessConfig                       `json:"coprocess_options"`
+	WasmOptions                       WasmOptionsConfig                     `json:"wasm_options"`
 	HideGeneratorHeader               bool                                  `json:"hide_generator_header"`
 	EventHandlers                     apidef.EventHandlerMetaConfig         `json:"event_handlers"`
 	EventTriggers                     map[apidef.TykEvent][]TykEventHandler `json:"event_trigers_defunct"`
@@ -253,6 +329,8 @@ type Config struct {
 	ForceGlobalSessionLifetime        bool                                  `bson:"force_global_session_lifetime" json:"force_global_session_lifetime"`
 	BundleBaseURL                     string                                `bson:"bundle_base_url" json:"bundle_base_url"`
 	EnableBundleDownloader            bool                                  `bson:"enable_bundle_downloader" json:"enable_bundle_downloader"`
+	BundleRequireSignature            bool                                  `bson:"bundle_require_signature" json:"bundle_require_signature"`
+	BundleKeepVersions                int                                   `bson:"bundle_keep_versions" json:"bundle_keep_versions"`
 	AllowRemoteConfig                 bool                                  `bson:"allow_remote_config" json:"allow_remote_config"`
 	LegacyEnableAllowanceCountdown    bool                                  `bson:"legacy_enable_allowance_countdown" json:"legacy_enable_allowance_countdown"`
 	MaxIdleConnsPerHost               int                                   `bson:"max_idle_connections_per_host" json:"max_idle_connections_per_host"`
@@ -262,6 +340,8 @@ type Config struct {
 	LogLevel                          string                                `json:"log_level"`
 	Security                          SecurityConfig                        `json:"security"`
 	EnableKeyLogging                  bool                                  `json:"enable_key_logging"`
+	TrustedProxies                    []string                              `json:"trusted_proxies"`
+	TrustXRealIP                      bool                                  `json:"trust_x_real_ip"`
 }
 
 type CertData struct {
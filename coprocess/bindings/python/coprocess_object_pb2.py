@@ -22,7 +22,7 @@ DESCRIPTOR = _descriptor.FileDescriptor(
   name='coprocess_object.proto',
   package='coprocess',
   syntax='proto3',
-  serialized_pb=_b('\n\x16\x63oprocess_object.proto\x12\tcoprocess\x1a#coprocess_mini_request_object.proto\x1a\x1d\x63oprocess_session_state.proto\x1a\x16\x63oprocess_common.proto\"\xd8\x02\n\x06Object\x12&\n\thook_type\x18\x01 \x01(\x0e\x32\x13.coprocess.HookType\x12\x11\n\thook_name\x18\x02 \x01(\t\x12-\n\x07request\x18\x03 \x01(\x0b\x32\x1c.coprocess.MiniRequestObject\x12(\n\x07session\x18\x04 \x01(\x0b\x32\x17.coprocess.SessionState\x12\x31\n\x08metadata\x18\x05 \x03(\x0b\x32\x1f.coprocess.Object.MetadataEntry\x12)\n\x04spec\x18\x06 \x03(\x0b\x32\x1b.coprocess.Object.SpecEntry\x1a/\n\rMetadataEntry\x12\x0b\n\x03key\x18\x01 \x01(\t\x12\r\n\x05value\x18\x02 \x01(\t:\x02\x38\x01\x1a+\n\tSpecEntry\x12\x0b\n\x03key\x18\x01 \x01(\t\x12\r\n\x05value\x18\x02 \x01(\t:\x02\x38\x01\"\x18\n\x05\x45vent\x12\x0f\n\x07payload\x18\x01 \x01(\t\"\x0c\n\nEventReply2|\n\nDispatcher\x12\x32\n\x08\x44ispatch\x12\x11.coprocess.Object\x1a\x11.coprocess.Object\"\x00\x12:\n\rDispatchEvent\x12\x10.coprocess.Event\x1a\x15.coprocess.EventReply\"\x00\x62\x06proto3')
+  serialized_pb=_b('\n\x16\x63oprocess_object.proto\x12\tcoprocess\x1a#coprocess_mini_request_object.proto\x1a\x1d\x63oprocess_session_state.proto\x1a\x16\x63oprocess_common.proto\"\xb3\x03\n\x06Object\x12&\n\thook_type\x18\x01 \x01(\x0e\x32\x13.coprocess.HookType\x12\x11\n\thook_name\x18\x02 \x01(\t\x12-\n\x07request\x18\x03 \x01(\x0b\x32\x1c.coprocess.MiniRequestObject\x12(\n\x07session\x18\x04 \x01(\x0b\x32\x17.coprocess.SessionState\x12\x31\n\x08metadata\x18\x05 \x03(\x0b\x32\x1f.coprocess.Object.MetadataEntry\x12)\n\x04spec\x18\x06 \x03(\x0b\x32\x1b.coprocess.Object.SpecEntry\x12+\n\x05state\x18\x07 \x03(\x0b\x32\x1c.coprocess.Object.StateEntry\x1a/\n\rMetadataEntry\x12\x0b\n\x03key\x18\x01 \x01(\t\x12\r\n\x05value\x18\x02 \x01(\t:\x02\x38\x01\x1a+\n\tSpecEntry\x12\x0b\n\x03key\x18\x01 \x01(\t\x12\r\n\x05value\x18\x02 \x01(\t:\x02\x38\x01\x1a,\n\nStateEntry\x12\x0b\n\x03key\x18\x01 \x01(\t\x12\r\n\x05value\x18\x02 \x01(\t:\x02\x38\x01\"\x18\n\x05\x45vent\x12\x0f\n\x07payload\x18\x01 \x01(\t\"\x0c\n\nEventReply2|\n\nDispatcher\x12\x32\n\x08\x44ispatch\x12\x11.coprocess.Object\x1a\x11.coprocess.Object\"\x00\x12:\n\rDispatchEvent\x12\x10.coprocess.Event\x1a\x15.coprocess.EventReply\"\x00\x62\x06proto3')
   ,
   dependencies=[coprocess__mini__request__object__pb2.DESCRIPTOR,coprocess__session__state__pb2.DESCRIPTOR,coprocess__common__pb2.DESCRIPTOR,])
 
@@ -62,8 +62,8 @@ _OBJECT_METADATAENTRY = _descriptor.Descriptor(
   extension_ranges=[],
   oneofs=[
   ],
-  serialized_start=382,
-  serialized_end=429,
+  serialized_start=427,
+  serialized_end=474,
 )
 
 _OBJECT_SPECENTRY = _descriptor.Descriptor(
@@ -99,8 +99,45 @@ _OBJECT_SPECENTRY = _descriptor.Descriptor(
   extension_ranges=[],
   oneofs=[
   ],
-  serialized_start=431,
-  serialized_end=474,
+  serialized_start=476,
+  serialized_end=519,
+)
+
+_OBJECT_STATEENTRY = _descriptor.Descriptor(
+  name='StateEntry',
+  full_name='coprocess.Object.StateEntry',
+  filename=None,
+  file=DESCRIPTOR,
+  containing_type=None,
+  fields=[
+    _descriptor.FieldDescriptor(
+      name='key', full_name='coprocess.Object.StateEntry.key', index=0,
+      number=1, type=9, cpp_type=9, label=1,
+      has_default_value=False, default_value=_b("").decode('utf-8'),
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      options=None),
+    _descriptor.FieldDescriptor(
+      name='value', full_name='coprocess.Object.StateEntry.value', index=1,
+      number=2, type=9, cpp_type=9, label=1,
+      has_default_value=False, default_value=_b("").decode('utf-8'),
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      options=None),
+  ],
+  extensions=[
+  ],
+  nested_types=[],
+  enum_types=[
+  ],
+  options=_descriptor._ParseOptions(descriptor_pb2.MessageOptions(), _b('8\001')),
+  is_extendable=False,
+  syntax='proto3',
+  extension_ranges=[],
+  oneofs=[
+  ],
+  serialized_start=521,
+  serialized_end=565,
 )
 
 _OBJECT = _descriptor.Descriptor(
@@ -152,10 +189,17 @@ _OBJECT = _descriptor.Descriptor(
       message_type=None, enum_type=None, containing_type=None,
       is_extension=False, extension_scope=None,
       options=None),
+    _descriptor.FieldDescriptor(
+      name='state', full_name='coprocess.Object.state', index=6,
+      number=7, type=11, cpp_type=10, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      options=None),
   ],
   extensions=[
   ],
-  nested_types=[_OBJECT_METADATAENTRY, _OBJECT_SPECENTRY, ],
+  nested_types=[_OBJECT_METADATAENTRY, _OBJECT_SPECENTRY, _OBJECT_STATEENTRY, ],
   enum_types=[
   ],
   options=None,
@@ -165,7 +209,7 @@ _OBJECT = _descriptor.Descriptor(
   oneofs=[
   ],
   serialized_start=130,
-  serialized_end=474,
+  serialized_end=565,
 )
 
 
@@ -195,8 +239,8 @@ _EVENT = _descriptor.Descriptor(
   extension_ranges=[],
   oneofs=[
   ],
-  serialized_start=476,
-  serialized_end=500,
+  serialized_start=567,
+  serialized_end=591,
 )
 
 
@@ -219,17 +263,19 @@ _EVENTREPLY = _descriptor.Descriptor(
   extension_ranges=[],
   oneofs=[
   ],
-  serialized_start=502,
-  serialized_end=514,
+  serialized_start=593,
+  serialized_end=605,
 )
 
 _OBJECT_METADATAENTRY.containing_type = _OBJECT
 _OBJECT_SPECENTRY.containing_type = _OBJECT
+_OBJECT_STATEENTRY.containing_type = _OBJECT
 _OBJECT.fields_by_name['hook_type'].enum_type = coprocess__common__pb2._HOOKTYPE
 _OBJECT.fields_by_name['request'].message_type = coprocess__mini__request__object__pb2._MINIREQUESTOBJECT
 _OBJECT.fields_by_name['session'].message_type = coprocess__session__state__pb2._SESSIONSTATE
 _OBJECT.fields_by_name['metadata'].message_type = _OBJECT_METADATAENTRY
 _OBJECT.fields_by_name['spec'].message_type = _OBJECT_SPECENTRY
+_OBJECT.fields_by_name['state'].message_type = _OBJECT_STATEENTRY
 DESCRIPTOR.message_types_by_name['Object'] = _OBJECT
 DESCRIPTOR.message_types_by_name['Event'] = _EVENT
 DESCRIPTOR.message_types_by_name['EventReply'] = _EVENTREPLY
@@ -250,6 +296,13 @@ Object = _reflection.GeneratedProtocolMessageType('Object', (_message.Message,),
     # @@protoc_insertion_point(class_scope:coprocess.Object.SpecEntry)
     ))
   ,
+
+  StateEntry = _reflection.GeneratedProtocolMessageType('StateEntry', (_message.Message,), dict(
+    DESCRIPTOR = _OBJECT_STATEENTRY,
+    __module__ = 'coprocess_object_pb2'
+    # @@protoc_insertion_point(class_scope:coprocess.Object.StateEntry)
+    ))
+  ,
   DESCRIPTOR = _OBJECT,
   __module__ = 'coprocess_object_pb2'
   # @@protoc_insertion_point(class_scope:coprocess.Object)
@@ -257,6 +310,7 @@ Object = _reflection.GeneratedProtocolMessageType('Object', (_message.Message,),
 _sym_db.RegisterMessage(Object)
 _sym_db.RegisterMessage(Object.MetadataEntry)
 _sym_db.RegisterMessage(Object.SpecEntry)
+_sym_db.RegisterMessage(Object.StateEntry)
 
 Event = _reflection.GeneratedProtocolMessageType('Event', (_message.Message,), dict(
   DESCRIPTOR = _EVENT,
@@ -277,6 +331,8 @@ _OBJECT_METADATAENTRY.has_options = True
 _OBJECT_METADATAENTRY._options = _descriptor._ParseOptions(descriptor_pb2.MessageOptions(), _b('8\001'))
 _OBJECT_SPECENTRY.has_options = True
 _OBJECT_SPECENTRY._options = _descriptor._ParseOptions(descriptor_pb2.MessageOptions(), _b('8\001'))
+_OBJECT_STATEENTRY.has_options = True
+_OBJECT_STATEENTRY._options = _descriptor._ParseOptions(descriptor_pb2.MessageOptions(), _b('8\001'))
 try:
   # THESE ELEMENTS WILL BE DEPRECATED.
   # Please use the generated *_pb2_grpc.py files instead.
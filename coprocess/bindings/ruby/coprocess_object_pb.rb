@@ -14,6 +14,7 @@ Google::Protobuf::DescriptorPool.generated_pool.build do
     optional :session, :message, 4, "coprocess.SessionState"
     map :metadata, :string, :string, 5
     map :spec, :string, :string, 6
+    map :state, :string, :string, 7
   end
   add_message "coprocess.Event" do
     optional :payload, :string, 1
@@ -5,6 +5,7 @@
 Package coprocess is a generated protocol buffer package.
 
 It is generated from these files:
+
 	coprocess_common.proto
 	coprocess_mini_request_object.proto
 	coprocess_object.proto
@@ -12,6 +13,7 @@ It is generated from these files:
 	coprocess_session_state.proto
 
 It has these top-level messages:
+
 	StringSlice
 	MiniRequestObject
 	Object
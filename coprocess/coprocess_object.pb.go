@@ -24,6 +24,7 @@ type Object struct {
 	Session  *SessionState      `protobuf:"bytes,4,opt,name=session" json:"session,omitempty"`
 	Metadata map[string]string  `protobuf:"bytes,5,rep,name=metadata" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	Spec     map[string]string  `protobuf:"bytes,6,rep,name=spec" json:"spec,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	State    map[string]string  `protobuf:"bytes,7,rep,name=state" json:"state,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 }
 
 func (m *Object) Reset()                    { *m = Object{} }
@@ -73,6 +74,13 @@ func (m *Object) GetSpec() map[string]string {
 	return nil
 }
 
+func (m *Object) GetState() map[string]string {
+	if m != nil {
+		return m.State
+	}
+	return nil
+}
+
 type Event struct {
 	Payload string `protobuf:"bytes,1,opt,name=payload" json:"payload,omitempty"`
 }
@@ -211,29 +219,30 @@ var _Dispatcher_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("coprocess_object.proto", fileDescriptor2) }
 
 var fileDescriptor2 = []byte{
-	// 383 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x92, 0x4f, 0xcf, 0x93, 0x40,
-	0x10, 0xc6, 0x4b, 0xe9, 0x3f, 0xa6, 0xd6, 0xd4, 0xf5, 0x1f, 0xa1, 0x1a, 0x11, 0x2f, 0x9c, 0x50,
-	0x31, 0x51, 0xd3, 0x5e, 0x6d, 0xe2, 0xa5, 0x9a, 0x50, 0xef, 0x64, 0x4b, 0x27, 0x29, 0xb6, 0xb0,
-	0x2b, 0xbb, 0x6d, 0x42, 0xe2, 0xe7, 0x79, 0x3f, 0xe7, 0x9b, 0xee, 0x02, 0xa5, 0x6f, 0x4f, 0xef,
-	0x6d, 0xe7, 0x99, 0xe7, 0x37, 0x0f, 0x33, 0x01, 0x5e, 0x25, 0x8c, 0x17, 0x2c, 0x41, 0x21, 0x62,
-	0xb6, 0xf9, 0x8b, 0x89, 0x0c, 0x78, 0xc1, 0x24, 0x23, 0x56, 0xa3, 0x3b, 0x1f, 0x2e, 0x96, 0x2c,
-	0xcd, 0xd3, 0xb8, 0xc0, 0x7f, 0x47, 0x14, 0xf2, 0xca, 0xef, 0xbc, 0xbd, 0x98, 0x04, 0x0a, 0x91,
-	0xb2, 0x3c, 0x16, 0x92, 0x4a, 0xac, 0xda, 0xad, 0x98, 0x84, 0x65, 0x19, 0xcb, 0xb5, 0xee, 0xdd,
-	0x99, 0x30, 0xf8, 0xad, 0xe6, 0x90, 0x4f, 0x60, 0xed, 0x18, 0xdb, 0xc7, 0xb2, 0xe4, 0x68, 0x1b,
-	0xae, 0xe1, 0x3f, 0x0d, 0x9f, 0x07, 0x0d, 0x16, 0xfc, 0x64, 0x6c, 0xff, 0xa7, 0xe4, 0x18, 0x8d,
-	0x76, 0xd5, 0x8b, 0xcc, 0x2a, 0x22, 0xa7, 0x19, 0xda, 0x5d, 0xd7, 0xf0, 0x2d, 0xdd, 0xfc, 0x45,
-	0x33, 0x24, 0x5f, 0x61, 0x58, 0x7d, 0xa8, 0x6d, 0xba, 0x86, 0x3f, 0x0e, 0xdf, 0xb4, 0x86, 0xad,
-	0xd2, 0x3c, 0x8d, 0x74, 0x57, 0xa7, 0x47, 0xb5, 0x99, 0x7c, 0x86, 0x61, 0xb5, 0x80, 0xdd, 0x53,
-	0xdc, 0xeb, 0x16, 0xb7, 0xd6, 0x9d, 0xf5, 0x79, 0xb3, 0xa8, 0xf6, 0x91, 0x05, 0x8c, 0x32, 0x94,
-	0x74, 0x4b, 0x25, 0xb5, 0xfb, 0xae, 0xe9, 0x8f, 0xc3, 0x77, 0x2d, 0x46, 0x07, 0x04, 0xab, 0xca,
-	0xb1, 0xcc, 0x65, 0x51, 0x46, 0x0d, 0x40, 0x3e, 0x42, 0x4f, 0x70, 0x4c, 0xec, 0x81, 0x02, 0x67,
-	0xb7, 0xe0, 0x9a, 0x63, 0xa2, 0x21, 0x65, 0x74, 0x16, 0x30, 0xb9, 0x9a, 0x45, 0xa6, 0x60, 0xee,
-	0xb1, 0x54, 0x27, 0xb3, 0xa2, 0xf3, 0x93, 0xbc, 0x80, 0xfe, 0x89, 0x1e, 0x8e, 0xf5, 0x51, 0x74,
-	0x31, 0xef, 0x7e, 0x37, 0x9c, 0x6f, 0x60, 0x35, 0xf3, 0x1e, 0x03, 0x7a, 0xef, 0xa1, 0xbf, 0x3c,
-	0x61, 0x2e, 0x89, 0x0d, 0x43, 0x4e, 0xcb, 0x03, 0xa3, 0xdb, 0x0a, 0xac, 0x4b, 0xef, 0x09, 0x80,
-	0xb2, 0x44, 0xc8, 0x0f, 0x65, 0xf8, 0x1f, 0xe0, 0x47, 0x2a, 0x38, 0x95, 0xc9, 0x0e, 0x0b, 0x12,
-	0xc2, 0xa8, 0xae, 0xc8, 0xb3, 0x9b, 0x1d, 0x9d, 0x5b, 0xc9, 0xeb, 0x90, 0x39, 0x4c, 0x6a, 0x46,
-	0x47, 0x4f, 0x5b, 0x2e, 0xa5, 0x38, 0x2f, 0x1f, 0x2a, 0x2a, 0xdb, 0xeb, 0x6c, 0x06, 0xea, 0xf7,
-	0xfa, 0x72, 0x1f, 0x00, 0x00, 0xff, 0xff, 0x6f, 0xaf, 0x58, 0x65, 0xdf, 0x02, 0x00, 0x00,
+	// 394 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x95, 0x92, 0x4b, 0x4f, 0xc2, 0x40,
+	0x14, 0x85, 0x79, 0x95, 0xd2, 0xab, 0x18, 0x1c, 0x5f, 0x4d, 0xc1, 0x88, 0x75, 0xe3, 0xaa, 0x6a,
+	0x4d, 0x94, 0xc0, 0x56, 0x12, 0x37, 0x68, 0x52, 0xdc, 0x93, 0xa1, 0x4c, 0x02, 0x42, 0x3b, 0xb5,
+	0x1d, 0x48, 0x9a, 0xf8, 0x33, 0xfc, 0xc1, 0x0e, 0x33, 0x6d, 0x29, 0xe2, 0x86, 0x4d, 0x33, 0xf7,
+	0x9e, 0xf3, 0xcd, 0xb9, 0x73, 0x53, 0x38, 0x77, 0x69, 0x10, 0x52, 0x97, 0x44, 0xd1, 0x88, 0x8e,
+	0x3f, 0x89, 0xcb, 0x2c, 0x5e, 0x32, 0x8a, 0xb4, 0xac, 0x6f, 0xdc, 0x6c, 0x2c, 0xde, 0xcc, 0x9f,
+	0x8d, 0x42, 0xf2, 0xb5, 0x24, 0x11, 0xdb, 0xf2, 0x1b, 0x97, 0x1b, 0x53, 0xc4, 0x3f, 0x33, 0xea,
+	0x8f, 0x22, 0x86, 0x19, 0x49, 0xe4, 0x5c, 0x8c, 0x4b, 0x3d, 0x8f, 0xfa, 0xb2, 0x6f, 0xfe, 0x54,
+	0xa0, 0xfa, 0x2e, 0xee, 0x41, 0xf7, 0xa0, 0x4d, 0x29, 0x9d, 0x8f, 0x58, 0x1c, 0x10, 0xbd, 0xd8,
+	0x2e, 0xde, 0x1e, 0xd9, 0x27, 0x56, 0x86, 0x59, 0xaf, 0x5c, 0xfb, 0xe0, 0x92, 0x53, 0x9b, 0x26,
+	0x27, 0xd4, 0x4c, 0x08, 0x1f, 0x7b, 0x44, 0x2f, 0x71, 0x42, 0x93, 0xe2, 0x1b, 0xaf, 0xd1, 0x13,
+	0xa8, 0xc9, 0xa0, 0x7a, 0x99, 0x4b, 0x07, 0x76, 0x2b, 0x77, 0xd9, 0x80, 0xbf, 0xc3, 0x91, 0xaa,
+	0x4c, 0x77, 0x52, 0x33, 0x7a, 0x00, 0x35, 0x79, 0x80, 0x5e, 0x11, 0xdc, 0x45, 0x8e, 0x1b, 0x4a,
+	0x65, 0xb8, 0x7e, 0x99, 0x93, 0xfa, 0x50, 0x0f, 0x6a, 0x1e, 0x61, 0x78, 0x82, 0x19, 0xd6, 0x95,
+	0x76, 0x99, 0x33, 0x57, 0x39, 0x46, 0x06, 0x58, 0x83, 0xc4, 0xd1, 0xf7, 0x59, 0x18, 0x3b, 0x19,
+	0x80, 0xee, 0xa0, 0x12, 0x05, 0xc4, 0xd5, 0xab, 0x02, 0x6c, 0xee, 0x82, 0x43, 0xae, 0x4a, 0x48,
+	0x18, 0x91, 0x0d, 0x8a, 0xd8, 0xac, 0xae, 0x0a, 0xa2, 0xf5, 0x0f, 0xb1, 0x96, 0x25, 0x22, 0xad,
+	0x46, 0x0f, 0xea, 0x5b, 0xf9, 0xa8, 0x01, 0xe5, 0x39, 0x89, 0xc5, 0x9a, 0x35, 0x67, 0x7d, 0x44,
+	0xa7, 0xa0, 0xac, 0xf0, 0x62, 0x99, 0x2e, 0x52, 0x16, 0xdd, 0x52, 0xa7, 0x68, 0x3c, 0x83, 0x96,
+	0xcd, 0xb0, 0x17, 0xd8, 0x01, 0xd8, 0x8c, 0xb2, 0x0f, 0x69, 0x5e, 0x83, 0xd2, 0x5f, 0x11, 0x9f,
+	0x21, 0x1d, 0xd4, 0x00, 0xc7, 0x0b, 0x8a, 0x27, 0x09, 0x98, 0x96, 0xe6, 0x21, 0x80, 0xb0, 0x38,
+	0x24, 0x58, 0xc4, 0xf6, 0x37, 0xc0, 0xcb, 0x2c, 0x0a, 0x30, 0x73, 0xa7, 0x24, 0xe4, 0x2b, 0xaa,
+	0xa5, 0x15, 0x3a, 0xde, 0xd9, 0x8f, 0xb1, 0xdb, 0x32, 0x0b, 0xa8, 0x0b, 0xf5, 0x94, 0x91, 0xd1,
+	0x8d, 0x9c, 0x4b, 0x74, 0x8c, 0xb3, 0xbf, 0x1d, 0x91, 0x6d, 0x16, 0xc6, 0x55, 0xf1, 0x33, 0x3f,
+	0xfe, 0x02, 0xc5, 0x0a, 0x2f, 0xb4, 0x4d, 0x03, 0x00, 0x00,
 }
@@ -1,5 +1,5 @@
-// +build coprocess
-// +build grpc
+//go:build coprocess && grpc
+// +build coprocess,grpc
 
 package coprocess
 
@@ -27,8 +27,9 @@ type Dispatcher interface {
 	// LoadModules is called the first time a CP binding starts. Used by Lua.
 	LoadModules()
 
-	// HandleMiddlewareCache is called when a bundle has been loaded and the dispatcher needs to cache its contents. Used by Lua.
-	HandleMiddlewareCache(*apidef.BundleManifest, string)
+	// HandleMiddlewareCache is called when a bundle has been loaded and the dispatcher needs to cache its contents. Used by Lua and Python.
+	// An error makes the bundle fail to load.
+	HandleMiddlewareCache(*apidef.BundleManifest, string) error
 
 	// Reload is called when a hot reload is triggered. Used by all the CPs.
 	Reload()
@@ -33,6 +33,20 @@ function request.clear_header(key)
   object['request']['delete_headers'] = {key}
 end
 
+function request.get_state(key)
+  if object['state'] == nil then
+    return nil
+  end
+  return object['state'][key]
+end
+
+function request.set_state(key, value)
+  if object['state'] == nil then
+    object['state'] = {}
+  end
+  object['state'][key] = value
+end
+
 tyk = {
   -- req = {},
   -- req=require("coprocess.lua.tyk.request"),
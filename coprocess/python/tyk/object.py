@@ -24,6 +24,8 @@ class TykCoProcessObject:
         self.session = self.object.session
         self.spec = self.object.spec
         self.metadata = self.object.metadata
+        self.state = self.object.state
+        self.request.state = self.object.state
         self.hook_name = self.object.hook_name
 
         if self.object.hook_type == HookType.Unknown:
@@ -1,5 +1,5 @@
-// +build coprocess
-// +build !grpc
+//go:build coprocess && !grpc
+// +build coprocess,!grpc
 
 package main
 
@@ -28,6 +28,7 @@ const CoProcessDefaultKeyPrefix = "coprocess-data:"
 // TODO: implement INCR, DECR?
 
 // TykStoreData is a CoProcess API function for storing data.
+//
 //export TykStoreData
 func TykStoreData(CKey, CValue *C.char, CTTL C.int) {
 	key := C.GoString(CKey)
@@ -39,6 +40,7 @@ func TykStoreData(CKey, CValue *C.char, CTTL C.int) {
 }
 
 // TykGetData is a CoProcess API function for fetching data.
+//
 //export TykGetData
 func TykGetData(CKey *C.char) *C.char {
 	key := C.GoString(CKey)
@@ -50,6 +52,7 @@ func TykGetData(CKey *C.char) *C.char {
 }
 
 // TykTriggerEvent is a CoProcess API function for triggering Tyk system events.
+//
 //export TykTriggerEvent
 func TykTriggerEvent(CEventName, CPayload *C.char) {
 	eventName := C.GoString(CEventName)
@@ -61,6 +64,7 @@ func TykTriggerEvent(CEventName, CPayload *C.char) {
 }
 
 // CoProcessLog is a bridge for using Tyk log from CP.
+//
 //export CoProcessLog
 func CoProcessLog(CMessage, CLogLevel *C.char) {
 	message := C.GoString(CMessage)
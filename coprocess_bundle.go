@@ -20,6 +20,9 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
 // Bundle is the basic bundle data structure, it holds the bundle name and the data.
@@ -46,7 +49,8 @@ func (b *Bundle) Verify() error {
 			// Error: A public key is set, but the bundle isn't signed.
 			return errors.New("Bundle isn't signed")
 		}
-		if notificationVerifier == nil {
+		bundleVerifier = notificationVerifier
+		if bundleVerifier == nil {
 			var err error
 			bundleVerifier, err = goverify.LoadPublicKeyFromFile(config.Global.PublicKeyPath)
 			if err != nil {
@@ -55,6 +59,8 @@ func (b *Bundle) Verify() error {
 		}
 
 		useSignature = true
+	} else if config.Global.BundleRequireSignature {
+		return errors.New("Bundle signatures are required, but no public key is set")
 	}
 
 	var bundleData bytes.Buffer
@@ -93,13 +99,16 @@ func (b *Bundle) Verify() error {
 	return nil
 }
 
-// AddToSpec attaches the custom middleware settings to an API definition.
-func (b *Bundle) AddToSpec() {
-	b.Spec.CustomMiddleware = b.Manifest.CustomMiddleware
-
+// AddToSpec attaches the custom middleware settings to an API definition,
+// once the dispatcher has taken the bundle in.
+func (b *Bundle) AddToSpec() error {
 	if GlobalDispatcher != nil {
-		GlobalDispatcher.HandleMiddlewareCache(&b.Manifest, b.Path)
+		if err := GlobalDispatcher.HandleMiddlewareCache(&b.Manifest, b.Path); err != nil {
+			return fmt.Errorf("Bundle doesn't load: %v", err)
+		}
 	}
+	b.Spec.CustomMiddleware = b.Manifest.CustomMiddleware
+	return nil
 }
 
 // BundleGetter is used for downloading bundle data, see HttpBundleGetter for reference.
@@ -138,10 +147,18 @@ type ZipBundleSaver struct{}
 // Save implements the main method of the BundleSaver interface. It makes use of archive/zip.
 func (ZipBundleSaver) Save(bundle *Bundle, bundlePath string, spec *APISpec) error {
 	buf := bytes.NewReader(bundle.Data)
-	reader, _ := zip.NewReader(buf, int64(len(bundle.Data)))
+	reader, err := zip.NewReader(buf, int64(len(bundle.Data)))
+	if err != nil {
+		return err
+	}
 
 	for _, f := range reader.File {
 		destPath := filepath.Join(bundlePath, f.Name)
+		// The bundle isn't verified yet, so it mustn't write anywhere
+		// else.
+		if !strings.HasPrefix(destPath, filepath.Clean(bundlePath)+string(filepath.Separator)) {
+			return fmt.Errorf("Bundle file %q is outside the bundle", f.Name)
+		}
 
 		if f.FileHeader.Mode().IsDir() {
 			if err := os.Mkdir(destPath, 0700); err != nil {
@@ -158,6 +175,7 @@ func (ZipBundleSaver) Save(bundle *Bundle, bundlePath string, spec *APISpec) err
 			return err
 		}
 		if _, err = io.Copy(newFile, rc); err != nil {
+			newFile.Close()
 			return err
 		}
 		rc.Close()
@@ -220,9 +238,7 @@ func saveBundle(bundle *Bundle, destPath string, spec *APISpec) error {
 		bundleSaver = ZipBundleSaver{}
 	}
 
-	bundleSaver.Save(bundle, destPath, spec)
-
-	return nil
+	return bundleSaver.Save(bundle, destPath, spec)
 }
 
 // loadBundleManifest will parse the manifest file and return the bundle parameters.
@@ -253,11 +269,39 @@ func loadBundleManifest(bundle *Bundle, spec *APISpec, skipVerification bool) er
 		log.WithFields(logrus.Fields{
 			"prefix": "main",
 		}).Info("----> Bundle verification failed: ", spec.CustomMiddlewareBundle)
+		return err
 	}
 	return nil
 }
 
-// loadBundle wraps the load and save steps, it will return if an error occurs at any point.
+// bundleLoadedMarker is the file in a bundle's directory whose
+// modification time is when the bundle last loaded.
+const bundleLoadedMarker = ".tyk-loaded"
+
+// bundleDirName returns the directory, in the bundles directory, of a
+// version of the API's bundle. Unversioned bundles keep the name they
+// always had.
+func bundleDirName(spec *APISpec, version string) string {
+	name := spec.APIID + "-" + spec.CustomMiddlewareBundle
+	if version != "" {
+		name += "@" + version
+	}
+	return name
+}
+
+// loadedBundleDir returns the directory, in the bundles directory, of
+// the API's bundle as it was loaded, which may be a version it fell back
+// to.
+func (s *APISpec) loadedBundleDir() string {
+	if s.bundleDir != "" {
+		return s.bundleDir
+	}
+	return bundleDirName(s, s.CustomMiddlewareBundleVersion)
+}
+
+// loadBundle loads the API's bundle, at the version it pins if any. If
+// that fails, it falls back to the version that loaded most recently,
+// and fires EventBundleFailure either way.
 func loadBundle(spec *APISpec) {
 	// Skip if no custom middleware bundle name is set.
 	if spec.CustomMiddlewareBundle == "" {
@@ -270,81 +314,229 @@ func loadBundle(spec *APISpec) {
 		return
 	}
 
-	tykBundlePath := filepath.Join(config.Global.MiddlewarePath, "bundles")
-	// Skip if the bundle destination path already exists.
-	bundlePath := spec.APIID + "-" + spec.CustomMiddlewareBundle
-	destPath := filepath.Join(tykBundlePath, bundlePath)
+	version := spec.CustomMiddlewareBundleVersion
+	err := loadBundleVersion(spec, version)
+	if err == nil {
+		pruneBundles(spec)
+		return
+	}
+	bundleError(spec, err, "Couldn't load bundle")
+
+	meta := EventBundleFailureMeta{
+		EventMetaDefault: EventMetaDefault{Message: err.Error()},
+		APIID:            spec.APIID,
+		Bundle:           spec.CustomMiddlewareBundle,
+		Version:          version,
+	}
+	for _, v := range bundleVersions(spec) {
+		if v.version == version || v.loaded.IsZero() {
+			continue
+		}
+		if err := loadBundleVersion(spec, v.version); err != nil {
+			bundleError(spec, err, "Couldn't fall back to bundle version "+v.version)
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"prefix": "main",
+		}).Warning("----> Fell back to bundle version ", v.version, ": ", spec.CustomMiddlewareBundle)
+		meta.RolledBack = true
+		meta.RolledBackTo = v.version
+		break
+	}
+	spec.FireEvent(EventBundleFailure, meta)
+}
+
+// loadBundleVersion loads a version of the API's bundle, fetching it if
+// it isn't on disk yet, and adds its middleware to the spec.
+func loadBundleVersion(spec *APISpec, version string) error {
+	if version != "" && (filepath.Base(version) != version || version == "..") {
+		return fmt.Errorf("Invalid bundle version %q", version)
+	}
+	dir := bundleDirName(spec, version)
+	destPath := filepath.Join(config.Global.MiddlewarePath, "bundles", dir)
+
+	bundle := Bundle{
+		Name: spec.CustomMiddlewareBundle,
+		Path: destPath,
+		Spec: spec,
+	}
 
-	// The bundle exists, load and return:
 	if _, err := os.Stat(destPath); err == nil {
 		log.WithFields(logrus.Fields{
 			"prefix": "main",
 		}).Info("Loading existing bundle: ", spec.CustomMiddlewareBundle)
 
-		bundle := Bundle{
-			Name: spec.CustomMiddlewareBundle,
-			Path: destPath,
-			Spec: spec,
+		// It was verified when it was fetched, but check it again if
+		// signatures are required, in case it was changed since
+		skipVerification := !config.Global.BundleRequireSignature
+		if err := loadBundleManifest(&bundle, spec, skipVerification); err != nil {
+			return err
 		}
-
-		err = loadBundleManifest(&bundle, spec, true)
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				"prefix": "main",
-			}).Info("----> Couldn't load bundle: ", spec.CustomMiddlewareBundle, " ", err)
+	} else {
+		if err := fetchAndSaveBundle(&bundle, version); err != nil {
+			return err
 		}
+	}
 
-		log.WithFields(logrus.Fields{
-			"prefix": "main",
-		}).Info("----> Using bundle: ", spec.CustomMiddlewareBundle)
+	spec.bundleDir = dir
+	if err := bundle.check(); err != nil {
+		spec.bundleDir = ""
+		return err
+	}
 
-		bundle.AddToSpec()
+	log.WithFields(logrus.Fields{
+		"prefix": "main",
+	}).Info("----> Using bundle: ", spec.CustomMiddlewareBundle)
 
-		return
+	if err := bundle.AddToSpec(); err != nil {
+		spec.bundleDir = ""
+		return err
 	}
 
+	marker := filepath.Join(destPath, bundleLoadedMarker)
+	if err := ioutil.WriteFile(marker, nil, 0600); err != nil {
+		bundleError(spec, err, "Couldn't mark bundle as loaded")
+	}
+	return nil
+}
+
+func fetchAndSaveBundle(bundle *Bundle, version string) error {
+	spec := bundle.Spec
+
 	log.WithFields(logrus.Fields{
 		"prefix": "main",
 	}).Info("----> Fetching Bundle: ", spec.CustomMiddlewareBundle)
 
-	bundle, err := fetchBundle(spec)
+	fetched, err := fetchBundle(spec)
 	if err != nil {
-		bundleError(spec, err, "Couldn't fetch bundle")
-		return
+		return fmt.Errorf("Couldn't fetch bundle: %v", err)
 	}
+	bundle.Data = fetched.Data
 
-	if err := os.MkdirAll(destPath, 0700); err != nil {
-		bundleError(spec, err, "Couldn't create bundle directory")
-		return
-	}
-
-	if err := saveBundle(&bundle, destPath, spec); err != nil {
-		bundleError(spec, err, "Couldn't save bundle")
-		return
+	if err := os.MkdirAll(bundle.Path, 0700); err != nil {
+		return fmt.Errorf("Couldn't create bundle directory: %v", err)
 	}
 
 	log.WithFields(logrus.Fields{
 		"prefix": "main",
 	}).Debug("----> Saving Bundle: ", spec.CustomMiddlewareBundle)
 
-	// Set the destination path:
-	bundle.Path = destPath
-
-	if err := loadBundleManifest(&bundle, spec, false); err != nil {
-		bundleError(spec, err, "Couldn't load bundle")
-
+	err = saveBundle(bundle, bundle.Path, spec)
+	if err == nil {
+		err = loadBundleManifest(bundle, spec, false)
+	}
+	if err == nil && version != "" && bundle.Manifest.Version != version {
+		err = fmt.Errorf("Bundle is version %q, not %q", bundle.Manifest.Version, version)
+	}
+	if err != nil {
 		if err := os.RemoveAll(bundle.Path); err != nil {
 			bundleError(spec, err, "Couldn't remove bundle")
 		}
-		return
+		return err
 	}
 
 	log.WithFields(logrus.Fields{
 		"prefix": "main",
 	}).Info("----> Bundle is valid, adding to spec: ", spec.CustomMiddlewareBundle)
+	return nil
+}
 
-	bundle.AddToSpec()
+// check makes sure that the bundle's middleware can be loaded: that the
+// files its hooks name are there and, for the drivers that run them in
+// the gateway, that they load.
+func (b *Bundle) check() error {
+	mw := b.Manifest.CustomMiddleware
+	hooks := []apidef.MiddlewareDefinition{mw.AuthCheck}
+	for _, list := range [][]apidef.MiddlewareDefinition{mw.Pre, mw.PostKeyAuth, mw.Post, mw.Response} {
+		hooks = append(hooks, list...)
+	}
+	var jsPaths []string
+	for _, hook := range hooks {
+		if hook.Path == "" {
+			continue
+		}
+		path := customMiddlewarePath(b.Spec, hook.Path)
+		var err error
+		switch mw.Driver {
+		case apidef.WasmDriver:
+			var p *wasmPlugin
+			if p, err = b.Spec.wasmPlugin(path); err == nil {
+				err = p.checkHook(hook.Name)
+			}
+		case apidef.GoPluginDriver:
+			_, err = loadGoPluginHandler(path, hook.Name)
+		case "", apidef.OttoDriver:
+			jsPaths = append(jsPaths, path)
+			_, err = os.Stat(path)
+		default:
+			_, err = os.Stat(path)
+		}
+		if err != nil {
+			return fmt.Errorf("Bundle hook %s doesn't load: %v", hook.Name, err)
+		}
+	}
+	if len(jsPaths) > 0 && config.Global.EnableJSVM {
+		// in a JSVM of their own, so that a version that fails to
+		// load leaves nothing behind in the API's
+		jsvm := JSVM{}
+		jsvm.Init(b.Spec)
+		if err := jsvm.LoadJSPaths(jsPaths, ""); err != nil {
+			return fmt.Errorf("Bundle middleware doesn't load: %v", err)
+		}
+	}
+	return nil
+}
+
+// bundleVersion is a version of an API's bundle on disk. loaded is
+// when it last loaded, if it ever did.
+type bundleVersion struct {
+	dir     string
+	version string
+	loaded  time.Time
+}
 
+// bundleVersions returns the versions of the API's bundle on disk, the
+// most recently loaded first.
+func bundleVersions(spec *APISpec) []bundleVersion {
+	bundlesPath := filepath.Join(config.Global.MiddlewarePath, "bundles")
+	infos, err := ioutil.ReadDir(bundlesPath)
+	if err != nil {
+		return nil
+	}
+	base := bundleDirName(spec, "")
+	var versions []bundleVersion
+	for _, info := range infos {
+		name := info.Name()
+		if !info.IsDir() || (name != base && !strings.HasPrefix(name, base+"@")) {
+			continue
+		}
+		v := bundleVersion{dir: name, version: strings.TrimPrefix(name[len(base):], "@")}
+		if marker, err := os.Stat(filepath.Join(bundlesPath, name, bundleLoadedMarker)); err == nil {
+			v.loaded = marker.ModTime()
+		}
+		versions = append(versions, v)
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].loaded.After(versions[j].loaded)
+	})
+	return versions
+}
+
+// pruneBundles removes all but the most recently loaded versions of the
+// API's bundle.
+func pruneBundles(spec *APISpec) {
+	keep := config.Global.BundleKeepVersions
+	if keep <= 0 {
+		keep = 3
+	}
+	for i, v := range bundleVersions(spec) {
+		if i < keep || v.dir == spec.bundleDir {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(config.Global.MiddlewarePath, "bundles", v.dir)); err != nil {
+			bundleError(spec, err, "Couldn't remove old bundle")
+		}
+	}
 }
 
 // bundleError is a log helper.
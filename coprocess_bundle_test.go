@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TykTechnologies/goverify"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+)
+
+type bundleEventHandler struct {
+	failures chan EventBundleFailureMeta
+}
+
+func (h *bundleEventHandler) Init(interface{}) error { return nil }
+
+func (h *bundleEventHandler) HandleEvent(em config.EventMessage) {
+	h.failures <- em.Meta.(EventBundleFailureMeta)
+}
+
+// testBundle builds a bundle with a pre hook named after the version,
+// signed if a signer is given. Missing is a file the hook names that
+// isn't in the bundle, if any.
+func testBundle(t *testing.T, version, missing string, signer goverify.Signer) []byte {
+	return testBundleJS(t, version, "// "+version, missing, signer)
+}
+
+// testBundleJS is testBundle with the pre hook's source.
+func testBundleJS(t *testing.T, version, js, missing string, signer goverify.Signer) []byte {
+	files := map[string]string{"pre.js": js}
+	path := "pre.js"
+	if missing != "" {
+		path = missing
+	}
+	manifest := apidef.BundleManifest{
+		FileList: []string{"pre.js"},
+		Version:  version,
+		CustomMiddleware: apidef.MiddlewareSection{
+			Pre: []apidef.MiddlewareDefinition{{Name: "v" + version, Path: path}},
+		},
+	}
+	manifest.Checksum = fmt.Sprintf("%x", md5.Sum([]byte(files["pre.js"])))
+	if signer != nil {
+		sig, err := signer.Sign([]byte(files["pre.js"]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest.Signature = base64.StdEncoding.EncodeToString(sig)
+	}
+	manifestJSON, _ := json.Marshal(manifest)
+	files["manifest.json"] = string(manifestJSON)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBundleVersions(t *testing.T) {
+	var served []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(served)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "tyk-bundles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pubKeyPath := filepath.Join(dir, "pub.pem")
+	if err := ioutil.WriteFile(pubKeyPath, []byte(jwtRSAPubKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := goverify.LoadPrivateKeyFromString(jwtRSAPrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := config.Global
+	oldVerifier := notificationVerifier
+	defer func() {
+		config.Global = old
+		notificationVerifier = oldVerifier
+	}()
+	notificationVerifier = nil
+	config.Global.MiddlewarePath = dir
+	config.Global.BundleBaseURL = server.URL + "/"
+	config.Global.EnableBundleDownloader = true
+	config.Global.BundleKeepVersions = 2
+
+	handler := &bundleEventHandler{failures: make(chan EventBundleFailureMeta, 1)}
+	load := func(version string) *APISpec {
+		spec := &APISpec{
+			APIDefinition: &apidef.APIDefinition{
+				APIID:                         "bundled",
+				CustomMiddlewareBundle:        "bundle.zip",
+				CustomMiddlewareBundleVersion: version,
+			},
+			EventPaths: map[apidef.TykEvent][]config.TykEventHandler{
+				EventBundleFailure: {handler},
+			},
+		}
+		loadBundle(spec)
+		return spec
+	}
+	hook := func(spec *APISpec) string {
+		if len(spec.CustomMiddleware.Pre) == 0 {
+			return ""
+		}
+		return spec.CustomMiddleware.Pre[0].Name
+	}
+	wantFailure := func(version string, rolledBackTo string) {
+		select {
+		case meta := <-handler.failures:
+			if meta.Version != version || meta.RolledBack != (rolledBackTo != "") || meta.RolledBackTo != rolledBackTo {
+				t.Fatalf("wanted version %q to roll back to %q, got %+v", version, rolledBackTo, meta)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("wanted version %q to fail", version)
+		}
+	}
+	onDisk := func() []string {
+		var dirs []string
+		for _, v := range bundleVersions(&APISpec{APIDefinition: &apidef.APIDefinition{
+			APIID:                  "bundled",
+			CustomMiddlewareBundle: "bundle.zip",
+		}}) {
+			dirs = append(dirs, v.version)
+		}
+		sort.Strings(dirs)
+		return dirs
+	}
+
+	served = testBundle(t, "1", "", nil)
+	if spec := load("1"); hook(spec) != "v1" {
+		t.Fatalf("wanted version 1 to load, got %q", hook(spec))
+	}
+
+	// a bundle whose middleware doesn't load falls back
+	served = testBundle(t, "2", "missing.js", nil)
+	if spec := load("2"); hook(spec) != "v1" || spec.loadedBundleDir() != "bundled-bundle.zip@1" {
+		t.Fatalf("wanted version 2 to fall back to 1, got %q", hook(spec))
+	}
+	wantFailure("2", "1")
+
+	// as does one that isn't the version asked for
+	served = testBundle(t, "3", "", nil)
+	if spec := load("4"); hook(spec) != "v1" {
+		t.Fatalf("wanted version 4 to fall back to 1, got %q", hook(spec))
+	}
+	wantFailure("4", "1")
+
+	// versions on disk are used without fetching them again
+	served = nil
+	if spec := load("1"); hook(spec) != "v1" {
+		t.Fatalf("wanted version 1 to load from disk, got %q", hook(spec))
+	}
+
+	served = testBundle(t, "3", "", nil)
+	if spec := load("3"); hook(spec) != "v3" {
+		t.Fatalf("wanted version 3 to load, got %q", hook(spec))
+	}
+	if got := strings.Join(onDisk(), ","); got != "1,3" {
+		t.Fatalf("wanted the last two versions kept, got %s", got)
+	}
+
+	// with signatures required, unsigned bundles are refused, even
+	// without a key, and so are those already on disk
+	config.Global.BundleRequireSignature = true
+	served = testBundle(t, "5", "", nil)
+	if spec := load("5"); hook(spec) != "" {
+		t.Fatalf("wanted version 5 not to load, got %q", hook(spec))
+	}
+	wantFailure("5", "")
+
+	config.Global.PublicKeyPath = pubKeyPath
+	served = testBundle(t, "6", "", nil)
+	if spec := load("6"); hook(spec) != "" {
+		t.Fatalf("wanted version 6 not to load, got %q", hook(spec))
+	}
+	wantFailure("6", "")
+
+	served = testBundle(t, "7", "", signer)
+	if spec := load("7"); hook(spec) != "v7" {
+		t.Fatalf("wanted signed version 7 to load, got %q", hook(spec))
+	}
+	served = testBundle(t, "8", "missing.js", signer)
+	if spec := load("8"); hook(spec) != "v7" {
+		t.Fatalf("wanted version 8 to fall back to 7, got %q", hook(spec))
+	}
+	wantFailure("8", "7")
+
+	// and so does one whose JS doesn't load
+	config.Global.EnableJSVM = true
+	served = testBundleJS(t, "9", "function (", "", signer)
+	if spec := load("9"); hook(spec) != "v7" {
+		t.Fatalf("wanted version 9 to fall back to 7, got %q", hook(spec))
+	}
+	wantFailure("9", "7")
+}
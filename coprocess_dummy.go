@@ -1,3 +1,4 @@
+//go:build !coprocess
 // +build !coprocess
 
 package main
@@ -19,7 +20,7 @@ const (
 type Dispatcher interface {
 	DispatchEvent([]byte)
 	LoadModules()
-	HandleMiddlewareCache(*apidef.BundleManifest, string)
+	HandleMiddlewareCache(*apidef.BundleManifest, string) error
 	Reload()
 }
 
@@ -33,6 +34,8 @@ type CoProcessMiddleware struct {
 	HookType         coprocess.HookType
 	HookName         string
 	MiddlewareDriver apidef.MiddlewareDriver
+	Timeout          int64
+	FailOpen         bool
 }
 
 func (m *CoProcessMiddleware) Name() string {
@@ -1,3 +1,4 @@
+//go:build coprocess
 // +build coprocess
 
 package main
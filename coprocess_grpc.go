@@ -1,5 +1,5 @@
-// +build coprocess
-// +build grpc
+//go:build coprocess && grpc
+// +build coprocess,grpc
 
 package main
 
@@ -7,11 +7,14 @@ import (
 	"errors"
 	"net"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 
 	"github.com/TykTechnologies/tyk/apidef"
 	"github.com/TykTechnologies/tyk/config"
@@ -24,38 +27,196 @@ const CoProcessName = apidef.GrpcDriver
 // MessageType sets the default message type.
 var MessageType = coprocess.ProtobufMessage
 
-var grpcConnection *grpc.ClientConn
-var grpcClient coprocess.DispatcherClient
+const (
+	grpcDefaultTimeout             = 5000 // milliseconds
+	grpcDefaultHealthCheckInterval = 5    // seconds
+)
 
-// GRPCDispatcher implements a coprocess.Dispatcher
+// GRPCDispatcher implements a coprocess.Dispatcher. It sends hooks to the
+// first healthy server of coprocess_grpc_server and
+// coprocess_grpc_servers, failing over to the next one when a server
+// can't be reached. Servers that go down are checked in the background
+// until they're back, until the dispatcher is closed.
 type GRPCDispatcher struct {
 	coprocess.Dispatcher
+	servers []*grpcServer
+
+	stop      chan struct{}
+	closeOnce sync.Once
 }
 
-func dialer(addr string, timeout time.Duration) (net.Conn, error) {
-	grpcUrl, err := url.Parse(config.Global.CoProcessOptions.CoProcessGRPCServer)
+// grpcServer is a plugin server and the pool of connections to it.
+type grpcServer struct {
+	addr    string
+	conns   []*grpc.ClientConn
+	clients []coprocess.DispatcherClient
+	next    uint32
+	down    int32
+}
+
+func grpcDialer(addr string) (func(string, time.Duration) (net.Conn, error), error) {
+	grpcUrl, err := url.Parse(addr)
 	if err != nil {
-		log.WithFields(logrus.Fields{
-			"prefix": "coprocess-grpc",
-		}).Error(err)
 		return nil, err
 	}
+	if addr == "" || grpcUrl.Scheme == "" {
+		return nil, errors.New("No gRPC URL is set!")
+	}
+	network, address := grpcUrl.Scheme, addr[len(grpcUrl.Scheme)+3:]
+	return func(_ string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout(network, address, timeout)
+	}, nil
+}
+
+// newGRPCServer connects to a server. Connections that break retry at
+// least as often as servers are health checked, so that they're ready
+// when a server is back.
+func newGRPCServer(addr string, poolSize int, interval time.Duration) (*grpcServer, error) {
+	dialer, err := grpcDialer(addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &grpcServer{addr: addr}
+	for i := 0; i < poolSize; i++ {
+		conn, err := grpc.Dial("", grpc.WithInsecure(), grpc.WithDialer(dialer),
+			grpc.WithBackoffMaxDelay(interval))
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.conns = append(s.conns, conn)
+		s.clients = append(s.clients, coprocess.NewDispatcherClient(conn))
+	}
+	return s, nil
+}
+
+// client picks the next connection in the pool.
+func (s *grpcServer) client() coprocess.DispatcherClient {
+	n := atomic.AddUint32(&s.next, 1)
+	return s.clients[int(n)%len(s.clients)]
+}
 
-	if grpcUrl == nil || config.Global.CoProcessOptions.CoProcessGRPCServer == "" {
-		errString := "No gRPC URL is set!"
+func (s *grpcServer) isDown() bool {
+	return atomic.LoadInt32(&s.down) == 1
+}
+
+func (s *grpcServer) setDown(down bool, err error) {
+	var v int32
+	if down {
+		v = 1
+	}
+	if atomic.SwapInt32(&s.down, v) == v {
+		return
+	}
+	if down {
 		log.WithFields(logrus.Fields{
 			"prefix": "coprocess-grpc",
-		}).Error(errString)
-		return nil, errors.New(errString)
+		}).Warning("gRPC server ", s.addr, " is down: ", err)
+	} else {
+		log.WithFields(logrus.Fields{
+			"prefix": "coprocess-grpc",
+		}).Info("gRPC server ", s.addr, " is back up")
+	}
+}
+
+// check tells whether the server accepts connections again.
+func (s *grpcServer) check() bool {
+	dialer, _ := grpcDialer(s.addr)
+	conn, err := dialer("", time.Second)
+	if err != nil {
+		return false
 	}
+	conn.Close()
+	return true
+}
 
-	grpcUrlString := config.Global.CoProcessOptions.CoProcessGRPCServer[len(grpcUrl.Scheme)+3:]
-	return net.DialTimeout(grpcUrl.Scheme, grpcUrlString, timeout)
+func (s *grpcServer) close() {
+	for _, conn := range s.conns {
+		conn.Close()
+	}
 }
 
-// Dispatch takes a CoProcessMessage and sends it to the CP.
-func (d *GRPCDispatcher) DispatchObject(object *coprocess.Object) (*coprocess.Object, error) {
-	newObject, err := grpcClient.Dispatch(context.Background(), object)
+// order returns the servers to try, the healthy ones first. Those that
+// are down are still tried last, as they may be back before the health
+// check notices.
+func (d *GRPCDispatcher) order() []*grpcServer {
+	servers := make([]*grpcServer, 0, len(d.servers))
+	for _, s := range d.servers {
+		if !s.isDown() {
+			servers = append(servers, s)
+		}
+	}
+	for _, s := range d.servers {
+		if s.isDown() {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
+// call runs fn against each server in turn until one can be reached.
+// Other errors, such as running out of time, aren't retried.
+func (d *GRPCDispatcher) call(fn func(coprocess.DispatcherClient) error) error {
+	var err error
+	for _, s := range d.order() {
+		err = fn(s.client())
+		if grpc.Code(err) != codes.Unavailable {
+			if err == nil {
+				s.setDown(false, nil)
+			}
+			return err
+		}
+		s.setDown(true, err)
+	}
+	return err
+}
+
+func (d *GRPCDispatcher) healthCheck(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-t.C:
+		}
+		for _, s := range d.servers {
+			if s.isDown() && s.check() {
+				s.setDown(false, nil)
+			}
+		}
+	}
+}
+
+// Close stops the health checks and closes the connections to the
+// servers.
+func (d *GRPCDispatcher) Close() {
+	d.closeOnce.Do(func() {
+		close(d.stop)
+		for _, s := range d.servers {
+			s.close()
+		}
+	})
+}
+
+// dispatch sends an object to a plugin server, giving it until the
+// timeout to reply. The deadline is in the object's metadata, as RFC
+// 3339, for servers to respect.
+func (d *GRPCDispatcher) dispatch(object *coprocess.Object, timeout time.Duration) (*coprocess.Object, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	if object.Metadata == nil {
+		object.Metadata = make(map[string]string)
+	}
+	object.Metadata["deadline"] = deadline.UTC().Format(time.RFC3339Nano)
+
+	var newObject *coprocess.Object
+	err := d.call(func(client coprocess.DispatcherClient) error {
+		var err error
+		newObject, err = client.Dispatch(ctx, object)
+		return err
+	})
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"prefix": "coprocess-grpc",
@@ -64,13 +225,23 @@ func (d *GRPCDispatcher) DispatchObject(object *coprocess.Object) (*coprocess.Ob
 	return newObject, err
 }
 
+// Dispatch takes a CoProcessMessage and sends it to the CP.
+func (d *GRPCDispatcher) DispatchObject(object *coprocess.Object) (*coprocess.Object, error) {
+	return d.dispatch(object, grpcTimeout(0))
+}
+
 // DispatchEvent dispatches a Tyk event.
 func (d *GRPCDispatcher) DispatchEvent(eventJSON []byte) {
 	eventObject := &coprocess.Event{
 		Payload: string(eventJSON),
 	}
 
-	_, err := grpcClient.DispatchEvent(context.Background(), eventObject)
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout(0))
+	defer cancel()
+	err := d.call(func(client coprocess.DispatcherClient) error {
+		_, err := client.DispatchEvent(ctx, eventObject)
+		return err
+	})
 
 	if err != nil {
 		log.WithFields(logrus.Fields{
@@ -83,24 +254,62 @@ func (d *GRPCDispatcher) DispatchEvent(eventJSON []byte) {
 func (d *GRPCDispatcher) Reload() {}
 
 // HandleMiddlewareCache isn't used by gRPC.
-func (d *GRPCDispatcher) HandleMiddlewareCache(b *apidef.BundleManifest, basePath string) {}
+func (d *GRPCDispatcher) HandleMiddlewareCache(b *apidef.BundleManifest, basePath string) error {
+	return nil
+}
+
+// grpcTimeout returns how long a hook may take, given its own timeout
+// in milliseconds.
+func grpcTimeout(hookTimeout int64) time.Duration {
+	ms := hookTimeout
+	if ms <= 0 {
+		ms = config.Global.CoProcessOptions.GRPCTimeout
+	}
+	if ms <= 0 {
+		ms = grpcDefaultTimeout
+	}
+	return time.Duration(ms) * time.Millisecond
+}
 
 // NewCoProcessDispatcher wraps all the actions needed for this CP.
 func NewCoProcessDispatcher() (coprocess.Dispatcher, error) {
-	var err error
-	grpcConnection, err = grpc.Dial("", grpc.WithInsecure(), grpc.WithDialer(dialer))
-	grpcClient = coprocess.NewDispatcherClient(grpcConnection)
+	opts := config.Global.CoProcessOptions
+	addrs := opts.CoProcessGRPCServers
+	if opts.CoProcessGRPCServer != "" || len(addrs) == 0 {
+		addrs = append([]string{opts.CoProcessGRPCServer}, addrs...)
+	}
+	poolSize := opts.GRPCPoolSize
+	if poolSize <= 0 {
+		poolSize = 1
+	}
+	interval := time.Duration(opts.GRPCHealthCheckInterval) * time.Second
+	if interval <= 0 {
+		interval = grpcDefaultHealthCheckInterval * time.Second
+	}
 
-	if err != nil {
-		log.WithFields(logrus.Fields{
-			"prefix": "coprocess-grpc",
-		}).Error(err)
-		return nil, err
+	d := &GRPCDispatcher{stop: make(chan struct{})}
+	for _, addr := range addrs {
+		s, err := newGRPCServer(addr, poolSize, interval)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "coprocess-grpc",
+			}).Error(err)
+			for _, s := range d.servers {
+				s.close()
+			}
+			return nil, err
+		}
+		d.servers = append(d.servers, s)
 	}
-	return &GRPCDispatcher{}, nil
+	go d.healthCheck(interval)
+	return d, nil
 }
 
 // Dispatch prepares a CoProcessMessage, sends it to the GlobalDispatcher and gets a reply.
 func (c *CoProcessor) Dispatch(object *coprocess.Object) (*coprocess.Object, error) {
-	return GlobalDispatcher.DispatchObject(object)
+	var hookTimeout int64
+	if c.Middleware != nil {
+		hookTimeout = c.Middleware.Timeout
+	}
+	return GlobalDispatcher.(*GRPCDispatcher).dispatch(object, grpcTimeout(hookTimeout))
 }
@@ -0,0 +1,207 @@
+//go:build coprocess && grpc
+// +build coprocess,grpc
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/coprocess"
+)
+
+// testGRPCPlugin says which server it is and the deadline it got, after
+// waiting for delay or the deadline.
+type testGRPCPlugin struct {
+	name  string
+	delay time.Duration
+}
+
+func (p testGRPCPlugin) Dispatch(ctx context.Context, object *coprocess.Object) (*coprocess.Object, error) {
+	select {
+	case <-time.After(p.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	object.Request.SetHeaders = map[string]string{
+		"X-Server":   p.name,
+		"X-Deadline": object.Metadata["deadline"],
+	}
+	return object, nil
+}
+
+func (testGRPCPlugin) DispatchEvent(ctx context.Context, event *coprocess.Event) (*coprocess.EventReply, error) {
+	return &coprocess.EventReply{}, nil
+}
+
+func startGRPCPlugin(t *testing.T, addr string, plugin testGRPCPlugin) (string, func()) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	coprocess.RegisterDispatcherServer(server, plugin)
+	go server.Serve(ln)
+	return ln.Addr().String(), server.Stop
+}
+
+// downGRPCAddr returns an address nothing listens on.
+func downGRPCAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	return ln.Addr().String()
+}
+
+func testGRPCDispatcher(t *testing.T, primary string, others ...string) (*GRPCDispatcher, func()) {
+	old := config.Global.CoProcessOptions
+	config.Global.CoProcessOptions.CoProcessGRPCServer = "tcp://" + primary
+	config.Global.CoProcessOptions.CoProcessGRPCServers = nil
+	for _, addr := range others {
+		config.Global.CoProcessOptions.CoProcessGRPCServers = append(
+			config.Global.CoProcessOptions.CoProcessGRPCServers, "tcp://"+addr)
+	}
+	config.Global.CoProcessOptions.GRPCPoolSize = 2
+	config.Global.CoProcessOptions.GRPCHealthCheckInterval = 1
+	d, err := NewCoProcessDispatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDispatcher := GlobalDispatcher
+	GlobalDispatcher = d
+	return d.(*GRPCDispatcher), func() {
+		GlobalDispatcher = oldDispatcher
+		config.Global.CoProcessOptions = old
+		d.(*GRPCDispatcher).Close()
+	}
+}
+
+func testGRPCObject() *coprocess.Object {
+	return &coprocess.Object{Request: &coprocess.MiniRequestObject{}}
+}
+
+func TestGRPCDispatcherFailover(t *testing.T) {
+	down := downGRPCAddr(t)
+	up, stop := startGRPCPlugin(t, "127.0.0.1:0", testGRPCPlugin{name: "backup"})
+	defer stop()
+	d, cleanup := testGRPCDispatcher(t, down, up)
+	defer cleanup()
+
+	start := time.Now()
+	reply, err := d.dispatch(testGRPCObject(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reply.Request.SetHeaders["X-Server"]; got != "backup" {
+		t.Fatalf("wanted the backup server to reply, got %q", got)
+	}
+	deadline, err := time.Parse(time.RFC3339Nano, reply.Request.SetHeaders["X-Deadline"])
+	if err != nil {
+		t.Fatalf("wanted the deadline in the metadata: %v", err)
+	}
+	if deadline.Before(start) || deadline.After(start.Add(time.Second+100*time.Millisecond)) {
+		t.Fatalf("wanted a deadline a second from %v, got %v", start, deadline)
+	}
+	if !d.servers[0].isDown() || d.servers[1].isDown() {
+		t.Fatal("wanted the primary server to be down and the backup up")
+	}
+
+	// the primary comes back and is used again once it's checked
+	_, stop = startGRPCPlugin(t, down, testGRPCPlugin{name: "primary"})
+	defer stop()
+	for i := 0; i < 30 && d.servers[0].isDown(); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if d.servers[0].isDown() {
+		t.Fatal("wanted the primary server to be checked and back up")
+	}
+	reply, err = d.dispatch(testGRPCObject(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reply.Request.SetHeaders["X-Server"]; got != "primary" {
+		t.Fatalf("wanted the primary server to reply, got %q", got)
+	}
+}
+
+func TestGRPCDispatcherClose(t *testing.T) {
+	down := downGRPCAddr(t)
+	d, cleanup := testGRPCDispatcher(t, down)
+	defer cleanup()
+
+	if _, err := d.dispatch(testGRPCObject(), time.Second); err == nil {
+		t.Fatal("wanted an error with the server down")
+	}
+	d.Close()
+	d.Close()
+
+	// the server isn't checked once the dispatcher is closed
+	_, stop := startGRPCPlugin(t, down, testGRPCPlugin{name: "primary"})
+	defer stop()
+	time.Sleep(1500 * time.Millisecond)
+	if !d.servers[0].isDown() {
+		t.Fatal("wanted the health checks to stop on close")
+	}
+}
+
+func TestGRPCDispatcherTimeout(t *testing.T) {
+	slow, stop := startGRPCPlugin(t, "127.0.0.1:0", testGRPCPlugin{delay: time.Second})
+	defer stop()
+	fast, stop := startGRPCPlugin(t, "127.0.0.1:0", testGRPCPlugin{})
+	defer stop()
+	d, cleanup := testGRPCDispatcher(t, slow, fast)
+	defer cleanup()
+
+	start := time.Now()
+	_, err := (&CoProcessor{Middleware: &CoProcessMiddleware{Timeout: 50}}).Dispatch(testGRPCObject())
+	if grpc.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("wanted the hook's deadline to be exceeded, got %v", err)
+	}
+	if took := time.Since(start); took > 500*time.Millisecond {
+		t.Fatalf("wanted the call to stop at its deadline, took %v", took)
+	}
+	if d.servers[0].isDown() {
+		t.Fatal("wanted a slow server not to be taken as down")
+	}
+}
+
+func TestGRPCFailOpen(t *testing.T) {
+	_, cleanup := testGRPCDispatcher(t, downGRPCAddr(t))
+	defer cleanup()
+	oldEnable := EnableCoProcess
+	EnableCoProcess = true
+	defer func() { EnableCoProcess = oldEnable }()
+
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{}}
+	for _, failOpen := range []bool{false, true} {
+		mw := pluginMiddleware(BaseMiddleware{Spec: spec}, coprocess.HookType_Pre,
+			apidef.MiddlewareDefinition{Name: "hook", FailOpen: failOpen}, apidef.GrpcDriver)
+		r := httptest.NewRequest("POST", "/foo", strings.NewReader("body"))
+		_, code := mw.ProcessRequest(httptest.NewRecorder(), r, nil)
+		want := 500
+		if failOpen {
+			want = 200
+		}
+		if code != want {
+			t.Fatalf("fail open %v: wanted %d, got %d", failOpen, want, code)
+		}
+		if failOpen {
+			body, _ := ioutil.ReadAll(r.Body)
+			if string(body) != "body" {
+				t.Fatalf("wanted the request body to be kept, got %q", body)
+			}
+		}
+	}
+}
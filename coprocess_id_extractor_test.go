@@ -1,5 +1,5 @@
-// +build coprocess
-// +build !python
+//go:build coprocess && !python
+// +build coprocess,!python
 
 package main
 
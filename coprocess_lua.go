@@ -1,5 +1,5 @@
-// +build coprocess
-// +build lua
+//go:build coprocess && lua
+// +build coprocess,lua
 
 package main
 
@@ -139,18 +139,19 @@ func (d *LuaDispatcher) Reload() {
 	}
 }
 
-func (d *LuaDispatcher) HandleMiddlewareCache(b *apidef.BundleManifest, basePath string) {
+func (d *LuaDispatcher) HandleMiddlewareCache(b *apidef.BundleManifest, basePath string) error {
 	for _, f := range b.FileList {
 		fullPath := filepath.Join(basePath, f)
 		contents, err := ioutil.ReadFile(fullPath)
-		if err == nil {
-			d.ModuleCache[f] = string(contents)
-		} else {
+		if err != nil {
 			log.WithFields(logrus.Fields{
 				"prefix": "coprocess",
 			}).Error("Failed to read bundle file: ", err)
+			return err
 		}
+		d.ModuleCache[f] = string(contents)
 	}
+	return nil
 }
 
 func (d *LuaDispatcher) LoadModules() {
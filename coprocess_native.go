@@ -1,5 +1,5 @@
-// +build coprocess
-// +build !grpc
+//go:build coprocess && !grpc
+// +build coprocess,!grpc
 
 package main
 
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/TykTechnologies/tyk/coprocess"
+)
+
+// CoProcessor represents a CoProcess during the request.
+type CoProcessor struct {
+	HookType   coprocess.HookType
+	Middleware *CoProcessMiddleware
+}
+
+// ObjectFromRequest constructs a CoProcessObject from a given http.Request.
+func (c *CoProcessor) ObjectFromRequest(r *http.Request) *coprocess.Object {
+	var body string
+	if r.Body == nil {
+		body = ""
+	} else {
+		defer r.Body.Close()
+		originalBody, _ := ioutil.ReadAll(r.Body)
+		body = string(originalBody)
+	}
+
+	miniRequestObject := &coprocess.MiniRequestObject{
+		Headers:        ProtoMap(r.Header),
+		SetHeaders:     map[string]string{},
+		DeleteHeaders:  []string{},
+		Body:           body,
+		Url:            r.URL.Path,
+		Params:         ProtoMap(r.URL.Query()),
+		AddParams:      map[string]string{},
+		ExtendedParams: ProtoMap(nil),
+		DeleteParams:   []string{},
+		ReturnOverrides: &coprocess.ReturnOverrides{
+			ResponseCode: -1,
+		},
+	}
+
+	object := &coprocess.Object{
+		Request:  miniRequestObject,
+		HookName: c.Middleware.HookName,
+	}
+
+	// If a middleware is set, take its HookType, otherwise override it with CoProcessor.HookType
+	if c.Middleware != nil && c.HookType == 0 {
+		c.HookType = c.Middleware.HookType
+	}
+
+	object.HookType = c.HookType
+
+	object.Metadata = make(map[string]string)
+	object.Spec = make(map[string]string)
+	object.State = ctxGetState(r)
+
+	// Append spec data:
+	if c.Middleware != nil {
+		configDataAsJson := []byte("{}")
+		if len(c.Middleware.Spec.ConfigData) > 0 {
+			configDataAsJson, _ = json.Marshal(c.Middleware.Spec.ConfigData)
+		}
+
+		object.Spec = map[string]string{
+			"OrgID":       c.Middleware.Spec.OrgID,
+			"APIID":       c.Middleware.Spec.APIID,
+			"config_data": string(configDataAsJson),
+		}
+	}
+
+	// Encode the session object (if not a pre-process & not a custom key check):
+	if c.HookType != coprocess.HookType_Pre && c.HookType != coprocess.HookType_CustomKeyCheck {
+		session := ctxGetSession(r)
+		if session != nil {
+			object.Session = ProtoSessionState(session)
+		}
+	}
+
+	return object
+}
+
+// ObjectPostProcess does CoProcessObject post-processing (adding/removing headers or params, etc.).
+func (c *CoProcessor) ObjectPostProcess(object *coprocess.Object, r *http.Request) {
+	r.ContentLength = int64(len(object.Request.Body))
+	r.Body = ioutil.NopCloser(strings.NewReader(object.Request.Body))
+
+	for _, dh := range object.Request.DeleteHeaders {
+		r.Header.Del(dh)
+	}
+
+	for h, v := range object.Request.SetHeaders {
+		r.Header.Set(h, v)
+	}
+
+	values := r.URL.Query()
+	for _, k := range object.Request.DeleteParams {
+		values.Del(k)
+	}
+
+	for p, v := range object.Request.AddParams {
+		values.Set(p, v)
+	}
+
+	r.URL.Path = object.Request.Url
+	r.URL.RawQuery = values.Encode()
+
+	// Servers that build their own object leave the state be
+	if object.State != nil {
+		ctxSetState(r, object.State)
+	}
+}
@@ -1,5 +1,5 @@
-// +build coprocess
-// +build python
+//go:build coprocess && python
+// +build coprocess,python
 
 package main
 
@@ -74,13 +74,19 @@ static void Python_ReloadDispatcher() {
 
 }
 
-static void Python_HandleMiddlewareCache(char* bundle_path) {
+static int Python_HandleMiddlewareCache(char* bundle_path) {
+	int ret = 0;
 	gilState = PyGILState_Ensure();
 	if( PyCallable_Check(dispatcher_load_bundle) ) {
 		PyObject* load_bundle_args = PyTuple_Pack( 1, PyUnicode_FromString(bundle_path) );
-		PyObject_CallObject( dispatcher_load_bundle, load_bundle_args );
+		PyObject* result = PyObject_CallObject( dispatcher_load_bundle, load_bundle_args );
+		if( result == NULL ) {
+			PyErr_Print();
+			ret = -1;
+		}
 	}
 	PyGILState_Release(gilState);
+	return ret;
 }
 
 static int Python_NewDispatcher(char* middleware_path, char* event_handler_path, char* bundle_paths) {
@@ -209,14 +215,16 @@ func (d *PythonDispatcher) Reload() {
 	C.Python_ReloadDispatcher()
 }
 
-// HandleMiddlewareCache isn't used by Python.
-func (d *PythonDispatcher) HandleMiddlewareCache(b *apidef.BundleManifest, basePath string) {
-	go func() {
-		runtime.LockOSThread()
-		defer runtime.UnlockOSThread()
-		CBundlePath := C.CString(basePath)
-		C.Python_HandleMiddlewareCache(CBundlePath)
-	}()
+// HandleMiddlewareCache loads the bundle's Python middleware.
+func (d *PythonDispatcher) HandleMiddlewareCache(b *apidef.BundleManifest, basePath string) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	CBundlePath := C.CString(basePath)
+	defer C.free(unsafe.Pointer(CBundlePath))
+	if C.Python_HandleMiddlewareCache(CBundlePath) != 0 {
+		return errors.New("Python couldn't load the bundle")
+	}
+	return nil
 }
 
 // PythonInit initializes the Python interpreter.
@@ -1,7 +1,5 @@
-// +build coprocess
-// +build !python
-// +build !lua
-// +build !grpc
+//go:build coprocess && !python && !lua && !grpc
+// +build coprocess,!python,!lua,!grpc
 
 package main
 
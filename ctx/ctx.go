@@ -0,0 +1,85 @@
+// Package ctx gives access to what Tyk keeps in a request's context, for
+// code that runs inside the gateway but isn't part of it, such as Go
+// plugins.
+package ctx
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/user"
+)
+
+// Key is the type of the context keys Tyk sets. Its values don't clash
+// with anyone else's keys.
+type Key uint
+
+const (
+	SessionData Key = iota
+	AuthToken
+	Definition
+	State
+)
+
+func setValue(r *http.Request, key, val interface{}) {
+	*r = *r.WithContext(context.WithValue(r.Context(), key, val))
+}
+
+// GetSession returns the session of the key the request was authorised
+// with, or nil if it wasn't authorised yet.
+func GetSession(r *http.Request) *user.SessionState {
+	if v := r.Context().Value(SessionData); v != nil {
+		return v.(*user.SessionState)
+	}
+	return nil
+}
+
+// SetSession sets the session and the key the request is authorised
+// with. An auth check hook calls it to let the request through.
+func SetSession(r *http.Request, s *user.SessionState, token string) {
+	if s == nil {
+		panic("setting a nil context SessionData")
+	}
+	setValue(r, SessionData, s)
+	if token != "" {
+		setValue(r, AuthToken, token)
+	}
+}
+
+// GetAuthToken returns the key the request was authorised with.
+func GetAuthToken(r *http.Request) string {
+	if v := r.Context().Value(AuthToken); v != nil {
+		return v.(string)
+	}
+	return ""
+}
+
+// GetDefinition returns the definition of the API the request is for.
+func GetDefinition(r *http.Request) *apidef.APIDefinition {
+	if v := r.Context().Value(Definition); v != nil {
+		return v.(*apidef.APIDefinition)
+	}
+	return nil
+}
+
+// GetState returns a copy of the request's state, the values hooks set
+// for others later in the chain. They're only kept for the request.
+func GetState(r *http.Request) map[string]string {
+	state := make(map[string]string)
+	if v := r.Context().Value(State); v != nil {
+		for k, val := range v.(map[string]string) {
+			state[k] = val
+		}
+	}
+	return state
+}
+
+// SetState replaces the request's state. The map mustn't be changed
+// after, so change a copy from GetState instead.
+func SetState(r *http.Request, state map[string]string) {
+	if state == nil {
+		state = make(map[string]string)
+	}
+	setValue(r, State, state)
+}
@@ -6,13 +6,14 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"html/template"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/satori/go.uuid"
 
 	"github.com/TykTechnologies/tyk/apidef"
 	"github.com/TykTechnologies/tyk/config"
@@ -68,6 +69,7 @@ func (w *WebHookHandler) Init(handlerConf interface{}) error {
 
 	w.store = storage.RedisCluster{KeyPrefix: "webhook.cache."}
 	w.store.Connect()
+	webhooks.start()
 
 	// Pre-load template on init
 	if w.conf.TemplatePath != "" {
@@ -211,24 +213,37 @@ func (w *WebHookHandler) HandleEvent(em config.EventMessage) {
 	}
 	// Fire web hook routine (setHookFired())
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.WithFields(logrus.Fields{
-			"prefix": "webhooks",
-		}).Error("Webhook request failed: ", err)
-	} else {
-		defer resp.Body.Close()
-		content, err := ioutil.ReadAll(resp.Body)
-		if err == nil {
-			log.WithFields(logrus.Fields{
-				"prefix": "webhooks",
-			}).Debug(string(content))
-		} else {
-			log.WithFields(logrus.Fields{
-				"prefix": "webhooks",
-			}).Error(err)
-		}
-	}
+	webhooks.deliver(w.newDelivery(em, req, reqBody))
 
 	w.setHookFired(reqChecksum)
 }
+
+// newDelivery makes a delivery of a built request, signed if the
+// handler has a secret.
+func (w *WebHookHandler) newDelivery(em config.EventMessage, req *http.Request, reqBody string) *WebHookDelivery {
+	maxAge := w.conf.MaxRetryAge
+	if maxAge <= 0 {
+		maxAge = webhookDefaultMaxRetryAge
+	}
+	now := time.Now()
+	d := &WebHookDelivery{
+		ID:         uuid.NewV4().String(),
+		Event:      em.Type,
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		Headers:    make(map[string]string),
+		Body:       reqBody,
+		Created:    now,
+		MaxAge:     maxAge,
+		Timeout:    w.conf.RequestTimeout,
+		RetryUntil: now.Add(time.Duration(maxAge) * time.Second),
+	}
+	for key := range req.Header {
+		d.Headers[key] = req.Header.Get(key)
+	}
+	d.Headers["X-Tyk-Delivery"] = d.ID
+	if w.conf.Secret != "" {
+		d.Headers["X-Tyk-Signature"] = signWebHook(w.conf.Secret, d.ID, reqBody)
+	}
+	return d
+}
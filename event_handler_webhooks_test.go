@@ -1,8 +1,15 @@
 package main
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
 
 	"github.com/TykTechnologies/tyk/config"
 )
@@ -210,3 +217,211 @@ func TestNewCustomTemplate(t *testing.T) {
 		})
 	}
 }
+
+// webhookTarget fails the first fails requests, and records the rest.
+type webhookTarget struct {
+	mu       sync.Mutex
+	fails    int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (t *webhookTarget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.fails != 0 {
+		t.fails--
+		w.WriteHeader(500)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	t.requests = append(t.requests, r)
+	t.bodies = append(t.bodies, string(body))
+}
+
+func (t *webhookTarget) received() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.requests)
+}
+
+func TestWebHookRetries(t *testing.T) {
+	oldInterval, oldMax := webhookRetryInterval, webhookMaxRetryInterval
+	webhookRetryInterval, webhookMaxRetryInterval = 100*time.Millisecond, time.Second
+	defer func() {
+		webhookRetryInterval, webhookMaxRetryInterval = oldInterval, oldMax
+	}()
+
+	target := &webhookTarget{fails: 2}
+	server := httptest.NewServer(target)
+	defer server.Close()
+
+	h := &WebHookHandler{}
+	if err := h.Init(config.WebHookHandlerConf{
+		TargetPath:   server.URL,
+		Method:       "POST",
+		EventTimeout: 1,
+		TemplatePath: "templates/default_webhook.json",
+		Secret:       "s3cret",
+		MaxRetryAge:  1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	fire := func(msg string) {
+		h.HandleEvent(config.EventMessage{
+			Type: EventKeyExpired,
+			Meta: EventKeyFailureMeta{EventMetaDefault: EventMetaDefault{Message: msg}},
+		})
+	}
+	// retry runs the retries until cond holds, as the background ones
+	// only run every second
+	retry := func(cond func() bool) bool {
+		for i := 0; i < 30 && !cond(); i++ {
+			time.Sleep(50 * time.Millisecond)
+			webhooks.retryDue(time.Now())
+		}
+		return cond()
+	}
+	inList := func(list, id string) bool {
+		for _, d := range webhooks.list(webhookQueueKeys[list]) {
+			if d.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	// a failing delivery is retried until it goes through
+	fire("retried")
+	if !retry(func() bool { return target.received() == 1 }) {
+		t.Fatal("wanted the delivery to be retried until it went through")
+	}
+	r := target.requests[0]
+	id := r.Header.Get("X-Tyk-Delivery")
+	if want := signWebHook("s3cret", id, target.bodies[0]); r.Header.Get("X-Tyk-Signature") != want {
+		t.Fatalf("wanted signature %q, got %q", want, r.Header.Get("X-Tyk-Signature"))
+	}
+	if got := r.Header.Get("X-Tyk-Delivery-Attempt"); got != "3" {
+		t.Fatalf("wanted the third attempt to go through, got attempt %q", got)
+	}
+	if _, err := webhooks.load(id); err == nil || inList("queue", id) {
+		t.Fatal("wanted the delivery to be forgotten once it went through")
+	}
+
+	// one that still fails when it's too old is dead lettered
+	target.mu.Lock()
+	target.fails = -1
+	target.mu.Unlock()
+	fire("dead lettered")
+	var dead *WebHookDelivery
+	found := retry(func() bool {
+		for _, d := range webhooks.list(webhookDeadLetterKey) {
+			if strings.Contains(d.Body, "dead lettered") {
+				dead = d
+				return true
+			}
+		}
+		return false
+	})
+	if !found {
+		t.Fatal("wanted the delivery to be dead lettered")
+	}
+	if inList("queue", dead.ID) || dead.Attempts < 2 || dead.LastError == "" {
+		t.Fatalf("wanted a dead letter out of the queue, after its attempts, got %+v", dead)
+	}
+
+	recorder := httptest.NewRecorder()
+	mainRouter.ServeHTTP(recorder, withAuth(testReq(t, "GET", "/tyk/webhooks/deadletter/"+dead.ID, nil)))
+	if recorder.Code != 200 || !strings.Contains(recorder.Body.String(), dead.ID) {
+		t.Fatalf("wanted the dead letter from the API, got %d %s", recorder.Code, recorder.Body)
+	}
+
+	// and can be replayed once the target is back
+	target.mu.Lock()
+	target.fails = 0
+	target.mu.Unlock()
+	recorder = httptest.NewRecorder()
+	mainRouter.ServeHTTP(recorder, withAuth(testReq(t, "POST", "/tyk/webhooks/deadletter/"+dead.ID+"/replay", nil)))
+	if recorder.Code != 200 {
+		t.Fatalf("wanted the dead letter to be replayed, got %d %s", recorder.Code, recorder.Body)
+	}
+	if !retry(func() bool { return target.received() == 2 }) {
+		t.Fatal("wanted the replayed delivery to go through")
+	}
+	if inList("deadletter", dead.ID) {
+		t.Fatal("wanted the replayed delivery out of the dead letters")
+	}
+
+	recorder = httptest.NewRecorder()
+	mainRouter.ServeHTTP(recorder, withAuth(testReq(t, "POST", "/tyk/webhooks/deadletter/"+dead.ID+"/replay", nil)))
+	if recorder.Code != 404 {
+		t.Fatalf("wanted a delivery that's not a dead letter to be missing, got %d", recorder.Code)
+	}
+}
+
+func TestWebHookSlowTargets(t *testing.T) {
+	var mu sync.Mutex
+	received := 0
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hang" {
+			<-release
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+		mu.Lock()
+		received++
+		mu.Unlock()
+	}))
+	defer server.Close()
+	defer close(release)
+
+	newDelivery := func(path string) *WebHookDelivery {
+		now := time.Now()
+		return &WebHookDelivery{
+			ID:          uuid.NewV4().String(),
+			Method:      "POST",
+			URL:         server.URL + path,
+			MaxAge:      60,
+			Timeout:     1,
+			RetryUntil:  now.Add(time.Minute),
+			NextAttempt: now.Add(-time.Second),
+		}
+	}
+
+	// an attempt gives up on a target that doesn't reply
+	start := time.Now()
+	if err := sendWebHook(newDelivery("/hang")); err == nil {
+		t.Fatal("wanted an attempt at a target that hangs to fail")
+	}
+	if took := time.Since(start); took > 3*time.Second {
+		t.Fatalf("wanted the attempt to time out, took %v", took)
+	}
+
+	// due retries are sent at once rather than one after another
+	var ids []string
+	for i := 0; i < 5; i++ {
+		d := newDelivery("/slow")
+		if err := webhooks.schedule(d); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, d.ID)
+	}
+	defer func() {
+		for _, id := range ids {
+			webhooks.store.RemoveFromSortedSet(webhookQueueKey, id)
+			webhooks.store.DeleteRawKey(webhookDeliveryPrefix + id)
+		}
+	}()
+	start = time.Now()
+	webhooks.retryDue(time.Now())
+	took := time.Since(start)
+	mu.Lock()
+	defer mu.Unlock()
+	if received != 5 {
+		t.Fatalf("wanted all 5 retries sent, got %d", received)
+	}
+	if took > 800*time.Millisecond {
+		t.Fatalf("wanted the retries sent concurrently, took %v", took)
+	}
+}
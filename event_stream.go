@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/websocket"
+	"github.com/satori/go.uuid"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const (
+	eventStreamBuffer    = 64
+	eventStreamWriteWait = 10 * time.Second
+)
+
+// eventStreamKeepAlive is how often an idle stream gets a comment or a
+// ping, so that proxies and clients don't give up on it.
+var eventStreamKeepAlive = 15 * time.Second
+
+// cloudEvent is a gateway event in the CloudEvents 1.0 JSON format. The
+// API and org the event is about, if any, are extensions.
+type cloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+	APIID           string      `json:"apiid,omitempty"`
+	OrgID           string      `json:"orgid,omitempty"`
+}
+
+// streamedEvent is an event encoded once for all the clients that want
+// it.
+type streamedEvent struct {
+	id   string
+	name apidef.TykEvent
+	json []byte
+}
+
+// eventSubscriber is a client of the event stream and the events it
+// wants. Empty filters match any event.
+type eventSubscriber struct {
+	types  map[apidef.TykEvent]bool
+	apiID  string
+	orgID  string
+	events chan *streamedEvent
+}
+
+func newEventSubscriber(r *http.Request) *eventSubscriber {
+	q := r.URL.Query()
+	s := &eventSubscriber{
+		types:  make(map[apidef.TykEvent]bool),
+		apiID:  q.Get("api_id"),
+		orgID:  q.Get("org_id"),
+		events: make(chan *streamedEvent, eventStreamBuffer),
+	}
+	for _, types := range q["type"] {
+		for _, name := range strings.Split(types, ",") {
+			if name != "" {
+				s.types[apidef.TykEvent(name)] = true
+			}
+		}
+	}
+	return s
+}
+
+func (s *eventSubscriber) wants(name apidef.TykEvent, apiID, orgID string) bool {
+	if len(s.types) > 0 && !s.types[name] {
+		return false
+	}
+	if s.apiID != "" && s.apiID != apiID {
+		return false
+	}
+	return s.orgID == "" || s.orgID == orgID
+}
+
+// eventHub passes the events fired on this node on to the clients of
+// the event stream. Clients that fall behind miss events rather than
+// hold up the gateway.
+type eventHub struct {
+	mu   sync.RWMutex
+	subs map[*eventSubscriber]bool
+}
+
+var eventStream = &eventHub{subs: make(map[*eventSubscriber]bool)}
+
+func (h *eventHub) subscribe(s *eventSubscriber) {
+	h.mu.Lock()
+	h.subs[s] = true
+	h.mu.Unlock()
+}
+
+func (h *eventHub) unsubscribe(s *eventSubscriber) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+}
+
+func (h *eventHub) publish(name apidef.TykEvent, meta interface{}, apiID, orgID string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var event *streamedEvent
+	for s := range h.subs {
+		if !s.wants(name, apiID, orgID) {
+			continue
+		}
+		if event == nil {
+			var err error
+			if event, err = newStreamedEvent(name, meta, apiID, orgID); err != nil {
+				log.WithFields(logrus.Fields{
+					"prefix": "events",
+				}).Error("Failed to encode event for the stream: ", err)
+				return
+			}
+		}
+		select {
+		case s.events <- event:
+		default:
+			log.WithFields(logrus.Fields{
+				"prefix": "events",
+			}).Warning("Event stream client is too slow, dropping event ", event.id)
+		}
+	}
+}
+
+func newStreamedEvent(name apidef.TykEvent, meta interface{}, apiID, orgID string) (*streamedEvent, error) {
+	ce := cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              uuid.NewV4().String(),
+		Source:          "/tyk/nodes/" + NodeID,
+		Type:            "io.tyk.gateway." + string(name),
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            meta,
+		APIID:           apiID,
+		OrgID:           orgID,
+	}
+	b, err := json.Marshal(ce)
+	if err != nil {
+		return nil, err
+	}
+	return &streamedEvent{id: ce.ID, name: name, json: b}, nil
+}
+
+// eventOrg returns the org a system event is about, if it says.
+func eventOrg(meta interface{}) string {
+	switch m := meta.(type) {
+	case EventTokenMeta:
+		return m.Org
+	case EventTriggerExceededMeta:
+		return m.Org
+	}
+	return ""
+}
+
+// eventStreamHandler streams the events fired on this node as they
+// happen, over a WebSocket if the client asks for one and as
+// Server-Sent Events otherwise. The type, api_id and org_id parameters
+// filter the events, and type may be given more than once or be a comma
+// separated list.
+func eventStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		streamEventsWS(w, r)
+		return
+	}
+	streamEventsSSE(w, r)
+}
+
+func streamEventsSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		doJSONWrite(w, 500, apiError("Streaming is not supported"))
+		return
+	}
+	sub := newEventSubscriber(r)
+	eventStream.subscribe(sub)
+	defer eventStream.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event := <-sub.events:
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.id, event.name, event.json)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+var eventStreamUpgrader = websocket.Upgrader{}
+
+func streamEventsWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := eventStreamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already replied
+		return
+	}
+	defer conn.Close()
+	sub := newEventSubscriber(r)
+	eventStream.subscribe(sub)
+	defer eventStream.unsubscribe(sub)
+
+	// clients don't send anything, but reading is how we know they left
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event := <-sub.events:
+			conn.SetWriteDeadline(time.Now().Add(eventStreamWriteWait))
+			err = conn.WriteMessage(websocket.TextMessage, event.json)
+		case <-keepAlive.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventStreamWriteWait))
+		case <-closed:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
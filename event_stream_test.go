@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+// waitForSubscribers waits until the event stream has n clients, so
+// that events fired after it aren't missed.
+func waitForSubscribers(t *testing.T, n int) {
+	for i := 0; i < 100; i++ {
+		eventStream.mu.RLock()
+		got := len(eventStream.subs)
+		eventStream.mu.RUnlock()
+		if got == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("event stream never had %d clients", n)
+}
+
+func TestEventStream(t *testing.T) {
+	srv := httptest.NewServer(mainRouter)
+	defer srv.Close()
+
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "stream-api", OrgID: "stream-org"}}
+	other := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "other-api", OrgID: "stream-org"}}
+
+	t.Run("SSE", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", srv.URL+"/tyk/events?type=AuthFailure,KeyExpired&api_id=stream-api", nil)
+		withAuth(req)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("wanted an event stream, got %q", ct)
+		}
+		waitForSubscribers(t, 1)
+
+		other.FireEvent(EventAuthFailure, EventKeyFailureMeta{Key: "other"})
+		spec.FireEvent(EventQuotaExceeded, EventKeyFailureMeta{Key: "wrong type"})
+		spec.FireEvent(EventKeyExpired, EventKeyFailureMeta{Key: "wanted"})
+
+		lines := bufio.NewScanner(resp.Body)
+		var event, data string
+		for lines.Scan() && lines.Text() != "" {
+			line := lines.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		if event != string(EventKeyExpired) {
+			t.Fatalf("wanted a %s event, got %q", EventKeyExpired, event)
+		}
+		var ce struct {
+			cloudEvent
+			Data EventKeyFailureMeta `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(data), &ce); err != nil {
+			t.Fatal(err)
+		}
+		if ce.SpecVersion != "1.0" || ce.Type != "io.tyk.gateway.KeyExpired" {
+			t.Errorf("unexpected CloudEvent attributes: %+v", ce.cloudEvent)
+		}
+		if ce.APIID != "stream-api" || ce.OrgID != "stream-org" || ce.Data.Key != "wanted" {
+			t.Errorf("got the wrong event: %s", data)
+		}
+	})
+	waitForSubscribers(t, 0)
+
+	t.Run("WebSocket", func(t *testing.T) {
+		url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/tyk/events?org_id=stream-org"
+		header := http.Header{}
+		header.Set("X-Tyk-Authorization", "352d20ee67be67f6340b4c0605b044b7")
+		conn, _, err := websocket.DefaultDialer.Dial(url, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		waitForSubscribers(t, 1)
+
+		FireSystemEvent(EventTokenCreated, EventTokenMeta{Org: "another-org", Key: "other"})
+		FireSystemEvent(EventTokenCreated, EventTokenMeta{Org: "stream-org", Key: "wanted"})
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var ce cloudEvent
+		if err := conn.ReadJSON(&ce); err != nil {
+			t.Fatal(err)
+		}
+		if ce.Type != "io.tyk.gateway.TokenCreated" || ce.OrgID != "stream-org" {
+			t.Fatalf("got the wrong event: %+v", ce)
+		}
+		if data := ce.Data.(map[string]interface{}); data["Key"] != "wanted" {
+			t.Fatalf("got the wrong event data: %v", data)
+		}
+	})
+}
@@ -32,6 +32,8 @@ const (
 	EventTokenCreated      apidef.TykEvent = "TokenCreated"
 	EventTokenUpdated      apidef.TykEvent = "TokenUpdated"
 	EventTokenDeleted      apidef.TykEvent = "TokenDeleted"
+	EventIPBanned          apidef.TykEvent = "IPBanned"
+	EventBundleFailure     apidef.TykEvent = "BundleFailure"
 )
 
 // EventMetaDefault is a standard embedded struct to be used with custom event metadata types, gives an interface for
@@ -55,6 +57,38 @@ type EventKeyFailureMeta struct {
 	Key    string
 }
 
+// EventIPBannedMeta is the metadata structure for a client IP being
+// banned for going over its limits too often.
+type EventIPBannedMeta struct {
+	EventMetaDefault
+	Path     string
+	Origin   string
+	APIID    string
+	Duration int64
+}
+
+// EventBundleFailureMeta is the metadata structure for an API's bundle
+// failing to load. If there was a good one to fall back to, RolledBack
+// is set and RolledBackTo is its version.
+type EventBundleFailureMeta struct {
+	EventMetaDefault
+	APIID        string
+	Bundle       string
+	Version      string
+	RolledBack   bool
+	RolledBackTo string
+}
+
+// EventCertificateFailureMeta is the metadata structure for an auth
+// failure caused by a client certificate. Reason is one of the
+// CertFailure constants.
+type EventCertificateFailureMeta struct {
+	EventKeyFailureMeta
+	CertID  string
+	Subject string
+	Reason  string
+}
+
 // EventCurcuitBreakerMeta is the event status for a circuit breaker tripping
 type EventCurcuitBreakerMeta struct {
 	EventMetaDefault
@@ -113,7 +147,7 @@ func EventHandlerByName(handlerConf apidef.EventHandlerTriggerConfig, spec *APIS
 			h := &JSVMEventHandler{Spec: spec}
 			err := h.Init(conf)
 			if err == nil {
-				GlobalEventsJSVM.LoadJSPaths([]string{conf["path"].(string)}, "")
+				err = GlobalEventsJSVM.LoadJSPaths([]string{conf["path"].(string)}, "")
 			}
 			return h, err
 		}
@@ -147,10 +181,12 @@ func fireEvent(name apidef.TykEvent, meta interface{}, handlers map[apidef.TykEv
 
 func (s *APISpec) FireEvent(name apidef.TykEvent, meta interface{}) {
 	fireEvent(name, meta, s.EventPaths)
+	eventStream.publish(name, meta, s.APIID, s.OrgID)
 }
 
 func FireSystemEvent(name apidef.TykEvent, meta interface{}) {
 	fireEvent(name, meta, config.Global.EventTriggers)
+	eventStream.publish(name, meta, "", eventOrg(meta))
 }
 
 // LogMessageEventHandler is a sample Event Handler
@@ -64,6 +64,8 @@ func (e *ErrorHandler) HandleError(w http.ResponseWriter, r *http.Request, errMs
 		w.Header().Set("Content-Type", defaultContentType)
 	}
 
+	setRateLimitHeaders(e.Spec, w.Header(), r)
+
 	// Need to return the correct error code!
 	w.WriteHeader(errCode)
 
@@ -118,7 +120,9 @@ func (e *ErrorHandler) HandleError(w http.ResponseWriter, r *http.Request, errMs
 
 		rawRequest := ""
 		rawResponse := ""
+		var state map[string]string
 		if recordDetail(r) {
+			state = ctxGetState(r)
 			requestCopy := copyRequest(r)
 			// Get the wire format representation
 			var wireFormatReq bytes.Buffer
@@ -159,6 +163,9 @@ func (e *ErrorHandler) HandleError(w http.ResponseWriter, r *http.Request, errMs
 			tags,
 			alias,
 			trackEP,
+			ctxGetAuthMethod(r),
+			ctxGetRequestCost(r),
+			state,
 			time.Now(),
 		}
 
@@ -21,15 +21,18 @@ import (
 // Enums for keys to be stored in a session context - this is how gorilla expects
 // these to be implemented and is lifted pretty much from docs
 const (
-	SessionData = iota
-	AuthHeaderValue
-	VersionData
+	VersionData = iota
 	OrgSessionContext
 	ContextData
 	RetainHost
 	TrackThisEndpoint
 	DoNotTrackThisEndpoint
 	UrlRewritePath
+	AuthMethodUsed
+	RateLimitStateData
+	RequestCostData
+	ConcurrencyLeases
+	SOAPMediationData
 )
 
 var SessionCache = cache.New(10*time.Second, 5*time.Second)
@@ -106,7 +109,9 @@ func (s *SuccessHandler) RecordHit(r *http.Request, timing int64, code int, requ
 
 		rawRequest := ""
 		rawResponse := ""
+		var state map[string]string
 		if recordDetail(r) {
+			state = ctxGetState(r)
 			// Get the wire format representation
 			var wireFormatReq bytes.Buffer
 			requestCopy.Write(&wireFormatReq)
@@ -159,6 +164,9 @@ func (s *SuccessHandler) RecordHit(r *http.Request, timing int64, code int, requ
 			tags,
 			alias,
 			trackEP,
+			ctxGetAuthMethod(r),
+			ctxGetRequestCost(r),
+			state,
 			time.Now(),
 		}
 
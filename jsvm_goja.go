@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dop251/goja"
+
+	"github.com/TykTechnologies/tyk/config"
+)
+
+var errJSVMMemory = errors.New("exceeded the memory limit")
+
+// gojaPool keeps goja VMs ready for the JSVM calls. Goja VMs can't be
+// copied like otto ones, so each VM is set up by running all the programs
+// loaded so far, and loading a program starts a new generation of VMs.
+//
+// VMs are reused between calls, so globals a call sets may be seen by
+// later ones, until the VM has made maxCalls calls and is replaced. A VM
+// whose call failed or was stopped is thrown away straight away, as it
+// may have been left half way through changing them.
+type gojaPool struct {
+	api       map[string]jsFunc
+	maxStack  int
+	maxMemory uint64
+	maxCalls  int
+
+	mu       sync.Mutex
+	programs []*goja.Program
+	gen      int
+
+	idle chan *gojaVM
+}
+
+type gojaVM struct {
+	*goja.Runtime
+	gen   int
+	calls int
+}
+
+func newGojaPool(api map[string]jsFunc, opts config.JSVMOptionsConfig) *gojaPool {
+	size := opts.PoolSize
+	if size <= 0 {
+		size = runtime.NumCPU()
+	}
+	maxCalls := opts.MaxVMCalls
+	if maxCalls <= 0 {
+		maxCalls = 1000
+	}
+	return &gojaPool{
+		api:       api,
+		maxStack:  opts.MaxCallStackSize,
+		maxMemory: uint64(opts.MaxMemoryMB) << 20,
+		maxCalls:  maxCalls,
+		idle:      make(chan *gojaVM, size),
+	}
+}
+
+// load adds a program to the VMs. It's run on a new VM straight away, so
+// that errors show up when loading as they do with otto.
+func (p *gojaPool) load(name, src string) error {
+	prg, err := goja.Compile(name, src, false)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.programs = append(p.programs, prg)
+	p.gen++
+	p.mu.Unlock()
+
+	vm, err := p.newVM()
+	if err != nil {
+		p.mu.Lock()
+		programs := make([]*goja.Program, 0, len(p.programs))
+		for _, other := range p.programs {
+			if other != prg {
+				programs = append(programs, other)
+			}
+		}
+		p.programs = programs
+		p.gen++
+		p.mu.Unlock()
+		return err
+	}
+	p.put(vm)
+	return nil
+}
+
+func (p *gojaPool) newVM() (*gojaVM, error) {
+	p.mu.Lock()
+	programs, gen := p.programs, p.gen
+	p.mu.Unlock()
+
+	rt := goja.New()
+	if p.maxStack > 0 {
+		rt.SetMaxCallStackSize(p.maxStack)
+	}
+	for name, fn := range p.api {
+		fn := fn
+		rt.Set(name, func(call goja.FunctionCall) goja.Value {
+			out, ok := fn(func(i int) string {
+				return call.Argument(i).String()
+			})
+			if !ok {
+				return goja.Undefined()
+			}
+			return rt.ToValue(out)
+		})
+	}
+	rt.Set("b64dec", func(call goja.FunctionCall) goja.Value {
+		out, err := base64.StdEncoding.DecodeString(call.Argument(0).String())
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "jsvm",
+			}).Error("Failed to base64 decode: ", err)
+			return goja.Undefined()
+		}
+		return bytesToGoja(out)
+	})
+	rt.Set("b64enc", func(call goja.FunctionCall) goja.Value {
+		in := gojaToBytes(call.Argument(0))
+		return rt.ToValue(base64.StdEncoding.EncodeToString(in))
+	})
+	for _, prg := range programs {
+		if _, err := rt.RunProgram(prg); err != nil {
+			return nil, err
+		}
+	}
+	return &gojaVM{Runtime: rt, gen: gen}, nil
+}
+
+func (p *gojaPool) current(vm *gojaVM) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return vm.gen == p.gen
+}
+
+func (p *gojaPool) get() (*gojaVM, error) {
+	for {
+		select {
+		case vm := <-p.idle:
+			if p.current(vm) {
+				return vm, nil
+			}
+		default:
+			return p.newVM()
+		}
+	}
+}
+
+func (p *gojaPool) put(vm *gojaVM) {
+	if !p.current(vm) {
+		return
+	}
+	select {
+	case p.idle <- vm:
+	default:
+	}
+}
+
+// warm fills the pool with VMs of the current generation.
+func (p *gojaPool) warm() {
+	var vms []*gojaVM
+	for len(vms) < cap(p.idle) {
+		select {
+		case vm := <-p.idle:
+			if p.current(vm) {
+				vms = append(vms, vm)
+			}
+			continue
+		default:
+		}
+		vm, err := p.newVM()
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "jsvm",
+			}).Error("Failed to set up JS VM: ", err)
+			break
+		}
+		vms = append(vms, vm)
+	}
+	for _, vm := range vms {
+		p.put(vm)
+	}
+}
+
+// run calls a JS expression on a VM from the pool, interrupting it if it
+// runs for longer than the timeout or the process heap grows by more than
+// the memory limit meanwhile.
+func (p *gojaPool) run(expr string, timeout time.Duration) (string, error) {
+	vm, err := p.get()
+	if err != nil {
+		return "", err
+	}
+	timer := time.AfterFunc(timeout, func() {
+		vm.Interrupt(fmt.Errorf("timed out after %v", timeout))
+	})
+	var stop, watched chan struct{}
+	if p.maxMemory > 0 {
+		stop, watched = make(chan struct{}), make(chan struct{})
+		go p.watchMemory(vm, stop, watched)
+	}
+
+	value, err := vm.RunString(expr)
+
+	timedOut := !timer.Stop()
+	if stop != nil {
+		close(stop)
+		<-watched
+	}
+	switch x := err.(type) {
+	case *goja.InterruptedError:
+		if verr, ok := x.Value().(error); ok {
+			err = verr
+		}
+	case *goja.StackOverflowError:
+		err = fmt.Errorf("exceeded the call stack size of %d", p.maxStack)
+	}
+	if err != nil {
+		return "", err
+	}
+	vm.calls++
+	if !timedOut && vm.calls < p.maxCalls {
+		// the memory watch may have fired just as the call
+		// returned
+		vm.ClearInterrupt()
+		p.put(vm)
+	}
+	return value.String(), nil
+}
+
+func (p *gojaPool) watchMemory(vm *gojaVM, stop <-chan struct{}, watched chan<- struct{}) {
+	defer close(watched)
+	limit := heapObjectBytes() + p.maxMemory
+	t := time.NewTicker(10 * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if heapObjectBytes() > limit {
+				vm.Interrupt(errJSVMMemory)
+				return
+			}
+		}
+	}
+}
+
+// bytesToGoja turns bytes that may not be valid UTF-8, such as a body,
+// into a JS string, keeping each invalid byte as a lone surrogate so that
+// gojaToBytes can give it back. Otto keeps the bytes as they are, and
+// goja would turn them into U+FFFD.
+func bytesToGoja(b []byte) goja.String {
+	chars := make([]uint16, 0, len(b))
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size == 1 {
+			chars = append(chars, 0xdc00|uint16(b[0]))
+		} else {
+			chars = utf16.AppendRune(chars, r)
+		}
+		b = b[size:]
+	}
+	return goja.StringFromUTF16(chars)
+}
+
+// gojaToBytes turns a JS string into UTF-8, giving back the bytes kept by
+// bytesToGoja.
+func gojaToBytes(v goja.Value) []byte {
+	s, ok := v.ToString().(goja.String)
+	if !ok {
+		return []byte(v.String())
+	}
+	var buf []byte
+	n := s.Length()
+	for i := 0; i < n; i++ {
+		c := s.CharAt(i)
+		switch {
+		case c >= 0xdc80 && c <= 0xdcff:
+			buf = append(buf, byte(c))
+		case utf16.IsSurrogate(rune(c)) && i+1 < n:
+			if r := utf16.DecodeRune(rune(c), rune(s.CharAt(i+1))); r != utf8.RuneError {
+				buf = utf8.AppendRune(buf, r)
+				i++
+				continue
+			}
+			buf = utf8.AppendRune(buf, utf8.RuneError)
+		default:
+			buf = utf8.AppendRune(buf, rune(c))
+		}
+	}
+	return buf
+}
+
+func heapObjectBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+	cache "github.com/pmylund/go-cache"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const (
+	ldapUserPlaceholder     = "TYKUSERNAME"
+	ldapDefaultGroupAttr    = "memberOf"
+	ldapDefaultCacheTTL     = 60
+	ldapDefaultMaxConns     = 10
+	ldapStartTLSOID         = "1.3.6.1.4.1.1466.20037"
+	ldapTimeout             = 5 * time.Second
+	ldapConnMaxIdleDuration = 30 * time.Second
+)
+
+var (
+	errLDAPInvalidCredentials = errors.New("invalid credentials")
+	errLDAPNoPolicy           = errors.New("no policy matches the user's groups")
+	errLDAPUserNotFound       = errors.New("the user filter doesn't find the bound user")
+)
+
+// LDAPBindCache remembers the identities of successful binds so that
+// the directory isn't queried on every request. Entries are keyed by
+// API, user name and password hash, so a wrong password never hits the
+// cache.
+var LDAPBindCache = cache.New(ldapDefaultCacheTTL*time.Second, 5*time.Minute)
+
+var (
+	ldapPoolsMu sync.Mutex
+	ldapPools   = map[string]*ldapConnPool{}
+)
+
+type ldapPooledConn struct {
+	*ldap.LDAPConnection
+	lastUsed time.Time
+}
+
+// ldapConnPool caps the number of connections open to a server and
+// keeps idle ones around for reuse. Pools are shared by all APIs
+// pointing at the same server, so they survive API reloads.
+type ldapConnPool struct {
+	conf  apidef.LDAPBindConfig
+	slots chan struct{}
+	idle  chan *ldapPooledConn
+}
+
+func getLDAPConnPool(conf apidef.LDAPBindConfig) *ldapConnPool {
+	maxConns := conf.MaxConnections
+	if maxConns <= 0 {
+		maxConns = ldapDefaultMaxConns
+	}
+	key := fmt.Sprintf("%s:%d:%v:%v:%v:%d", conf.Server, conf.Port,
+		conf.UseSSL, conf.StartTLS, conf.SSLInsecureSkipVerify, maxConns)
+
+	ldapPoolsMu.Lock()
+	defer ldapPoolsMu.Unlock()
+	if p := ldapPools[key]; p != nil {
+		return p
+	}
+	p := &ldapConnPool{
+		conf:  conf,
+		slots: make(chan struct{}, maxConns),
+		idle:  make(chan *ldapPooledConn, maxConns),
+	}
+	ldapPools[key] = p
+	return p
+}
+
+func (p *ldapConnPool) tlsConfig() *tls.Config {
+	return &tls.Config{
+		ServerName:         p.conf.Server,
+		InsecureSkipVerify: p.conf.SSLInsecureSkipVerify,
+	}
+}
+
+func (p *ldapConnPool) dial() (*ldapPooledConn, error) {
+	var conn *ldap.LDAPConnection
+	if p.conf.UseSSL {
+		conn = ldap.NewLDAPSSLConnection(p.conf.Server, p.conf.Port, p.tlsConfig())
+	} else {
+		conn = ldap.NewLDAPConnection(p.conf.Server, p.conf.Port)
+	}
+	conn.NetworkConnectTimeout = ldapTimeout
+	conn.ReadTimeout = ldapTimeout
+	if p.conf.StartTLS && !p.conf.UseSSL {
+		// The client's own StartTLS swaps the connection from under
+		// its reader goroutine, so upgrade it before handing it over.
+		conn.Dialer = ldap.TimedDialer(func(network, addr string, timeout time.Duration) (net.Conn, error) {
+			return ldapDialStartTLS(network, addr, timeout, p.tlsConfig())
+		})
+	}
+	if err := conn.Connect(); err != nil {
+		return nil, err
+	}
+	return &ldapPooledConn{LDAPConnection: conn}, nil
+}
+
+func ldapDialStartTLS(network, addr string, timeout time.Duration, tlsConfig *tls.Config) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	req := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Request")
+	req.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagInteger, 1, "MessageID"))
+	ext := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationExtendedRequest, nil, "Start TLS")
+	ext.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimative, 0, ldapStartTLSOID, "TLS Extended Command"))
+	req.AppendChild(ext)
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := ber.ReadPacket(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if len(resp.Children) < 2 || len(resp.Children[1].Children) < 1 {
+		conn.Close()
+		return nil, errors.New("malformed StartTLS response")
+	}
+	if code, _ := resp.Children[1].Children[0].Value.(uint64); code != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("StartTLS refused with result code %d", code)
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+func (p *ldapConnPool) get() (*ldapPooledConn, error) {
+	for {
+		var conn *ldapPooledConn
+		select {
+		case conn = <-p.idle:
+		default:
+		}
+		if conn == nil {
+			break
+		}
+		// servers drop idle connections, and the client can't tell
+		// us when that happened
+		if time.Since(conn.lastUsed) > ldapConnMaxIdleDuration {
+			p.discard(conn)
+			continue
+		}
+		return conn, nil
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case conn := <-p.idle:
+		return conn, nil
+	case <-time.After(ldapTimeout):
+		return nil, errors.New("timed out waiting for a free LDAP connection")
+	}
+	conn, err := p.dial()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (p *ldapConnPool) put(conn *ldapPooledConn) {
+	conn.lastUsed = time.Now()
+	p.idle <- conn
+}
+
+func (p *ldapConnPool) discard(conn *ldapPooledConn) {
+	conn.Close()
+	<-p.slots
+}
+
+// do runs fn on a pooled connection, dropping the connection if it
+// turns out to be broken.
+func (p *ldapConnPool) do(fn func(*ldap.LDAPConnection) error) error {
+	conn, err := p.get()
+	if err != nil {
+		return err
+	}
+	err = fn(conn.LDAPConnection)
+	if lerr, ok := err.(*ldap.LDAPError); ok && lerr.ResultCode >= ldap.ErrorNetwork {
+		p.discard(conn)
+		return err
+	}
+	p.put(conn)
+	return err
+}
+
+// ldapEscapeDN escapes a value for use in a DN, as per RFC 4514.
+func ldapEscapeDN(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.IndexByte(`,+"\<>;=`, c) >= 0,
+			c == '#' && i == 0,
+			c == ' ' && (i == 0 || i == len(s)-1):
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// ldapIdentity is what the directory tells us about a user.
+type ldapIdentity struct {
+	DN     string
+	Groups []string
+}
+
+// ldapBind verifies a user's credentials by binding as them, then
+// looks up their group memberships.
+func ldapBind(conf apidef.LDAPBindConfig, username, password string) (*ldapIdentity, error) {
+	if password == "" {
+		// an empty password is an unauthenticated bind, which
+		// servers accept for any DN
+		return nil, errLDAPInvalidCredentials
+	}
+	id := &ldapIdentity{
+		DN: strings.Replace(conf.UserDNTemplate, ldapUserPlaceholder, ldapEscapeDN(username), -1),
+	}
+	groupAttr := conf.GroupAttribute
+	if groupAttr == "" {
+		groupAttr = ldapDefaultGroupAttr
+	}
+
+	err := getLDAPConnPool(conf).do(func(conn *ldap.LDAPConnection) error {
+		if err := conn.Bind(id.DN, password); err != nil {
+			if lerr, ok := err.(*ldap.LDAPError); ok && lerr.ResultCode == ldap.LDAPResultInvalidCredentials {
+				return errLDAPInvalidCredentials
+			}
+			return err
+		}
+		if conf.UserFilter == "" {
+			return nil
+		}
+		if conf.SearchBindDN != "" {
+			if err := conn.Bind(conf.SearchBindDN, conf.SearchBindPassword); err != nil {
+				return err
+			}
+		}
+		filter := strings.Replace(conf.UserFilter, ldapUserPlaceholder, ldap.EscapeFilterValue(username), -1)
+		req := ldap.NewSearchRequest(conf.BaseDN,
+			ldap.ScopeWholeSubtree, ldap.DerefAlways, 0, 0, false,
+			filter, []string{groupAttr}, nil)
+		sr, err := conn.Search(req)
+		if err != nil {
+			return err
+		}
+		// the filter may find other users too, whose groups aren't
+		// this one's
+		for _, entry := range sr.Entries {
+			if strings.EqualFold(entry.DN, id.DN) {
+				id.Groups = entry.GetAttributeValues(groupAttr)
+				return nil
+			}
+		}
+		return errLDAPUserNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// ldapPolicies maps group memberships to policy IDs, falling back to
+// the default policy.
+func ldapPolicies(conf apidef.LDAPBindConfig, groups []string) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, group := range groups {
+		for groupDN, polID := range conf.GroupPolicies {
+			if strings.EqualFold(groupDN, group) && !seen[polID] {
+				seen[polID] = true
+				ids = append(ids, polID)
+			}
+		}
+	}
+	if len(ids) == 0 && conf.DefaultPolicyID != "" {
+		ids = append(ids, conf.DefaultPolicyID)
+	}
+	return ids
+}
+
+func ldapBindCacheKey(apiID, username, password string) string {
+	sum := sha256.Sum256([]byte(username + "\x00" + password))
+	return apiID + hex.EncodeToString(sum[:])
+}
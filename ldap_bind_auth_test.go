@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mavricknz/asn1-ber"
+	"github.com/mavricknz/ldap"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/user"
+)
+
+type testLDAPUser struct {
+	uid, password string
+	groups        []string
+	// unlisted users can bind, but aren't found by searches
+	unlisted bool
+}
+
+// testLDAPServer is an in-process stand-in for an LDAP server, which
+// understands just enough of the protocol for simple binds, equality
+// searches and StartTLS.
+type testLDAPServer struct {
+	ln         net.Listener
+	users      map[string]testLDAPUser
+	requireTLS bool
+	tlsConfig  *tls.Config
+
+	conns, binds int32
+}
+
+func newTestLDAPServer(t *testing.T, requireTLS bool) *testLDAPServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, cert := genServerCertificate()
+	s := &testLDAPServer{
+		ln: ln,
+		users: map[string]testLDAPUser{
+			"uid=alice,ou=people,dc=tyk": {"alice", "alice-pass", []string{"cn=admins,ou=groups,dc=tyk"}, false},
+			"uid=bob,ou=people,dc=tyk":   {"bob", "bob-pass", []string{"cn=guests,ou=groups,dc=tyk"}, false},
+			// another carol, who sorts first
+			"uid=carol,ou=admins,dc=tyk": {"carol", "", []string{"cn=admins,ou=groups,dc=tyk"}, false},
+			"uid=carol,ou=people,dc=tyk": {"carol", "carol-pass", []string{"cn=guests,ou=groups,dc=tyk"}, false},
+			"uid=dave,ou=people,dc=tyk":  {"dave", "dave-pass", nil, true},
+			// and another dave, who is found instead of the one above
+			"uid=dave,ou=admins,dc=tyk": {"dave", "", []string{"cn=admins,ou=groups,dc=tyk"}, false},
+		},
+		requireTLS: requireTLS,
+		tlsConfig:  &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.conns, 1)
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testLDAPServer) port() uint16 {
+	_, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return uint16(p)
+}
+
+func testLDAPResult(msgID uint64, tag uint8, code uint64) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagInteger, msgID, "MessageID"))
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	res.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagEnumerated, code, "Result Code"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, "", "Matched DN"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, "", "Diagnostic"))
+	p.AppendChild(res)
+	return p
+}
+
+func testLDAPEntry(msgID uint64, dn string, groups []string) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimative, ber.TagInteger, msgID, "MessageID"))
+	entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+	entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, dn, "DN"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+	attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, "memberOf", "Type"))
+	values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+	for _, group := range groups {
+		values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimative, ber.TagOctetString, group, "Value"))
+	}
+	attr.AppendChild(values)
+	attrs.AppendChild(attr)
+	entry.AppendChild(attrs)
+	p.AppendChild(entry)
+	return p
+}
+
+func (s *testLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	isTLS := false
+	for {
+		p, err := ber.ReadPacket(conn)
+		if err != nil || len(p.Children) < 2 {
+			return
+		}
+		msgID, _ := p.Children[0].Value.(uint64)
+		op := p.Children[1]
+		var resp []*ber.Packet
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			atomic.AddInt32(&s.binds, 1)
+			dn := op.Children[1].ValueString()
+			code := uint64(ldap.LDAPResultInvalidCredentials)
+			if s.requireTLS && !isTLS {
+				code = ldap.LDAPResultConfidentialityRequired
+			} else if u, ok := s.users[dn]; ok && u.password == op.Children[2].Data.String() {
+				code = ldap.LDAPResultSuccess
+			}
+			resp = append(resp, testLDAPResult(msgID, ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			// only (uid=...) filters are supported
+			filter := op.Children[6]
+			uid := ""
+			if len(filter.Children) == 2 && filter.Children[0].ValueString() == "uid" {
+				uid = filter.Children[1].ValueString()
+			}
+			var dns []string
+			for dn, u := range s.users {
+				if u.uid == uid && !u.unlisted {
+					dns = append(dns, dn)
+				}
+			}
+			sort.Strings(dns)
+			for _, dn := range dns {
+				resp = append(resp, testLDAPEntry(msgID, dn, s.users[dn].groups))
+			}
+			resp = append(resp, testLDAPResult(msgID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		case ldap.ApplicationExtendedRequest:
+			conn.Write(testLDAPResult(msgID, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess).Bytes())
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, isTLS = tlsConn, true
+			continue
+		default:
+			return
+		}
+		for _, r := range resp {
+			if _, err := conn.Write(r.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestLDAPBindAuth(t *testing.T) {
+	policiesMu.Lock()
+	policiesByID["ldap-admins"] = user.Policy{
+		ID:               "ldap-admins",
+		Rate:             1000.0,
+		Per:              1.0,
+		QuotaMax:         -1,
+		QuotaRenewalRate: -1,
+		AccessRights: map[string]user.AccessDefinition{"test": {
+			APIName:  "Test",
+			APIID:    "test",
+			Versions: []string{"v1"},
+		}},
+		Active: true,
+	}
+	policiesMu.Unlock()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen(ln, nil, nil)
+	defer ln.Close()
+	baseURL := "http://" + ln.Addr().String() + "/ldap/"
+
+	loadAPI := func(server *testLDAPServer, startTLS bool, modify ...func(*APISpec)) {
+		buildAndLoadAPI(func(spec *APISpec) {
+			for _, m := range modify {
+				m(spec)
+			}
+			spec.UseKeylessAccess = false
+			spec.UseBasicAuth = true
+			spec.Proxy.ListenPath = "/ldap/"
+			spec.LDAPBind = apidef.LDAPBindConfig{
+				Enabled:               true,
+				Server:                "127.0.0.1",
+				Port:                  server.port(),
+				StartTLS:              startTLS,
+				SSLInsecureSkipVerify: true,
+				UserDNTemplate:        "uid=TYKUSERNAME,ou=people,dc=tyk",
+				BaseDN:                "ou=people,dc=tyk",
+				UserFilter:            "(uid=TYKUSERNAME)",
+				GroupPolicies: map[string]string{
+					"cn=admins,ou=groups,dc=tyk": "ldap-admins",
+				},
+			}
+		})
+		LDAPBindCache.Flush()
+	}
+
+	get := func(username, password string) *http.Response {
+		req, _ := http.NewRequest("GET", baseURL, nil)
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("Bind", func(t *testing.T) {
+		server := newTestLDAPServer(t, false)
+		defer server.ln.Close()
+		loadAPI(server, false)
+
+		if resp := get("alice", "wrong"); resp.StatusCode != 401 || resp.Header.Get("WWW-Authenticate") == "" {
+			t.Error("Wrong password should be rejected with a basic auth challenge, got", resp.StatusCode)
+		}
+		if resp := get("bob", "bob-pass"); resp.StatusCode != 403 {
+			t.Error("User without mapped group should be forbidden, got", resp.StatusCode)
+		}
+		if resp := get("alice", "alice-pass"); resp.StatusCode != 200 {
+			t.Error("Valid user should be let through, got", resp.StatusCode)
+		}
+
+		binds := atomic.LoadInt32(&server.binds)
+		if resp := get("alice", "alice-pass"); resp.StatusCode != 200 {
+			t.Error("Cached user should be let through, got", resp.StatusCode)
+		}
+		if got := atomic.LoadInt32(&server.binds); got != binds {
+			t.Error("Cached credentials should not bind again")
+		}
+		if resp := get("alice", "alice-pass2"); resp.StatusCode != 401 {
+			t.Error("Cache should not accept other passwords, got", resp.StatusCode)
+		}
+		if got := atomic.LoadInt32(&server.conns); got != 1 {
+			t.Error("Connections should be pooled, got", got, "connections")
+		}
+	})
+
+	t.Run("UserFilter", func(t *testing.T) {
+		server := newTestLDAPServer(t, false)
+		defer server.ln.Close()
+		loadAPI(server, false)
+
+		if resp := get("carol", "carol-pass"); resp.StatusCode != 403 {
+			t.Error("User should not get the groups of another found by the filter, got", resp.StatusCode)
+		}
+		if resp := get("dave", "dave-pass"); resp.StatusCode != 403 {
+			t.Error("User not found by the filter should be forbidden, got", resp.StatusCode)
+		}
+	})
+
+	t.Run("CacheWithoutLimits", func(t *testing.T) {
+		server := newTestLDAPServer(t, false)
+		defer server.ln.Close()
+		loadAPI(server, false, func(spec *APISpec) {
+			spec.DisableRateLimit = true
+			spec.DisableQuota = true
+		})
+
+		for i := 0; i < 3; i++ {
+			if resp := get("alice", "alice-pass"); resp.StatusCode != 200 {
+				t.Fatal("Valid user should be let through, got", resp.StatusCode)
+			}
+		}
+		if got := atomic.LoadInt32(&server.binds); got != 1 {
+			t.Error("Cached credentials should not bind again, got", got, "binds")
+		}
+	})
+
+	t.Run("StartTLS", func(t *testing.T) {
+		server := newTestLDAPServer(t, true)
+		defer server.ln.Close()
+
+		loadAPI(server, false)
+		if resp := get("alice", "alice-pass"); resp.StatusCode != 503 {
+			t.Error("Server should refuse binds without TLS, got", resp.StatusCode)
+		}
+
+		loadAPI(server, true)
+		if resp := get("alice", "alice-pass"); resp.StatusCode != 200 {
+			t.Error("Valid user should be let through over StartTLS, got", resp.StatusCode)
+		}
+	})
+
+	t.Run("ServerDown", func(t *testing.T) {
+		server := newTestLDAPServer(t, false)
+		server.ln.Close()
+		loadAPI(server, false)
+
+		if resp := get("alice", "alice-pass"); resp.StatusCode != 503 {
+			t.Error("Unreachable LDAP server should give 503, got", resp.StatusCode)
+		}
+	})
+}
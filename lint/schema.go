@@ -143,9 +143,49 @@ const confSchema = `{
 			}
 		}
 	},
+	"basic_auth_hashing": {
+		"type": ["object", "null"],
+		"additionalProperties": false,
+		"properties": {
+			"argon2_memory": {
+				"type": "integer"
+			},
+			"argon2_threads": {
+				"type": "integer"
+			},
+			"argon2_time": {
+				"type": "integer"
+			},
+			"bcrypt_cost": {
+				"type": "integer"
+			},
+			"disable_rehash_on_login": {
+				"type": "boolean"
+			},
+			"hash_type": {
+				"type": "string",
+				"enum": ["", "bcrypt", "argon2id", "scrypt"]
+			},
+			"scrypt_n": {
+				"type": "integer"
+			},
+			"scrypt_p": {
+				"type": "integer"
+			},
+			"scrypt_r": {
+				"type": "integer"
+			}
+		}
+	},
 	"bundle_base_url": {
 		"type": "string"
 	},
+	"bundle_keep_versions": {
+		"type": "integer"
+	},
+	"bundle_require_signature": {
+		"type": "boolean"
+	},
 	"cache_storage": {
 		"$ref": "#/definitions/StorageOptions"
 	},
@@ -168,9 +208,24 @@ const confSchema = `{
 			"coprocess_grpc_server": {
 				"type": "string"
 			},
+			"coprocess_grpc_servers": {
+				"type": ["array", "null"],
+				"items": {
+					"type": "string"
+				}
+			},
 			"enable_coprocess": {
 				"type": "boolean"
 			},
+			"grpc_health_check_interval": {
+				"type": "integer"
+			},
+			"grpc_pool_size": {
+				"type": "integer"
+			},
+			"grpc_timeout": {
+				"type": "integer"
+			},
 			"python_path_prefix": {
 				"type": "string"
 			}
@@ -348,6 +403,31 @@ const confSchema = `{
 			}
 		}
 	},
+	"jsvm_engine": {
+		"type": "string",
+		"enum": ["", "otto", "goja"]
+	},
+	"jsvm_options": {
+		"type": ["object", "null"],
+		"additionalProperties": false,
+		"properties": {
+			"max_call_stack_size": {
+				"type": "integer"
+			},
+			"max_memory_mb": {
+				"type": "integer"
+			},
+			"max_vm_calls": {
+				"type": "integer"
+			},
+			"pool_size": {
+				"type": "integer"
+			},
+			"timeout": {
+				"type": "integer"
+			}
+		}
+	},
 	"legacy_enable_allowance_countdown": {
 		"type": "boolean"
 	},
@@ -407,9 +487,18 @@ const confSchema = `{
 					"header_map": {
 						"type": ["array", "null"]
 					},
+					"max_retry_age": {
+						"type": "integer"
+					},
 					"method": {
 						"type": "string"
 					},
+					"request_timeout": {
+						"type": "integer"
+					},
+					"secret": {
+						"type": "string"
+					},
 					"target_path": {
 						"type": "string"
 					},
@@ -617,6 +706,24 @@ const confSchema = `{
 	"use_syslog": {
 		"type": "boolean"
 	},
+	"wasm_options": {
+		"type": ["object", "null"],
+		"additionalProperties": false,
+		"properties": {
+			"enable_wasm": {
+				"type": "boolean"
+			},
+			"fuel": {
+				"type": "integer"
+			},
+			"max_memory_mb": {
+				"type": "integer"
+			},
+			"pool_size": {
+				"type": "integer"
+			}
+		}
+	},
 	"security": {
 		"type": ["object", "null"],
 		"additionalProperties": false,
@@ -664,6 +771,15 @@ const confSchema = `{
 	},
 	"enable_key_logging": {
 		"type": "boolean"
+	},
+	"trusted_proxies": {
+		"type": ["array", "null"],
+		"items": {
+			"type": "string"
+		}
+	},
+	"trust_x_real_ip": {
+		"type": "boolean"
 	}
 }
 }`
@@ -87,6 +87,7 @@ var (
 	debugMode          = kingpin.Flag("debug", "enable debug mode").Bool()
 	importBlueprint    = kingpin.Flag("import-blueprint", "import an API Blueprint file").PlaceHolder("FILE").String()
 	importSwagger      = kingpin.Flag("import-swagger", "import a Swagger file").PlaceHolder("FILE").String()
+	importWSDL         = kingpin.Flag("import-wsdl", "import a WSDL file as JSON endpoints for its SOAP service").PlaceHolder("FILE").String()
 	createAPI          = kingpin.Flag("create-api", "creates a new API definition from the blueprint").Bool()
 	orgID              = kingpin.Flag("org-id", "assign the API Definition to this org_id (required with create-api").String()
 	upstreamTarget     = kingpin.Flag("upstream-target", "set the upstream target for the definition").PlaceHolder("URL").String()
@@ -399,6 +400,10 @@ func loadAPIEndpoints(muxer *mux.Router) {
 	r.HandleFunc("/certs/{certID:[^/]*}", allowMethods(certHandler, "POST", "GET", "DELETE"))
 	r.HandleFunc("/oauth/clients/{apiID}", allowMethods(oAuthClientHandler, "GET", "DELETE"))
 	r.HandleFunc("/oauth/clients/{apiID}/{keyName:[^/]*}", allowMethods(oAuthClientHandler, "GET", "DELETE"))
+	r.HandleFunc("/webhooks/{list:queue|deadletter}", allowMethods(webhookHandler, "GET"))
+	r.HandleFunc("/webhooks/{list:queue|deadletter}/{deliveryID}", allowMethods(webhookHandler, "GET", "DELETE"))
+	r.HandleFunc("/webhooks/deadletter/{deliveryID}/replay", allowMethods(webhookReplayHandler, "POST"))
+	r.HandleFunc("/events", allowMethods(eventStreamHandler, "GET"))
 
 	log.WithFields(logrus.Fields{
 		"prefix": "main",
@@ -584,6 +589,31 @@ func creeateResponseMiddlewareChain(spec *APISpec) {
 		}).Debug("Loading Response processor: ", processorDetail.Name)
 		responseChain[i] = processor
 	}
+	if spec.CustomMiddleware.Driver == apidef.GoPluginDriver {
+		for _, obj := range spec.CustomMiddleware.Response {
+			processor := &ResponseGoPluginMiddleware{Path: obj.Path, SymbolName: obj.Name}
+			if err := processor.Init(nil, spec); err != nil {
+				log.WithFields(logrus.Fields{
+					"prefix": "goplugin",
+				}).Error("Failed to load Go plugin response middleware ", obj.Name, ": ", err)
+			}
+			responseChain = append(responseChain, processor)
+		}
+	}
+	if soapMediationEnabled(spec) {
+		// SOAP responses are turned into JSON before anything else
+		// gets to them
+		soap := &ResponseSOAPMiddleware{}
+		soap.Init(nil, spec)
+		responseChain = append([]TykResponseHandler{soap}, responseChain...)
+	}
+	if spec.Compression.Enabled {
+		// compression goes last, so that it compresses what the
+		// others have done
+		compression := &ResponseCompressionMiddleware{}
+		compression.Init(nil, spec)
+		responseChain = append(responseChain, compression)
+	}
 	spec.ResponseChain = responseChain
 }
 
@@ -946,6 +976,12 @@ func afterConfSetup(conf *config.Config) {
 	GlobalRPCPingTimeout = time.Second * time.Duration(conf.SlaveOptions.PingTimeout)
 	GlobalRPCCallTimeout = time.Second * time.Duration(conf.SlaveOptions.CallTimeout)
 	conf.EventTriggers = InitGenericEventHandlers(conf.EventHandlers)
+	if err := user.ValidateHashConf(conf.BasicAuthHashing); err != nil {
+		log.Error("Invalid basic_auth_hashing settings, using bcrypt: ", err)
+		conf.BasicAuthHashing = config.BasicAuthHashConf{
+			DisableRehashOnLogin: conf.BasicAuthHashing.DisableRehashOnLogin,
+		}
+	}
 }
 
 var hostDetails struct {
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"path/filepath"
 	"strconv"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 
 	"github.com/TykTechnologies/tyk/apidef"
 	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/coprocess"
 	"github.com/TykTechnologies/tyk/user"
 )
 
@@ -29,6 +31,13 @@ type TykMiddleware interface {
 	Name() string
 }
 
+// requestFinisher is implemented by middleware that holds on to something
+// for as long as the request is being handled, to let go of it once the
+// rest of the chain is done.
+type requestFinisher interface {
+	FinishRequest(r *http.Request)
+}
+
 func createDynamicMiddleware(name string, isPre, useSession bool, baseMid BaseMiddleware) func(http.Handler) http.Handler {
 	dMiddleware := &DynamicMiddleware{
 		BaseMiddleware:      baseMid,
@@ -80,6 +89,10 @@ func createMiddleware(mw TykMiddleware) func(http.Handler) http.Handler {
 				job.TimingKv(eventName+".exec_time", time.Since(startTime).Nanoseconds(), meta)
 				return
 			}
+			if f, ok := mw.(requestFinisher); ok {
+				// deferred, so that a panic further down doesn't keep hold
+				defer f.FinishRequest(r)
+			}
 
 			// Special code, bypasses all other execution
 			if errCode != mwStatusRespond {
@@ -110,6 +123,35 @@ func mwList(mws ...TykMiddleware) []alice.Constructor {
 	return list
 }
 
+// pluginMiddleware returns the middleware that runs a custom middleware
+// hook with a driver other than otto.
+func pluginMiddleware(baseMid BaseMiddleware, hookType coprocess.HookType, def apidef.MiddlewareDefinition, driver apidef.MiddlewareDriver) TykMiddleware {
+	if driver == apidef.GoPluginDriver {
+		return &GoPluginMiddleware{BaseMiddleware: baseMid, HookType: hookType, Path: def.Path, SymbolName: def.Name}
+	}
+	mw := &CoProcessMiddleware{
+		BaseMiddleware:   baseMid,
+		HookType:         hookType,
+		HookName:         def.Name,
+		MiddlewareDriver: driver,
+		Timeout:          def.Timeout,
+		FailOpen:         def.FailOpen,
+	}
+	if driver == apidef.WasmDriver {
+		return &WasmMiddleware{CoProcessMiddleware: mw, Path: def.Path}
+	}
+	return mw
+}
+
+// customMiddlewarePath returns where a custom middleware file is,
+// which is in the API's bundle if it has one.
+func customMiddlewarePath(spec *APISpec, path string) string {
+	if spec.CustomMiddlewareBundle == "" {
+		return path
+	}
+	return filepath.Join(config.Global.MiddlewarePath, "bundles", spec.loadedBundleDir(), path)
+}
+
 // BaseMiddleware wraps up the ApiSpec and Proxy objects to be included in a
 // middleware handler, this can probably be handled better.
 type BaseMiddleware struct {
@@ -184,6 +226,8 @@ func (t BaseMiddleware) ApplyPolicies(key string, session *user.SessionState) er
 				// Quotas
 				session.QuotaMax = policy.QuotaMax
 				session.QuotaRenewalRate = policy.QuotaRenewalRate
+				session.QuotaSchedule = policy.QuotaSchedule
+				session.QuotaTimezone = policy.QuotaTimezone
 			}
 
 			if policy.Partitions.RateLimit {
@@ -195,6 +239,7 @@ func (t BaseMiddleware) ApplyPolicies(key string, session *user.SessionState) er
 				session.Allowance = policy.Rate // This is a legacy thing, merely to make sure output is consistent. Needs to be purged
 				session.Rate = policy.Rate
 				session.Per = policy.Per
+				session.MaxInFlight = policy.MaxInFlight
 				if policy.LastUpdated != "" {
 					session.LastUpdated = policy.LastUpdated
 				}
@@ -203,10 +248,16 @@ func (t BaseMiddleware) ApplyPolicies(key string, session *user.SessionState) er
 			if policy.Partitions.Acl {
 				// ACL
 				if !didACL { // first, overwrite rights
-					session.AccessRights = policy.AccessRights
+					session.AccessRights = make(map[string]user.AccessDefinition, len(policy.AccessRights))
+					for k, v := range policy.AccessRights {
+						session.AccessRights[k] = v
+					}
 					didACL = true
 				} else { // second or later, merge
 					for k, v := range policy.AccessRights {
+						if prev, ok := session.AccessRights[k]; ok {
+							v = mergeAccessDefinitions(prev, v)
+						}
 						session.AccessRights[k] = v
 					}
 				}
@@ -221,11 +272,14 @@ func (t BaseMiddleware) ApplyPolicies(key string, session *user.SessionState) er
 			// Quotas
 			session.QuotaMax = policy.QuotaMax
 			session.QuotaRenewalRate = policy.QuotaRenewalRate
+			session.QuotaSchedule = policy.QuotaSchedule
+			session.QuotaTimezone = policy.QuotaTimezone
 
 			// Rate limting
 			session.Allowance = policy.Rate // This is a legacy thing, merely to make sure output is consistent. Needs to be purged
 			session.Rate = policy.Rate
 			session.Per = policy.Per
+			session.MaxInFlight = policy.MaxInFlight
 			if policy.LastUpdated != "" {
 				session.LastUpdated = policy.LastUpdated
 			}
@@ -253,6 +307,81 @@ func (t BaseMiddleware) ApplyPolicies(key string, session *user.SessionState) er
 	return t.Spec.SessionManager.UpdateSession(key, session, session.Lifetime(t.Spec.SessionLifetime))
 }
 
+// mergeAccessDefinitions combines the access two policies give to the
+// same API, keeping whatever is the most permissive of the two.
+func mergeAccessDefinitions(a, b user.AccessDefinition) user.AccessDefinition {
+	merged := a
+	merged.Versions = append([]string(nil), a.Versions...)
+	for _, v := range b.Versions {
+		found := false
+		for _, have := range merged.Versions {
+			if have == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged.Versions = append(merged.Versions, v)
+		}
+	}
+
+	// no allowed URLs means access to all of them
+	if len(a.AllowedURLs) == 0 || len(b.AllowedURLs) == 0 {
+		merged.AllowedURLs = nil
+		return merged
+	}
+	merged.AllowedURLs = append([]user.AccessSpec{}, a.AllowedURLs...)
+	for _, spec := range b.AllowedURLs {
+		found := false
+		for i, have := range merged.AllowedURLs {
+			if have.URL == spec.URL && sameMethods(have.Methods, spec.Methods) {
+				merged.AllowedURLs[i] = mergeAccessSpecLimits(have, spec)
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged.AllowedURLs = append(merged.AllowedURLs, spec)
+		}
+	}
+	return merged
+}
+
+func sameMethods(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]bool, len(a))
+	for _, m := range a {
+		seen[m] = true
+	}
+	for _, m := range b {
+		if !seen[m] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeAccessSpecLimits keeps the higher rate and quota of two specs for
+// the same endpoint, where a spec with no limit beats any limit.
+func mergeAccessSpecLimits(a, b user.AccessSpec) user.AccessSpec {
+	merged := a
+	switch {
+	case !a.HasRateLimit() || !b.HasRateLimit():
+		merged.Rate, merged.Per = 0, 0
+	case b.Rate/b.Per > a.Rate/a.Per:
+		merged.Rate, merged.Per = b.Rate, b.Per
+	}
+	switch {
+	case !a.HasQuota() || !b.HasQuota():
+		merged.QuotaMax, merged.QuotaRenewalRate = 0, 0
+	case float64(b.QuotaMax)/float64(b.QuotaRenewalRate) > float64(a.QuotaMax)/float64(a.QuotaRenewalRate):
+		merged.QuotaMax, merged.QuotaRenewalRate = b.QuotaMax, b.QuotaRenewalRate
+	}
+	return merged
+}
+
 // CheckSessionAndIdentityForValidKey will check first the Session store for a valid key, if not found, it will try
 // the Auth Handler, if not found it will fail
 func (t BaseMiddleware) CheckSessionAndIdentityForValidKey(key string) (user.SessionState, bool) {
@@ -313,7 +442,7 @@ func (t BaseMiddleware) CheckSessionAndIdentityForValidKey(key string) (user.Ses
 
 // FireEvent is added to the BaseMiddleware object so it is available across the entire stack
 func (t BaseMiddleware) FireEvent(name apidef.TykEvent, meta interface{}) {
-	fireEvent(name, meta, t.Spec.EventPaths)
+	t.Spec.FireEvent(name, meta)
 }
 
 type TykResponseHandler interface {
@@ -334,10 +463,17 @@ func responseProcessorByName(name string) TykResponseHandler {
 }
 
 func handleResponseChain(chain []TykResponseHandler, rw http.ResponseWriter, res *http.Response, req *http.Request, ses *user.SessionState) error {
+	if len(chain) == 0 {
+		return nil
+	}
+	// processors work on the body as it is, so undo any compression
+	// from the upstream and redo it afterwards
+	encoding := decodeResponse(res)
 	for _, rh := range chain {
 		if err := rh.HandleResponse(rw, res, req, ses); err != nil {
 			return err
 		}
 	}
+	encodeResponse(res, encoding)
 	return nil
 }
@@ -26,6 +26,7 @@ func (Monitor) Fire(sessionData *user.SessionState, key string, triggerLimit flo
 	}
 
 	go MonitoringHandler.HandleEvent(em)
+	eventStream.publish(em.Type, em.Meta, "", sessionData.OrgID)
 }
 
 func (m Monitor) Check(sessionData *user.SessionState, key string) {
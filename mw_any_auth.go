@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+// authMethodCustom is recorded for plugin auth, the other methods are
+// recorded by their identity type.
+const authMethodCustom = "custom"
+
+type anyAuthMethod struct {
+	name string
+	mw   TykMiddleware
+	conf interface{}
+}
+
+// AnyOfAuth is used instead of chaining the auth middlewares when an
+// API's auth mode is apidef.AuthModeAnyOf. Each method whose
+// credentials are present in the request is tried in turn, and the
+// first one to accept them authenticates the request.
+type AnyOfAuth struct {
+	BaseMiddleware
+	methods []anyAuthMethod
+}
+
+func (a *AnyOfAuth) Name() string {
+	return "AnyOfAuth"
+}
+
+// add registers an auth middleware, if it is enabled for the API.
+func (a *AnyOfAuth) add(mw TykMiddleware) bool {
+	if !mw.EnabledForSpec() {
+		return false
+	}
+	mw.Init()
+	conf, err := mw.Config()
+	if err != nil {
+		log.Fatal("[Middleware] Configuration load failed")
+	}
+	a.methods = append(a.methods, anyAuthMethod{authMethodName(mw), mw, conf})
+	return true
+}
+
+func authMethodName(mw TykMiddleware) string {
+	switch mw.(type) {
+	case *AuthKey:
+		return string(apidef.AuthToken)
+	case *Oauth2KeyExists:
+		return string(apidef.OAuthKey)
+	case *BasicAuthKeyIsValid:
+		return string(apidef.BasicAuthUser)
+	case *HMACMiddleware:
+		return string(apidef.HMACKey)
+	case *JWTMiddleware:
+		return string(apidef.JWTClaim)
+	case *OpenIDMW:
+		return string(apidef.OIDCUser)
+	}
+	return authMethodCustom
+}
+
+func authSchemeIs(r *http.Request, scheme string) bool {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	return len(parts) == 2 && strings.EqualFold(parts[0], scheme)
+}
+
+// hasCredentials reports whether a request carries credentials an
+// auth middleware could check. Plugins are always tried, as there's no
+// telling what they look at.
+func hasCredentials(mw TykMiddleware, r *http.Request) bool {
+	conf := mw.Base().Spec.Auth
+	switch x := mw.(type) {
+	case *AuthKey:
+		if r.Header.Get(conf.AuthHeaderName) != "" {
+			return true
+		}
+		if paramName := conf.ParamName; conf.UseParam || paramName != "" {
+			if paramName == "" {
+				paramName = conf.AuthHeaderName
+			}
+			if r.URL.Query().Get(paramName) != "" {
+				return true
+			}
+		}
+		if cookieName := conf.CookieName; conf.UseCookie || cookieName != "" {
+			if cookieName == "" {
+				cookieName = conf.AuthHeaderName
+			}
+			if c, err := r.Cookie(cookieName); err == nil && c.Value != "" {
+				return true
+			}
+		}
+		return conf.UseCertificate && r.TLS != nil && len(r.TLS.PeerCertificates) > 0
+	case *JWTMiddleware:
+		switch {
+		case conf.UseCookie:
+			c, err := r.Cookie(conf.AuthHeaderName)
+			return err == nil && c.Value != ""
+		case conf.UseParam:
+			return r.URL.Query().Get(conf.AuthHeaderName) != ""
+		}
+		return r.Header.Get(conf.AuthHeaderName) != ""
+	case *Oauth2KeyExists:
+		return authSchemeIs(r, "bearer")
+	case *BasicAuthKeyIsValid:
+		return authSchemeIs(r, "basic")
+	case *HMACMiddleware:
+		return authSchemeIs(r, "signature")
+	case *OpenIDMW:
+		raw, _ := x.idToken(r)
+		return raw != ""
+	}
+	return true
+}
+
+// authAttemptWriter holds back what an auth middleware writes, so that
+// only the attempt that decides the outcome reaches the client.
+type authAttemptWriter struct {
+	header http.Header
+	code   int
+	body   []byte
+}
+
+func (w *authAttemptWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *authAttemptWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.body = append(w.body, b...)
+	return len(b), nil
+}
+
+func (w *authAttemptWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *authAttemptWriter) flushTo(rw http.ResponseWriter) {
+	for k, v := range w.header {
+		rw.Header()[k] = v
+	}
+	if w.code != 0 {
+		rw.WriteHeader(w.code)
+		rw.Write(w.body)
+	}
+}
+
+func (a *AnyOfAuth) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	var failed *authAttemptWriter
+	var firstErr error
+	firstCode := 0
+
+	for _, m := range a.methods {
+		if !hasCredentials(m.mw, r) {
+			continue
+		}
+		attempt := &authAttemptWriter{header: make(http.Header)}
+		err, code := m.mw.ProcessRequest(attempt, r, m.conf)
+		if err == nil {
+			attempt.flushTo(w)
+			ctxSetAuthMethod(r, m.name)
+			if a.Spec.EnableContextVars {
+				if cnt := ctxGetData(r); cnt != nil {
+					cnt["auth_method"] = m.name
+					ctxSetData(r, cnt)
+				}
+			}
+			return nil, code
+		}
+		logEntry := getLogEntryForRequest(r, "", nil)
+		logEntry.Debug("Auth method ", m.name, " rejected request: ", err)
+		if firstErr == nil {
+			failed, firstErr, firstCode = attempt, err, code
+		}
+	}
+
+	if firstErr == nil {
+		logEntry := getLogEntryForRequest(r, "", nil)
+		logEntry.Info("Attempted access with no credentials for any auth method.")
+
+		return errors.New("Authorization field missing"), 401
+	}
+	// keep headers such as the basic auth challenge
+	for k, v := range failed.header {
+		w.Header()[k] = v
+	}
+	return firstErr, firstCode
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/user"
+)
+
+func TestAnyOfAuth(t *testing.T) {
+	policiesMu.Lock()
+	policiesByID["any-of-jwt"] = user.Policy{
+		ID:               "any-of-jwt",
+		Rate:             1000.0,
+		Per:              1.0,
+		QuotaMax:         -1,
+		QuotaRenewalRate: -1,
+		Active:           true,
+	}
+	policiesMu.Unlock()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen(ln, nil, nil)
+	defer ln.Close()
+	baseURL := "http://" + ln.Addr().String() + "/any/"
+
+	buildAndLoadAPI(func(spec *APISpec) {
+		spec.UseKeylessAccess = false
+		spec.UseStandardAuth = true
+		spec.EnableJWT = true
+		spec.AuthMode = apidef.AuthModeAnyOf
+		spec.BaseIdentityProvidedBy = apidef.AuthToken
+		spec.JWTSigningMethod = "rsa"
+		spec.JWTSource = base64.StdEncoding.EncodeToString([]byte(jwtRSAPubKey))
+		spec.JWTIdentityBaseField = "user_id"
+		spec.JWTPolicyFieldName = "policy_id"
+		spec.Auth.AuthHeaderName = "authorization"
+		spec.EnableContextVars = true
+		spec.Proxy.ListenPath = "/any/"
+		v := spec.VersionData.Versions["v1"]
+		v.GlobalHeaders = map[string]string{"X-Auth-Method": "$tyk_context.auth_method"}
+		spec.VersionData.Versions["v1"] = v
+	})
+
+	session := createNonThrottledSession()
+	FallbackKeySesionManager.UpdateSession("any-of-key", session, 60)
+	defer FallbackKeySesionManager.RemoveSession("any-of-key")
+
+	token := jwt.New(jwt.GetSigningMethod("RS512"))
+	token.Claims.(jwt.MapClaims)["user_id"] = "any-of-user"
+	token.Claims.(jwt.MapClaims)["policy_id"] = "any-of-jwt"
+	token.Claims.(jwt.MapClaims)["exp"] = time.Now().Add(time.Hour).Unix()
+	signKey, _ := jwt.ParseRSAPrivateKeyFromPEM([]byte(jwtRSAPrivKey))
+	tokenString, err := token.SignedString(signKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, auth string
+		code       int
+		method     string
+	}{
+		{"Key", "any-of-key", 200, string(apidef.AuthToken)},
+		{"JWT", "Bearer " + tokenString, 200, string(apidef.JWTClaim)},
+		{"Invalid", "Bearer not-a-credential", 403, ""},
+		{"Missing", "", 401, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", baseURL, nil)
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tc.code {
+				t.Fatalf("Wanted status %d, got %d", tc.code, resp.StatusCode)
+			}
+			if tc.method == "" {
+				return
+			}
+			var upstream testHttpResponse
+			json.NewDecoder(resp.Body).Decode(&upstream)
+			if got := upstream.Headers["X-Auth-Method"]; got != tc.method {
+				t.Errorf("Wanted auth method %q, got %q", tc.method, got)
+			}
+		})
+	}
+}
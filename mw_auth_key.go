@@ -69,7 +69,7 @@ func (k *AuthKey) ProcessRequest(w http.ResponseWriter, r *http.Request, _ inter
 
 	// If key not provided in header or cookie and client certificate is provided, try to find certificate based key
 	if config.UseCertificate && key == "" && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
-		key = k.Spec.OrgID + certs.HexSHA256(r.TLS.PeerCertificates[0].Raw)
+		return k.processCertificate(r)
 	}
 
 	if key == "" {
@@ -109,6 +109,46 @@ func (k *AuthKey) ProcessRequest(w http.ResponseWriter, r *http.Request, _ inter
 	return nil, 200
 }
 
+// processCertificate resolves the key for a client certificate, trying
+// the identity rules before the certificate hash.
+func (k *AuthKey) processCertificate(r *http.Request) (error, int) {
+	cert := r.TLS.PeerCertificates[0]
+	certID := k.Spec.OrgID + certs.HexSHA256(cert.Raw)
+
+	// the certificate check middleware has already done this
+	if !k.Spec.UseMutualTLSAuth {
+		if reason, msg := checkClientCertificate(k.Spec, r); reason != "" {
+			logEntry := getLogEntryForRequest(r, certID, nil)
+			logEntry.Info("Attempted access with invalid certificate: ", reason)
+
+			CertificateAuthFailed(k, r, certID, reason)
+			reportHealthValue(k.Spec, KeyFailure, "1")
+			return errors.New(msg), 403
+		}
+	}
+
+	for _, key := range certificateKeyCandidates(k.Spec, r) {
+		session, keyExists := k.CheckSessionAndIdentityForValidKey(key)
+		if !keyExists {
+			continue
+		}
+		switch k.Spec.BaseIdentityProvidedBy {
+		case apidef.AuthToken, apidef.UnsetAuth:
+			ctxSetSession(r, &session)
+			ctxSetAuthToken(r, key)
+			k.setContextVars(r, key)
+		}
+		return nil, 200
+	}
+
+	logEntry := getLogEntryForRequest(r, certID, nil)
+	logEntry.Info("Attempted access with unknown certificate.")
+
+	CertificateAuthFailed(k, r, certID, CertFailureUnknown)
+	reportHealthValue(k.Spec, KeyFailure, "1")
+	return errors.New("Key not authorised"), 403
+}
+
 func stripBearer(token string) string {
 	token = strings.Replace(token, "Bearer", "", 1)
 	token = strings.Replace(token, "bearer", "", 1)
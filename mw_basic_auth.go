@@ -5,10 +5,12 @@ import (
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	cache "github.com/pmylund/go-cache"
 
 	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
 	"github.com/TykTechnologies/tyk/user"
 )
 
@@ -71,6 +73,9 @@ func (k *BasicAuthKeyIsValid) ProcessRequest(w http.ResponseWriter, r *http.Requ
 	// Check if API key valid
 	keyName := k.Spec.OrgID + authValues[0]
 	logEntry = getLogEntryForRequest(r, keyName, nil)
+	if k.Spec.LDAPBind.Enabled {
+		return k.processLDAPBind(w, r, token, keyName, authValues[0], authValues[1])
+	}
 	session, keyExists := k.CheckSessionAndIdentityForValidKey(keyName)
 	if !keyExists {
 		logEntry.Info("Attempted access with non-existent user.")
@@ -85,20 +90,7 @@ func (k *BasicAuthKeyIsValid) ProcessRequest(w http.ResponseWriter, r *http.Requ
 	}
 
 	// Ensure that the username and password match up
-	var passMatch bool
-	switch session.BasicAuthData.Hash {
-	case user.HashBCrypt:
-		err := bcrypt.CompareHashAndPassword([]byte(session.BasicAuthData.Password), []byte(authValues[1]))
-		if err == nil {
-			passMatch = true
-		}
-	case user.HashPlainText:
-		if session.BasicAuthData.Password == authValues[1] {
-			passMatch = true
-		}
-	}
-
-	if !passMatch {
+	if !user.CheckPassword(session.BasicAuthData.Hash, session.BasicAuthData.Password, authValues[1]) {
 		logEntry.Info("Attempted access with existing user but failed password check.")
 
 		// Fire Authfailed Event
@@ -110,6 +102,11 @@ func (k *BasicAuthKeyIsValid) ProcessRequest(w http.ResponseWriter, r *http.Requ
 		return k.requestForBasicAuth(w, "User not authorised")
 	}
 
+	if !config.Global.BasicAuthHashing.DisableRehashOnLogin &&
+		user.NeedsRehash(session.BasicAuthData.Hash, session.BasicAuthData.Password) {
+		k.rehashPassword(keyName, &session, authValues[1])
+	}
+
 	// Set session state on context, we will need it later
 	switch k.Spec.BaseIdentityProvidedBy {
 	case apidef.BasicAuthUser, apidef.UnsetAuth:
@@ -120,3 +117,116 @@ func (k *BasicAuthKeyIsValid) ProcessRequest(w http.ResponseWriter, r *http.Requ
 	// Request is valid, carry on
 	return nil, 200
 }
+
+// rehashPassword stores the password with the current default hash
+// type and cost, now that we know it in the clear.
+func (k *BasicAuthKeyIsValid) rehashPassword(keyName string, session *user.SessionState, password string) {
+	hashType := user.DefaultHashType()
+	hashed, err := user.HashPassword(hashType, password)
+	if err != nil {
+		log.WithField("key", keyName).Error("Could not re-hash password: ", err)
+		return
+	}
+	session.BasicAuthData.Hash = hashType
+	session.BasicAuthData.Password = hashed
+	if err := k.Spec.SessionManager.UpdateSession(keyName, session, session.Lifetime(k.Spec.SessionLifetime)); err != nil {
+		log.WithField("key", keyName).Error("Could not save re-hashed password: ", err)
+		return
+	}
+	SessionCache.Set(keyName, *session, cache.DefaultExpiration)
+	log.WithField("key", keyName).Debug("Password re-hashed to ", hashType)
+}
+
+// processLDAPBind checks the credentials against the LDAP server, and
+// builds the session from the policies mapped to the user's groups.
+func (k *BasicAuthKeyIsValid) processLDAPBind(w http.ResponseWriter, r *http.Request, token, keyName, username, password string) (error, int) {
+	logEntry := getLogEntryForRequest(r, keyName, nil)
+	session, err := k.ldapSession(keyName, username, password)
+	switch err {
+	case nil:
+	case errLDAPInvalidCredentials:
+		logEntry.Info("Attempted access with invalid LDAP credentials.")
+
+		// Fire Authfailed Event
+		AuthFailed(k, r, token)
+
+		// Report in health check
+		reportHealthValue(k.Spec, KeyFailure, "-1")
+
+		return k.requestForBasicAuth(w, "User not authorised")
+	case errLDAPNoPolicy:
+		logEntry.Info("Attempted access by LDAP user with no matching policy.")
+
+		// Fire Authfailed Event
+		AuthFailed(k, r, token)
+
+		// Report in health check
+		reportHealthValue(k.Spec, KeyFailure, "-1")
+
+		return errors.New("Key not authorized: no matching policy"), 403
+	case errLDAPUserNotFound:
+		logEntry.Info("Attempted access by LDAP user not found by the user filter.")
+
+		// Fire Authfailed Event
+		AuthFailed(k, r, token)
+
+		// Report in health check
+		reportHealthValue(k.Spec, KeyFailure, "-1")
+
+		return errors.New("Key not authorized: user not found"), 403
+	default:
+		logEntry.Error("LDAP authentication failed: ", err)
+
+		return errors.New("Authentication service unavailable"), 503
+	}
+
+	switch k.Spec.BaseIdentityProvidedBy {
+	case apidef.BasicAuthUser, apidef.UnsetAuth:
+		ctxSetSession(r, &session)
+		ctxSetAuthToken(r, keyName)
+	}
+	return nil, 200
+}
+
+func (k *BasicAuthKeyIsValid) ldapSession(keyName, username, password string) (user.SessionState, error) {
+	conf := k.Spec.LDAPBind
+	cacheKey := ldapBindCacheKey(k.Spec.APIID, username, password)
+	// the identity is cached rather than the session, which may have
+	// expired or been removed from the store since
+	var id *ldapIdentity
+	if cached, found := LDAPBindCache.Get(cacheKey); found {
+		id = cached.(*ldapIdentity)
+	} else {
+		var err error
+		if id, err = ldapBind(conf, username, password); err != nil {
+			return user.SessionState{}, err
+		}
+		ttl := conf.CacheTTL
+		if ttl <= 0 {
+			ttl = ldapDefaultCacheTTL
+		}
+		LDAPBindCache.Set(cacheKey, id, time.Duration(ttl)*time.Second)
+	}
+	policies := ldapPolicies(conf, id.Groups)
+	if len(policies) == 0 {
+		return user.SessionState{}, errLDAPNoPolicy
+	}
+
+	// keep the existing session, if any, so that the rate limit and
+	// quota counters carry on
+	session, exists := k.CheckSessionAndIdentityForValidKey(keyName)
+	if !exists {
+		session = user.SessionState{OrgID: k.Spec.OrgID}
+	}
+	session.SetPolicies(policies...)
+	session.Alias = username
+	session.MetaData = map[string]interface{}{
+		"ldap_dn":     id.DN,
+		"ldap_groups": id.Groups,
+	}
+	if err := k.ApplyPolicies(keyName, &session); err != nil {
+		log.WithField("key", keyName).Error("Could not apply LDAP group policies: ", err)
+		return user.SessionState{}, errLDAPNoPolicy
+	}
+	return session, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -11,7 +12,9 @@ import (
 	"time"
 
 	"github.com/justinas/alice"
+	"golang.org/x/crypto/bcrypt"
 
+	"github.com/TykTechnologies/tyk/config"
 	"github.com/TykTechnologies/tyk/user"
 )
 
@@ -246,3 +249,82 @@ func TestBasicAuthWrongPassword(t *testing.T) {
 		t.Error("Request should have returned WWW-Authenticate header!: \n")
 	}
 }
+
+func TestBasicAuthHashTypes(t *testing.T) {
+	defer func() { config.Global.BasicAuthHashing = config.BasicAuthHashConf{} }()
+	// keep the tests fast
+	config.Global.BasicAuthHashing.Argon2Memory = 1024
+	config.Global.BasicAuthHashing.ScryptN = 1024
+	config.Global.BasicAuthHashing.BCryptCost = bcrypt.MinCost
+
+	spec := createSpecTest(t, basicAuthDef)
+	for _, hashType := range []user.HashType{user.HashBCrypt, user.HashArgon2ID, user.HashScrypt} {
+		t.Run(string(hashType), func(t *testing.T) {
+			config.Global.BasicAuthHashing.HashType = string(hashType)
+			session := createBasicAuthSession()
+			if err := setSessionPassword(session); err != nil {
+				t.Fatal(err)
+			}
+			if session.BasicAuthData.Hash != hashType {
+				t.Fatalf("Wanted hash type %q, got %q", hashType, session.BasicAuthData.Hash)
+			}
+			username := "hash-" + string(hashType)
+			spec.SessionManager.UpdateSession("default"+username, session, 60)
+
+			recorder := httptest.NewRecorder()
+			req := testReq(t, "GET", "/", nil)
+			req.SetBasicAuth(username, "TEST")
+			getBasicAuthChain(spec).ServeHTTP(recorder, req)
+			if recorder.Code != 200 {
+				t.Error("Request with hashed password should have gone through, got", recorder.Code)
+			}
+		})
+	}
+
+	t.Run("HashError", func(t *testing.T) {
+		config.Global.BasicAuthHashing.HashType = string(user.HashScrypt)
+		config.Global.BasicAuthHashing.ScryptN = 1000
+
+		session := createBasicAuthSession()
+		session.AccessRights = map[string]user.AccessDefinition{"1": {APIID: "1"}}
+		body, _ := json.Marshal(session)
+		recorder := httptest.NewRecorder()
+		mainRouter.ServeHTTP(recorder, withAuth(testReq(t, "POST", "/tyk/keys/hasherror", body)))
+		if recorder.Code != 500 {
+			t.Fatal("Key with a password that can't be hashed should not be saved, got", recorder.Code)
+		}
+		if _, found := spec.SessionManager.SessionDetail("hasherror"); found {
+			t.Fatal("Key should not have been stored")
+		}
+	})
+}
+
+func TestBasicAuthRehashOnLogin(t *testing.T) {
+	defer func() { config.Global.BasicAuthHashing = config.BasicAuthHashConf{} }()
+	config.Global.BasicAuthHashing.BCryptCost = bcrypt.MinCost
+
+	spec := createSpecTest(t, basicAuthDef)
+	session := createBasicAuthSession()
+	setSessionPassword(session)
+	spec.SessionManager.UpdateSession("defaultrehash", session, 60)
+
+	// switch the default, as an upgrade would
+	config.Global.BasicAuthHashing.HashType = string(user.HashArgon2ID)
+	config.Global.BasicAuthHashing.Argon2Memory = 1024
+
+	chain := getBasicAuthChain(spec)
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		req := testReq(t, "GET", "/", nil)
+		req.SetBasicAuth("rehash", "TEST")
+		chain.ServeHTTP(recorder, req)
+		if recorder.Code != 200 {
+			t.Fatal("Request should have gone through, got", recorder.Code)
+		}
+
+		stored, _ := spec.SessionManager.SessionDetail("defaultrehash")
+		if stored.BasicAuthData.Hash != user.HashArgon2ID {
+			t.Fatalf("Password should have been re-hashed to argon2id, got %q", stored.BasicAuthData.Hash)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/TykTechnologies/tyk/config"
@@ -24,8 +25,18 @@ func (m *CertificateCheckMW) ProcessRequest(w http.ResponseWriter, r *http.Reque
 		certIDs := append(m.Spec.ClientCertificates, config.Global.Security.Certificates.API...)
 
 		if err := CertificateManager.ValidateRequestCertificate(certIDs, r); err != nil {
+			reason := CertFailureUnknown
+			if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+				reason = CertFailureMissing
+			}
+			CertificateAuthFailed(m, r, "", reason)
 			return err, 403
 		}
+
+		if reason, msg := checkClientCertificate(m.Spec, r); reason != "" {
+			CertificateAuthFailed(m, r, "", reason)
+			return errors.New(msg), 403
+		}
 	}
 	return nil, 200
 }
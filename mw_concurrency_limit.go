@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	cache "github.com/pmylund/go-cache"
+	"github.com/satori/go.uuid"
+
+	"github.com/TykTechnologies/tyk/storage"
+)
+
+const (
+	ConcurrencyKeyPrefix = "concurrency-"
+
+	concurrencyDefaultLeaseTTL = 30 * time.Second
+	concurrencyPollInterval    = 20 * time.Millisecond
+)
+
+// leaseStorage is implemented by the stores that can keep in-flight
+// request counts across the cluster.
+type leaseStorage interface {
+	AcquireLease(keyName, lease string, max int64, ttl time.Duration) (bool, error)
+	ReleaseLease(keyName, lease string)
+}
+
+// concurrencyQueue keeps track of a node's requests waiting for a slot
+// under one limit, so that they can be woken as slots are freed on the
+// node, and so that priority requests go first.
+type concurrencyQueue struct {
+	mu       sync.Mutex
+	waiting  int64
+	priority int64
+	freed    chan struct{}
+}
+
+// concurrencyQueues holds the queues by limit key.
+var concurrencyQueues = cache.New(5*time.Minute, 10*time.Minute)
+
+var concurrencyQueuesMu sync.Mutex
+
+func getConcurrencyQueue(key string) *concurrencyQueue {
+	concurrencyQueuesMu.Lock()
+	defer concurrencyQueuesMu.Unlock()
+	if q, found := concurrencyQueues.Get(key); found {
+		return q.(*concurrencyQueue)
+	}
+	q := &concurrencyQueue{freed: make(chan struct{})}
+	concurrencyQueues.Set(key, q, cache.DefaultExpiration)
+	return q
+}
+
+// join adds a waiter to the queue, unless it is full.
+func (q *concurrencyQueue) join(priority bool, maxQueued int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if maxQueued > 0 && q.waiting >= maxQueued {
+		return false
+	}
+	q.waiting++
+	if priority {
+		q.priority++
+	}
+	return true
+}
+
+func (q *concurrencyQueue) leave(priority bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.waiting--
+	if priority {
+		q.priority--
+	}
+}
+
+// turn reports whether a waiter may try for a slot, which others may
+// only do while no priority requests are waiting.
+func (q *concurrencyQueue) turn(priority bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return priority || q.priority == 0
+}
+
+func (q *concurrencyQueue) freedChan() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.freed
+}
+
+// free wakes the waiters, as a slot was freed.
+func (q *concurrencyQueue) free() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	close(q.freed)
+	q.freed = make(chan struct{})
+}
+
+// concurrencyLease is a slot held under one limit by a request in flight.
+type concurrencyLease struct {
+	store   leaseStorage
+	key     string
+	name    string
+	max     int64
+	ttl     time.Duration
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// keepAlive renews the lease while the request is in flight, so that
+// slow requests don't lose their slot.
+func (l *concurrencyLease) keepAlive() {
+	defer close(l.stopped)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			if _, err := l.store.AcquireLease(l.key, l.name, l.max, l.ttl); err != nil {
+				log.Warning("Failed to renew concurrency lease: ", err)
+			}
+		}
+	}
+}
+
+func (l *concurrencyLease) release() {
+	close(l.done)
+	// a renewal under way would take the slot back
+	<-l.stopped
+	l.store.ReleaseLease(l.key, l.name)
+	getConcurrencyQueue(l.key).free()
+}
+
+// ConcurrencyLimitMiddleware caps the requests in flight to an API, in all
+// and for each key, queueing those over the limit for a while.
+type ConcurrencyLimitMiddleware struct {
+	BaseMiddleware
+}
+
+func (m *ConcurrencyLimitMiddleware) Name() string {
+	return "ConcurrencyLimitMiddleware"
+}
+
+func (m *ConcurrencyLimitMiddleware) EnabledForSpec() bool {
+	conf := m.Spec.ConcurrencyLimit
+	if conf.MaxInFlight > 0 || conf.MaxInFlightPerKey > 0 {
+		return true
+	}
+	// keys may have limits of their own
+	return !m.Spec.UseKeylessAccess
+}
+
+// Init checks that the API's store can count requests in flight, as
+// no limits are enforced otherwise.
+func (m *ConcurrencyLimitMiddleware) Init() {
+	if _, ok := m.Spec.SessionManager.Store().(leaseStorage); ok {
+		return
+	}
+	logEntry := log.WithFields(logrus.Fields{
+		"prefix":   "main",
+		"api_name": m.Spec.Name,
+	})
+	conf := m.Spec.ConcurrencyLimit
+	if conf.MaxInFlight > 0 || conf.MaxInFlightPerKey > 0 {
+		logEntry.Error("Store doesn't support concurrency limits, they won't be enforced")
+		return
+	}
+	logEntry.Warning("Store doesn't support concurrency limits, those of keys won't be enforced")
+}
+
+func (m *ConcurrencyLimitMiddleware) leaseTTL() time.Duration {
+	if ttl := m.Spec.ConcurrencyLimit.LeaseTTL; ttl > 0 {
+		return time.Duration(ttl) * time.Second
+	}
+	return concurrencyDefaultLeaseTTL
+}
+
+func (m *ConcurrencyLimitMiddleware) isPriority(r *http.Request) bool {
+	session := ctxGetSession(r)
+	if session == nil {
+		return false
+	}
+	for _, tag := range session.Tags {
+		for _, priorityTag := range m.Spec.ConcurrencyLimit.PriorityTags {
+			if tag == priorityTag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acquire takes a slot under a limit, waiting in the node's queue for
+// one to be freed until the deadline. Waiters are woken as slots on the
+// node are freed, and check every so often for those freed elsewhere.
+func (m *ConcurrencyLimitMiddleware) acquire(store leaseStorage, key string, max int64, priority bool, deadline time.Time) (*concurrencyLease, error) {
+	lease := &concurrencyLease{
+		store:   store,
+		key:     key,
+		name:    uuid.NewV4().String(),
+		max:     max,
+		ttl:     m.leaseTTL(),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	q := getConcurrencyQueue(key)
+	queued := false
+	defer func() {
+		if queued {
+			q.leave(priority)
+		}
+	}()
+
+	for {
+		if q.turn(priority) {
+			acquired, err := store.AcquireLease(key, lease.name, max, lease.ttl)
+			if err != nil {
+				return nil, err
+			}
+			if acquired {
+				go lease.keepAlive()
+				return lease, nil
+			}
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil, nil
+		}
+		if !queued {
+			if !q.join(priority, m.Spec.ConcurrencyLimit.MaxQueued) {
+				return nil, nil
+			}
+			queued = true
+		}
+		if wait > concurrencyPollInterval {
+			wait = concurrencyPollInterval
+		}
+		select {
+		case <-q.freedChan():
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (m *ConcurrencyLimitMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	store, ok := m.Spec.SessionManager.Store().(leaseStorage)
+	if !ok {
+		// Init has already said so
+		return nil, 200
+	}
+	conf := m.Spec.ConcurrencyLimit
+	priority := m.isPriority(r)
+	deadline := time.Now().Add(time.Duration(conf.QueueTimeout) * time.Millisecond)
+	var leases []*concurrencyLease
+
+	perKey := conf.MaxInFlightPerKey
+	if session := ctxGetSession(r); session != nil && session.MaxInFlight > 0 {
+		perKey = session.MaxInFlight
+	}
+	if token := ctxGetAuthToken(r); token != "" && perKey > 0 {
+		key := ConcurrencyKeyPrefix + m.Spec.APIID + "-" + storage.HashKey(token)
+		lease, err := m.acquire(store, key, perKey, priority, deadline)
+		if err != nil {
+			// let the request through, as the rate limiter does
+			log.Error("Failed to count request in flight: ", err)
+			return nil, 200
+		}
+		if lease == nil {
+			logEntry := getLogEntryForRequest(r, token, nil)
+			logEntry.Info("Key concurrency limit exceeded.")
+			return errors.New("Too many requests in flight"), 429
+		}
+		leases = append(leases, lease)
+	}
+
+	if conf.MaxInFlight > 0 {
+		lease, err := m.acquire(store, ConcurrencyKeyPrefix+m.Spec.APIID, conf.MaxInFlight, priority, deadline)
+		if err != nil {
+			log.Error("Failed to count request in flight: ", err)
+		} else if lease == nil {
+			for _, l := range leases {
+				l.release()
+			}
+			logEntry := getLogEntryForRequest(r, ctxGetAuthToken(r), nil)
+			logEntry.Info("API concurrency limit exceeded, shedding request.")
+			reportHealthValue(m.Spec, Throttle, "-1")
+			return errors.New("Service is too busy, please try again later"), 503
+		} else {
+			leases = append(leases, lease)
+		}
+	}
+
+	if len(leases) > 0 {
+		ctxSetConcurrencyLeases(r, leases)
+	}
+	return nil, 200
+}
+
+// FinishRequest frees the slots the request held.
+func (m *ConcurrencyLimitMiddleware) FinishRequest(r *http.Request) {
+	for _, l := range ctxGetConcurrencyLeases(r) {
+		l.release()
+	}
+}
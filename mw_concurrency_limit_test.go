@@ -0,0 +1,234 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/storage"
+	"github.com/TykTechnologies/tyk/user"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	// key tags come from their policies
+	policiesMu.Lock()
+	policiesByID["concurrency-premium"] = user.Policy{
+		ID:               "concurrency-premium",
+		Rate:             1000.0,
+		Per:              1.0,
+		QuotaMax:         -1,
+		QuotaRenewalRate: -1,
+		Tags:             []string{"premium"},
+		Active:           true,
+	}
+	policiesMu.Unlock()
+	defer func() {
+		policiesMu.Lock()
+		delete(policiesByID, "concurrency-premium")
+		policiesMu.Unlock()
+	}()
+
+	var mu sync.Mutex
+	var arrived []string
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrived = append(arrived, r.Header.Get("X-Client"))
+		mu.Unlock()
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen(ln, nil, nil)
+	defer ln.Close()
+	baseURL := "http://" + ln.Addr().String() + "/concurrency/"
+
+	var apiID string
+	loadAPI := func(conf apidef.ConcurrencyLimitConfig) {
+		buildAndLoadAPI(func(spec *APISpec) {
+			apiID = spec.APIID
+			spec.UseKeylessAccess = false
+			spec.Auth.AuthHeaderName = "authorization"
+			spec.Proxy.ListenPath = "/concurrency/"
+			spec.Proxy.TargetURL = upstream.URL
+			spec.ConcurrencyLimit = conf
+		})
+		// leases left behind by an earlier run would hold the slots
+		storage.RedisCluster{}.DeleteRawKey(ConcurrencyKeyPrefix + apiID)
+	}
+	loadAPI(apidef.ConcurrencyLimitConfig{})
+
+	newKey := func(policies ...string) string {
+		key := uuid.NewV4().String()
+		session := createNonThrottledSession()
+		session.QuotaMax = -1
+		session.ApplyPolicies = policies
+		FallbackKeySesionManager.UpdateSession(key, session, 60)
+		return key
+	}
+
+	get := func(key, client string) int {
+		req, _ := http.NewRequest("GET", baseURL, nil)
+		req.Header.Set("Authorization", key)
+		req.Header.Set("X-Client", client)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// goGet sends a request in the background, which holds its slot until
+	// the upstream is let go.
+	goGet := func(key, client string) <-chan int {
+		code := make(chan int, 1)
+		go func() { code <- get(key, client) }()
+		return code
+	}
+
+	resetArrived := func() {
+		mu.Lock()
+		arrived = nil
+		mu.Unlock()
+	}
+	waitArrived := func(t *testing.T, n int) {
+		for i := 0; i < 500; i++ {
+			mu.Lock()
+			got := len(arrived)
+			mu.Unlock()
+			if got >= n {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Wanted %d requests at the upstream", n)
+	}
+	// waitQueued waits for n requests to be queued for the API's slots
+	// on this node.
+	waitQueued := func(t *testing.T, n int64) {
+		q := getConcurrencyQueue(ConcurrencyKeyPrefix + apiID)
+		for i := 0; i < 500; i++ {
+			q.mu.Lock()
+			got := q.waiting
+			q.mu.Unlock()
+			if got >= n {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Wanted %d requests queued", n)
+	}
+
+	t.Run("PerKey", func(t *testing.T) {
+		loadAPI(apidef.ConcurrencyLimitConfig{MaxInFlightPerKey: 1})
+		key, other := newKey(), newKey()
+		defer FallbackKeySesionManager.RemoveSession(key)
+		defer FallbackKeySesionManager.RemoveSession(other)
+		resetArrived()
+
+		first := goGet(key, "first")
+		waitArrived(t, 1)
+		if code := get(key, "second"); code != 429 {
+			t.Error("Wanted the second request in flight to be rejected, got", code)
+		}
+		second := goGet(other, "other")
+		waitArrived(t, 2)
+
+		release <- struct{}{}
+		release <- struct{}{}
+		if code := <-first; code != 200 {
+			t.Error("Wanted the first request through, got", code)
+		}
+		<-second
+	})
+
+	t.Run("Priority", func(t *testing.T) {
+		loadAPI(apidef.ConcurrencyLimitConfig{
+			MaxInFlight:  1,
+			QueueTimeout: 5000,
+			PriorityTags: []string{"premium"},
+		})
+		normal, premium := newKey(), newKey("concurrency-premium")
+		defer FallbackKeySesionManager.RemoveSession(normal)
+		defer FallbackKeySesionManager.RemoveSession(premium)
+		resetArrived()
+
+		holder := goGet(normal, "holder")
+		waitArrived(t, 1)
+		queuedNormal := goGet(normal, "normal")
+		waitQueued(t, 1)
+		queuedPremium := goGet(premium, "premium")
+		waitQueued(t, 2)
+
+		release <- struct{}{}
+		waitArrived(t, 2)
+		release <- struct{}{}
+		waitArrived(t, 3)
+		release <- struct{}{}
+		for _, code := range []<-chan int{holder, queuedNormal, queuedPremium} {
+			if got := <-code; got != 200 {
+				t.Error("Wanted queued requests through, got", got)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if arrived[1] != "premium" || arrived[2] != "normal" {
+			t.Error("Wanted the premium request let in first, got", arrived)
+		}
+	})
+
+	t.Run("Shed", func(t *testing.T) {
+		loadAPI(apidef.ConcurrencyLimitConfig{MaxInFlight: 1, QueueTimeout: 100})
+		key := newKey()
+		defer FallbackKeySesionManager.RemoveSession(key)
+		resetArrived()
+
+		holder := goGet(key, "holder")
+		waitArrived(t, 1)
+		if code := get(key, "shed"); code != 503 {
+			t.Error("Wanted the request shed once its wait was up, got", code)
+		}
+		release <- struct{}{}
+		<-holder
+	})
+}
+
+type finishRecorder struct {
+	BaseMiddleware
+	finished bool
+}
+
+func (m *finishRecorder) Name() string { return "finishRecorder" }
+
+func (m *finishRecorder) ProcessRequest(http.ResponseWriter, *http.Request, interface{}) (error, int) {
+	return nil, 200
+}
+
+func (m *finishRecorder) FinishRequest(*http.Request) { m.finished = true }
+
+func TestFinishRequestOnPanic(t *testing.T) {
+	mw := &finishRecorder{BaseMiddleware: BaseMiddleware{Spec: &APISpec{APIDefinition: &apidef.APIDefinition{}}}}
+	h := createMiddleware(mw)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("upstream failed")
+	}))
+	func() {
+		defer func() { recover() }()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+	if !mw.finished {
+		t.Error("Wanted FinishRequest called after a panic down the chain")
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	cache "github.com/pmylund/go-cache"
+	"google.golang.org/grpc"
+
+	"github.com/TykTechnologies/tyk/coprocess"
+)
+
+const (
+	externalAuthzDefaultTimeout = 5
+	externalAuthzHookName       = "external_authz"
+	externalAuthzMaxBody        = 1 << 20
+)
+
+// ExternalAuthzCache holds authorization decisions for the configured
+// TTL, keyed by everything that was sent to the service to make them.
+var ExternalAuthzCache = cache.New(time.Minute, 5*time.Minute)
+
+var (
+	externalAuthzConnsMu sync.Mutex
+	externalAuthzConns   = map[string]*grpc.ClientConn{}
+)
+
+// ExternalAuthzRequest is what is sent to an HTTP authorization service.
+type ExternalAuthzRequest struct {
+	APIID    string                 `json:"api_id"`
+	OrgID    string                 `json:"org_id"`
+	Method   string                 `json:"method"`
+	Path     string                 `json:"path"`
+	Query    string                 `json:"query"`
+	Headers  map[string]string      `json:"headers"`
+	RemoteIP string                 `json:"remote_ip"`
+	Alias    string                 `json:"alias"`
+	Policies []string               `json:"policies"`
+	MetaData map[string]interface{} `json:"meta_data"`
+}
+
+// ExternalAuthzResponse is the optional body of the reply of an HTTP
+// authorization service. A 2xx status allows the request, in which case
+// Headers are added to it. A 4xx status denies it, and Headers are
+// added to the response instead.
+type ExternalAuthzResponse struct {
+	Message string            `json:"message"`
+	Headers map[string]string `json:"headers"`
+}
+
+type externalAuthzDecision struct {
+	allowed bool
+	code    int
+	message string
+	headers map[string]string
+}
+
+// ExternalAuthzMiddleware asks an external service whether an
+// authenticated request may go through.
+type ExternalAuthzMiddleware struct {
+	BaseMiddleware
+}
+
+func (m *ExternalAuthzMiddleware) Name() string {
+	return "ExternalAuthzMiddleware"
+}
+
+func (m *ExternalAuthzMiddleware) EnabledForSpec() bool {
+	return m.Spec.ExternalAuthz.Enabled
+}
+
+func (m *ExternalAuthzMiddleware) timeout() time.Duration {
+	if t := m.Spec.ExternalAuthz.Timeout; t > 0 {
+		return time.Duration(t) * time.Second
+	}
+	return externalAuthzDefaultTimeout * time.Second
+}
+
+func (m *ExternalAuthzMiddleware) buildRequest(r *http.Request) *ExternalAuthzRequest {
+	req := &ExternalAuthzRequest{
+		APIID:    m.Spec.APIID,
+		OrgID:    m.Spec.OrgID,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Query:    r.URL.RawQuery,
+		Headers:  make(map[string]string, len(r.Header)),
+		RemoteIP: requestIP(r),
+	}
+	for k, v := range r.Header {
+		req.Headers[k] = strings.Join(v, ",")
+	}
+	if session := ctxGetSession(r); session != nil {
+		req.Alias = session.Alias
+		req.Policies = session.PolicyIDs()
+		req.MetaData = session.MetaData
+	}
+	return req
+}
+
+func (m *ExternalAuthzMiddleware) checkHTTP(authzReq *ExternalAuthzRequest) (*externalAuthzDecision, error) {
+	body, err := json.Marshal(authzReq)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: m.timeout()}
+	resp, err := client.Post(m.Spec.ExternalAuthz.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 || resp.StatusCode < 200 || (resp.StatusCode >= 300 && resp.StatusCode < 400) {
+		return nil, fmt.Errorf("authorization service returned status %d", resp.StatusCode)
+	}
+
+	var authzResp ExternalAuthzResponse
+	raw, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, externalAuthzMaxBody))
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(raw)) > 0 {
+		if err := json.Unmarshal(raw, &authzResp); err != nil {
+			return nil, fmt.Errorf("malformed authorization response: %v", err)
+		}
+	}
+	return &externalAuthzDecision{
+		allowed: resp.StatusCode < 300,
+		code:    resp.StatusCode,
+		message: authzResp.Message,
+		headers: authzResp.Headers,
+	}, nil
+}
+
+func externalAuthzConn(addr string) (*grpc.ClientConn, error) {
+	externalAuthzConnsMu.Lock()
+	defer externalAuthzConnsMu.Unlock()
+	if conn := externalAuthzConns[addr]; conn != nil {
+		return conn, nil
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	target := addr
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	switch u.Scheme {
+	case "unix":
+		target = u.Path
+		opts = append(opts, grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}))
+	case "tcp":
+		target = u.Host
+	}
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	externalAuthzConns[addr] = conn
+	return conn, nil
+}
+
+// checkGRPC calls a coprocess Dispatcher, with the request details in
+// the object metadata. A return override with an error code denies the
+// request, otherwise the headers it sets are added.
+func (m *ExternalAuthzMiddleware) checkGRPC(authzReq *ExternalAuthzRequest) (*externalAuthzDecision, error) {
+	conn, err := externalAuthzConn(m.Spec.ExternalAuthz.URL)
+	if err != nil {
+		return nil, err
+	}
+	metaData, _ := json.Marshal(authzReq.MetaData)
+	object := &coprocess.Object{
+		HookType: coprocess.HookType_PostKeyAuth,
+		HookName: externalAuthzHookName,
+		Request: &coprocess.MiniRequestObject{
+			Headers: authzReq.Headers,
+			Url:     authzReq.Path,
+		},
+		Session: &coprocess.SessionState{
+			Alias:         authzReq.Alias,
+			ApplyPolicies: authzReq.Policies,
+			Metadata:      string(metaData),
+			OrgId:         authzReq.OrgID,
+		},
+		Metadata: map[string]string{
+			"method":    authzReq.Method,
+			"path":      authzReq.Path,
+			"query":     authzReq.Query,
+			"remote_ip": authzReq.RemoteIP,
+		},
+		Spec: map[string]string{
+			"APIID": authzReq.APIID,
+			"OrgID": authzReq.OrgID,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout())
+	defer cancel()
+	reply, err := coprocess.NewDispatcherClient(conn).Dispatch(ctx, object)
+	if err != nil {
+		return nil, err
+	}
+
+	decision := &externalAuthzDecision{allowed: true, code: 200}
+	if reply.Request == nil {
+		return decision, nil
+	}
+	if ro := reply.Request.ReturnOverrides; ro != nil && ro.ResponseCode >= 400 {
+		if ro.ResponseCode >= 500 {
+			return nil, fmt.Errorf("authorization service returned code %d", ro.ResponseCode)
+		}
+		decision.allowed = false
+		decision.code = int(ro.ResponseCode)
+		decision.message = ro.ResponseError
+		decision.headers = ro.Headers
+		return decision, nil
+	}
+	decision.headers = reply.Request.SetHeaders
+	return decision, nil
+}
+
+// externalAuthzCacheKey hashes all of an authorization request, as the
+// service may base its decision on any of it. The key is in there too,
+// as it's in the headers.
+func externalAuthzCacheKey(authzReq *ExternalAuthzRequest) (string, error) {
+	b, err := json.Marshal(authzReq)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (m *ExternalAuthzMiddleware) decide(r *http.Request) (*externalAuthzDecision, error) {
+	conf := m.Spec.ExternalAuthz
+	authzReq := m.buildRequest(r)
+	var key string
+	if conf.CacheTTL > 0 {
+		var err error
+		if key, err = externalAuthzCacheKey(authzReq); err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix":   "external_authz",
+				"api_name": m.Spec.Name,
+			}).Warning("Not caching authorization decision: ", err)
+		} else if cached, found := ExternalAuthzCache.Get(key); found {
+			return cached.(*externalAuthzDecision), nil
+		}
+	}
+
+	var decision *externalAuthzDecision
+	var err error
+	switch conf.Protocol {
+	case "", "http":
+		decision, err = m.checkHTTP(authzReq)
+	case "grpc":
+		decision, err = m.checkGRPC(authzReq)
+	default:
+		err = errors.New("unknown protocol " + conf.Protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		ExternalAuthzCache.Set(key, decision, time.Duration(conf.CacheTTL)*time.Second)
+	}
+	return decision, nil
+}
+
+func (m *ExternalAuthzMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	logger := log.WithFields(logrus.Fields{
+		"prefix":   "external_authz",
+		"api_name": m.Spec.Name,
+	})
+
+	decision, err := m.decide(r)
+	if err != nil {
+		if m.Spec.ExternalAuthz.FailOpen {
+			logger.Warning("Authorization service failed, allowing request: ", err)
+			return nil, 200
+		}
+		logger.Error("Authorization service failed: ", err)
+		return errors.New("Authorization service unavailable"), 503
+	}
+
+	if !decision.allowed {
+		logEntry := getLogEntryForRequest(r, ctxGetAuthToken(r), nil)
+		logEntry.Info("Request denied by authorization service.")
+
+		for k, v := range decision.headers {
+			w.Header().Set(k, v)
+		}
+		msg := decision.message
+		if msg == "" {
+			msg = "Access to this resource has been disallowed"
+		}
+		return errors.New(msg), decision.code
+	}
+
+	for k, v := range decision.headers {
+		r.Header.Set(k, v)
+	}
+	return nil, 200
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/coprocess"
+)
+
+// testGRPCAuthz denies requests from the "blocked" alias.
+type testGRPCAuthz struct{}
+
+func (testGRPCAuthz) Dispatch(ctx context.Context, object *coprocess.Object) (*coprocess.Object, error) {
+	if object.Session.Alias == "blocked" || object.Metadata["method"] != "GET" {
+		object.Request.ReturnOverrides = &coprocess.ReturnOverrides{
+			ResponseCode:  403,
+			ResponseError: "Blocked by policy",
+		}
+		return object, nil
+	}
+	object.Request.SetHeaders = map[string]string{"X-Authz": "grpc"}
+	return object, nil
+}
+
+func (testGRPCAuthz) DispatchEvent(ctx context.Context, event *coprocess.Event) (*coprocess.EventReply, error) {
+	return &coprocess.EventReply{}, nil
+}
+
+func TestExternalAuthz(t *testing.T) {
+	var hits int32
+	authz := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		var req ExternalAuthzRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Alias == "blocked" || req.MetaData["tier"] != "gold" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(403)
+			json.NewEncoder(w).Encode(ExternalAuthzResponse{
+				Message: "Blocked by policy",
+				Headers: map[string]string{"X-Authz-Reason": "tier"},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(ExternalAuthzResponse{
+			Headers: map[string]string{"X-Authz": "http", "X-Authz-Path": req.Path},
+		})
+	}))
+	defer authz.Close()
+
+	grpcLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	coprocess.RegisterDispatcherServer(grpcServer, testGRPCAuthz{})
+	go grpcServer.Serve(grpcLn)
+	defer grpcServer.Stop()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen(ln, nil, nil)
+	defer ln.Close()
+	baseURL := "http://" + ln.Addr().String() + "/authz/"
+
+	loadAPI := func(conf apidef.ExternalAuthzConfig) {
+		buildAndLoadAPI(func(spec *APISpec) {
+			spec.UseKeylessAccess = false
+			spec.Auth.AuthHeaderName = "authorization"
+			spec.Proxy.ListenPath = "/authz/"
+			spec.ExternalAuthz = conf
+		})
+		ExternalAuthzCache.Flush()
+	}
+
+	// loading the API sets up the key store
+	loadAPI(apidef.ExternalAuthzConfig{})
+	for key, alias := range map[string]string{"authz-gold": "gold", "authz-blocked": "blocked"} {
+		session := createNonThrottledSession()
+		session.QuotaMax = -1
+		session.Alias = alias
+		session.MetaData = map[string]interface{}{"tier": "gold"}
+		FallbackKeySesionManager.UpdateSession(key, session, 60)
+		defer FallbackKeySesionManager.RemoveSession(key)
+	}
+
+	getPath := func(t *testing.T, key, path string, code int) (testHttpResponse, http.Header) {
+		req, _ := http.NewRequest("GET", baseURL+path, nil)
+		req.Header.Set("Authorization", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("Wanted status %d, got %d", code, resp.StatusCode)
+		}
+		var upstream testHttpResponse
+		if code == 200 {
+			json.NewDecoder(resp.Body).Decode(&upstream)
+		}
+		return upstream, resp.Header
+	}
+	get := func(t *testing.T, key string, code int) (testHttpResponse, http.Header) {
+		return getPath(t, key, "resource", code)
+	}
+
+	t.Run("HTTP", func(t *testing.T) {
+		loadAPI(apidef.ExternalAuthzConfig{Enabled: true, URL: authz.URL, CacheTTL: 60})
+
+		atomic.StoreInt32(&hits, 0)
+		upstream, _ := get(t, "authz-gold", 200)
+		if upstream.Headers["X-Authz"] != "http" || upstream.Headers["X-Authz-Path"] != "/authz/resource" {
+			t.Error("Headers from authorization service should be added, got", upstream.Headers)
+		}
+		get(t, "authz-gold", 200)
+		if got := atomic.LoadInt32(&hits); got != 1 {
+			t.Error("Decisions should be cached, got", got, "calls")
+		}
+		// anything sent to the service may change its decision
+		getPath(t, "authz-gold", "resource?id=2", 200)
+		if got := atomic.LoadInt32(&hits); got != 2 {
+			t.Error("Decisions should be cached by query string, got", got, "calls")
+		}
+		if _, header := get(t, "authz-blocked", 403); header.Get("X-Authz-Reason") != "tier" {
+			t.Error("Deny headers should be passed on")
+		}
+	})
+
+	t.Run("GRPC", func(t *testing.T) {
+		loadAPI(apidef.ExternalAuthzConfig{Enabled: true, Protocol: "grpc", URL: "tcp://" + grpcLn.Addr().String()})
+
+		if upstream, _ := get(t, "authz-gold", 200); upstream.Headers["X-Authz"] != "grpc" {
+			t.Error("Headers from authorization service should be added, got", upstream.Headers)
+		}
+		get(t, "authz-blocked", 403)
+	})
+
+	t.Run("Unavailable", func(t *testing.T) {
+		down := apidef.ExternalAuthzConfig{Enabled: true, URL: "http://127.0.0.1:1/"}
+		loadAPI(down)
+		get(t, "authz-gold", 503)
+
+		down.FailOpen = true
+		loadAPI(down)
+		get(t, "authz-gold", 200)
+	})
+}
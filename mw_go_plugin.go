@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"plugin"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/TykTechnologies/tyk/coprocess"
+	"github.com/TykTechnologies/tyk/ctx"
+	"github.com/TykTechnologies/tyk/user"
+)
+
+// GoPluginMiddleware runs a custom middleware hook from a Go plugin, for
+// APIs using the goplugin driver. The hook's path is the plugin's shared
+// object, built with go build -buildmode=plugin against the same Tyk
+// source, and its name is that of a function the plugin exports,
+//
+//	func Hook(w http.ResponseWriter, r *http.Request)
+//
+// The hook can change the request as it likes, and use the ctx package
+// to get the API's definition, the request's session and its state. If
+// it writes a response, that's what the client gets and the request goes
+// no further.
+// An auth check hook has to call ctx.SetSession with the session and key
+// to let the request through, or it's denied.
+//
+// Go can't unload plugins, so a reloaded API whose plugin changed needs
+// the new plugin at a different path.
+type GoPluginMiddleware struct {
+	BaseMiddleware
+	HookType   coprocess.HookType
+	Path       string
+	SymbolName string
+
+	handler http.HandlerFunc
+	err     error
+}
+
+func (m *GoPluginMiddleware) Name() string {
+	return "GoPluginMiddleware"
+}
+
+func (m *GoPluginMiddleware) EnabledForSpec() bool {
+	return true
+}
+
+// Init loads the hook from its plugin. If that fails, the hook fails
+// every request rather than letting it through.
+func (m *GoPluginMiddleware) Init() {
+	m.handler, m.err = loadGoPluginHandler(customMiddlewarePath(m.Spec, m.Path), m.SymbolName)
+	if m.err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "goplugin",
+		}).Error("Failed to load Go plugin middleware ", m.SymbolName, ": ", m.err)
+	}
+}
+
+// ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
+func (m *GoPluginMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	setCtxValue(r, ctx.Definition, m.Spec.APIDefinition)
+	rw := &goPluginResponseWriter{ResponseWriter: w}
+
+	err := m.err
+	if err == nil {
+		err = runGoPluginHandler(m.handler, rw, r)
+	}
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "goplugin",
+		}).Error("Failed to run Go plugin middleware ", m.SymbolName, ": ", err)
+		if rw.written {
+			return nil, mwStatusRespond
+		}
+		if m.HookType == coprocess.HookType_CustomKeyCheck {
+			return errors.New("Key not authorised"), 403
+		}
+		return errors.New("Middleware error"), 500
+	}
+	if rw.written {
+		return nil, mwStatusRespond
+	}
+
+	if m.HookType == coprocess.HookType_CustomKeyCheck {
+		session, token := ctxGetSession(r), ctxGetAuthToken(r)
+		if session == nil || token == "" {
+			logEntry := getLogEntryForRequest(r, token, nil)
+			logEntry.Info("Attempted access with invalid key.")
+
+			AuthFailed(m, r, r.Header.Get(m.Spec.Auth.AuthHeaderName))
+			reportHealthValue(m.Spec, KeyFailure, "1")
+			return errors.New("Key not authorised"), 403
+		}
+		m.Spec.SessionManager.UpdateSession(token, session, session.Lifetime(m.Spec.SessionLifetime))
+	}
+
+	return nil, 200
+}
+
+// ResponseGoPluginMiddleware runs a response hook from a Go plugin. The
+// hook has the same signature as the others, and its writer changes the
+// upstream's response: what it writes replaces the response's body.
+type ResponseGoPluginMiddleware struct {
+	Path       string
+	SymbolName string
+
+	spec    *APISpec
+	handler http.HandlerFunc
+	err     error
+}
+
+func (h *ResponseGoPluginMiddleware) Init(c interface{}, spec *APISpec) error {
+	h.spec = spec
+	h.handler, h.err = loadGoPluginHandler(customMiddlewarePath(spec, h.Path), h.SymbolName)
+	return h.err
+}
+
+func (h *ResponseGoPluginMiddleware) HandleResponse(rw http.ResponseWriter, res *http.Response, req *http.Request, ses *user.SessionState) error {
+	if h.err != nil {
+		return h.err
+	}
+	setCtxValue(req, ctx.Definition, h.spec.APIDefinition)
+	w := &goPluginUpstreamWriter{res: res}
+	if err := runGoPluginHandler(h.handler, w, req); err != nil {
+		return err
+	}
+	if w.body != nil {
+		res.Body.Close()
+		res.Body = ioutil.NopCloser(w.body)
+		res.ContentLength = int64(w.body.Len())
+		res.Header.Set("Content-Length", strconv.Itoa(w.body.Len()))
+	}
+	return nil
+}
+
+func loadGoPluginHandler(path, name string) (http.HandlerFunc, error) {
+	log.WithFields(logrus.Fields{
+		"prefix": "goplugin",
+	}).Info("Loading Go plugin: ", path)
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := sym.(func(http.ResponseWriter, *http.Request))
+	if !ok {
+		return nil, fmt.Errorf("%s in %s is a %T, not a func(http.ResponseWriter, *http.Request)", name, path, sym)
+	}
+	return fn, nil
+}
+
+// runGoPluginHandler runs a hook, turning its panics into errors.
+func runGoPluginHandler(h http.HandlerFunc, w http.ResponseWriter, r *http.Request) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	h(w, r)
+	return nil
+}
+
+// goPluginResponseWriter records whether a hook responded.
+type goPluginResponseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *goPluginResponseWriter) WriteHeader(code int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *goPluginResponseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+// goPluginUpstreamWriter is what a response hook writes to, changing the
+// upstream's response.
+type goPluginUpstreamWriter struct {
+	res  *http.Response
+	body *bytes.Buffer
+}
+
+func (w *goPluginUpstreamWriter) Header() http.Header {
+	return w.res.Header
+}
+
+func (w *goPluginUpstreamWriter) WriteHeader(code int) {
+	w.res.StatusCode = code
+	w.res.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+}
+
+func (w *goPluginUpstreamWriter) Write(b []byte) (int, error) {
+	if w.body == nil {
+		w.body = new(bytes.Buffer)
+	}
+	return w.body.Write(b)
+}
@@ -0,0 +1,156 @@
+//go:build goplugin
+// +build goplugin
+
+// These tests build the plugin in testdata/goplugins, which only loads if
+// it's built like the test binary, so they don't run with -race or
+// -cover.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/coprocess"
+)
+
+var (
+	goPluginOnce sync.Once
+	goPluginPath string
+	goPluginErr  error
+)
+
+// testGoPlugin builds the test plugin once, as Go won't load the same
+// plugin again from a different path.
+func testGoPlugin(t *testing.T) string {
+	goPluginOnce.Do(func() {
+		goPluginPath = filepath.Join(os.TempDir(), "tyk-goplugin-test.so")
+		cmd := exec.Command("go", "build", "-buildmode=plugin", "-o", goPluginPath, "./testdata/goplugins")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			goPluginErr = fmt.Errorf("building the plugin: %v\n%s", err, out)
+		}
+	})
+	if goPluginErr != nil {
+		t.Fatal(goPluginErr)
+	}
+	return goPluginPath
+}
+
+func TestGoPluginMiddleware(t *testing.T) {
+	path := testGoPlugin(t)
+
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{APIID: "go-api"}}
+	tests := []struct {
+		hook       string
+		wantCode   int
+		wantHeader string
+	}{
+		{"AddHeader", 200, "go-api"},
+		{"Respond", mwStatusRespond, "responded"},
+		{"Panic", 500, ""},
+		{"NotAHook", 500, ""},
+		{"Missing", 500, ""},
+	}
+	for _, tc := range tests {
+		mw := pluginMiddleware(BaseMiddleware{Spec: spec}, coprocess.HookType_Pre,
+			apidef.MiddlewareDefinition{Name: tc.hook, Path: path}, apidef.GoPluginDriver)
+		mw.Init()
+		r := httptest.NewRequest("GET", "/foo", nil)
+		w := httptest.NewRecorder()
+		_, code := mw.ProcessRequest(w, r, nil)
+		if code != tc.wantCode {
+			t.Errorf("%s: wanted code %d, got %d", tc.hook, tc.wantCode, code)
+			continue
+		}
+		got := r.Header.Get("X-Go-Plugin")
+		if code == mwStatusRespond {
+			got = w.Header().Get("X-Go-Plugin")
+		}
+		if got != tc.wantHeader {
+			t.Errorf("%s: wanted header %q, got %q", tc.hook, tc.wantHeader, got)
+		}
+	}
+}
+
+func TestGoPluginMiddlewareChain(t *testing.T) {
+	path := testGoPlugin(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"api": r.Header.Get("X-Go-Plugin"),
+			"key": r.Header.Get("X-Go-Plugin-Key"),
+		})
+	}))
+	defer upstream.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen(ln, nil, nil)
+	defer ln.Close()
+	baseURL := "http://" + ln.Addr().String()
+
+	buildAndLoadAPI(func(spec *APISpec) {
+		spec.APIID = "go-auth"
+		spec.UseKeylessAccess = false
+		spec.EnableCoProcessAuth = true
+		spec.Proxy.ListenPath = "/go-auth/"
+		spec.Proxy.TargetURL = upstream.URL
+		spec.CustomMiddleware = apidef.MiddlewareSection{
+			Driver:      apidef.GoPluginDriver,
+			Pre:         []apidef.MiddlewareDefinition{{Name: "AddHeader", Path: path}},
+			AuthCheck:   apidef.MiddlewareDefinition{Name: "Auth", Path: path},
+			PostKeyAuth: []apidef.MiddlewareDefinition{{Name: "AddKey", Path: path}},
+		}
+	}, func(spec *APISpec) {
+		spec.APIID = "go-response"
+		spec.Proxy.ListenPath = "/go-response/"
+		spec.Proxy.TargetURL = upstream.URL
+		spec.CustomMiddleware = apidef.MiddlewareSection{
+			Driver:   apidef.GoPluginDriver,
+			Response: []apidef.MiddlewareDefinition{{Name: "Rewrite", Path: path}},
+		}
+	})
+
+	get := func(path, key string) (*http.Response, string) {
+		req, _ := http.NewRequest("GET", baseURL+path, nil)
+		req.Header.Set("Authorization", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	resp, body := get("/go-auth/foo", "go-key")
+	if resp.StatusCode != 200 {
+		t.Fatalf("wanted the hook's session to authorise the request, got %d", resp.StatusCode)
+	}
+	var got map[string]string
+	json.Unmarshal([]byte(body), &got)
+	if got["api"] != "go-auth" || got["key"] != "go-key" {
+		t.Fatalf("wanted the hooks' changes upstream, got %v", got)
+	}
+
+	if resp, _ := get("/go-auth/foo", "other-key"); resp.StatusCode != 403 {
+		t.Fatalf("wanted an auth hook that set no session to deny, got %d", resp.StatusCode)
+	}
+
+	resp, body = get("/go-response/foo", "")
+	if resp.StatusCode != 201 || body != "rewritten" || resp.Header.Get("X-Go-Plugin") != "rewritten" {
+		t.Fatalf("wanted the response hook's response, got %d %q", resp.StatusCode, body)
+	}
+}
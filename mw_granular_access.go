@@ -4,6 +4,8 @@ import (
 	"errors"
 	"net/http"
 	"regexp"
+
+	"github.com/TykTechnologies/tyk/user"
 )
 
 // GranularAccessMiddleware will check if a URL is specifically enabled for the key
@@ -30,13 +32,33 @@ func (m *GranularAccessMiddleware) ProcessRequest(w http.ResponseWriter, r *http
 		return nil, 200
 	}
 
-	for _, accessSpec := range sessionVersionData.AllowedURLs {
+	accessSpec, err := matchAccessSpec(sessionVersionData.AllowedURLs, r)
+	if err != nil {
+		log.Error("Regex error: ", err)
+		return nil, 200
+	}
+	if accessSpec != nil {
+		return nil, 200
+	}
+
+	token := ctxGetAuthToken(r)
+	// No paths matched, disallow
+	logEntry := getLogEntryForRequest(r, token, map[string]interface{}{"api_found": false})
+	logEntry.Info("Attempted access to unauthorised endpoint (Granular).")
+
+	return errors.New("Access to this resource has been disallowed"), 403
+
+}
+
+// matchAccessSpec returns the first access spec whose URL and methods
+// match the request, or nil if there is none.
+func matchAccessSpec(specs []user.AccessSpec, r *http.Request) (*user.AccessSpec, error) {
+	for i, accessSpec := range specs {
 		log.Debug("Checking: ", r.URL.Path)
 		log.Debug("Against: ", accessSpec.URL)
 		asRegex, err := regexp.Compile(accessSpec.URL)
 		if err != nil {
-			log.Error("Regex error: ", err)
-			return nil, 200
+			return nil, err
 		}
 
 		match := asRegex.MatchString(r.URL.Path)
@@ -44,17 +66,10 @@ func (m *GranularAccessMiddleware) ProcessRequest(w http.ResponseWriter, r *http
 			log.Debug("Match!")
 			for _, method := range accessSpec.Methods {
 				if method == r.Method {
-					return nil, 200
+					return &specs[i], nil
 				}
 			}
 		}
 	}
-
-	token := ctxGetAuthToken(r)
-	// No paths matched, disallow
-	logEntry := getLogEntryForRequest(r, token, map[string]interface{}{"api_found": false})
-	logEntry.Info("Attempted access to unauthorised endpoint (Granular).")
-
-	return errors.New("Access to this resource has been disallowed"), 403
-
+	return nil, nil
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+)
+
+// IPBlackListMiddleware lets you define a list of IPs to block from upstream
+type IPBlackListMiddleware struct {
+	BaseMiddleware
+}
+
+func (i *IPBlackListMiddleware) Name() string {
+	return "IPBlackListMiddleware"
+}
+
+func (i *IPBlackListMiddleware) EnabledForSpec() bool {
+	return i.Spec.EnableIpBlacklisting && len(i.Spec.BlacklistedIPs) > 0
+}
+
+// ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
+func (i *IPBlackListMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	remoteIP := accessControlIP(r)
+	if remoteIP != nil && !ipInList(remoteIP, i.Spec.BlacklistedIPs) {
+		return nil, 200
+	}
+
+	// Fire Authfailed Event
+	AuthFailed(i, r, trustedRequestIP(r))
+	// Report in health check
+	reportHealthValue(i.Spec, KeyFailure, "-1")
+
+	return errors.New("Access from this IP has been disallowed"), 403
+}
+
+// ipInList reports whether an IP is one of a list of IPs and CIDR ranges.
+func ipInList(ip net.IP, list []string) bool {
+	if ip == nil {
+		return false
+	}
+	for _, entry := range list {
+		// Might be CIDR, try this one first then fallback to IP parsing later
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			if ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if net.ParseIP(entry).Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
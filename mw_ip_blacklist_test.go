@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/TykTechnologies/tyk/config"
+)
+
+func TestIPBlackListMiddleware(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen(ln, nil, nil)
+	defer ln.Close()
+	baseURL := "http://" + ln.Addr().String() + "/blacklist/"
+
+	buildAndLoadAPI(func(spec *APISpec) {
+		spec.UseKeylessAccess = true
+		spec.Proxy.ListenPath = "/blacklist/"
+		spec.EnableIpBlacklisting = true
+		spec.BlacklistedIPs = []string{"10.0.0.1", "192.168.0.0/16"}
+	})
+
+	for _, tc := range []struct {
+		name            string
+		trustedProxies  []string
+		trustRealIP     bool
+		realIP, forward string
+		wantCode        int
+	}{
+		{"NoMatch", nil, false, "", "", 200},
+		// without trusted proxies, the headers are anyone's to set
+		{"UntrustedRealIP", nil, false, "10.0.0.1", "", 200},
+		{"UntrustedForwarded", nil, false, "", "192.168.4.4", 200},
+		{"ExactMatch", []string{"127.0.0.0/8"}, true, "10.0.0.1", "", 403},
+		{"CIDRMatch", []string{"127.0.0.0/8"}, false, "", "192.168.4.4", 403},
+		{"Forwarded", []string{"127.0.0.0/8", "10.0.0.0/8"}, false, "", "192.168.4.4, 10.1.1.1", 403},
+		{"UntrustedPeer", []string{"10.0.0.0/8"}, false, "10.0.0.1", "", 200},
+		{"TrustedPeer", []string{"127.0.0.0/8"}, false, "", "192.168.4.4, 10.1.1.1", 200},
+		{"TrustedHops", []string{"127.0.0.0/8", "10.0.0.0/8"}, false, "", "192.168.4.4, 10.1.1.1", 403},
+		{"SpoofedRealIP", []string{"127.0.0.0/8"}, false, "10.0.0.1", "", 200},
+		{"SpoofedRealIPForwarded", []string{"127.0.0.0/8"}, true, "172.16.0.1", "10.0.0.1", 403},
+		{"TrustedRealIP", []string{"127.0.0.0/8"}, true, "10.0.0.1", "", 403},
+		{"Unparsable", []string{"127.0.0.0/8"}, true, "not-an-ip", "", 403},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config.Global.TrustedProxies = tc.trustedProxies
+			config.Global.TrustXRealIP = tc.trustRealIP
+			defer func() {
+				config.Global.TrustedProxies = nil
+				config.Global.TrustXRealIP = false
+			}()
+
+			req, _ := http.NewRequest("GET", baseURL, nil)
+			if tc.realIP != "" {
+				req.Header.Set("X-Real-IP", tc.realIP)
+			}
+			if tc.forward != "" {
+				req.Header.Set("X-Forwarded-For", tc.forward)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.wantCode {
+				t.Errorf("Wanted status %d, got %d", tc.wantCode, resp.StatusCode)
+			}
+		})
+	}
+}
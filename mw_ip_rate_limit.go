@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/storage"
+)
+
+const (
+	IPLimitKeyPrefix = "ip-limit-"
+	IPBanKeyPrefix   = "ip-ban-"
+)
+
+// IPRateLimitMiddleware holds each client IP of a keyless API to a rate
+// limit and quota of its own, as there's no key to hold them to, and bans
+// IPs which keep going over them for a while.
+type IPRateLimitMiddleware struct {
+	BaseMiddleware
+}
+
+func (m *IPRateLimitMiddleware) Name() string {
+	return "IPRateLimitMiddleware"
+}
+
+func (m *IPRateLimitMiddleware) EnabledForSpec() bool {
+	conf := m.Spec.IPRateLimit
+	return m.Spec.UseKeylessAccess && (conf.Rate > 0 || conf.QuotaMax > 0)
+}
+
+func (m *IPRateLimitMiddleware) ipKey(prefix, ip string) string {
+	return prefix + m.Spec.APIID + "-" + storage.HashStr(ip)
+}
+
+func (m *IPRateLimitMiddleware) fireLimitEvent(r *http.Request, event apidef.TykEvent, message, ip string) {
+	m.FireEvent(event, EventKeyFailureMeta{
+		EventMetaDefault: EventMetaDefault{Message: message, OriginatingRequest: EncodeRequestToEvent(r)},
+		Path:             r.URL.Path,
+		Origin:           ip,
+	})
+}
+
+// recordRejection counts a request turned away from an IP, banning the IP
+// once it has been turned away too often within the ban window.
+func (m *IPRateLimitMiddleware) recordRejection(r *http.Request, ip string, store storage.Handler) {
+	conf := m.Spec.IPRateLimit
+	if conf.BanThreshold <= 0 || conf.BanDuration <= 0 {
+		return
+	}
+	window := conf.BanWindow
+	if window <= 0 {
+		window = conf.BanDuration
+	}
+	count := store.IncrememntWithExpire(m.ipKey(IPBanKeyPrefix+"count-", ip), window)
+	if count < conf.BanThreshold {
+		return
+	}
+	store.SetRawKey(m.ipKey(IPBanKeyPrefix, ip), strconv.FormatInt(time.Now().Unix()+conf.BanDuration, 10), conf.BanDuration)
+	store.DeleteRawKey(m.ipKey(IPBanKeyPrefix+"count-", ip))
+
+	log.WithField("prefix", "ip_rate_limit").Warning("Banning IP ", ip, " for ", conf.BanDuration, " seconds.")
+	m.FireEvent(EventIPBanned, EventIPBannedMeta{
+		EventMetaDefault: EventMetaDefault{Message: "IP Banned", OriginatingRequest: EncodeRequestToEvent(r)},
+		Path:             r.URL.Path,
+		Origin:           ip,
+		APIID:            m.Spec.APIID,
+		Duration:         conf.BanDuration,
+	})
+}
+
+// ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
+func (m *IPRateLimitMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	conf := m.Spec.IPRateLimit
+	remoteIP := accessControlIP(r)
+	if remoteIP == nil {
+		// there's nothing to count requests under
+		return errors.New("Access from this IP has been disallowed"), 403
+	}
+	ip := remoteIP.String()
+	store := m.Spec.SessionManager.Store()
+
+	if until, err := store.GetRawKey(m.ipKey(IPBanKeyPrefix, ip)); err == nil {
+		if expires, err := strconv.ParseInt(until, 10, 64); err == nil && !m.Spec.DisableRateLimitHeaders {
+			w.Header().Set("Retry-After", strconv.FormatInt(secondsUntil(time.Unix(expires, 0)), 10))
+		}
+		reportHealthValue(m.Spec, Throttle, "-1")
+		return errors.New("Access from this IP has been temporarily blocked"), 403
+	}
+
+	key := m.ipKey(IPLimitKeyPrefix, ip)
+	opts := limitOptions{limiter: m.Spec.RateLimiter}
+	if !m.Spec.DisableRateLimit && conf.Rate > 0 && conf.Per > 0 {
+		rateLimiterKey := RateLimitKeyPrefix + key
+		exceeded, state := sessionLimiter.rateLimitExceeded(key, rateLimiterKey, key, conf.Rate, conf.Per, opts, store)
+		if exceeded {
+			setRetryAfter(m.Spec, w.Header(), state)
+			m.fireLimitEvent(r, EventRateLimitExceeded, "IP Rate Limit Exceeded", ip)
+			m.recordRejection(r, ip, store)
+			reportHealthValue(m.Spec, Throttle, "-1")
+			return errors.New("Rate limit exceeded"), 429
+		}
+	}
+	if !m.Spec.DisableQuota && conf.QuotaMax > 0 && conf.QuotaRenewalRate > 0 {
+		exceeded, state := sessionLimiter.counterQuotaExceeded(QuotaKeyPrefix+key, conf.QuotaMax, conf.QuotaRenewalRate, store, opts)
+		if exceeded {
+			setRetryAfter(m.Spec, w.Header(), state)
+			m.fireLimitEvent(r, EventQuotaExceeded, "IP Quota Exceeded", ip)
+			m.recordRejection(r, ip, store)
+			reportHealthValue(m.Spec, QuotaViolation, "-1")
+			return errors.New("Quota exceeded"), 403
+		}
+	}
+	return nil, 200
+}
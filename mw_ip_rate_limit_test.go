@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+)
+
+type ipBanEventHandler struct {
+	banned chan EventIPBannedMeta
+}
+
+func (h *ipBanEventHandler) Init(interface{}) error { return nil }
+
+func (h *ipBanEventHandler) HandleEvent(em config.EventMessage) {
+	h.banned <- em.Meta.(EventIPBannedMeta)
+}
+
+func TestIPRateLimit(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen(ln, nil, nil)
+	defer ln.Close()
+	baseURL := "http://" + ln.Addr().String() + "/ip-limits/"
+
+	// clients are told apart by the X-Real-IP the local proxy sets
+	config.Global.TrustedProxies = []string{"127.0.0.0/8"}
+	config.Global.TrustXRealIP = true
+	defer func() {
+		config.Global.TrustedProxies = nil
+		config.Global.TrustXRealIP = false
+	}()
+
+	DRLManager.CurrentTokenValue = 1
+	DRLManager.RequestTokenValue = 1
+	defer func() {
+		DRLManager.CurrentTokenValue = 0
+		DRLManager.RequestTokenValue = 0
+	}()
+
+	handler := &ipBanEventHandler{banned: make(chan EventIPBannedMeta, 1)}
+	loadAPI := func(conf apidef.IPRateLimit) {
+		specs := buildAndLoadAPI(func(spec *APISpec) {
+			spec.APIID = uuid.NewV4().String()
+			spec.UseKeylessAccess = true
+			spec.Proxy.ListenPath = "/ip-limits/"
+			spec.IPRateLimit = conf
+		})
+		spec := getApiSpec(specs[0].APIID)
+		spec.EventPaths = map[apidef.TykEvent][]config.TykEventHandler{
+			EventIPBanned: {handler},
+		}
+	}
+
+	get := func(t *testing.T, ip string, code int) {
+		req, _ := http.NewRequest("GET", baseURL, nil)
+		req.Header.Set("X-Real-IP", ip)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("Wanted status %d for %s, got %d", code, ip, resp.StatusCode)
+		}
+	}
+
+	t.Run("RateLimitAndBan", func(t *testing.T) {
+		loadAPI(apidef.IPRateLimit{Rate: 2, Per: 60, BanThreshold: 2, BanWindow: 60, BanDuration: 60})
+
+		get(t, "10.0.0.1", 200)
+		get(t, "10.0.0.1", 200)
+		get(t, "10.0.0.1", 429)
+		get(t, "10.0.0.2", 200)
+		get(t, "10.0.0.1", 429)
+		select {
+		case meta := <-handler.banned:
+			if meta.Origin != "10.0.0.1" || meta.Duration != 60 {
+				t.Error("Wrong ban event:", meta)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Wanted a ban event")
+		}
+		get(t, "10.0.0.1", 403)
+		get(t, "10.0.0.2", 200)
+	})
+
+	t.Run("Quota", func(t *testing.T) {
+		loadAPI(apidef.IPRateLimit{QuotaMax: 1, QuotaRenewalRate: 60})
+
+		get(t, "10.0.0.3", 200)
+		get(t, "10.0.0.3", 403)
+		get(t, "10.0.0.4", 200)
+	})
+
+	t.Run("Unparsable", func(t *testing.T) {
+		loadAPI(apidef.IPRateLimit{Rate: 2, Per: 60})
+
+		get(t, "not-an-ip", 403)
+	})
+
+	t.Run("UntrustedPeer", func(t *testing.T) {
+		loadAPI(apidef.IPRateLimit{QuotaMax: 1, QuotaRenewalRate: 60})
+		config.Global.TrustedProxies = nil
+		defer func() { config.Global.TrustedProxies = []string{"127.0.0.0/8"} }()
+
+		// the header is ignored, so both requests count against the peer
+		get(t, "10.0.0.5", 200)
+		get(t, "10.0.0.6", 403)
+	})
+}
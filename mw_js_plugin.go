@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -43,6 +44,7 @@ type MiniRequestObject struct {
 	DeleteParams    []string
 	ReturnOverrides ReturnOverrides
 	IgnoreBody      bool
+	State           map[string]string
 }
 
 type VMReturnObject struct {
@@ -106,6 +108,7 @@ func (d *DynamicMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Reques
 		AddParams:      map[string]string{},
 		ExtendedParams: map[string][]string{},
 		DeleteParams:   []string{},
+		State:          ctxGetState(r),
 	}
 
 	requestAsJson, err := json.Marshal(requestData)
@@ -136,50 +139,16 @@ func (d *DynamicMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Reques
 
 	// Run the middleware
 	middlewareClassname := d.MiddlewareClassName
-	vm := d.Spec.JSVM.VM.Copy()
-	vm.Interrupt = make(chan func(), 1)
 	log.WithFields(logrus.Fields{
 		"prefix": "jsvm",
 	}).Debug("Running: ", middlewareClassname)
-	// buffered, leaving no chance of a goroutine leak since the
-	// spawned goroutine will send 0 or 1 values.
-	ret := make(chan otto.Value, 1)
-	errRet := make(chan error, 1)
-	go func() {
-		defer func() {
-			// the VM executes the panic func that gets it
-			// to stop, so we must recover here to not crash
-			// the whole Go program.
-			recover()
-		}()
-		returnRaw, err := vm.Run(middlewareClassname + `.DoProcessRequest(` + string(requestAsJson) + `, ` + string(sessionAsJson) + `, ` + specAsJson + `);`)
-		ret <- returnRaw
-		errRet <- err
-	}()
-	var returnRaw otto.Value
-	t := time.NewTimer(d.Spec.JSVM.Timeout)
-	select {
-	case returnRaw = <-ret:
-		if err := <-errRet; err != nil {
-			log.WithFields(logrus.Fields{
-				"prefix": "jsvm",
-			}).Error("Failed to run JS middleware: ", err)
-			return nil, 200
-		}
-		t.Stop()
-	case <-t.C:
-		t.Stop()
+	returnDataStr, err := d.Spec.JSVM.Run(middlewareClassname + `.DoProcessRequest(` + string(requestAsJson) + `, ` + string(sessionAsJson) + `, ` + specAsJson + `);`)
+	if err != nil {
 		log.WithFields(logrus.Fields{
 			"prefix": "jsvm",
-		}).Error("JS middleware timed out after ", d.Spec.JSVM.Timeout)
-		vm.Interrupt <- func() {
-			// only way to stop the VM is to send it a func
-			// that panics.
-			panic("stop")
-		}
+		}).Error("Failed to run JS middleware: ", err)
 		return nil, 200
 	}
-	returnDataStr, _ := returnRaw.ToString()
 
 	// Decode the return object
 	newRequestData := VMReturnObject{}
@@ -231,6 +200,11 @@ func (d *DynamicMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Reques
 
 	r.URL.RawQuery = values.Encode()
 
+	// Middleware that builds its own request object leaves the state be
+	if newRequestData.Request.State != nil {
+		ctxSetState(r, newRequestData.Request.State)
+	}
+
 	// Save the sesison data (if modified)
 	if !d.Pre && d.UseSession && len(newRequestData.SessionMeta) > 0 {
 		session.MetaData = mapStrsToIfaces(newRequestData.SessionMeta)
@@ -285,11 +259,33 @@ type JSVM struct {
 	Timeout time.Duration
 	Log     *logrus.Logger // logger used by the JS code
 	RawLog  *logrus.Logger // logger used by `rawlog` func to avoid formatting
+
+	pool *gojaPool // set instead of VM with the goja engine
 }
 
 // Init creates the JSVM with the core library and sets up a default
 // timeout.
 func (j *JSVM) Init(spec *APISpec) {
+	j.Spec = spec
+	j.Timeout = 5 * time.Second
+	if t := config.Global.JSVMOptions.Timeout; t > 0 {
+		j.Timeout = time.Duration(t) * time.Second
+	}
+	j.Log = log // use the global logger by default
+	j.RawLog = rawLog
+	j.VM, j.pool = nil, nil
+
+	switch engine := config.Global.JSVMEngine; engine {
+	case config.GojaJSVMEngine:
+		j.initGoja()
+		return
+	case "", config.OttoJSVMEngine:
+	default:
+		log.WithFields(logrus.Fields{
+			"prefix": "jsvm",
+		}).Errorf("Unknown JSVM engine %q, using %s", engine, config.OttoJSVMEngine)
+	}
+
 	vm := otto.New()
 
 	// Init TykJS namespace, constructors etc.
@@ -316,18 +312,112 @@ func (j *JSVM) Init(spec *APISpec) {
 	}
 
 	j.VM = vm
-	j.Spec = spec
 
 	// Add environment API
 	j.LoadTykJSApi()
+}
 
-	j.Timeout = 5 * time.Second
-	j.Log = log // use the global logger by default
-	j.RawLog = rawLog
+func (j *JSVM) initGoja() {
+	pool := newGojaPool(j.tykJSApi(), config.Global.JSVMOptions)
+	if err := pool.load("tyk.js", coreJS); err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "jsvm",
+		}).Error("Could not load TykJS: ", err)
+		return
+	}
+	if path := config.Global.TykJSPath; path != "" {
+		src, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = pool.load(path, string(src))
+		}
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "jsvm",
+			}).Error("Could not load user's TykJS: ", err)
+			return
+		}
+	}
+	if err := pool.load("tyk-response.js", tykJSResponse); err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "jsvm",
+		}).Error("Could not load TykJS response library: ", err)
+		return
+	}
+	j.pool = pool
+}
+
+// Load runs a JS source in the JSVM, so that the calls made with Run can
+// use what it defines.
+func (j *JSVM) Load(name string, src []byte) error {
+	if j.pool != nil {
+		return j.pool.load(name, string(src))
+	}
+	if j.VM == nil {
+		return errors.New("JSVM is not initialised")
+	}
+	_, err := j.VM.Run(src)
+	return err
 }
 
-// LoadJSPaths will load JS classes and functionality in to the VM by file
-func (j *JSVM) LoadJSPaths(paths []string, pathPrefix string) {
+// Warm readies the VMs for the calls to come, once the JS sources are
+// loaded. Only the goja engine needs it.
+func (j *JSVM) Warm() {
+	if j.pool != nil {
+		j.pool.warm()
+	}
+}
+
+// Run calls a JS expression, stopping it after the timeout, and returns
+// its value as a string. Each call gets a VM to itself: a copy of the
+// otto VM, or one from the goja pool.
+func (j *JSVM) Run(expr string) (string, error) {
+	if j.pool != nil {
+		return j.pool.run(expr, j.Timeout)
+	}
+	if j.VM == nil {
+		return "", errors.New("JSVM is not initialised")
+	}
+
+	vm := j.VM.Copy()
+	vm.Interrupt = make(chan func(), 1)
+	// buffered, leaving no chance of a goroutine leak since the
+	// spawned goroutine will send 0 or 1 values.
+	ret := make(chan otto.Value, 1)
+	errRet := make(chan error, 1)
+	go func() {
+		defer func() {
+			// the VM executes the panic func that gets it
+			// to stop, so we must recover here to not crash
+			// the whole Go program.
+			recover()
+		}()
+		returnRaw, err := vm.Run(expr)
+		ret <- returnRaw
+		errRet <- err
+	}()
+	t := time.NewTimer(j.Timeout)
+	defer t.Stop()
+	select {
+	case returnRaw := <-ret:
+		if err := <-errRet; err != nil {
+			return "", err
+		}
+		return returnRaw.ToString()
+	case <-t.C:
+		vm.Interrupt <- func() {
+			// only way to stop the VM is to send it a func
+			// that panics.
+			panic("stop")
+		}
+		return "", fmt.Errorf("timed out after %v", j.Timeout)
+	}
+}
+
+// LoadJSPaths will load JS classes and functionality in to the VM by file.
+// A file that fails to load doesn't stop the rest, but the first error is
+// returned.
+func (j *JSVM) LoadJSPaths(paths []string, pathPrefix string) error {
+	var firstErr error
 	tykBundlePath := filepath.Join(config.Global.MiddlewarePath, "bundles")
 	for _, mwPath := range paths {
 		if pathPrefix != "" {
@@ -336,20 +426,21 @@ func (j *JSVM) LoadJSPaths(paths []string, pathPrefix string) {
 		log.WithFields(logrus.Fields{
 			"prefix": "jsvm",
 		}).Info("Loading JS File: ", mwPath)
-		f, err := os.Open(mwPath)
+		src, err := ioutil.ReadFile(mwPath)
 		if err != nil {
 			log.WithFields(logrus.Fields{
 				"prefix": "jsvm",
 			}).Error("Failed to open JS middleware file: ", err)
-			continue
-		}
-		if _, err := j.VM.Run(f); err != nil {
+		} else if err = j.Load(mwPath, src); err != nil {
 			log.WithFields(logrus.Fields{
 				"prefix": "jsvm",
 			}).Error("Failed to load JS middleware: ", err)
 		}
-		f.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
+	return firstErr
 }
 
 type TykJSHttpRequest struct {
@@ -367,205 +458,192 @@ type TykJSHttpResponse struct {
 	Headers map[string][]string
 }
 
-func (j *JSVM) LoadTykJSApi() {
-	// Enable a log
-	j.VM.Set("log", func(call otto.FunctionCall) otto.Value {
-		j.Log.WithFields(logrus.Fields{
-			"prefix": "jsvm-logmsg",
-			"type":   "log-msg",
-		}).Info(call.Argument(0).String())
-		return otto.Value{}
-	})
-	j.VM.Set("rawlog", func(call otto.FunctionCall) otto.Value {
-		j.RawLog.Print(call.Argument(0).String() + "\n")
-		return otto.Value{}
-	})
-
-	// these two needed for non-utf8 bodies
-	j.VM.Set("b64dec", func(call otto.FunctionCall) otto.Value {
-		in := call.Argument(0).String()
-		out, err := base64.StdEncoding.DecodeString(in)
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				"prefix": "jsvm",
-			}).Error("Failed to base64 decode: ", err)
-			return otto.Value{}
-		}
-		returnVal, err := j.VM.ToValue(string(out))
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				"prefix": "jsvm",
-			}).Error("Failed to base64 decode: ", err)
-			return otto.Value{}
-		}
-		return returnVal
-	})
-	j.VM.Set("b64enc", func(call otto.FunctionCall) otto.Value {
-		in := []byte(call.Argument(0).String())
-		out := base64.StdEncoding.EncodeToString(in)
-		returnVal, err := j.VM.ToValue(out)
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				"prefix": "jsvm",
-			}).Error("Failed to base64 encode: ", err)
-			return otto.Value{}
-		}
-		return returnVal
-	})
-
-	// Enable the creation of HTTP Requsts
-	j.VM.Set("TykMakeHttpRequest", func(call otto.FunctionCall) otto.Value {
-
-		jsonHRO := call.Argument(0).String()
-		if jsonHRO == "undefined" {
-			// Nope, return nothing
-			return otto.Value{}
-		}
-		hro := TykJSHttpRequest{}
-		if err := json.Unmarshal([]byte(jsonHRO), &hro); err != nil {
-			log.WithFields(logrus.Fields{
-				"prefix": "jsvm",
-			}).Error("JSVM: Failed to deserialise HTTP Request object")
-			return otto.Value{}
-		}
-
-		// Make the request
-		domain := hro.Domain
-		data := url.Values{}
-		for k, v := range hro.FormData {
-			data.Set(k, v)
-		}
-
-		u, _ := url.ParseRequestURI(domain)
-		u.Path = hro.Resource
-		urlStr := u.String() // "https://api.com/user/"
-
-		var d string
-		if hro.Body != "" {
-			d = hro.Body
-		} else if len(hro.FormData) > 0 {
-			d = data.Encode()
-		}
-
-		r, _ := http.NewRequest(hro.Method, urlStr, nil)
-
-		if d != "" {
-			r, _ = http.NewRequest(hro.Method, urlStr, strings.NewReader(d))
-		}
-
-		for k, v := range hro.Headers {
-			r.Header.Set(k, v)
-		}
-		r.Close = true
-
-		tr := &http.Transport{TLSClientConfig: &tls.Config{}}
-		if cert := getUpstreamCertificate(r.Host, j.Spec); cert != nil {
-			tr.TLSClientConfig.Certificates = []tls.Certificate{*cert}
-		}
-
-		if config.Global.ProxySSLInsecureSkipVerify {
-			tr.TLSClientConfig.InsecureSkipVerify = true
-		}
-
-		// using new Client each time should be ok, since we closing connection every time
-		client := &http.Client{Transport: tr}
-		resp, err := client.Do(r)
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				"prefix": "jsvm",
-			}).Error("Request failed: ", err)
-			return otto.Value{}
-		}
-
-		body, _ := ioutil.ReadAll(resp.Body)
-		tykResp := TykJSHttpResponse{
-			Code:    resp.StatusCode,
-			Body:    string(body),
-			Headers: resp.Header,
-		}
-
-		retAsStr, _ := json.Marshal(tykResp)
-		returnVal, err := j.VM.ToValue(string(retAsStr))
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				"prefix": "jsvm",
-			}).Error("Failed to encode return value: ", err)
-			return otto.Value{}
-		}
-
-		return returnVal
-	})
-
-	// Expose Setters and Getters in the REST API for a key:
-	j.VM.Set("TykGetKeyData", func(call otto.FunctionCall) otto.Value {
-		apiKey := call.Argument(0).String()
-		apiId := call.Argument(1).String()
-
-		obj, _ := handleGetDetail(apiKey, apiId)
-		bs, _ := json.Marshal(obj)
-
-		returnVal, err := j.VM.ToValue(string(bs))
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				"prefix": "jsvm",
-			}).Error("Failed to encode return value: ", err)
-			return otto.Value{}
-		}
-
-		return returnVal
-	})
-
-	j.VM.Set("TykSetKeyData", func(call otto.FunctionCall) otto.Value {
-		apiKey := call.Argument(0).String()
-		encoddedSession := call.Argument(1).String()
-		suppressReset := call.Argument(2).String()
+// jsFunc is a function of the Tyk JS API. It gets its arguments as
+// strings, and returns a string, or false to return undefined.
+type jsFunc func(arg func(int) string) (string, bool)
 
-		newSession := user.SessionState{}
-		err := json.Unmarshal([]byte(encoddedSession), &newSession)
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				"prefix": "jsvm",
-			}).Error("Failed to decode the sesison data")
-			return otto.Value{}
-		}
-
-		doAddOrUpdate(apiKey, &newSession, suppressReset == "1")
-
-		return otto.Value{}
-	})
+func (j *JSVM) LoadTykJSApi() {
+	for name, fn := range j.tykJSApi() {
+		fn := fn
+		j.VM.Set(name, func(call otto.FunctionCall) otto.Value {
+			out, ok := fn(func(i int) string {
+				return call.Argument(i).String()
+			})
+			if !ok {
+				return otto.Value{}
+			}
+			returnVal, err := call.Otto.ToValue(out)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"prefix": "jsvm",
+				}).Error("Failed to encode return value: ", err)
+				return otto.Value{}
+			}
+			return returnVal
+		})
+	}
+	j.VM.Run(tykJSResponse)
+}
 
+// tykJSApi returns the Go side of the Tyk JS API, which both engines
+// expose in the same way.
+func (j *JSVM) tykJSApi() map[string]jsFunc {
 	// Batch request method
 	unsafeBatchHandler := BatchRequestHandler{}
-	j.VM.Set("TykBatchRequest", func(call otto.FunctionCall) otto.Value {
-		requestSet := call.Argument(0).String()
-		log.WithFields(logrus.Fields{
-			"prefix": "jsvm",
-		}).Debug("Batch input is: ", requestSet)
 
-		bs, err := unsafeBatchHandler.ManualBatchRequest([]byte(requestSet))
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				"prefix": "jsvm",
-			}).Error(err)
-			return otto.Value{}
-		}
-
-		returnVal, err := j.VM.ToValue(string(bs))
-		if err != nil {
+	return map[string]jsFunc{
+		// Enable a log
+		"log": func(arg func(int) string) (string, bool) {
+			j.Log.WithFields(logrus.Fields{
+				"prefix": "jsvm-logmsg",
+				"type":   "log-msg",
+			}).Info(arg(0))
+			return "", false
+		},
+		"rawlog": func(arg func(int) string) (string, bool) {
+			j.RawLog.Print(arg(0) + "\n")
+			return "", false
+		},
+
+		// these two needed for non-utf8 bodies
+		"b64dec": func(arg func(int) string) (string, bool) {
+			out, err := base64.StdEncoding.DecodeString(arg(0))
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"prefix": "jsvm",
+				}).Error("Failed to base64 decode: ", err)
+				return "", false
+			}
+			return string(out), true
+		},
+		"b64enc": func(arg func(int) string) (string, bool) {
+			return base64.StdEncoding.EncodeToString([]byte(arg(0))), true
+		},
+
+		// Enable the creation of HTTP Requsts
+		"TykMakeHttpRequest": func(arg func(int) string) (string, bool) {
+			jsonHRO := arg(0)
+			if jsonHRO == "undefined" {
+				// Nope, return nothing
+				return "", false
+			}
+			hro := TykJSHttpRequest{}
+			if err := json.Unmarshal([]byte(jsonHRO), &hro); err != nil {
+				log.WithFields(logrus.Fields{
+					"prefix": "jsvm",
+				}).Error("JSVM: Failed to deserialise HTTP Request object")
+				return "", false
+			}
+
+			// Make the request
+			domain := hro.Domain
+			data := url.Values{}
+			for k, v := range hro.FormData {
+				data.Set(k, v)
+			}
+
+			u, _ := url.ParseRequestURI(domain)
+			u.Path = hro.Resource
+			urlStr := u.String() // "https://api.com/user/"
+
+			var d string
+			if hro.Body != "" {
+				d = hro.Body
+			} else if len(hro.FormData) > 0 {
+				d = data.Encode()
+			}
+
+			r, _ := http.NewRequest(hro.Method, urlStr, nil)
+
+			if d != "" {
+				r, _ = http.NewRequest(hro.Method, urlStr, strings.NewReader(d))
+			}
+
+			for k, v := range hro.Headers {
+				r.Header.Set(k, v)
+			}
+			r.Close = true
+
+			tr := &http.Transport{TLSClientConfig: &tls.Config{}}
+			if cert := getUpstreamCertificate(r.Host, j.Spec); cert != nil {
+				tr.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+			}
+
+			if config.Global.ProxySSLInsecureSkipVerify {
+				tr.TLSClientConfig.InsecureSkipVerify = true
+			}
+
+			// using new Client each time should be ok, since we closing connection every time
+			client := &http.Client{Transport: tr}
+			resp, err := client.Do(r)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"prefix": "jsvm",
+				}).Error("Request failed: ", err)
+				return "", false
+			}
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			tykResp := TykJSHttpResponse{
+				Code:    resp.StatusCode,
+				Body:    string(body),
+				Headers: resp.Header,
+			}
+
+			retAsStr, _ := json.Marshal(tykResp)
+			return string(retAsStr), true
+		},
+
+		// Expose Setters and Getters in the REST API for a key:
+		"TykGetKeyData": func(arg func(int) string) (string, bool) {
+			apiKey := arg(0)
+			apiId := arg(1)
+
+			obj, _ := handleGetDetail(apiKey, apiId)
+			bs, _ := json.Marshal(obj)
+			return string(bs), true
+		},
+
+		"TykSetKeyData": func(arg func(int) string) (string, bool) {
+			apiKey := arg(0)
+			encoddedSession := arg(1)
+			suppressReset := arg(2)
+
+			newSession := user.SessionState{}
+			err := json.Unmarshal([]byte(encoddedSession), &newSession)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"prefix": "jsvm",
+				}).Error("Failed to decode the sesison data")
+				return "", false
+			}
+
+			doAddOrUpdate(apiKey, &newSession, suppressReset == "1")
+			return "", false
+		},
+
+		"TykBatchRequest": func(arg func(int) string) (string, bool) {
+			requestSet := arg(0)
 			log.WithFields(logrus.Fields{
 				"prefix": "jsvm",
-			}).Error("Failed to encode return value: ", err)
-			return otto.Value{}
-		}
-
-		return returnVal
-	})
-
-	j.VM.Run(`function TykJsResponse(response, session_meta) {
-		return JSON.stringify({Response: response, SessionMeta: session_meta})
-	}`)
+			}).Debug("Batch input is: ", requestSet)
+
+			bs, err := unsafeBatchHandler.ManualBatchRequest([]byte(requestSet))
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"prefix": "jsvm",
+				}).Error(err)
+				return "", false
+			}
+			return string(bs), true
+		},
+	}
 }
 
+const tykJSResponse = `function TykJsResponse(response, session_meta) {
+	return JSON.stringify({Response: response, SessionMeta: session_meta})
+}`
+
 const coreJS = `
 var TykJS = {
 	TykMiddleware: {
@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"text/template"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -175,6 +176,78 @@ testJSVMData.NewProcessRequest(function(request, session, spec) {
 	}
 }
 
+func TestJSVMState(t *testing.T) {
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{}}
+	const js = `
+var testJSVMState = new TykJS.TykMiddleware.NewMiddleware({})
+
+testJSVMState.NewProcessRequest(function(request, session, spec) {
+	request.State["tier"] = "gold-" + request.State["from"]
+	delete request.State["from"]
+	return testJSVMState.ReturnData(request, {})
+});`
+	dynMid := &DynamicMiddleware{
+		BaseMiddleware:      BaseMiddleware{spec, nil},
+		MiddlewareClassName: "testJSVMState",
+		Pre:                 true,
+	}
+	jsvm := JSVM{}
+	jsvm.Init(nil)
+	if _, err := jsvm.VM.Run(js); err != nil {
+		t.Fatalf("failed to set up js plugin: %v", err)
+	}
+	dynMid.Spec.JSVM = jsvm
+
+	r := testReq(t, "POST", "/v1/test-data", "{}")
+	ctxSetData(r, map[string]interface{}{})
+	ctxSetState(r, map[string]string{"from": "pre"})
+	if got := ctxGetData(r)["state_from"]; got != "pre" {
+		t.Fatalf("wanted the state in the context vars, got %v", got)
+	}
+	dynMid.ProcessRequest(nil, r, nil)
+
+	// the next hook, whatever its driver, gets it and can change it
+	coProcessor := CoProcessor{Middleware: &CoProcessMiddleware{BaseMiddleware: BaseMiddleware{Spec: spec}}}
+	object := coProcessor.ObjectFromRequest(r)
+	if want, got := "gold-pre", object.State["tier"]; want != got {
+		t.Fatalf("wanted state %q for the coprocess hook, got %q", want, got)
+	}
+	if _, ok := object.State["from"]; ok {
+		t.Fatal("wanted the deleted state to be gone")
+	}
+	object.State["seen"] = "coprocess"
+	coProcessor.ObjectPostProcess(object, r)
+
+	data := ctxGetData(r)
+	if data["state_tier"] != "gold-pre" || data["state_seen"] != "coprocess" || data["state_from"] != nil {
+		t.Fatalf("wanted the context vars to follow the state, got %v", data)
+	}
+
+	// the context vars handed out are a copy
+	data["state_tier"] = "changed"
+	data["added"] = "yes"
+	if again := ctxGetData(r); again["state_tier"] != "gold-pre" || again["added"] != nil {
+		t.Fatalf("wanted the stored context vars left alone, got %v", again)
+	}
+
+	// a server that doesn't send the state back leaves it be
+	object.State = nil
+	coProcessor.ObjectPostProcess(object, r)
+	if got := ctxGetState(r)["tier"]; got != "gold-pre" {
+		t.Fatalf("wanted the state kept, got %q", got)
+	}
+
+	tmeta := &TransformSpec{}
+	tmeta.TemplateData.Input = apidef.RequestJSON
+	tmeta.Template = template.Must(apiTemplate.New("").Parse(`{{._tyk_state.tier}} {{._tyk_context.state_seen}}`))
+	if err := transformBody(r, tmeta, true); err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(r.Body); string(body) != "gold-pre coprocess" {
+		t.Fatalf("wanted the template to see the state, got %q", body)
+	}
+}
+
 func TestJSVMReturnOverridesFullResponse(t *testing.T) {
 	spec := &APISpec{APIDefinition: &apidef.APIDefinition{}}
 	spec.ConfigData = map[string]interface{}{
@@ -301,3 +374,169 @@ testJSVMCore.NewProcessRequest(function(request, session, config) {
 		t.Fatalf("wanted header to be %q, got %q", want, got)
 	}
 }
+
+func testGojaJSVM(t *testing.T, opts config.JSVMOptionsConfig, js string) JSVM {
+	oldEngine, oldOpts := config.Global.JSVMEngine, config.Global.JSVMOptions
+	config.Global.JSVMEngine = config.GojaJSVMEngine
+	config.Global.JSVMOptions = opts
+	defer func() {
+		config.Global.JSVMEngine, config.Global.JSVMOptions = oldEngine, oldOpts
+	}()
+	jsvm := JSVM{}
+	jsvm.Init(nil)
+	if jsvm.pool == nil {
+		t.Fatal("goja JSVM wasn't set up")
+	}
+	if err := jsvm.Load("test.js", []byte(js)); err != nil {
+		t.Fatalf("failed to set up js plugin: %v", err)
+	}
+	jsvm.Warm()
+	return jsvm
+}
+
+func TestJSVMGoja(t *testing.T) {
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{}}
+	spec.ConfigData = map[string]interface{}{
+		"name": "tyk",
+	}
+	const js = `
+class Greeter {
+	constructor(greeting) {
+		this.greeting = greeting
+	}
+	greet(name) {
+		return ` + "`${this.greeting} ${name}`" + `
+	}
+}
+
+const gojaMid = new TykJS.TykMiddleware.NewMiddleware({})
+
+gojaMid.NewProcessRequest((request, session, spec) => {
+	let {name} = spec.config_data
+	request.SetHeaders["X-Greeting"] = new Greeter("hello").greet(name)
+	request.SetHeaders["X-Promise"] = typeof Promise.resolve(1).then
+	request.Body += " appended"
+	return gojaMid.ReturnData(request, {})
+});`
+	dynMid := &DynamicMiddleware{
+		BaseMiddleware:      BaseMiddleware{spec, nil},
+		MiddlewareClassName: "gojaMid",
+		Pre:                 true,
+	}
+	dynMid.Spec.JSVM = testGojaJSVM(t, config.JSVMOptionsConfig{PoolSize: 2}, js)
+
+	body := "foô ￿ \u0000 \xff bàr"
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest("GET", "/foo", strings.NewReader(body))
+		dynMid.ProcessRequest(nil, r, nil)
+		if want, got := "hello tyk", r.Header.Get("X-Greeting"); want != got {
+			t.Fatalf("wanted header to be %q, got %q", want, got)
+		}
+		if want, got := "function", r.Header.Get("X-Promise"); want != got {
+			t.Fatalf("wanted header to be %q, got %q", want, got)
+		}
+		bs, _ := ioutil.ReadAll(r.Body)
+		if want, got := body+" appended", string(bs); want != got {
+			t.Fatalf("JS plugin broke non-UTF8 body %q into %q", want, got)
+		}
+	}
+}
+
+func TestJSVMGojaLimits(t *testing.T) {
+	const js = `
+function spin() {
+	while (true) {
+	}
+}
+function recurse(n) {
+	return recurse(n + 1) + 1
+}
+function hog() {
+	let list = []
+	while (true) {
+		list.push("x".repeat(1024) + list.length)
+	}
+}`
+	jsvm := testGojaJSVM(t, config.JSVMOptionsConfig{
+		MaxCallStackSize: 100,
+		MaxMemoryMB:      16,
+		PoolSize:         1,
+	}, js)
+	jsvm.Timeout = 100 * time.Millisecond
+
+	tests := []struct {
+		name, expr, want string
+	}{
+		{"Timeout", "spin()", "timed out after 100ms"},
+		{"CallStack", "recurse(0)", "call stack size of 100"},
+		{"Memory", "hog()", errJSVMMemory.Error()},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.name != "Timeout" {
+				jsvm.Timeout = 5 * time.Second
+			}
+			_, err := jsvm.Run(tc.expr)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("wanted error %q, got %v", tc.want, err)
+			}
+			// the VM was thrown away and a new one works
+			if got, err := jsvm.Run("1 + 1"); err != nil || got != "2" {
+				t.Fatalf("wanted JSVM to keep working, got %q %v", got, err)
+			}
+		})
+	}
+}
+
+func TestJSVMGojaLoad(t *testing.T) {
+	jsvm := testGojaJSVM(t, config.JSVMOptionsConfig{PoolSize: 2}, `var first = "one"`)
+
+	// VMs in the pool pick up later loads
+	if err := jsvm.Load("second.js", []byte(`var second = first + " two"`)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := jsvm.Run("second"); err != nil || got != "one two" {
+		t.Fatalf("wanted the second program loaded, got %q %v", got, err)
+	}
+
+	// a program failing to load doesn't break the VMs
+	if err := jsvm.Load("bad.js", []byte(`undefinedFunc()`)); err == nil {
+		t.Fatal("wanted an error loading a failing program")
+	}
+	if got, err := jsvm.Run("typeof TykMakeHttpRequest + ' ' + second"); err != nil || got != "function one two" {
+		t.Fatalf("wanted JSVM to keep working, got %q %v", got, err)
+	}
+}
+
+func TestJSVMGojaMaxVMCalls(t *testing.T) {
+	jsvm := testGojaJSVM(t, config.JSVMOptionsConfig{PoolSize: 1, MaxVMCalls: 2}, `var count = 0`)
+
+	// globals live on for as many calls as a VM makes
+	for _, want := range []string{"1", "2", "1", "2"} {
+		if got, err := jsvm.Run("++count"); err != nil || got != want {
+			t.Fatalf("wanted %s, got %q %v", want, got, err)
+		}
+	}
+}
+
+func TestJSVMInitFailure(t *testing.T) {
+	oldEngine, oldPath := config.Global.JSVMEngine, config.Global.TykJSPath
+	config.Global.TykJSPath = "/nonexistent/tyk.js"
+	defer func() {
+		config.Global.JSVMEngine, config.Global.TykJSPath = oldEngine, oldPath
+	}()
+
+	for _, engine := range []string{config.OttoJSVMEngine, config.GojaJSVMEngine} {
+		t.Run(engine, func(t *testing.T) {
+			config.Global.JSVMEngine = engine
+			jsvm := JSVM{}
+			jsvm.Init(nil)
+			if err := jsvm.Load("test.js", []byte(`var x = 1`)); err == nil {
+				t.Error("wanted an error loading into a JSVM that failed to set up")
+			}
+			if _, err := jsvm.Run("1"); err == nil {
+				t.Error("wanted an error running on a JSVM that failed to set up")
+			}
+		})
+	}
+}
@@ -6,12 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/dgrijalva/jwt-go"
 
-	"github.com/TykTechnologies/openid2go/openid"
 	"github.com/TykTechnologies/tyk/apidef"
 	"github.com/TykTechnologies/tyk/user"
 )
@@ -20,7 +20,6 @@ const OIDPREFIX = "openid"
 
 type OpenIDMW struct {
 	BaseMiddleware
-	providerConfiguration     *openid.Configuration
 	provider_client_policymap map[string]map[string]string
 	lock                      sync.RWMutex
 }
@@ -35,92 +34,120 @@ func (k *OpenIDMW) EnabledForSpec() bool {
 
 func (k *OpenIDMW) Init() {
 	k.provider_client_policymap = make(map[string]map[string]string)
-	// Create an OpenID Configuration and store
-	var err error
-	k.providerConfiguration, err = openid.NewConfiguration(openid.ProvidersGetter(k.getProviders),
-		openid.ErrorHandler(k.dummyErrorHandler))
-
-	if err != nil {
-		log.WithFields(logrus.Fields{
-			"prefix": OIDPREFIX,
-		}).Error("OpenID configuration error: ", err)
-	}
-}
-
-func (k *OpenIDMW) getProviders() ([]openid.Provider, error) {
-	providers := []openid.Provider{}
 	log.Debug("Setting up providers: ", k.Spec.OpenIDOptions.Providers)
 	for _, provider := range k.Spec.OpenIDOptions.Providers {
 		iss := provider.Issuer
 		log.Debug("Setting up Issuer: ", iss)
-		providerClientArray := make([]string, len(provider.ClientIDs))
-
-		i := 0
+		clientPolicies := make(map[string]string, len(provider.ClientIDs))
 		for clientID, policyID := range provider.ClientIDs {
 			clID, _ := base64.StdEncoding.DecodeString(clientID)
-			clientID := string(clID)
-
-			k.lock.Lock()
-			if k.provider_client_policymap[iss] == nil {
-				k.provider_client_policymap[iss] = map[string]string{clientID: policyID}
-			} else {
-				k.provider_client_policymap[iss][clientID] = policyID
-			}
-			k.lock.Unlock()
-
-			log.Debug("--> Setting up client: ", clientID, " with policy: ", policyID)
-			providerClientArray[i] = clientID
-			i++
-		}
-
-		p, err := openid.NewProvider(iss, providerClientArray)
-
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				"prefix":   OIDPREFIX,
-				"provider": iss,
-			}).Error("Failed to create provider: ", err)
-		} else {
-			providers = append(providers, p)
+			log.Debug("--> Setting up client: ", string(clID), " with policy: ", policyID)
+			clientPolicies[string(clID)] = policyID
 		}
+		k.lock.Lock()
+		k.provider_client_policymap[iss] = clientPolicies
+		k.lock.Unlock()
+
+		// Run discovery ahead of the first request, so that a
+		// misconfigured issuer shows up in the logs early
+		go func(iss string) {
+			if _, err := getOIDCProvider(iss); err != nil {
+				log.WithFields(logrus.Fields{
+					"prefix":   OIDPREFIX,
+					"provider": iss,
+				}).Error("Failed to discover provider: ", err)
+			}
+		}(iss)
 	}
+}
 
-	return providers, nil
+// idToken returns the raw ID token from the Authorization header or,
+// in relying party mode, from the session cookie.
+func (k *OpenIDMW) idToken(r *http.Request) (raw string, fromCookie bool) {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) == 2 && strings.EqualFold(parts[0], "bearer") {
+		return strings.TrimSpace(parts[1]), false
+	}
+	if rp := k.Spec.OIDCRelyingParty; rp != nil {
+		return rp.sessionToken(r), true
+	}
+	return "", false
 }
 
-// We don't want any of the error handling, we use our own
-func (k *OpenIDMW) dummyErrorHandler(e error, w http.ResponseWriter, r *http.Request) bool {
-	log.WithFields(logrus.Fields{
-		"prefix": OIDPREFIX,
-	}).Warning("JWT Invalid: ", e)
+// startLogin sends browser requests through the relying party login
+// flow, reporting whether it did so.
+func (k *OpenIDMW) startLogin(w http.ResponseWriter, r *http.Request) bool {
+	rp := k.Spec.OIDCRelyingParty
+	if rp == nil || !isBrowserRequest(r) {
+		return false
+	}
+	if err := rp.redirectToLogin(w, r); err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": OIDPREFIX,
+		}).Error("Could not start login: ", err)
+		return false
+	}
 	return true
 }
 
+// providerPolicy returns the policy of a provider configured without
+// client IDs.
+func (k *OpenIDMW) providerPolicy(iss string) string {
+	for _, provider := range k.Spec.OpenIDOptions.Providers {
+		if sameIssuer(provider.Issuer, iss) {
+			return provider.PolicyID
+		}
+	}
+	return ""
+}
+
 func (k *OpenIDMW) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
 	// 1. Validate the JWT
-	ouser, token, halt := openid.AuthenticateOIDWithUser(k.providerConfiguration, w, r)
-
-	// 2. Generate the internal representation for the key
-	if halt {
-		// Fire Authfailed Event
+	rawToken, fromCookie := k.idToken(r)
+	if rawToken == "" {
+		if k.startLogin(w, r) {
+			return nil, mwStatusRespond
+		}
 		k.reportLoginFailure("[JWT]", r)
 		return errors.New("Key not authorised"), 403
 	}
 
-	// 3. Create or set the session to match
-	iss, found := token.Claims.(jwt.MapClaims)["iss"]
-	clients, cfound := token.Claims.(jwt.MapClaims)["aud"]
+	token, _, err := validateOIDCToken(rawToken, k.Spec.OpenIDOptions.Providers)
+	if err == nil && fromCookie {
+		err = k.Spec.OIDCRelyingParty.checkToken(token)
+	}
 
-	if !found && !cfound {
+	// 2. Generate the internal representation for the key
+	if err != nil {
 		log.WithFields(logrus.Fields{
 			"prefix": OIDPREFIX,
-		}).Error("No issuer or audiences found!")
-		k.reportLoginFailure("[NOT GENERATED]", r)
+		}).Warning("JWT Invalid: ", err)
+		if fromCookie {
+			// the session has expired or been tampered with, log in again
+			k.Spec.OIDCRelyingParty.clearSessionCookie(w)
+			if k.startLogin(w, r) {
+				return nil, mwStatusRespond
+			}
+		}
+		// Fire Authfailed Event
+		k.reportLoginFailure("[JWT]", r)
 		return errors.New("Key not authorised"), 403
 	}
+	claims := token.Claims.(jwt.MapClaims)
+	subject := claims["sub"].(string)
 
+	// 3. Create or set the session to match
+	iss, _ := claims["iss"].(string)
 	k.lock.RLock()
-	clientSet, foundIssuer := k.provider_client_policymap[iss.(string)]
+	clientSet, foundIssuer := k.provider_client_policymap[iss]
+	if !foundIssuer {
+		for confIss, set := range k.provider_client_policymap {
+			if sameIssuer(confIss, iss) {
+				clientSet, foundIssuer = set, true
+				break
+			}
+		}
+	}
 	k.lock.RUnlock()
 	if !foundIssuer {
 		log.WithFields(logrus.Fields{
@@ -132,19 +159,17 @@ func (k *OpenIDMW) ProcessRequest(w http.ResponseWriter, r *http.Request, _ inte
 
 	policyID := ""
 	clientID := ""
-	switch v := clients.(type) {
-	case string:
-		k.lock.RLock()
-		policyID = clientSet[v]
-		k.lock.RUnlock()
-		clientID = v
-	case []interface{}:
-		for _, audVal := range v {
-			k.lock.RLock()
-			policy, foundPolicy := clientSet[audVal.(string)]
-			k.lock.RUnlock()
-			if foundPolicy {
-				clientID = audVal.(string)
+	audiences := tokenAudiences(token)
+	if len(clientSet) == 0 {
+		// No client IDs configured, so any audience is accepted
+		policyID = k.providerPolicy(iss)
+		if len(audiences) > 0 {
+			clientID = audiences[0]
+		}
+	} else {
+		for _, aud := range audiences {
+			if policy, foundPolicy := clientSet[aud]; foundPolicy {
+				clientID = aud
 				policyID = policy
 				break
 			}
@@ -159,7 +184,7 @@ func (k *OpenIDMW) ProcessRequest(w http.ResponseWriter, r *http.Request, _ inte
 		return errors.New("Key not authorised"), 403
 	}
 
-	data := []byte(ouser.ID)
+	data := []byte(subject)
 	tokenID := fmt.Sprintf("%x", md5.Sum(data))
 	sessionID := k.Spec.OrgID + tokenID
 	if k.Spec.OpenIDOptions.SegregateByClient {
@@ -191,7 +216,7 @@ func (k *OpenIDMW) ProcessRequest(w http.ResponseWriter, r *http.Request, _ inte
 
 		session = newSession
 		session.MetaData = map[string]interface{}{"TykJWTSessionID": sessionID, "ClientID": clientID}
-		session.Alias = clientID + ":" + ouser.ID
+		session.Alias = clientID + ":" + subject
 
 		// Update the session in the session manager in case it gets called again
 		k.Spec.SessionManager.UpdateSession(sessionID, &session, session.Lifetime(k.Spec.SessionLifetime))
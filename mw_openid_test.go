@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/square/go-jose"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/user"
+)
+
+const openIDTestClientID = "tyk-test-client"
+
+// testOpenIDProvider is a minimal OpenID Connect provider, signing ID
+// tokens with jwtRSAPrivKey.
+type testOpenIDProvider struct {
+	*httptest.Server
+	nonce string
+}
+
+func newTestOpenIDProvider(t *testing.T) *testOpenIDProvider {
+	pubKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(jwtRSAPubKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testOpenIDProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcWellKnownPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcProviderMeta{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSURI:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JsonWebKeySet{Keys: []jose.JsonWebKey{{
+			Key:       pubKey,
+			KeyID:     "test",
+			Algorithm: "RS256",
+			Use:       "sig",
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != openIDTestClientID || secret != "secret" {
+			http.Error(w, "invalid_client", 401)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"id_token": p.token(t, openIDTestClientID, p.nonce),
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *testOpenIDProvider) token(t *testing.T, aud, nonce string) string {
+	claims := jwt.MapClaims{
+		"iss": p.URL,
+		"sub": "user@example.com",
+		"aud": aud,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	return p.sign(t, claims)
+}
+
+func (p *testOpenIDProvider) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	signKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(jwtRSAPrivKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenString, err := token.SignedString(signKey)
+	if err != nil {
+		t.Fatal("Couldn't create JWT token: ", err)
+	}
+	return tokenString
+}
+
+func setupOpenIDTest(t *testing.T, rp bool) (*testOpenIDProvider, string, func()) {
+	idp := newTestOpenIDProvider(t)
+
+	policiesMu.Lock()
+	policiesByID["oidc-policy"] = user.Policy{
+		ID:               "oidc-policy",
+		Rate:             1000.0,
+		Per:              1.0,
+		QuotaMax:         -1,
+		QuotaRenewalRate: -1,
+		AccessRights: map[string]user.AccessDefinition{"test": {
+			APIName:  "Test",
+			APIID:    "test",
+			Versions: []string{"v1"},
+		}},
+		Active: true,
+	}
+	policiesMu.Unlock()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen(ln, nil, nil)
+
+	buildAndLoadAPI(func(spec *APISpec) {
+		spec.UseKeylessAccess = false
+		spec.UseOpenID = true
+		spec.Proxy.ListenPath = "/oidc/"
+		spec.OpenIDOptions.Providers = []apidef.OIDProviderConfig{{
+			Issuer:   idp.URL,
+			PolicyID: "oidc-policy",
+		}}
+		spec.OpenIDOptions.RelyingParty = apidef.OIDRelyingPartyConfig{
+			Enabled:      rp,
+			Issuer:       idp.URL,
+			ClientID:     openIDTestClientID,
+			ClientSecret: "secret",
+			CookieSecret: "cookie-secret",
+		}
+	})
+
+	return idp, "http://" + ln.Addr().String() + "/oidc", func() {
+		ln.Close()
+		idp.Close()
+		OIDCProviderCache.Flush()
+	}
+}
+
+func TestOpenIDIssuerOnlyProvider(t *testing.T) {
+	idp, baseURL, cleanup := setupOpenIDTest(t, false)
+	defer cleanup()
+
+	tests := []struct {
+		name, auth string
+		code       int
+	}{
+		{"NoToken", "", 403},
+		{"ValidToken", "Bearer " + idp.token(t, "any-audience", ""), 200},
+		{"BadSignature", "Bearer " + idp.token(t, "any-audience", "")[:50] + "AAAA", 403},
+		{"NoExpiry", "Bearer " + idp.sign(t, jwt.MapClaims{"iss": idp.URL, "sub": "user@example.com"}), 403},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", baseURL+"/", nil)
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.code {
+				t.Errorf("Wanted status %d, got %d", tc.code, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestOpenIDRelyingParty(t *testing.T) {
+	idp, baseURL, cleanup := setupOpenIDTest(t, true)
+	defer cleanup()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// Non-browser clients are rejected outright
+	resp, err := client.Get(baseURL + "/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 403 {
+		t.Fatal("Expected 403 for API client without token, got", resp.StatusCode)
+	}
+
+	// Browsers are sent to the provider
+	req, _ := http.NewRequest("GET", baseURL+"/resource?a=b", nil)
+	req.Header.Set("Accept", "text/html")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 302 {
+		t.Fatal("Expected redirect to login, got", resp.StatusCode)
+	}
+	loc, _ := url.Parse(resp.Header.Get("Location"))
+	if got := loc.Scheme + "://" + loc.Host + loc.Path; got != idp.URL+"/authorize" {
+		t.Fatal("Unexpected login redirect:", got)
+	}
+	q := loc.Query()
+	if q.Get("client_id") != openIDTestClientID || q.Get("scope") != "openid" {
+		t.Fatal("Unexpected authorization request:", loc.RawQuery)
+	}
+	idp.nonce = q.Get("nonce")
+	var stateCookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == oidcDefaultCookieName+"_state" {
+			stateCookie = c
+		}
+	}
+	if stateCookie == nil || !stateCookie.HttpOnly || stateCookie.MaxAge <= 0 {
+		t.Fatal("Expected a short lived login state cookie, got", resp.Cookies())
+	}
+	callback := baseURL + "/" + oidcCallbackPath + "?code=abc&state=" + url.QueryEscape(q.Get("state"))
+
+	// A forged state is rejected
+	resp, err = client.Get(baseURL + "/" + oidcCallbackPath + "?code=abc&state=forged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Fatal("Expected forged state to be rejected, got", resp.StatusCode)
+	}
+
+	// A callback from a login another browser started is rejected
+	resp, err = client.Get(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Fatal("Expected callback without the state cookie to be rejected, got", resp.StatusCode)
+	}
+
+	// The provider redirects back with a code
+	req, _ = http.NewRequest("GET", callback, nil)
+	req.AddCookie(stateCookie)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 302 || resp.Header.Get("Location") != "/oidc/resource?a=b" {
+		t.Fatal("Expected redirect back to the resource, got", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	var cookies []*http.Cookie
+	for _, c := range resp.Cookies() {
+		switch {
+		case c.Name == stateCookie.Name && c.MaxAge < 0:
+		case c.Name == oidcDefaultCookieName && c.HttpOnly:
+			cookies = append(cookies, c)
+		default:
+			t.Fatal("Unexpected cookie", c)
+		}
+	}
+	if len(cookies) != 1 {
+		t.Fatal("Expected session cookie, got", resp.Cookies())
+	}
+
+	req, _ = http.NewRequest("GET", baseURL+"/resource?a=b", nil)
+	req.Header.Set("Accept", "text/html")
+	req.AddCookie(cookies[0])
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatal("Expected session cookie to grant access, got", resp.StatusCode)
+	}
+
+	// A tampered cookie sends the browser back to login
+	req, _ = http.NewRequest("GET", baseURL+"/resource", nil)
+	req.Header.Set("Accept", "text/html")
+	req.AddCookie(&http.Cookie{Name: oidcDefaultCookieName, Value: cookies[0].Value[:20]})
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 302 {
+		t.Fatal("Expected tampered cookie to restart login, got", resp.StatusCode)
+	}
+}
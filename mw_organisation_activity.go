@@ -63,9 +63,9 @@ func (k *OrganizationMonitor) ProcessRequestLive(r *http.Request) (error, int) {
 	}
 
 	// We found a session, apply the quota limiter
-	reason := k.sessionlimiter.ForwardMessage(&session,
+	reason, _ := k.sessionlimiter.ForwardMessage(&session,
 		k.Spec.OrgID,
-		k.Spec.OrgSessionManager.Store(), false, false)
+		k.Spec.OrgSessionManager.Store(), false, false, limitOptions{})
 
 	k.Spec.OrgSessionManager.UpdateSession(k.Spec.OrgID, &session, session.Lifetime(k.Spec.SessionLifetime))
 
@@ -154,7 +154,7 @@ func (k *OrganizationMonitor) AllowAccessNext(orgChan chan bool, r *http.Request
 	}
 
 	// We found a session, apply the quota limiter
-	isQuotaExceeded := k.sessionlimiter.RedisQuotaExceeded(&session, k.Spec.OrgID, k.Spec.OrgSessionManager.Store())
+	isQuotaExceeded := k.sessionlimiter.RedisQuotaExceeded(&session, k.Spec.OrgID, k.Spec.OrgSessionManager.Store(), limitOptions{})
 
 	k.Spec.OrgSessionManager.UpdateSession(k.Spec.OrgID, &session, session.Lifetime(k.Spec.SessionLifetime))
 
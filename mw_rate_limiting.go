@@ -1,10 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"io"
+	"io/ioutil"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/TykTechnologies/tyk/apidef"
 	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/user"
 )
 
 var sessionLimiter = SessionLimiter{}
@@ -60,17 +68,97 @@ func (k *RateLimitAndQuotaCheck) handleQuotaFailure(r *http.Request, token strin
 	return errors.New("Quota exceeded"), 403
 }
 
+// endpointLimit finds the access spec for the request, if it has a rate
+// limit or quota of its own.
+func (k *RateLimitAndQuotaCheck) endpointLimit(r *http.Request, session *user.SessionState) *endpointLimit {
+	accessDef, ok := session.AccessRights[k.Spec.APIID]
+	if !ok || len(accessDef.AllowedURLs) == 0 {
+		return nil
+	}
+	accessSpec, err := matchAccessSpec(accessDef.AllowedURLs, r)
+	if err != nil {
+		log.Error("Regex error: ", err)
+		return nil
+	}
+	if accessSpec == nil || (!accessSpec.HasRateLimit() && !accessSpec.HasQuota()) {
+		return nil
+	}
+	return &endpointLimit{apiID: k.Spec.APIID, spec: accessSpec}
+}
+
+// requestCharge is what a request was charged against its key's limits,
+// kept so that a cost set by the upstream can be charged in full once
+// it responds.
+type requestCharge struct {
+	cost     int64
+	meta     *apidef.RequestCostMeta
+	endpoint *endpointLimit
+}
+
+// requestCost works out what the request costs, before it is proxied.
+// A cost set in a response header isn't known yet, so the configured
+// cost is charged for now.
+func (k *RateLimitAndQuotaCheck) requestCost(r *http.Request) (int64, *apidef.RequestCostMeta) {
+	_, versionPaths, _, _ := k.Spec.Version(r)
+	found, meta := k.Spec.CheckSpecMatchesStatus(r, versionPaths, RequestCost)
+	if !found {
+		return 1, nil
+	}
+	costMeta := meta.(*apidef.RequestCostMeta)
+	cost := costMeta.Cost
+	if cost < 1 {
+		cost = 1
+	}
+	if costMeta.Source == "body_size" {
+		if units := requestBodyUnits(r, costMeta.BodyUnit); units > cost {
+			cost = units
+		}
+	}
+	return cost, costMeta
+}
+
+// maxBodyCostSize is as much of a chunked request body as is read to
+// work out its cost. Bigger bodies cost as much as one this size.
+var maxBodyCostSize int64 = 10 << 20
+
+// requestBodyUnits is the size of the request body in units of unit
+// bytes, rounded up. Unit defaults to a kilobyte.
+func requestBodyUnits(r *http.Request, unit int64) int64 {
+	if unit <= 0 {
+		unit = 1024
+	}
+	size := r.ContentLength
+	if size < 0 && r.Body != nil {
+		// chunked, so the body has to be read to be measured, up to a
+		// point; the rest is left for the upstream
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodyCostSize))
+		if err != nil {
+			log.Error("Failed to read request body: ", err)
+		}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		size = int64(len(body))
+	}
+	return (size + unit - 1) / unit
+}
+
 // ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
 func (k *RateLimitAndQuotaCheck) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
 	session := ctxGetSession(r)
 	token := ctxGetAuthToken(r)
 
+	cost, costMeta := k.requestCost(r)
+	endpoint := k.endpointLimit(r, session)
+
 	storeRef := k.Spec.SessionManager.Store()
-	reason := sessionLimiter.ForwardMessage(session,
+	reason, state := sessionLimiter.ForwardMessage(session,
 		token,
 		storeRef,
 		!k.Spec.DisableRateLimit,
-		!k.Spec.DisableQuota)
+		!k.Spec.DisableQuota,
+		limitOptions{limiter: k.Spec.RateLimiter, endpoint: endpoint, cost: cost})
 
 	// If either are disabled, save the write roundtrip
 	if !k.Spec.DisableRateLimit || !k.Spec.DisableQuota {
@@ -79,16 +167,22 @@ func (k *RateLimitAndQuotaCheck) ProcessRequest(w http.ResponseWriter, r *http.R
 		ctxSetSession(r, session)
 	}
 
+	ctxSetRateLimitState(r, state)
+
 	switch reason {
 	case sessionFailNone:
 	case sessionFailRateLimit:
+		setRetryAfter(k.Spec, w.Header(), state)
 		return k.handleRateLimitFailure(r, token)
 	case sessionFailQuota:
+		setRetryAfter(k.Spec, w.Header(), state)
 		return k.handleQuotaFailure(r, token)
 	default:
 		// Other reason? Still not allowed
 		return errors.New("Access denied"), 403
 	}
+	ctxSetRequestCharge(r, &requestCharge{cost: cost, meta: costMeta, endpoint: endpoint})
+
 	// Run the trigger monitor
 	if config.Global.Monitor.MonitorUserKeys {
 		sessionMonitor.Check(session, token)
@@ -97,3 +191,52 @@ func (k *RateLimitAndQuotaCheck) ProcessRequest(w http.ResponseWriter, r *http.R
 	// Request is valid, carry on
 	return nil, 200
 }
+
+// setRateLimitHeaders tells the client of an authenticated request where
+// it stands against its limits. The X-RateLimit headers report the key's
+// quota, as they always have, while the RateLimit headers from the IETF
+// draft report whichever limit is closest to being exceeded.
+func setRateLimitHeaders(spec *APISpec, h http.Header, r *http.Request) {
+	if spec.DisableRateLimitHeaders {
+		return
+	}
+	session := ctxGetSession(r)
+	if session == nil {
+		return
+	}
+	h.Set("X-RateLimit-Limit", strconv.Itoa(int(session.QuotaMax)))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(int(session.QuotaRemaining)))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(int(session.QuotaRenews)))
+
+	state := ctxGetRateLimitState(r)
+	if state == nil || state.remaining < 0 {
+		return
+	}
+	h.Set("RateLimit-Limit", strconv.FormatInt(state.limit, 10))
+	h.Set("RateLimit-Remaining", strconv.FormatInt(state.remaining, 10))
+	h.Set("RateLimit-Reset", strconv.FormatInt(secondsUntil(state.reset), 10))
+}
+
+// setRetryAfter tells a client that went over a limit how long to wait
+// before trying again.
+func setRetryAfter(spec *APISpec, h http.Header, state *rateLimitState) {
+	if spec.DisableRateLimitHeaders || state == nil || state.reset.IsZero() {
+		return
+	}
+	wait := secondsUntil(state.reset)
+	if wait < 1 {
+		wait = 1
+	}
+	h.Set("Retry-After", strconv.FormatInt(wait, 10))
+}
+
+func secondsUntil(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	secs := int64(math.Ceil(time.Until(t).Seconds()))
+	if secs < 0 {
+		return 0
+	}
+	return secs
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/storage"
+	"github.com/TykTechnologies/tyk/user"
+)
+
+func TestEndpointRateLimitAndQuota(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen(ln, nil, nil)
+	defer ln.Close()
+	baseURL := "http://" + ln.Addr().String() + "/endpoint-limits"
+
+	buildAndLoadAPI(func(spec *APISpec) {
+		spec.UseKeylessAccess = false
+		spec.Auth.AuthHeaderName = "authorization"
+		spec.Proxy.ListenPath = "/endpoint-limits/"
+	})
+
+	DRLManager.CurrentTokenValue = 1
+	DRLManager.RequestTokenValue = 1
+	defer func() {
+		DRLManager.CurrentTokenValue = 0
+		DRLManager.RequestTokenValue = 0
+	}()
+
+	key := uuid.NewV4().String()
+	session := createNonThrottledSession()
+	session.QuotaMax = -1
+	session.AccessRights = map[string]user.AccessDefinition{"test": {
+		APIID:    "test",
+		Versions: []string{"v1"},
+		AllowedURLs: []user.AccessSpec{
+			{URL: "/reports", Methods: []string{"GET"}, Rate: 2, Per: 60},
+			{URL: "/exports", Methods: []string{"GET"}, QuotaMax: 2, QuotaRenewalRate: 60},
+			{URL: "/status", Methods: []string{"GET"}},
+		},
+	}}
+	FallbackKeySesionManager.UpdateSession(key, session, 60)
+	defer FallbackKeySesionManager.RemoveSession(key)
+
+	get := func(t *testing.T, path string, code int) http.Header {
+		req, _ := http.NewRequest("GET", baseURL+path, nil)
+		req.Header.Set("Authorization", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("Wanted status %d for %s, got %d", code, path, resp.StatusCode)
+		}
+		return resp.Header
+	}
+
+	t.Run("Rate", func(t *testing.T) {
+		get(t, "/reports", 200)
+		get(t, "/reports", 200)
+		get(t, "/reports", 429)
+		get(t, "/status", 200)
+	})
+	t.Run("Quota", func(t *testing.T) {
+		get(t, "/exports", 200)
+		get(t, "/exports", 200)
+		if header := get(t, "/exports", 403); header.Get("Retry-After") == "" {
+			t.Error("Retry-After should be set when the quota is exceeded")
+		}
+		get(t, "/status", 200)
+	})
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen(ln, nil, nil)
+	defer ln.Close()
+	baseURL := "http://" + ln.Addr().String() + "/rl-headers/"
+
+	loadAPI := func(disableHeaders bool) {
+		buildAndLoadAPI(func(spec *APISpec) {
+			spec.UseKeylessAccess = false
+			spec.Auth.AuthHeaderName = "authorization"
+			spec.Proxy.ListenPath = "/rl-headers/"
+			spec.DisableRateLimitHeaders = disableHeaders
+		})
+	}
+	loadAPI(false)
+
+	DRLManager.CurrentTokenValue = 1
+	DRLManager.RequestTokenValue = 1
+	defer func() {
+		DRLManager.CurrentTokenValue = 0
+		DRLManager.RequestTokenValue = 0
+	}()
+
+	newKey := func() string {
+		key := uuid.NewV4().String()
+		session := createNonThrottledSession()
+		session.Rate = 2
+		session.Per = 60
+		session.QuotaMax = 5
+		session.QuotaRenewalRate = 60
+		FallbackKeySesionManager.UpdateSession(key, session, 60)
+		return key
+	}
+
+	get := func(t *testing.T, key string, code int) http.Header {
+		req, _ := http.NewRequest("GET", baseURL, nil)
+		req.Header.Set("Authorization", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("Wanted status %d, got %d", code, resp.StatusCode)
+		}
+		return resp.Header
+	}
+
+	t.Run("Enabled", func(t *testing.T) {
+		key := newKey()
+		defer FallbackKeySesionManager.RemoveSession(key)
+
+		header := get(t, key, 200)
+		want := map[string]string{
+			"X-RateLimit-Limit":     "5",
+			"X-RateLimit-Remaining": "4",
+			"RateLimit-Limit":       "2",
+			"RateLimit-Remaining":   "1",
+		}
+		for name, value := range want {
+			if got := header.Get(name); got != value {
+				t.Errorf("Wanted %s to be %q, got %q", name, value, got)
+			}
+		}
+		if header.Get("RateLimit-Reset") == "" {
+			t.Error("RateLimit-Reset should be set")
+		}
+
+		get(t, key, 200)
+		header = get(t, key, 429)
+		if header.Get("RateLimit-Remaining") != "0" {
+			t.Error("Wanted no requests remaining, got", header.Get("RateLimit-Remaining"))
+		}
+		if header.Get("Retry-After") == "" {
+			t.Error("Retry-After should be set when the rate limit is exceeded")
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		loadAPI(true)
+		key := newKey()
+		defer FallbackKeySesionManager.RemoveSession(key)
+
+		get(t, key, 200)
+		get(t, key, 200)
+		header := get(t, key, 429)
+		for _, name := range []string{"X-RateLimit-Limit", "RateLimit-Limit", "Retry-After"} {
+			if header.Get(name) != "" {
+				t.Errorf("%s should not be set", name)
+			}
+		}
+	})
+}
+
+func TestRequestCost(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metered" {
+			w.Header().Set("X-Cost", "4")
+		}
+	}))
+	defer upstream.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen(ln, nil, nil)
+	defer ln.Close()
+	baseURL := "http://" + ln.Addr().String()
+
+	buildAndLoadAPI(func(spec *APISpec) {
+		spec.UseKeylessAccess = false
+		spec.Auth.AuthHeaderName = "authorization"
+		spec.Proxy.ListenPath = "/"
+		spec.Proxy.TargetURL = upstream.URL
+		v := spec.VersionData.Versions["v1"]
+		v.UseExtendedPaths = true
+		v.ExtendedPaths = apidef.ExtendedPathsSet{
+			RequestCost: []apidef.RequestCostMeta{
+				{Path: "/static", Method: "GET", Cost: 3},
+				{Path: "/upload", Method: "POST", Source: "body_size", BodyUnit: 10},
+				{Path: "/metered", Method: "GET", Source: "header", HeaderName: "X-Cost"},
+			},
+		}
+		spec.VersionData.Versions["v1"] = v
+	})
+
+	key := uuid.NewV4().String()
+	session := createNonThrottledSession()
+	session.QuotaMax = 12
+	session.QuotaRenewalRate = 60
+	session.QuotaRenews = time.Now().Add(time.Minute).Unix()
+	FallbackKeySesionManager.UpdateSession(key, session, 60)
+	defer FallbackKeySesionManager.RemoveSession(key)
+
+	do := func(t *testing.T, method, path, body string, code int, remaining string) http.Header {
+		req, _ := http.NewRequest(method, baseURL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("Wanted status %d for %s, got %d", code, path, resp.StatusCode)
+		}
+		if got := resp.Header.Get("X-RateLimit-Remaining"); code == 200 && got != remaining {
+			t.Errorf("Wanted %s of the quota remaining after %s, got %s", remaining, path, got)
+		}
+		return resp.Header
+	}
+
+	do(t, "GET", "/static", "", 200, "9")
+	do(t, "POST", "/upload", strings.Repeat("x", 25), 200, "6")
+	if header := do(t, "GET", "/metered", "", 200, "2"); header.Get("X-Cost") != "" {
+		t.Error("The cost header should not be passed on")
+	}
+	// too costly, but it doesn't use up what's left
+	do(t, "GET", "/static", "", 403, "")
+	do(t, "GET", "/other", "", 200, "1")
+}
+
+func TestRequestBodyUnits(t *testing.T) {
+	defer func(size int64) { maxBodyCostSize = size }(maxBodyCostSize)
+	maxBodyCostSize = 100
+
+	body := strings.Repeat("x", 250)
+	req := testReq(t, "POST", "/", body)
+	req.ContentLength = -1
+	if units := requestBodyUnits(req, 10); units != 10 {
+		t.Errorf("Wanted a chunked body to be measured up to the limit, got %d units", units)
+	}
+	if got, _ := ioutil.ReadAll(req.Body); string(got) != body {
+		t.Errorf("Wanted the whole body to be left for the upstream, got %d bytes", len(got))
+	}
+}
+
+func TestRollingWindowCost(t *testing.T) {
+	store := storage.RedisCluster{}
+	key := RateLimitKeyPrefix + uuid.NewV4().String()
+	defer store.DeleteRawKey(key)
+
+	exceeded, _ := sessionLimiter.doRollingWindowWrite(key, key, key+".BLOCKED", 5, 60, 100000, store)
+	if !exceeded {
+		t.Fatal("Request costing more than the rate should be rejected")
+	}
+	window, err := store.GetSortedSetRange(key, "-inf", "+inf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(window) != 6 {
+		t.Errorf("Wanted a costly request to fill the window and no more, got %d places", len(window))
+	}
+}
+
+func TestScheduledQuota(t *testing.T) {
+	store := storage.RedisCluster{KeyPrefix: "apikey-"}
+	key := uuid.NewV4().String()
+	session := createNonThrottledSession()
+	session.QuotaMax = 2
+	session.QuotaRenewalRate = 0
+	session.QuotaSchedule = user.QuotaScheduleDaily
+	session.QuotaTimezone = "America/New_York"
+
+	for i := 0; i < 2; i++ {
+		if sessionLimiter.RedisQuotaExceeded(session, key, store, limitOptions{}) {
+			t.Fatalf("Request %d should be within the quota", i)
+		}
+	}
+	if !sessionLimiter.RedisQuotaExceeded(session, key, store, limitOptions{}) {
+		t.Error("Request over the quota should be rejected")
+	}
+
+	loc, _ := time.LoadLocation("America/New_York")
+	renews := time.Unix(session.QuotaRenews, 0).In(loc)
+	if renews.Hour() != 0 || renews.Minute() != 0 || time.Until(renews) > 24*time.Hour {
+		t.Error("Wanted the quota to renew at the next midnight in New York, got", renews)
+	}
+	// quota counters are raw keys
+	rawStore := storage.RedisCluster{}
+	ttl, err := rawStore.GetExp(QuotaKeyPrefix + storage.HashKey(key))
+	if err != nil || ttl <= 0 || ttl > 24*60*60 {
+		t.Error("Wanted the counter to expire when the quota renews, got", ttl, err)
+	}
+}
@@ -242,14 +242,7 @@ func (m *RedisCacheMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Req
 	}
 
 	copyHeader(w.Header(), newRes.Header)
-	session := ctxGetSession(r)
-
-	// Only add ratelimit data to keyed sessions
-	if session != nil {
-		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(session.QuotaMax)))
-		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(session.QuotaRemaining)))
-		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(session.QuotaRenews)))
-	}
+	setRateLimitHeaders(m.Spec, w.Header(), r)
 	w.Header().Set("x-tyk-cached-response", "1")
 	w.WriteHeader(newRes.StatusCode)
 	m.Proxy.CopyResponse(w, newRes.Body)
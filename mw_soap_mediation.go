@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+// SOAPMediationMiddleware turns JSON requests to the endpoints of SOAP
+// operations into SOAP requests for the upstream. The responses are
+// turned back into JSON by ResponseSOAPMiddleware.
+type SOAPMediationMiddleware struct {
+	BaseMiddleware
+}
+
+func (m *SOAPMediationMiddleware) Name() string {
+	return "SOAPMediationMiddleware"
+}
+
+func (m *SOAPMediationMiddleware) EnabledForSpec() bool {
+	return soapMediationEnabled(m.Spec)
+}
+
+func soapMediationEnabled(spec *APISpec) bool {
+	for _, version := range spec.VersionData.Versions {
+		if len(version.ExtendedPaths.SOAP) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
+func (m *SOAPMediationMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	_, versionPaths, _, _ := m.Spec.Version(r)
+	found, meta := m.Spec.CheckSpecMatchesStatus(r, versionPaths, SOAPMediated)
+	if !found {
+		return nil, 200
+	}
+	soapMeta := meta.(*apidef.SOAPMeta)
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return errors.New("Failed to read request body"), 400
+	}
+	var envelope []byte
+	if len(bytes.TrimSpace(body)) == 0 {
+		envelope, err = soapEnvelopeFromQuery(soapMeta, r.URL.Query())
+	} else {
+		envelope, err = soapEnvelope(soapMeta, bytes.NewReader(body))
+	}
+	if err != nil {
+		logEntry := getLogEntryForRequest(r, "", map[string]interface{}{
+			"prefix": "soap",
+			"api_id": m.Spec.APIID,
+		})
+		logEntry.Info("Failed to build SOAP request: ", err)
+		return errors.New("Request body could not be converted: " + err.Error()), 400
+	}
+
+	r.Method = http.MethodPost
+	r.Body = ioutil.NopCloser(bytes.NewReader(envelope))
+	r.ContentLength = int64(len(envelope))
+	r.Header.Set("Content-Length", strconv.Itoa(len(envelope)))
+	if soapMeta.Version == "1.2" {
+		contentType := "application/soap+xml; charset=utf-8"
+		if soapMeta.SOAPAction != "" {
+			contentType += `; action="` + soapMeta.SOAPAction + `"`
+		}
+		r.Header.Set("Content-Type", contentType)
+	} else {
+		r.Header.Set("Content-Type", "text/xml; charset=utf-8")
+		r.Header.Set("SOAPAction", `"`+soapMeta.SOAPAction+`"`)
+	}
+	r.Header.Set("Accept", "text/xml, application/soap+xml")
+
+	ctxSetSOAPMediation(r, soapMeta)
+	return nil, 200
+}
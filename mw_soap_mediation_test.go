@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const soapUserResponse = `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+	<soap:Body>
+		<GetUserResponse xmlns="http://example.com/users" xmlns:a="http://example.com/audit">
+			<Name>Foo &amp; Bar</Name>
+			<Role>admin</Role>
+			<Group id="1">staff</Group>
+			<Manager xsi:nil="true"/>
+			<a:Modified>2018-01-01</a:Modified>
+		</GetUserResponse>
+	</soap:Body>
+</soap:Envelope>`
+
+const soapFaultResponse = `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+	<soap:Body>
+		<soap:Fault>
+			<faultcode>soap:%s</faultcode>
+			<faultstring>No such user</faultstring>
+			<detail><UserID>42</UserID></detail>
+		</soap:Fault>
+	</soap:Body>
+</soap:Envelope>`
+
+func TestSOAPMediation(t *testing.T) {
+	var gotAction, gotType, gotMethod, gotBody string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		gotAction = r.Header.Get("SOAPAction")
+		gotType = r.Header.Get("Content-Type")
+		gotMethod = r.Method
+		gotBody = string(body)
+
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		switch r.URL.Path {
+		case "/client-fault":
+			w.WriteHeader(500)
+			w.Write([]byte(strings.Replace(soapFaultResponse, "%s", "Client", 1)))
+		case "/server-fault":
+			w.WriteHeader(500)
+			w.Write([]byte(strings.Replace(soapFaultResponse, "%s", "Server", 1)))
+		default:
+			w.Write([]byte(soapUserResponse))
+		}
+	}))
+	defer upstream.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen(ln, nil, nil)
+	defer ln.Close()
+	baseURL := "http://" + ln.Addr().String()
+
+	soapMeta := func(path, method string) apidef.SOAPMeta {
+		return apidef.SOAPMeta{
+			Path:          path,
+			Method:        method,
+			Operation:     "GetUser",
+			Namespace:     "http://example.com/users",
+			SOAPAction:    "http://example.com/users/GetUser",
+			Namespaces:    map[string]string{"http://example.com/audit": "audit"},
+			ArrayElements: []string{"Role"},
+			FaultCodes:    map[string]int{"Server": 404},
+		}
+	}
+	buildAndLoadAPI(func(spec *APISpec) {
+		spec.Proxy.ListenPath = "/"
+		spec.Proxy.TargetURL = upstream.URL
+		v := spec.VersionData.Versions["v1"]
+		v.UseExtendedPaths = true
+		v.ExtendedPaths = apidef.ExtendedPathsSet{
+			SOAP: []apidef.SOAPMeta{
+				soapMeta("/users", "POST"),
+				soapMeta("/users", "GET"),
+				soapMeta("/client-fault", "POST"),
+				soapMeta("/server-fault", "POST"),
+			},
+		}
+		spec.VersionData.Versions["v1"] = v
+	})
+
+	do := func(t *testing.T, method, path, body string, code int) map[string]interface{} {
+		req, _ := http.NewRequest(method, baseURL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("Wanted status %d, got %d", code, resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("Wanted a JSON response, got %q", ct)
+		}
+		var out map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	t.Run("Request", func(t *testing.T) {
+		out := do(t, "POST", "/users", `{"UserID": 42, "Filter": {"Active": true, "Tag": ["a", "<b>"]}, "Note": null}`, 200)
+
+		want := `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>` +
+			`<GetUser xmlns="http://example.com/users"><UserID>42</UserID>` +
+			`<Filter><Active>true</Active><Tag>a</Tag><Tag>&lt;b&gt;</Tag></Filter><Note/></GetUser>` +
+			`</soap:Body></soap:Envelope>`
+		if !strings.HasSuffix(gotBody, want) {
+			t.Errorf("Wanted envelope %s, got %s", want, gotBody)
+		}
+		if gotMethod != "POST" || gotAction != `"http://example.com/users/GetUser"` || !strings.HasPrefix(gotType, "text/xml") {
+			t.Errorf("Wanted a SOAP 1.1 request, got %s with action %s and type %s", gotMethod, gotAction, gotType)
+		}
+
+		got, _ := json.Marshal(out)
+		wantJSON := `{"Group":{"#text":"staff","-id":"1"},"Manager":null,"Name":"Foo \u0026 Bar","Role":["admin"],"audit:Modified":"2018-01-01"}`
+		if string(got) != wantJSON {
+			t.Errorf("Wanted response %s, got %s", wantJSON, got)
+		}
+	})
+
+	t.Run("Query", func(t *testing.T) {
+		do(t, "GET", "/users?UserID=42", "", 200)
+		if !strings.Contains(gotBody, `<GetUser xmlns="http://example.com/users"><UserID>42</UserID></GetUser>`) || gotMethod != "POST" {
+			t.Errorf("Wanted the query string sent as the operation, got %s %s", gotMethod, gotBody)
+		}
+	})
+
+	t.Run("InvalidBody", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", baseURL+"/users", strings.NewReader(`{"bad name": 1}`))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Errorf("Wanted status 400, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("Faults", func(t *testing.T) {
+		out := do(t, "POST", "/client-fault", `{"UserID": 42}`, 400)
+		if out["error"] != "No such user" || out["fault_code"] != "Client" {
+			t.Errorf("Wanted the fault as the error, got %v", out)
+		}
+		if detail, _ := out["detail"].(map[string]interface{}); detail["UserID"] != "42" {
+			t.Errorf("Wanted the fault's detail, got %v", out["detail"])
+		}
+		do(t, "POST", "/server-fault", `{"UserID": 42}`, 404)
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"golang.org/x/net/html/charset"
 
 	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/user"
 )
 
 func WrappedCharsetReader(s string, i io.Reader) (io.Reader, error) {
@@ -81,18 +82,10 @@ func transformBody(r *http.Request, tmeta *TransformSpec, contextVars bool) erro
 		return fmt.Errorf("unsupported request input type: %v", tmeta.TemplateData.Input)
 	}
 
-	if tmeta.TemplateData.EnableSession {
-		session := ctxGetSession(r)
-		bodyData["_tyk_meta"] = session.MetaData
-	}
-
-	if contextVars {
-		bodyData["_tyk_context"] = ctxGetData(r)
-	}
-
 	// Apply to template
 	var bodyBuffer bytes.Buffer
-	if err := tmeta.Template.Execute(&bodyBuffer, bodyData); err != nil {
+	vars := transformVars(r, ctxGetSession(r), tmeta, contextVars)
+	if err := tmeta.apply(&bodyBuffer, bodyData, vars); err != nil {
 		return fmt.Errorf("failed to apply template to request: %v", err)
 	}
 	r.Body = ioutil.NopCloser(&bodyBuffer)
@@ -100,3 +93,34 @@ func transformBody(r *http.Request, tmeta *TransformSpec, contextVars bool) erro
 
 	return nil
 }
+
+// transformVars gathers the context, session and request state data a
+// transform can use, under _tyk_context, _tyk_meta and _tyk_state.
+func transformVars(r *http.Request, session *user.SessionState, tmeta *TransformSpec, contextVars bool) map[string]interface{} {
+	vars := map[string]interface{}{
+		"_tyk_state": ctxGetState(r),
+	}
+	if tmeta.TemplateData.EnableSession && session != nil {
+		vars["_tyk_meta"] = session.MetaData
+	}
+	if contextVars {
+		vars["_tyk_context"] = ctxGetData(r)
+	}
+	return vars
+}
+
+// apply runs the transform on the body data. Templates get the context,
+// session and response data along with the body, while jq expressions get
+// the body as their input and the rest as variables, as in $_tyk_context.
+func (t *TransformSpec) apply(w io.Writer, bodyData, vars map[string]interface{}) error {
+	if t.JQ != nil {
+		return applyJQ(w, t.JQ, bodyData, vars)
+	}
+	if bodyData == nil {
+		bodyData = make(map[string]interface{})
+	}
+	for k, v := range vars {
+		bodyData[k] = v
+	}
+	return t.Template.Execute(w, bodyData)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/base64"
 	"io/ioutil"
 	"strings"
 	"testing"
@@ -68,3 +69,49 @@ func TestTransformJSONMarshal(t *testing.T) {
 		t.Fatalf("wanted body %q, got %q", want, got)
 	}
 }
+
+func TestTransformJQ(t *testing.T) {
+	in := `{"user": {"first": "Foo", "last": "Bar"}, "roles": ["admin", "dev"]}`
+	want := `{"name":"Foo Bar","path":"/users","roles":"admin,dev"}`
+	r := testReq(t, "POST", "/users", in)
+	ctxSetData(r, map[string]interface{}{"path": "/users"})
+	tmeta := &TransformSpec{}
+	tmeta.TemplateData.Input = apidef.RequestJSON
+	code, err := compileJQ(`{name: "\(.user.first) \(.user.last)", roles: (.roles | join(",")), path: $_tyk_context.path}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmeta.JQ = code
+	if err := transformBody(r, tmeta, true); err != nil {
+		t.Fatalf("wanted nil error, got %v", err)
+	}
+	gotBs, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(gotBs); got != want {
+		t.Fatalf("wanted body %q, got %q", want, got)
+	}
+}
+
+func TestTransformJQCompile(t *testing.T) {
+	meta := func(expr string) apidef.TemplateMeta {
+		m := apidef.TemplateMeta{Path: "/foo", Method: "POST"}
+		m.TemplateData.Mode = apidef.UseBlob
+		m.TemplateData.Engine = apidef.JQTemplateEngine
+		m.TemplateData.TemplateSource = base64.StdEncoding.EncodeToString([]byte(expr))
+		return m
+	}
+	loader := APIDefinitionLoader{}
+	specs := loader.compileTransformPathSpec([]apidef.TemplateMeta{
+		meta(`{id: .id}`),
+		meta(`{id: .id`),
+		meta(`$unknown`),
+	}, Transformed)
+	if len(specs) != 1 {
+		t.Fatalf("wanted only the valid expression loaded, got %d", len(specs))
+	}
+	if specs[0].TransformAction.JQ == nil {
+		t.Fatal("wanted the expression compiled")
+	}
+}
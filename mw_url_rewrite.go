@@ -420,6 +420,7 @@ func checkPayload(r *http.Request, options apidef.StringRegexMap, triggernum int
 	if len(b) > 0 {
 		kn := fmt.Sprintf("trigger-%d-payload", triggernum)
 		contextData[kn] = string(b)
+		ctxSetData(r, contextData)
 		return true
 	}
 
@@ -7,8 +7,6 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
-	"os"
-	"strconv"
 	"time"
 
 	"github.com/TykTechnologies/tyk/apidef"
@@ -48,11 +46,11 @@ func (d *VirtualEndpoint) Name() string {
 func preLoadVirtualMetaCode(meta *apidef.VirtualMeta, j *JSVM) {
 	// the only call site uses (&foo, &bar) so meta and j won't be
 	// nil.
-	var src interface{}
+	var src []byte
 	switch meta.FunctionSourceType {
 	case "file":
 		log.Debug("Loading JS Endpoint File: ", meta.FunctionSourceURI)
-		f, err := os.Open(meta.FunctionSourceURI)
+		f, err := ioutil.ReadFile(meta.FunctionSourceURI)
 		if err != nil {
 			log.Error("Failed to open Endpoint JS: ", err)
 			return
@@ -74,7 +72,7 @@ func preLoadVirtualMetaCode(meta *apidef.VirtualMeta, j *JSVM) {
 		log.Error("Type must be either file or blob (base64)!")
 		return
 	}
-	if _, err := j.VM.Run(src); err != nil {
+	if err := j.Load(meta.Path, src); err != nil {
 		log.Error("Could not load virtual endpoint JS: ", err)
 	}
 }
@@ -154,13 +152,11 @@ func (d *VirtualEndpoint) ServeHTTPForCache(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Run the middleware
-	vm := d.Spec.JSVM.VM.Copy()
-	returnRaw, err := vm.Run(vmeta.ResponseFunctionName + `(` + string(requestAsJson) + `, ` + string(sessionAsJson) + `, ` + specAsJson + `);`)
+	returnDataStr, err := d.Spec.JSVM.Run(vmeta.ResponseFunctionName + `(` + string(requestAsJson) + `, ` + string(sessionAsJson) + `, ` + specAsJson + `);`)
 	if err != nil {
 		log.Error("Failed to run virtual endpoint JS code:", err)
 		return nil
 	}
-	returnDataStr, _ := returnRaw.ToString()
 
 	// Decode the return object
 	newResponseData := VMResponseObject{}
@@ -222,7 +218,7 @@ func forceResponse(w http.ResponseWriter,
 	// Clone the response so we can save it
 	copiedRes := copyResponse(newResponse)
 
-	handleForcedResponse(w, newResponse, session)
+	handleForcedResponse(w, newResponse, spec, r)
 
 	// Record analytics
 	return copiedRes
@@ -240,12 +236,12 @@ func (d *VirtualEndpoint) ProcessRequest(w http.ResponseWriter, r *http.Request,
 	return nil, mwStatusRespond
 }
 
-func (d *VirtualEndpoint) HandleResponse(rw http.ResponseWriter, res *http.Response, ses *user.SessionState) {
+func (d *VirtualEndpoint) HandleResponse(rw http.ResponseWriter, res *http.Response, r *http.Request) {
 	// Externalising this from the MW so we can re-use it elsewhere
-	handleForcedResponse(rw, res, ses)
+	handleForcedResponse(rw, res, d.Spec, r)
 }
 
-func handleForcedResponse(rw http.ResponseWriter, res *http.Response, ses *user.SessionState) {
+func handleForcedResponse(rw http.ResponseWriter, res *http.Response, spec *APISpec, r *http.Request) {
 	defer res.Body.Close()
 
 	// Close connections
@@ -254,12 +250,7 @@ func handleForcedResponse(rw http.ResponseWriter, res *http.Response, ses *user.
 	}
 
 	// Add resource headers
-	if ses != nil {
-		// We have found a session, lets report back
-		res.Header.Set("X-RateLimit-Limit", strconv.Itoa(int(ses.QuotaMax)))
-		res.Header.Set("X-RateLimit-Remaining", strconv.Itoa(int(ses.QuotaRemaining)))
-		res.Header.Set("X-RateLimit-Reset", strconv.Itoa(int(ses.QuotaRenews)))
-	}
+	setRateLimitHeaders(spec, res.Header, r)
 
 	copyHeader(rw.Header(), res.Header)
 
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/coprocess"
+	"github.com/TykTechnologies/tyk/wasm"
+)
+
+// WasmMiddleware runs a custom middleware hook exported by a WebAssembly
+// module, for APIs using the wasm driver. Modules run in-process, each
+// call limited by the fuel and memory set in wasm_options.
+//
+// Hooks get and return a coprocess.Object as JSON in the module's memory,
+// so they can do what the other coprocess drivers' hooks do. A module
+// must export its memory, and
+//
+//	tyk_alloc(size i32) i32
+//
+// which returns a buffer for Tyk to write the object to. Each hook is an
+// export named after it,
+//
+//	hook(ptr i32, len i32) i64
+//
+// which returns the object it changed, in its memory, as ptr<<32 | len,
+// or 0 to leave the object as it was. If the module also exports
+// tyk_free(ptr i32, len i32), Tyk calls it with both objects once it's
+// done with them. Modules may import tyk.log(level i32, ptr i32, len i32)
+// to log a message, with levels 0 to 3 being debug, info, warning and
+// error. They can't import anything else, so they have to be built
+// without WASI, such as for wasm32-unknown-unknown in Rust or TinyGo's
+// wasm-unknown target.
+type WasmMiddleware struct {
+	*CoProcessMiddleware
+	Path string
+
+	plugin *wasmPlugin
+	err    error
+}
+
+func (m *WasmMiddleware) Name() string {
+	return "WasmMiddleware"
+}
+
+func (m *WasmMiddleware) EnabledForSpec() bool {
+	if !config.Global.WasmOptions.EnableWasm {
+		log.WithFields(logrus.Fields{
+			"prefix": "wasm",
+		}).Error("Your API specifies a WASM custom middleware, but WASM is not enabled in your Tyk configuration file!")
+		return false
+	}
+	return true
+}
+
+// Init loads the hook's module. If that fails, the hook fails every
+// request rather than letting it through.
+func (m *WasmMiddleware) Init() {
+	m.plugin, m.err = m.Spec.wasmPlugin(customMiddlewarePath(m.Spec, m.Path))
+	if m.err == nil {
+		m.err = m.plugin.checkHook(m.HookName)
+	}
+	if m.err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "wasm",
+		}).Error("Failed to load WASM middleware ", m.HookName, ": ", m.err)
+	}
+}
+
+// ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
+func (m *WasmMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	coProcessor := CoProcessor{Middleware: m.CoProcessMiddleware}
+	object := coProcessor.ObjectFromRequest(r)
+
+	err := m.err
+	var returnObject *coprocess.Object
+	if err == nil {
+		returnObject, err = m.plugin.call(m.HookName, object)
+	}
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "wasm",
+		}).Error("Failed to run WASM middleware ", m.HookName, ": ", err)
+		if m.HookType == coprocess.HookType_CustomKeyCheck {
+			return errors.New("Key not authorised"), 403
+		}
+		if m.FailOpen {
+			r.Body = ioutil.NopCloser(strings.NewReader(object.Request.Body))
+			return nil, 200
+		}
+		return errors.New("Middleware error"), 500
+	}
+
+	coProcessor.ObjectPostProcess(returnObject, r)
+
+	token := returnObject.Metadata["token"]
+	overrides := returnObject.Request.ReturnOverrides
+
+	// The hook indicates this is a bad auth:
+	if overrides.ResponseCode > 400 {
+		logEntry := getLogEntryForRequest(r, token, nil)
+		logEntry.Info("Attempted access with invalid key.")
+
+		AuthFailed(m, r, token)
+		reportHealthValue(m.Spec, KeyFailure, "1")
+
+		errorMsg := "Key not authorised"
+		if overrides.ResponseError != "" {
+			errorMsg = overrides.ResponseError
+		}
+		return errors.New(errorMsg), int(overrides.ResponseCode)
+	}
+
+	if overrides.ResponseCode > 0 {
+		for h, v := range overrides.Headers {
+			w.Header().Set(h, v)
+		}
+		w.WriteHeader(int(overrides.ResponseCode))
+		w.Write([]byte(overrides.ResponseError))
+		return nil, mwStatusRespond
+	}
+
+	if m.Spec.EnableCoProcessAuth && m.HookType == coprocess.HookType_CustomKeyCheck {
+		if returnObject.Session == nil {
+			AuthFailed(m, r, r.Header.Get(m.Spec.Auth.AuthHeaderName))
+			return errors.New("Key not authorised"), 403
+		}
+		session := TykSessionState(returnObject.Session)
+		m.Spec.SessionManager.UpdateSession(token, session, session.Lifetime(m.Spec.SessionLifetime))
+		ctxSetSession(r, session)
+		ctxSetAuthToken(r, token)
+	}
+
+	return nil, 200
+}
+
+// wasmPlugin is a compiled module with a pool of instances. Instances
+// are reused between calls, so a module may keep state in its memory,
+// but one whose call failed is thrown away.
+type wasmPlugin struct {
+	path   string
+	module *wasm.Module
+	conf   wasm.Config
+	free   bool
+	idle   chan *wasm.Instance
+}
+
+var (
+	wasmBufferType = wasm.FuncType{Params: []wasm.ValueType{wasm.I32, wasm.I32}}
+	wasmAllocType  = wasm.FuncType{Params: []wasm.ValueType{wasm.I32}, Results: []wasm.ValueType{wasm.I32}}
+	wasmHookType   = wasm.FuncType{Params: []wasm.ValueType{wasm.I32, wasm.I32}, Results: []wasm.ValueType{wasm.I64}}
+	wasmLogType    = wasm.FuncType{Params: []wasm.ValueType{wasm.I32, wasm.I32, wasm.I32}}
+)
+
+func (s *APISpec) wasmPlugin(path string) (*wasmPlugin, error) {
+	if p := s.wasmPlugins[path]; p != nil {
+		return p, nil
+	}
+	p, err := loadWasmPlugin(path, config.Global.WasmOptions)
+	if err != nil {
+		return nil, err
+	}
+	if s.wasmPlugins == nil {
+		s.wasmPlugins = make(map[string]*wasmPlugin)
+	}
+	s.wasmPlugins[path] = p
+	return p, nil
+}
+
+func loadWasmPlugin(path string, opts config.WasmOptionsConfig) (*wasmPlugin, error) {
+	log.WithFields(logrus.Fields{
+		"prefix": "wasm",
+	}).Info("Loading WASM module: ", path)
+	bin, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	module, err := wasm.Compile(bin)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := module.ExportType("tyk_alloc"); !ok || !t.Equal(wasmAllocType) {
+		return nil, errors.New("module doesn't export tyk_alloc(i32) i32")
+	}
+	p := &wasmPlugin{path: path, module: module}
+	if t, ok := module.ExportType("tyk_free"); ok {
+		if !t.Equal(wasmBufferType) {
+			return nil, errors.New("module's tyk_free isn't tyk_free(i32, i32)")
+		}
+		p.free = true
+	}
+
+	maxMemoryMB := opts.MaxMemoryMB
+	if maxMemoryMB <= 0 {
+		maxMemoryMB = 16
+	}
+	p.conf.MaxMemoryPages = uint32(maxMemoryMB) << 4
+	switch {
+	case opts.Fuel == 0:
+		p.conf.Fuel = 50000000
+	case opts.Fuel > 0:
+		p.conf.Fuel = opts.Fuel
+	}
+	size := opts.PoolSize
+	if size <= 0 {
+		size = runtime.NumCPU()
+	}
+	p.idle = make(chan *wasm.Instance, size)
+
+	// instantiate it straight away, so that missing imports and
+	// failing start functions show up when loading
+	in, err := p.instantiate()
+	if err != nil {
+		return nil, err
+	}
+	p.idle <- in
+	return p, nil
+}
+
+func (p *wasmPlugin) checkHook(name string) error {
+	t, ok := p.module.ExportType(name)
+	if !ok {
+		return fmt.Errorf("module doesn't export %s", name)
+	}
+	if !t.Equal(wasmHookType) {
+		return fmt.Errorf("%s should be %s(i32, i32) i64", name, name)
+	}
+	return nil
+}
+
+func (p *wasmPlugin) instantiate() (*wasm.Instance, error) {
+	imports := map[string]map[string]wasm.HostFunc{
+		"tyk": {"log": {Type: wasmLogType, Func: p.log}},
+	}
+	return p.module.Instantiate(imports, p.conf)
+}
+
+func (p *wasmPlugin) log(in *wasm.Instance, args []uint64) ([]uint64, error) {
+	msg, err := wasmBuffer(in, uint32(args[1]), uint32(args[2]))
+	if err != nil {
+		return nil, err
+	}
+	logger := log.WithFields(logrus.Fields{
+		"prefix": "wasm",
+		"module": p.path,
+	})
+	switch args[0] {
+	case 0:
+		logger.Debug(string(msg))
+	case 1:
+		logger.Info(string(msg))
+	case 2:
+		logger.Warning(string(msg))
+	default:
+		logger.Error(string(msg))
+	}
+	return nil, nil
+}
+
+func wasmBuffer(in *wasm.Instance, ptr, size uint32) ([]byte, error) {
+	mem := in.Memory()
+	if uint64(ptr)+uint64(size) > uint64(len(mem)) {
+		return nil, fmt.Errorf("buffer at %d of %d bytes is out of memory bounds", ptr, size)
+	}
+	return mem[ptr : ptr+size], nil
+}
+
+// call runs a hook on an instance from the pool.
+func (p *wasmPlugin) call(hook string, object *coprocess.Object) (*coprocess.Object, error) {
+	var in *wasm.Instance
+	select {
+	case in = <-p.idle:
+	default:
+		var err error
+		if in, err = p.instantiate(); err != nil {
+			return nil, err
+		}
+	}
+	returnObject, err := p.callOn(in, hook, object)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case p.idle <- in:
+	default:
+	}
+	return returnObject, nil
+}
+
+func (p *wasmPlugin) callOn(in *wasm.Instance, hook string, object *coprocess.Object) (*coprocess.Object, error) {
+	input, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	res, err := in.Call("tyk_alloc", uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(res[0])
+	buf, err := wasmBuffer(in, ptr, uint32(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("tyk_alloc returned a bad buffer: %v", err)
+	}
+	copy(buf, input)
+
+	if res, err = in.Call(hook, uint64(ptr), uint64(len(input))); err != nil {
+		return nil, err
+	}
+	returnObject := object
+	if res[0] != 0 {
+		outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+		out, err := wasmBuffer(in, outPtr, outLen)
+		if err != nil {
+			return nil, fmt.Errorf("%s returned a bad buffer: %v", hook, err)
+		}
+		returnObject = new(coprocess.Object)
+		if err := json.Unmarshal(out, returnObject); err != nil {
+			return nil, fmt.Errorf("%s returned a bad object: %v", hook, err)
+		}
+		if returnObject.Request == nil {
+			return nil, fmt.Errorf("%s returned an object without a request", hook)
+		}
+		if returnObject.Request.ReturnOverrides == nil {
+			returnObject.Request.ReturnOverrides = &coprocess.ReturnOverrides{ResponseCode: -1}
+		}
+		if p.free {
+			if _, err := in.Call("tyk_free", uint64(outPtr), uint64(outLen)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.free {
+		if _, err := in.Call("tyk_free", uint64(ptr), uint64(len(input))); err != nil {
+			return nil, err
+		}
+	}
+	return returnObject, nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/coprocess"
+)
+
+func wasmULEB(v uint64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+func wasmSLEB(v int64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func wasmVec(n int, items ...[]byte) []byte {
+	b := wasmULEB(uint64(n))
+	for _, it := range items {
+		b = append(b, it...)
+	}
+	return b
+}
+
+func wasmName(s string) []byte {
+	return append(wasmULEB(uint64(len(s))), s...)
+}
+
+const wasmDataOffset = 1024
+
+// buildWasmPlugin assembles a module with the plugin ABI: it imports
+// tyk.log, exports two pages of memory with data at wasmDataOffset,
+// tyk_alloc, which always returns the second page, a no-op tyk_free,
+// and the given hooks.
+func buildWasmPlugin(data []byte, hooks map[string][]byte) []byte {
+	var names []string
+	for name := range hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	section := func(id byte, body []byte) []byte {
+		return append(append([]byte{id}, wasmULEB(uint64(len(body)))...), body...)
+	}
+	body := func(code ...byte) []byte {
+		b := append([]byte{0}, code...) // no locals
+		b = append(b, 0x0b)
+		return append(wasmULEB(uint64(len(b))), b...)
+	}
+	const i32, i64 = 0x7f, 0x7e
+
+	mod := []byte("\x00asm\x01\x00\x00\x00")
+	mod = append(mod, section(1, wasmVec(4,
+		[]byte{0x60, 1, i32, 1, i32},      // 0: tyk_alloc
+		[]byte{0x60, 2, i32, i32, 1, i64}, // 1: hooks
+		[]byte{0x60, 3, i32, i32, i32, 0}, // 2: tyk.log
+		[]byte{0x60, 2, i32, i32, 0},      // 3: tyk_free
+	))...)
+	mod = append(mod, section(2, wasmVec(1, wasmName("tyk"), wasmName("log"), []byte{0, 2}))...)
+	funcs := [][]byte{{0}, {3}}
+	exports := [][]byte{
+		append(wasmName("memory"), 2, 0),
+		append(wasmName("tyk_alloc"), 0, 1),
+		append(wasmName("tyk_free"), 0, 2),
+	}
+	codes := [][]byte{
+		body(append([]byte{0x41}, wasmSLEB(65536)...)...),
+		body(),
+	}
+	for i, name := range names {
+		funcs = append(funcs, []byte{1})
+		exports = append(exports, append(wasmName(name), 0, byte(3+i)))
+		codes = append(codes, body(hooks[name]...))
+	}
+	mod = append(mod, section(3, wasmVec(len(funcs), funcs...))...)
+	mod = append(mod, section(5, wasmVec(1, []byte{0, 2}))...)
+	mod = append(mod, section(7, wasmVec(len(exports), exports...))...)
+	mod = append(mod, section(10, wasmVec(len(codes), codes...))...)
+	offset := append([]byte{0, 0x41}, wasmSLEB(wasmDataOffset)...)
+	offset = append(offset, 0x0b)
+	mod = append(mod, section(11, wasmVec(1, offset, wasmULEB(uint64(len(data))), data))...)
+	return mod
+}
+
+// wasmReturn is a hook body returning the object at off in the data.
+func wasmReturn(off, size int) []byte {
+	return append([]byte{0x42}, wasmSLEB(int64(wasmDataOffset+off)<<32|int64(size))...)
+}
+
+func testWasmPlugin(t *testing.T) (path string, cleanup func()) {
+	authObject := `{"request":{"set_headers":{"X-Wasm":"yes"},"url":"/wasm/"},` +
+		`"metadata":{"token":"wasm-key"},"session":{"rate":1000,"per":1,"quota_max":-1}}`
+	respondObject := `{"request":{"return_overrides":{"response_code":202,` +
+		`"response_error":"handled","headers":{"X-Wasm":"responded"}}}}`
+	data := []byte(authObject + respondObject)
+	hooks := map[string][]byte{
+		"SetHeader": append(
+			// tyk.log(1, data, 9)
+			[]byte{0x41, 1, 0x41}, append(wasmSLEB(wasmDataOffset), append([]byte{0x41, 9, 0x10, 0},
+				wasmReturn(0, len(authObject))...)...)...),
+		"Respond":     wasmReturn(len(authObject), len(respondObject)),
+		"Passthrough": {0x42, 0},
+		// loop forever
+		"Spin": {0x03, 0x40, 0x0c, 0, 0x0b, 0x42, 0},
+		// grow memory until that fails, then trap like
+		// allocators do
+		"Grow": {0x03, 0x40, 0x41, 1, 0x40, 0, 0x41, 0x7f, 0x47, 0x0d, 0, 0x0b, 0x00},
+	}
+	dir, err := ioutil.TempDir("", "tyk-wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "plugin.wasm")
+	if err := ioutil.WriteFile(path, buildWasmPlugin(data, hooks), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := config.Global.WasmOptions
+	config.Global.WasmOptions = config.WasmOptionsConfig{
+		EnableWasm:  true,
+		MaxMemoryMB: 1,
+		Fuel:        100000,
+		PoolSize:    2,
+	}
+	return path, func() {
+		config.Global.WasmOptions = old
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWasmMiddleware(t *testing.T) {
+	path, cleanup := testWasmPlugin(t)
+	defer cleanup()
+
+	spec := &APISpec{APIDefinition: &apidef.APIDefinition{}}
+	newMW := func(hook string) *WasmMiddleware {
+		mw := pluginMiddleware(BaseMiddleware{Spec: spec}, coprocess.HookType_Pre,
+			apidef.MiddlewareDefinition{Name: hook, Path: path}, apidef.WasmDriver).(*WasmMiddleware)
+		mw.Init()
+		return mw
+	}
+
+	tests := []struct {
+		hook       string
+		wantCode   int
+		wantURL    string
+		wantHeader string
+	}{
+		{"SetHeader", 200, "/wasm/", "yes"},
+		{"Passthrough", 200, "/foo", ""},
+		{"Respond", mwStatusRespond, "/foo", "responded"},
+		{"Spin", 500, "/foo", ""},
+		{"Grow", 500, "/foo", ""},
+		{"Missing", 500, "/foo", ""},
+		// an instance is usable after another one failed
+		{"SetHeader", 200, "/wasm/", "yes"},
+	}
+	for _, tc := range tests {
+		mw := newMW(tc.hook)
+		r := httptest.NewRequest("POST", "/foo", strings.NewReader("body"))
+		w := httptest.NewRecorder()
+		_, code := mw.ProcessRequest(w, r, nil)
+		if code != tc.wantCode {
+			t.Errorf("%s: wanted code %d, got %d", tc.hook, tc.wantCode, code)
+			continue
+		}
+		if code != 200 {
+			if code == mwStatusRespond && w.Header().Get("X-Wasm") != tc.wantHeader {
+				t.Errorf("%s: wanted response header %q, got %q", tc.hook, tc.wantHeader, w.Header().Get("X-Wasm"))
+			}
+			continue
+		}
+		if r.URL.Path != tc.wantURL {
+			t.Errorf("%s: wanted URL %q, got %q", tc.hook, tc.wantURL, r.URL.Path)
+		}
+		if got := r.Header.Get("X-Wasm"); got != tc.wantHeader {
+			t.Errorf("%s: wanted header %q, got %q", tc.hook, tc.wantHeader, got)
+		}
+	}
+	if len(spec.wasmPlugins) != 1 {
+		t.Fatalf("wanted the module to be loaded once, got %d", len(spec.wasmPlugins))
+	}
+}
+
+func TestWasmMiddlewareAuth(t *testing.T) {
+	path, cleanup := testWasmPlugin(t)
+	defer cleanup()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"path":   r.URL.Path,
+			"header": r.Header.Get("X-Wasm"),
+		})
+	}))
+	defer upstream.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen(ln, nil, nil)
+	defer ln.Close()
+	baseURL := "http://" + ln.Addr().String()
+
+	buildAndLoadAPI(func(spec *APISpec) {
+		spec.APIID = "wasm-auth"
+		spec.UseKeylessAccess = false
+		spec.EnableCoProcessAuth = true
+		spec.Proxy.ListenPath = "/wasm-auth/"
+		spec.Proxy.TargetURL = upstream.URL
+		spec.CustomMiddleware = apidef.MiddlewareSection{
+			Driver:    apidef.WasmDriver,
+			AuthCheck: apidef.MiddlewareDefinition{Name: "SetHeader", Path: path},
+			Pre:       []apidef.MiddlewareDefinition{{Name: "Passthrough", Path: path}},
+		}
+	}, func(spec *APISpec) {
+		spec.APIID = "wasm-spin"
+		spec.UseKeylessAccess = false
+		spec.EnableCoProcessAuth = true
+		spec.Proxy.ListenPath = "/wasm-spin/"
+		spec.Proxy.TargetURL = upstream.URL
+		spec.CustomMiddleware = apidef.MiddlewareSection{
+			Driver:    apidef.WasmDriver,
+			AuthCheck: apidef.MiddlewareDefinition{Name: "Spin", Path: path},
+		}
+	})
+
+	resp, err := http.Get(baseURL + "/wasm-auth/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("wanted the hook's session to authorise the request, got %d", resp.StatusCode)
+	}
+	if got["header"] != "yes" || got["path"] != "/wasm/" {
+		t.Fatalf("wanted the hook's changes upstream, got %v", got)
+	}
+
+	resp, err = http.Get(baseURL + "/wasm-spin/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 403 {
+		t.Fatalf("wanted a failing auth hook to deny, got %d", resp.StatusCode)
+	}
+}
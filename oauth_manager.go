@@ -10,7 +10,6 @@ import (
 
 	osin "github.com/lonelycode/osin"
 	"github.com/satori/go.uuid"
-	"golang.org/x/crypto/bcrypt"
 
 	"github.com/TykTechnologies/tyk/config"
 	"github.com/TykTechnologies/tyk/storage"
@@ -257,19 +256,7 @@ func (o *OAuthManager) HandleAccess(r *http.Request) *osin.Response {
 			if err != nil {
 				log.Warning("Attempted access with non-existent user (OAuth password flow).")
 			} else {
-				var passMatch bool
-				if session.BasicAuthData.Hash == user.HashBCrypt {
-					err := bcrypt.CompareHashAndPassword([]byte(session.BasicAuthData.Password), []byte(password))
-					if err == nil {
-						passMatch = true
-					}
-				}
-
-				if session.BasicAuthData.Hash == user.HashPlainText &&
-					session.BasicAuthData.Password == password {
-					passMatch = true
-				}
-
+				passMatch := user.CheckPassword(session.BasicAuthData.Hash, session.BasicAuthData.Password, password)
 				if passMatch {
 					log.Info("Here we are")
 					ar.Authorized = true
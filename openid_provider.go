@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	cache "github.com/pmylund/go-cache"
+	"github.com/square/go-jose"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const (
+	oidcWellKnownPath = "/.well-known/openid-configuration"
+
+	// don't hammer the JWKS endpoint when clients send tokens
+	// signed with unknown keys
+	oidcKeyRefreshInterval = time.Minute
+)
+
+// OIDCProviderCache holds the discovered providers, keyed by issuer,
+// so that metadata is refreshed periodically.
+var OIDCProviderCache = cache.New(time.Hour, 10*time.Minute)
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcProviderMeta is the subset of the OpenID Provider Metadata
+// served on .well-known/openid-configuration that we use.
+type oidcProviderMeta struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider is a discovered OpenID Connect issuer along with its
+// signing keys.
+type oidcProvider struct {
+	oidcProviderMeta
+
+	mu          sync.RWMutex
+	keys        jose.JsonWebKeySet
+	keysFetched time.Time
+}
+
+func oidcGetJSON(url string, v interface{}) error {
+	resp, err := oidcHTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// getOIDCProvider returns the provider for an issuer, running
+// discovery against the issuer URL if it isn't cached.
+func getOIDCProvider(issuer string) (*oidcProvider, error) {
+	if cached, found := OIDCProviderCache.Get(issuer); found {
+		return cached.(*oidcProvider), nil
+	}
+
+	// Workaround for tokens issued by google
+	discoveryURL := issuer
+	if !httpScheme.MatchString(discoveryURL) {
+		discoveryURL = "https://" + discoveryURL
+	}
+	discoveryURL = strings.TrimSuffix(discoveryURL, "/") + oidcWellKnownPath
+
+	log.WithField("prefix", OIDPREFIX).Debug("Discovering provider: ", discoveryURL)
+	p := &oidcProvider{}
+	if err := oidcGetJSON(discoveryURL, &p.oidcProviderMeta); err != nil {
+		return nil, fmt.Errorf("discovery failed: %v", err)
+	}
+	if !sameIssuer(p.Issuer, issuer) {
+		return nil, fmt.Errorf("discovered issuer %q does not match %q", p.Issuer, issuer)
+	}
+	if p.JWKSURI == "" {
+		return nil, errors.New("discovery document has no jwks_uri")
+	}
+	if err := p.refreshKeys(); err != nil {
+		return nil, err
+	}
+
+	OIDCProviderCache.Set(issuer, p, cache.DefaultExpiration)
+	return p, nil
+}
+
+func sameIssuer(a, b string) bool {
+	trim := func(s string) string {
+		s = strings.TrimPrefix(s, "https://")
+		return strings.TrimSuffix(s, "/")
+	}
+	return trim(a) == trim(b)
+}
+
+func (p *oidcProvider) refreshKeys() error {
+	var keys jose.JsonWebKeySet
+	if err := oidcGetJSON(p.JWKSURI, &keys); err != nil {
+		return fmt.Errorf("fetching JWKS failed: %v", err)
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetched = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *oidcProvider) findKey(kid string) interface{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if kid == "" {
+		if len(p.keys.Keys) == 1 {
+			return p.keys.Keys[0].Key
+		}
+		return nil
+	}
+	if keys := p.keys.Key(kid); len(keys) > 0 {
+		return keys[0].Key
+	}
+	return nil
+}
+
+// signingKey returns the public key with the given ID, refetching the
+// key set once if the key is unknown, to pick up key rotations.
+func (p *oidcProvider) signingKey(kid string) (interface{}, error) {
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+	p.mu.RLock()
+	stale := time.Since(p.keysFetched) > oidcKeyRefreshInterval
+	p.mu.RUnlock()
+	if stale {
+		if err := p.refreshKeys(); err != nil {
+			return nil, err
+		}
+		if key := p.findKey(kid); key != nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("no signing key found with kid %q", kid)
+}
+
+// validateOIDCToken verifies the signature and standard claims of an
+// ID token issued by one of the configured providers, returning the
+// token and the matching provider config.
+func validateOIDCToken(raw string, providers []apidef.OIDProviderConfig) (*jwt.Token, *apidef.OIDProviderConfig, error) {
+	var conf *apidef.OIDProviderConfig
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		iss, _ := token.Claims.(jwt.MapClaims)["iss"].(string)
+		for i := range providers {
+			if sameIssuer(providers[i].Issuer, iss) {
+				conf = &providers[i]
+				break
+			}
+		}
+		if conf == nil {
+			return nil, fmt.Errorf("issuer %q is not configured", iss)
+		}
+		provider, err := getOIDCProvider(conf.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, err := provider.signingKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+			return key, nil
+		}
+		return nil, errors.New("signing key is not a public RSA or ECDSA key")
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, nil, errors.New("token has no subject")
+	}
+	// jwt-go only checks exp if it's there, but ID tokens must have it
+	if _, ok := claims["exp"].(float64); !ok {
+		return nil, nil, errors.New("token has no expiry")
+	}
+	return token, conf, nil
+}
+
+// tokenAudiences returns the aud claim, which may be a single string
+// or a list of them.
+func tokenAudiences(token *jwt.Token) []string {
+	switch v := token.Claims.(jwt.MapClaims)["aud"].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		auds := make([]string, 0, len(v))
+		for _, aud := range v {
+			if s, ok := aud.(string); ok {
+				auds = append(auds, s)
+			}
+		}
+		return auds
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const (
+	oidcCallbackPath      = "tyk/oidc/callback"
+	oidcDefaultCookieName = "tyk_oidc_session"
+	oidcStateLifetime     = 10 * time.Minute
+)
+
+// oidcRelyingParty runs the authorization code flow on behalf of
+// browser clients, keeping the resulting ID token in an encrypted
+// cookie so that no server side state is needed.
+type oidcRelyingParty struct {
+	Spec *APISpec
+	conf apidef.OIDRelyingPartyConfig
+	aead cipher.AEAD
+}
+
+// oidcLoginState is carried, encrypted, in the state parameter of the
+// authorization request.
+type oidcLoginState struct {
+	ReturnTo string `json:"return_to"`
+	Nonce    string `json:"nonce"`
+	Expires  int64  `json:"exp"`
+}
+
+func newOIDCRelyingParty(spec *APISpec) (*oidcRelyingParty, error) {
+	conf := spec.OpenIDOptions.RelyingParty
+	if conf.ClientID == "" {
+		return nil, errors.New("relying party client_id is required")
+	}
+	if conf.CookieSecret == "" {
+		return nil, errors.New("relying party cookie_secret is required")
+	}
+	if conf.CookieName == "" {
+		conf.CookieName = oidcDefaultCookieName
+	}
+	found := false
+	for _, p := range spec.OpenIDOptions.Providers {
+		if sameIssuer(p.Issuer, conf.Issuer) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("relying party issuer %q is not one of the providers", conf.Issuer)
+	}
+	key := sha256.Sum256([]byte(conf.CookieSecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &oidcRelyingParty{Spec: spec, conf: conf, aead: aead}, nil
+}
+
+func addOpenIDCallbackHandler(spec *APISpec, muxer *mux.Router) *oidcRelyingParty {
+	rp, err := newOIDCRelyingParty(spec)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix":   OIDPREFIX,
+			"api_name": spec.Name,
+		}).Error("Relying party mode disabled: ", err)
+		return nil
+	}
+	muxer.Handle(spec.Proxy.ListenPath+oidcCallbackPath, rp)
+	return rp
+}
+
+func (rp *oidcRelyingParty) seal(plain []byte) (string, error) {
+	nonce := make([]byte, rp.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := rp.aead.Seal(nonce, nonce, plain, nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (rp *oidcRelyingParty) open(s string) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	size := rp.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, errors.New("sealed value too short")
+	}
+	return rp.aead.Open(nil, sealed[:size], sealed[size:], nil)
+}
+
+func (rp *oidcRelyingParty) redirectURL(r *http.Request) string {
+	if rp.conf.RedirectURL != "" {
+		return rp.conf.RedirectURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + rp.Spec.Proxy.ListenPath + oidcCallbackPath
+}
+
+// isBrowserRequest reports whether a request can be sent through an
+// interactive login, rather than just being rejected.
+func isBrowserRequest(r *http.Request) bool {
+	return r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// sessionToken returns the ID token stored in the session cookie, if
+// there is a valid one.
+func (rp *oidcRelyingParty) sessionToken(r *http.Request) string {
+	cookie, err := r.Cookie(rp.conf.CookieName)
+	if err != nil {
+		return ""
+	}
+	raw, err := rp.open(cookie.Value)
+	if err != nil {
+		log.WithField("prefix", OIDPREFIX).Debug("Could not decrypt session cookie: ", err)
+		return ""
+	}
+	return string(raw)
+}
+
+func (rp *oidcRelyingParty) setSessionCookie(w http.ResponseWriter, r *http.Request, rawToken string, expires time.Time) error {
+	value, err := rp.seal([]byte(rawToken))
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     rp.conf.CookieName,
+		Value:    value,
+		Path:     rp.Spec.Proxy.ListenPath,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	})
+	return nil
+}
+
+// stateCookieName is the cookie that ties a login to the browser that
+// started it, so that a callback URL from someone else's login is
+// refused.
+func (rp *oidcRelyingParty) stateCookieName() string {
+	return rp.conf.CookieName + "_state"
+}
+
+func (rp *oidcRelyingParty) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   rp.conf.CookieName,
+		Path:   rp.Spec.Proxy.ListenPath,
+		MaxAge: -1,
+	})
+}
+
+// redirectToLogin sends the client to the provider's authorization
+// endpoint, remembering where to send it back to afterwards.
+func (rp *oidcRelyingParty) redirectToLogin(w http.ResponseWriter, r *http.Request) error {
+	provider, err := getOIDCProvider(rp.conf.Issuer)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	state := oidcLoginState{
+		ReturnTo: r.URL.RequestURI(),
+		Nonce:    hex.EncodeToString(nonce),
+		Expires:  time.Now().Add(oidcStateLifetime).Unix(),
+	}
+	stateJSON, _ := json.Marshal(state)
+	sealedState, err := rp.seal(stateJSON)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     rp.stateCookieName(),
+		Value:    state.Nonce,
+		Path:     rp.Spec.Proxy.ListenPath,
+		MaxAge:   int(oidcStateLifetime / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	scopes := rp.conf.Scopes
+	hasOpenID := false
+	for _, scope := range scopes {
+		if scope == "openid" {
+			hasOpenID = true
+		}
+	}
+	if !hasOpenID {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", rp.conf.ClientID)
+	q.Set("redirect_uri", rp.redirectURL(r))
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", sealedState)
+	q.Set("nonce", state.Nonce)
+
+	target := provider.AuthorizationEndpoint
+	if strings.Contains(target, "?") {
+		target += "&" + q.Encode()
+	} else {
+		target += "?" + q.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+	return nil
+}
+
+func (rp *oidcRelyingParty) exchangeCode(provider *oidcProvider, code, redirectURL string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURL)
+	req, err := http.NewRequest("POST", provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(rp.conf.ClientID), url.QueryEscape(rp.conf.ClientSecret))
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return "", err
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return tokens.IDToken, nil
+}
+
+// ServeHTTP handles the redirect back from the provider, exchanging
+// the code for an ID token and storing it in the session cookie.
+func (rp *oidcRelyingParty) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(logrus.Fields{
+		"prefix":   OIDPREFIX,
+		"api_name": rp.Spec.Name,
+	})
+	q := r.URL.Query()
+	if errCode := q.Get("error"); errCode != "" {
+		logger.Warning("Provider returned login error: ", errCode)
+		doJSONWrite(w, 401, apiError("Login failed"))
+		return
+	}
+
+	var state oidcLoginState
+	stateJSON, err := rp.open(q.Get("state"))
+	if err == nil {
+		err = json.Unmarshal(stateJSON, &state)
+	}
+	if err != nil || time.Now().Unix() > state.Expires {
+		logger.Warning("Invalid or expired login state")
+		doJSONWrite(w, 400, apiError("Invalid login state"))
+		return
+	}
+	cookie, err := r.Cookie(rp.stateCookieName())
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state.Nonce)) != 1 {
+		logger.Warning("Login state was not issued to this browser")
+		doJSONWrite(w, 400, apiError("Invalid login state"))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   rp.stateCookieName(),
+		Path:   rp.Spec.Proxy.ListenPath,
+		MaxAge: -1,
+	})
+
+	provider, err := getOIDCProvider(rp.conf.Issuer)
+	if err != nil {
+		logger.Error("Provider discovery failed: ", err)
+		doJSONWrite(w, 502, apiError("Identity provider unavailable"))
+		return
+	}
+	rawToken, err := rp.exchangeCode(provider, q.Get("code"), rp.redirectURL(r))
+	if err != nil {
+		logger.Error("Code exchange failed: ", err)
+		doJSONWrite(w, 502, apiError("Login failed"))
+		return
+	}
+	token, _, err := validateOIDCToken(rawToken, rp.Spec.OpenIDOptions.Providers)
+	if err == nil {
+		err = rp.checkToken(token)
+	}
+	if err == nil {
+		if nonce, _ := token.Claims.(jwt.MapClaims)["nonce"].(string); nonce != state.Nonce {
+			err = errors.New("nonce mismatch")
+		}
+	}
+	if err != nil {
+		logger.Warning("Received invalid ID token: ", err)
+		doJSONWrite(w, 401, apiError("Login failed"))
+		return
+	}
+
+	// validated tokens always have an expiry
+	exp, _ := token.Claims.(jwt.MapClaims)["exp"].(float64)
+	expires := time.Unix(int64(exp), 0)
+	if err := rp.setSessionCookie(w, r, rawToken, expires); err != nil {
+		logger.Error("Could not set session cookie: ", err)
+		doJSONWrite(w, 500, apiError("Login failed"))
+		return
+	}
+	http.Redirect(w, r, state.ReturnTo, http.StatusFound)
+}
+
+// checkToken ensures an ID token was issued to this relying party.
+func (rp *oidcRelyingParty) checkToken(token *jwt.Token) error {
+	for _, aud := range tokenAudiences(token) {
+		if aud == rp.conf.ClientID {
+			return nil
+		}
+	}
+	return errors.New("token was not issued to this client")
+}
@@ -82,6 +82,26 @@ func TestApplyPolicies(t *testing.T) {
 			Partitions:   user.PolicyPartitions{Acl: true},
 			AccessRights: map[string]user.AccessDefinition{"b": {}},
 		},
+		"acl3": {
+			Partitions: user.PolicyPartitions{Acl: true},
+			AccessRights: map[string]user.AccessDefinition{"a": {
+				Versions: []string{"v1"},
+				AllowedURLs: []user.AccessSpec{
+					{URL: "/reports", Methods: []string{"GET"}, Rate: 1, Per: 1, QuotaMax: 10, QuotaRenewalRate: 60},
+					{URL: "/status", Methods: []string{"GET"}},
+				},
+			}},
+		},
+		"acl4": {
+			Partitions: user.PolicyPartitions{Acl: true},
+			AccessRights: map[string]user.AccessDefinition{"a": {
+				Versions: []string{"v2"},
+				AllowedURLs: []user.AccessSpec{
+					{URL: "/reports", Methods: []string{"GET"}, Rate: 5, Per: 1},
+					{URL: "/admin", Methods: []string{"POST"}, Rate: 1, Per: 10},
+				},
+			}},
+		},
 	}
 	policiesMu.RUnlock()
 	bmid := &BaseMiddleware{Spec: &APISpec{
@@ -186,6 +206,22 @@ func TestApplyPolicies(t *testing.T) {
 				}
 			},
 		},
+		{
+			"AclPartEndpointLimits", []string{"acl3", "acl4"},
+			"", func(t *testing.T, s *user.SessionState) {
+				want := map[string]user.AccessDefinition{"a": {
+					Versions: []string{"v1", "v2"},
+					AllowedURLs: []user.AccessSpec{
+						{URL: "/reports", Methods: []string{"GET"}, Rate: 5, Per: 1},
+						{URL: "/status", Methods: []string{"GET"}},
+						{URL: "/admin", Methods: []string{"POST"}, Rate: 1, Per: 10},
+					},
+				}}
+				if !reflect.DeepEqual(want, s.AccessRights) {
+					t.Fatalf("want %v got %v", want, s.AccessRights)
+				}
+			},
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
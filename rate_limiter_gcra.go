@@ -0,0 +1,169 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	cache "github.com/pmylund/go-cache"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/storage"
+)
+
+// gcraKeySuffix keeps GCRA state apart from the rolling window sets,
+// so that an API can switch limiters without clashing key types.
+const gcraKeySuffix = "-gcra"
+
+const hybridDefaultSyncInterval = 100 * time.Millisecond
+
+// gcraStorage is implemented by the stores that can run the GCRA limiter.
+type gcraStorage interface {
+	GCRA(keyName string, rate, per float64, quantity int64, force bool) (storage.GCRAResult, error)
+}
+
+// hybridBuckets holds the node-local GCRA state of the hybrid limiter,
+// by rate limiter key.
+var hybridBuckets = cache.New(5*time.Minute, 10*time.Minute)
+
+var hybridBucketsMu sync.Mutex
+
+// hybridBucket is a local copy of a key's GCRA state. Requests are let
+// through against it, and counted in Redis in batches as it syncs.
+type hybridBucket struct {
+	mu sync.Mutex
+	// tat is the theoretical arrival time as last synced, plus the
+	// requests let through since.
+	tat     time.Time
+	pending int64
+	synced  time.Time
+	syncing bool
+}
+
+func gcraIntervals(rate, per float64) (emission, tolerance time.Duration) {
+	emission = time.Duration(per / rate * float64(time.Second))
+	return emission, time.Duration(rate * float64(emission))
+}
+
+// gcraExceeded counts a request of the given cost in Redis with the GCRA
+// limiter. If force is set, it is counted even if it goes over the limit.
+func (l *SessionLimiter) gcraExceeded(key string, rate, per float64, cost int64, force bool, store gcraStorage) (bool, *rateLimitState) {
+	if rate <= 0 {
+		return true, nil
+	}
+	if per <= 0 {
+		return false, nil
+	}
+	res, err := store.GCRA(key, rate, per, cost, force)
+	if err != nil {
+		// let the request through, as the rolling window does
+		return false, nil
+	}
+	now := time.Now()
+	state := &rateLimitState{
+		limit:     int64(rate),
+		remaining: res.Remaining,
+		reset:     now.Add(res.ResetAfter),
+	}
+	if !res.Allowed {
+		state.reset = now.Add(res.RetryAfter)
+		return true, state
+	}
+	return false, state
+}
+
+// hybridGCRAExceeded lets a request through against the local copy of
+// the key's GCRA state, syncing it with Redis if it is due. Only a node's
+// first request for a key waits for Redis, later syncs run in the
+// background, so other nodes' requests are seen at most one sync
+// interval late.
+func (l *SessionLimiter) hybridGCRAExceeded(key string, rate, per float64, cost int64, force bool, limiter apidef.RateLimiterConfig, store gcraStorage) (bool, *rateLimitState) {
+	if rate <= 0 {
+		return true, nil
+	}
+	if per <= 0 {
+		return false, nil
+	}
+	interval := hybridDefaultSyncInterval
+	if limiter.SyncInterval > 0 {
+		interval = time.Duration(limiter.SyncInterval) * time.Millisecond
+	}
+
+	b := getHybridBucket(key)
+	b.mu.Lock()
+	if b.synced.IsZero() && !b.syncing {
+		b.syncing = true
+		b.mu.Unlock()
+		b.sync(key, rate, per, store)
+		b.mu.Lock()
+	}
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	emission, tolerance := gcraIntervals(rate, per)
+	tat := b.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(time.Duration(cost) * emission)
+	diff := now.Sub(newTat.Add(-tolerance))
+
+	if !b.syncing && now.Sub(b.synced) >= interval {
+		b.syncing = true
+		go b.sync(key, rate, per, store)
+	}
+
+	if diff < 0 && !force {
+		return true, &rateLimitState{
+			limit: int64(rate),
+			reset: now.Add(-diff),
+		}
+	}
+	b.tat = newTat
+	b.pending += cost
+	state := &rateLimitState{
+		limit: int64(rate),
+		reset: newTat,
+	}
+	if diff > 0 {
+		state.remaining = int64(math.Floor(float64(diff) / float64(emission)))
+	}
+	return diff < 0, state
+}
+
+func getHybridBucket(key string) *hybridBucket {
+	hybridBucketsMu.Lock()
+	defer hybridBucketsMu.Unlock()
+	if b, found := hybridBuckets.Get(key); found {
+		return b.(*hybridBucket)
+	}
+	b := &hybridBucket{}
+	hybridBuckets.Set(key, b, cache.DefaultExpiration)
+	return b
+}
+
+// sync counts the requests let through since the last sync in Redis,
+// and takes on the state there, which includes other nodes' requests.
+func (b *hybridBucket) sync(key string, rate, per float64, store gcraStorage) {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = 0
+	b.mu.Unlock()
+
+	res, err := store.GCRA(key, rate, per, pending, true)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.syncing = false
+	b.synced = time.Now()
+	if err != nil {
+		b.pending += pending
+		return
+	}
+	// add back what was let through while syncing
+	emission, _ := gcraIntervals(rate, per)
+	b.tat = b.synced.Add(res.ResetAfter).Add(time.Duration(b.pending) * emission)
+	hybridBucketsMu.Lock()
+	hybridBuckets.Set(key, b, cache.DefaultExpiration)
+	hybridBucketsMu.Unlock()
+}
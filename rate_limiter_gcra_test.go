@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/config"
+	"github.com/TykTechnologies/tyk/storage"
+)
+
+func TestGCRARateLimiter(t *testing.T) {
+	store := storage.RedisCluster{KeyPrefix: "apikey-"}
+	limiters := []apidef.RateLimiterConfig{
+		{Type: apidef.RateLimiterGCRA},
+		{Type: apidef.RateLimiterGCRAHybrid, SyncInterval: 10},
+	}
+	for _, limiter := range limiters {
+		t.Run(string(limiter.Type), func(t *testing.T) {
+			key := uuid.NewV4().String()
+			session := createNonThrottledSession()
+			session.Rate = 3
+			session.Per = 60
+
+			for i := 0; i < 3; i++ {
+				reason, state := sessionLimiter.ForwardMessage(session, key, store, true, false, limitOptions{limiter: limiter})
+				if reason != sessionFailNone {
+					t.Fatalf("Request %d should be allowed", i)
+				}
+				if want := int64(2 - i); state.remaining != want {
+					t.Errorf("Wanted %d requests remaining, got %d", want, state.remaining)
+				}
+			}
+			reason, state := sessionLimiter.ForwardMessage(session, key, store, true, false, limitOptions{limiter: limiter})
+			if reason != sessionFailRateLimit {
+				t.Fatal("Request over the limit should be rate limited")
+			}
+			if wait := time.Until(state.reset); wait <= 0 || wait > 20*time.Second {
+				t.Error("Wanted a retry within the emission interval, got", wait)
+			}
+
+			// whatever was let through locally ends up in Redis
+			time.Sleep(50 * time.Millisecond)
+			if limiter.Type == apidef.RateLimiterGCRAHybrid {
+				sessionLimiter.ForwardMessage(session, key, store, true, false, limitOptions{limiter: limiter})
+				time.Sleep(50 * time.Millisecond)
+			}
+			res, err := store.GCRA(RateLimitKeyPrefix+storage.HashKey(key)+gcraKeySuffix, 3, 60, 0, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Remaining != 0 || res.ResetAfter < 59*time.Second {
+				t.Errorf("Wanted the limit used up in Redis, got %+v", res)
+			}
+		})
+	}
+}
+
+func BenchmarkRateLimiters(b *testing.B) {
+	store := storage.RedisCluster{KeyPrefix: "apikey-"}
+	session := createNonThrottledSession()
+	session.Rate = 1e9
+	session.Per = 1
+
+	DRLManager.CurrentTokenValue = 1
+	DRLManager.RequestTokenValue = 1
+	defer func() {
+		DRLManager.CurrentTokenValue = 0
+		DRLManager.RequestTokenValue = 0
+	}()
+
+	benchmarks := []struct {
+		name    string
+		rolling bool
+		limiter apidef.RateLimiterConfig
+	}{
+		{"DRL", false, apidef.RateLimiterConfig{}},
+		{"RollingWindow", true, apidef.RateLimiterConfig{}},
+		{"GCRA", false, apidef.RateLimiterConfig{Type: apidef.RateLimiterGCRA}},
+		{"GCRAHybrid", false, apidef.RateLimiterConfig{Type: apidef.RateLimiterGCRAHybrid}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			config.Global.EnableRedisRollingLimiter = bm.rolling
+			defer func() { config.Global.EnableRedisRollingLimiter = false }()
+			key := uuid.NewV4().String()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				sessionLimiter.ForwardMessage(session, key, store, true, false, limitOptions{limiter: bm.limiter})
+			}
+		})
+	}
+}
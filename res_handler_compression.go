@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/TykTechnologies/tyk/user"
+)
+
+// compressionEncodings are the encodings the gateway can compress with,
+// in the order it prefers them.
+var compressionEncodings = []string{"br", "gzip", "deflate"}
+
+var defaultCompressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/x-javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// decodeBody decodes a body in one of the compression encodings.
+func decodeBody(encoding string, body []byte) ([]byte, error) {
+	var r io.Reader
+	var err error
+	switch encoding {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// deflate should be zlib wrapped, but some send it raw
+		if r, err = zlib.NewReader(bytes.NewReader(body)); err != nil {
+			r, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// encodeBody compresses a body with one of the compression encodings, at
+// the encoding's default level if level is 0.
+func encodeBody(encoding string, body []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch encoding {
+	case "gzip", "x-gzip":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		w, err = gzip.NewWriterLevel(&buf, level)
+	case "deflate":
+		if level == 0 {
+			level = zlib.DefaultCompression
+		}
+		w, err = zlib.NewWriterLevel(&buf, level)
+	case "br":
+		if level == 0 {
+			level = brotli.DefaultCompression
+		}
+		w = brotli.NewWriterLevel(&buf, level)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isCompressionEncoding(encoding string) bool {
+	switch encoding {
+	case "gzip", "x-gzip", "deflate", "br":
+		return true
+	}
+	return false
+}
+
+func setResponseBody(res *http.Response, body []byte) {
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// decodeResponse decodes a compressed upstream response, so that the
+// response processors can work on its body, and returns the encoding it
+// was in so that it can be compressed again after them.
+func decodeResponse(res *http.Response) string {
+	encoding := strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding")))
+	if !isCompressionEncoding(encoding) {
+		return ""
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err == nil {
+		var decoded []byte
+		if decoded, err = decodeBody(encoding, body); err == nil {
+			res.Header.Del("Content-Encoding")
+			setResponseBody(res, decoded)
+			return encoding
+		}
+	}
+	log.Warning("Failed to decode response body, processing it as it is: ", err)
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return ""
+}
+
+// encodeResponse compresses a response decoded by decodeResponse again,
+// unless a response processor has encoded it in the meantime.
+func encodeResponse(res *http.Response, encoding string) {
+	if encoding == "" || res.Header.Get("Content-Encoding") != "" {
+		return
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err == nil {
+		var encoded []byte
+		if encoded, err = encodeBody(encoding, body, 0); err == nil {
+			res.Header.Set("Content-Encoding", encoding)
+			setResponseBody(res, encoded)
+			return
+		}
+	}
+	log.Error("Failed to encode response body, sending it uncompressed: ", err)
+	setResponseBody(res, body)
+}
+
+// negotiateEncoding picks the compression encoding to use from an
+// Accept-Encoding header, going by the clients' q-values and then by our
+// own preference.
+func negotiateEncoding(acceptEncoding string) string {
+	qs := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if name == "*" {
+			wildcard = q
+		} else if name != "" {
+			qs[name] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range compressionEncodings {
+		q, ok := qs[encoding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+func compressibleType(types []string, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if len(types) == 0 {
+		if mediaType == "text/event-stream" {
+			// streams must go out as they come in
+			return false
+		}
+		if strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+			return true
+		}
+		types = defaultCompressibleTypes
+	}
+	for _, t := range types {
+		if t == mediaType || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponseCompressionMiddleware compresses responses for the clients that
+// accept it. It's added to the end of the response chain of APIs which
+// have compression enabled.
+type ResponseCompressionMiddleware struct {
+	Spec *APISpec
+}
+
+func (h *ResponseCompressionMiddleware) Init(c interface{}, spec *APISpec) error {
+	h.Spec = spec
+	return nil
+}
+
+func (h *ResponseCompressionMiddleware) HandleResponse(rw http.ResponseWriter, res *http.Response, req *http.Request, ses *user.SessionState) error {
+	conf := h.Spec.Compression
+	if res.Header.Get("Content-Encoding") != "" || req.Method == http.MethodHead {
+		return nil
+	}
+	if res.StatusCode < 200 || res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if !compressibleType(conf.ContentTypes, res.Header.Get("Content-Type")) {
+		return nil
+	}
+	if !strings.Contains(strings.ToLower(strings.Join(res.Header["Vary"], ",")), "accept-encoding") {
+		res.Header.Add("Vary", "Accept-Encoding")
+	}
+	encoding := negotiateEncoding(req.Header.Get("Accept-Encoding"))
+	if encoding == "" || (res.ContentLength >= 0 && res.ContentLength < conf.MinSize) {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return err
+	}
+	if int64(len(body)) < conf.MinSize {
+		setResponseBody(res, body)
+		return nil
+	}
+	encoded, err := encodeBody(encoding, body, conf.Level)
+	if err != nil {
+		log.Error("Failed to compress response: ", err)
+		setResponseBody(res, body)
+		return nil
+	}
+	res.Header.Set("Content-Encoding", encoding)
+	setResponseBody(res, encoded)
+	return nil
+}
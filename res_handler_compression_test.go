@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip, deflate, br", "br"},
+		{"gzip, deflate", "gzip"},
+		{"gzip;q=0.5, deflate;q=0.8", "deflate"},
+		{"br;q=0, gzip", "gzip"},
+		{"*", "br"},
+		{"*;q=0.1, br;q=0", "gzip"},
+	}
+	for _, tc := range tests {
+		if got := negotiateEncoding(tc.header); got != tc.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestResponseCompression(t *testing.T) {
+	payload := `{"message":"` + strings.Repeat("hello ", 100) + `"}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gzipped":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte(payload))
+			gz.Close()
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(payload))
+		default:
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Write([]byte(payload))
+		}
+	}))
+	defer upstream.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen(ln, nil, nil)
+	defer ln.Close()
+	baseURL := "http://" + ln.Addr().String()
+
+	loadAPI := func(compression apidef.CompressionConfig) {
+		buildAndLoadAPI(func(spec *APISpec) {
+			spec.Proxy.ListenPath = "/"
+			spec.Proxy.TargetURL = upstream.URL
+			spec.Compression = compression
+			spec.ResponseProcessors = []apidef.ResponseProcessor{{Name: "response_body_transform"}}
+			transform := apidef.TemplateMeta{Path: "/gzipped", Method: "GET"}
+			transform.TemplateData.Mode = apidef.UseBlob
+			transform.TemplateData.Engine = apidef.JQTemplateEngine
+			transform.TemplateData.Input = apidef.RequestJSON
+			transform.TemplateData.TemplateSource = base64.StdEncoding.EncodeToString([]byte(`{length: (.message | length)}`))
+			v := spec.VersionData.Versions["v1"]
+			v.UseExtendedPaths = true
+			v.ExtendedPaths = apidef.ExtendedPathsSet{
+				TransformResponse: []apidef.TemplateMeta{transform},
+			}
+			spec.VersionData.Versions["v1"] = v
+		})
+	}
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	get := func(t *testing.T, path, acceptEncoding string) (string, string) {
+		req, _ := http.NewRequest("GET", baseURL+path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		encoding := resp.Header.Get("Content-Encoding")
+		if encoding == "" {
+			return "", string(body)
+		}
+		decoded, err := decodeBody(encoding, body)
+		if err != nil {
+			t.Fatalf("Failed to decode %s body: %v", encoding, err)
+		}
+		return encoding, string(decoded)
+	}
+
+	t.Run("TransformCompressedUpstream", func(t *testing.T) {
+		loadAPI(apidef.CompressionConfig{})
+		encoding, body := get(t, "/gzipped", "gzip")
+		if encoding != "gzip" || body != `{"length":600}` {
+			t.Errorf("Wanted the transformed body gzipped again, got %q %s", encoding, body)
+		}
+	})
+
+	t.Run("Gateway", func(t *testing.T) {
+		loadAPI(apidef.CompressionConfig{Enabled: true, MinSize: 10})
+		if encoding, body := get(t, "/plain", "gzip, br"); encoding != "br" || body != payload {
+			t.Errorf("Wanted the body compressed with brotli, got %q", encoding)
+		}
+		if encoding, body := get(t, "/plain", "gzip;q=1.0, br;q=0"); encoding != "gzip" || body != payload {
+			t.Errorf("Wanted the body gzipped, got %q", encoding)
+		}
+		if encoding, _ := get(t, "/plain", ""); encoding != "" {
+			t.Errorf("Wanted no compression without Accept-Encoding, got %q", encoding)
+		}
+		if encoding, _ := get(t, "/small", "br"); encoding != "" {
+			t.Errorf("Wanted small bodies left alone, got %q", encoding)
+		}
+		if encoding, _ := get(t, "/image", "br"); encoding != "" {
+			t.Errorf("Wanted images left alone, got %q", encoding)
+		}
+		// transformed bodies are compressed after the transform
+		if encoding, body := get(t, "/gzipped", "br"); encoding != "br" || body != `{"length":600}` {
+			t.Errorf("Wanted the transformed body compressed with brotli, got %q %s", encoding, body)
+		}
+	})
+}
+
+func TestEncodeBody(t *testing.T) {
+	body := []byte(strings.Repeat("tyk ", 50))
+	for _, encoding := range []string{"gzip", "deflate", "br"} {
+		encoded, err := encodeBody(encoding, body, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeBody(encoding, encoded)
+		if err != nil || !bytes.Equal(decoded, body) {
+			t.Errorf("Wanted %s round trip, got %v", encoding, err)
+		}
+	}
+	// brotli streams from elsewhere decode too
+	var buf bytes.Buffer
+	w := brotli.NewWriter(&buf)
+	w.Write(body)
+	w.Close()
+	if decoded, err := decodeBody("br", buf.Bytes()); err != nil || !bytes.Equal(decoded, body) {
+		t.Errorf("Wanted brotli body decoded, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/TykTechnologies/tyk/user"
+)
+
+// ResponseSOAPMiddleware turns the SOAP responses to requests built by
+// SOAPMediationMiddleware back into JSON, and SOAP faults into errors
+// with a status code to match.
+type ResponseSOAPMiddleware struct {
+	Spec *APISpec
+}
+
+func (h *ResponseSOAPMiddleware) Init(c interface{}, spec *APISpec) error {
+	h.Spec = spec
+	return nil
+}
+
+func (h *ResponseSOAPMiddleware) HandleResponse(rw http.ResponseWriter, res *http.Response, req *http.Request, ses *user.SessionState) error {
+	meta := ctxGetSOAPMediation(req)
+	if meta == nil {
+		return nil
+	}
+	logEntry := log.WithFields(logrus.Fields{
+		"prefix":      "soap",
+		"server_name": h.Spec.Proxy.TargetURL,
+		"api_id":      h.Spec.APIID,
+		"path":        req.URL.Path,
+	})
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		logEntry.Error("Failed to read SOAP response: ", err)
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return nil
+	}
+	envelope, err := parseXML(bytes.NewReader(body))
+	var content *xmlNode
+	var fault *soapFault
+	if err == nil {
+		content, fault, err = soapBody(envelope)
+	}
+	if err != nil {
+		// leave the response as it is, it may not have come from the
+		// SOAP service at all
+		logEntry.Error("Failed to parse SOAP response: ", err)
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return nil
+	}
+
+	conv := xmlToJSON{meta: meta}
+	var out interface{}
+	if fault != nil {
+		errBody := map[string]interface{}{
+			"error":      fault.Reason,
+			"fault_code": fault.Code,
+		}
+		if fault.Detail != nil {
+			errBody["detail"] = conv.value(fault.Detail)
+		}
+		out = errBody
+		res.StatusCode = faultStatus(meta, fault.Code)
+		res.Status = fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	} else {
+		out = conv.value(content)
+	}
+
+	converted, err := json.Marshal(out)
+	if err != nil {
+		logEntry.Error("Failed to convert SOAP response: ", err)
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return nil
+	}
+	res.Header.Set("Content-Type", "application/json")
+	res.Header.Set("Content-Length", strconv.Itoa(len(converted)))
+	res.ContentLength = int64(len(converted))
+	res.Body = ioutil.NopCloser(bytes.NewReader(converted))
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/clbanning/mxj"
@@ -62,7 +63,9 @@ func (h *ResponseTransformMiddleware) HandleResponse(rw http.ResponseWriter, res
 
 	// Apply to template
 	var bodyBuffer bytes.Buffer
-	if err := tmeta.Template.Execute(&bodyBuffer, bodyData); err != nil {
+	vars := transformVars(req, ses, tmeta, h.Spec.EnableContextVars)
+	vars["_tyk_response"] = responseTransformVars(res)
+	if err := tmeta.apply(&bodyBuffer, bodyData, vars); err != nil {
 		log.WithFields(logrus.Fields{
 			"prefix":      "outbound-transform",
 			"server_name": h.Spec.Proxy.TargetURL,
@@ -77,3 +80,17 @@ func (h *ResponseTransformMiddleware) HandleResponse(rw http.ResponseWriter, res
 
 	return nil
 }
+
+// responseTransformVars has the upstream's status code and headers, for
+// response transforms to use under _tyk_response. Headers with more than
+// one value have them joined with commas.
+func responseTransformVars(res *http.Response) map[string]interface{} {
+	headers := make(map[string]interface{}, len(res.Header))
+	for name, values := range res.Header {
+		headers[name] = strings.Join(values, ", ")
+	}
+	return map[string]interface{}{
+		"status_code": res.StatusCode,
+		"headers":     headers,
+	}
+}
@@ -10,6 +10,8 @@ import (
 
 func TestTransformResponseWithURLRewrite(t *testing.T) {
 	testTemplateBlob := base64.StdEncoding.EncodeToString([]byte(`{"http_method":"{{.Method}}"}`))
+	testJQBlob := base64.StdEncoding.EncodeToString([]byte(`{http_method: .Method}`))
+	testResponseBlob := base64.StdEncoding.EncodeToString([]byte(`{"status":{{._tyk_response.status_code}},"type":"{{index ._tyk_response.headers "Content-Type" | lower}}","method":"{{.Method | lower}}"}`))
 
 	testData := map[string]struct {
 		apiSpec      string
@@ -54,6 +56,81 @@ func TestTransformResponseWithURLRewrite(t *testing.T) {
 			expectedCode: http.StatusOK,
 			expectedBody: `{"http_method":"GET"}`,
 		},
+		"jq_transform_response": {
+			apiSpec: `
+			{
+				"api_id": "1",
+				"auth": {"auth_header_name": "authorization"},
+				"version_data": {
+					"not_versioned": true,
+					"versions": {
+						"v1": {
+							"name": "v1",
+							"use_extended_paths": true,
+							"extended_paths": {
+								"transform_response": [
+									{
+										"path": "get",
+										"method": "GET",
+										"template_data": {
+											"template_mode": "blob",
+											"engine": "jq",
+											"template_source": "` + testJQBlob + `"
+										}
+									}
+								]
+							}
+						}
+					}
+				},
+				"response_processors":[{"name": "response_body_transform"}],
+				"proxy": {
+					"listen_path": "/v1",
+					"target_url": "` + testHttpAny + `"
+				}
+			}
+			`,
+			url:          "/v1/get",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"http_method":"GET"}`,
+		},
+		"response_metadata": {
+			apiSpec: `
+			{
+				"api_id": "1",
+				"auth": {"auth_header_name": "authorization"},
+				"version_data": {
+					"not_versioned": true,
+					"versions": {
+						"v1": {
+							"name": "v1",
+							"use_extended_paths": true,
+							"extended_paths": {
+								"transform_response": [
+									{
+										"path": "get",
+										"method": "GET",
+										"template_data": {
+											"template_mode": "blob",
+											"template_source": "` + testResponseBlob + `"
+										}
+									}
+								]
+							}
+						}
+					}
+				},
+				"response_processors":[{"name": "response_body_transform"}],
+				"proxy": {
+					"listen_path": "/v1",
+					"target_url": "` + testHttpAny + `"
+				}
+			}
+			`,
+			url:          "/v1/get",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"status":200,"type":"text/plain; charset=utf-8","method":"get"}`,
+		},
 		"transform_path_equal_to_rewrite_to": {
 			apiSpec: `
 			{
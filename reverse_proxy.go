@@ -615,6 +615,10 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 		inres.Body = ioutil.NopCloser(bodyBuffer2)
 	}
 
+	if session != nil {
+		p.chargeResponseCost(req, res, session)
+	}
+
 	ses := new(user.SessionState)
 	if session != nil {
 		ses = session
@@ -629,11 +633,50 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 	// We should at least copy the status code in
 	inres.StatusCode = res.StatusCode
 	inres.ContentLength = res.ContentLength
-	p.HandleResponse(rw, res, ses)
+	p.HandleResponse(rw, res, req)
 	return inres
 }
 
-func (p *ReverseProxy) HandleResponse(rw http.ResponseWriter, res *http.Response, ses *user.SessionState) error {
+// chargeResponseCost charges the rest of a cost the upstream sets in a
+// response header, over what was charged before proxying. The request
+// has been served already, so the charge goes through even if it takes
+// the key over its limits, and the next request pays for that.
+func (p *ReverseProxy) chargeResponseCost(req *http.Request, res *http.Response, session *user.SessionState) {
+	charge := ctxGetRequestCharge(req)
+	if charge == nil || charge.meta == nil || charge.meta.Source != "header" {
+		return
+	}
+	value := res.Header.Get(charge.meta.HeaderName)
+	if value == "" {
+		return
+	}
+	res.Header.Del(charge.meta.HeaderName)
+	cost, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || cost <= charge.cost {
+		return
+	}
+
+	spec := p.TykAPISpec
+	token := ctxGetAuthToken(req)
+	_, state := sessionLimiter.ForwardMessage(session,
+		token,
+		spec.SessionManager.Store(),
+		!spec.DisableRateLimit,
+		!spec.DisableQuota,
+		limitOptions{
+			limiter:  spec.RateLimiter,
+			endpoint: charge.endpoint,
+			cost:     cost - charge.cost,
+			force:    true,
+		})
+	spec.SessionManager.UpdateSession(token, session, session.Lifetime(spec.SessionLifetime))
+	charge.cost = cost
+	if state != nil {
+		ctxSetRateLimitState(req, state)
+	}
+}
+
+func (p *ReverseProxy) HandleResponse(rw http.ResponseWriter, res *http.Response, req *http.Request) error {
 
 	// Remove hop-by-hop headers listed in the
 	// "Connection" header of the response.
@@ -656,12 +699,7 @@ func (p *ReverseProxy) HandleResponse(rw http.ResponseWriter, res *http.Response
 	}
 
 	// Add resource headers
-	if ses != nil {
-		// We have found a session, lets report back
-		res.Header.Set("X-RateLimit-Limit", strconv.Itoa(int(ses.QuotaMax)))
-		res.Header.Set("X-RateLimit-Remaining", strconv.Itoa(int(ses.QuotaRemaining)))
-		res.Header.Set("X-RateLimit-Reset", strconv.Itoa(int(ses.QuotaRenews)))
-	}
+	setRateLimitHeaders(p.TykAPISpec, res.Header, req)
 
 	copyHeader(rw.Header(), res.Header)
 
@@ -756,6 +794,9 @@ func (m *maxLatencyWriter) flushLoop() {
 func (m *maxLatencyWriter) stop() { m.done <- true }
 
 func requestIP(r *http.Request) string {
+	if len(config.Global.TrustedProxies) > 0 {
+		return trustedRequestIP(r)
+	}
 	if real := r.Header.Get("X-Real-IP"); real != "" {
 		return real
 	}
@@ -775,6 +816,39 @@ func requestIP(r *http.Request) string {
 	return host
 }
 
+// trustedRequestIP only takes the client IP from the forwarding headers
+// as far as they were set by trusted proxies. The hops in X-Forwarded-For,
+// and the peer after them, are walked back from the nearest until one
+// isn't a trusted proxy. Proxies tend to pass X-Real-IP on as the
+// client sent it, so it's only used if there is no X-Forwarded-For and
+// the proxies are trusted to set it.
+func trustedRequestIP(r *http.Request) string {
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if !ipInList(net.ParseIP(host), config.Global.TrustedProxies) {
+		return host
+	}
+	if _, ok := r.Header["X-Forwarded-For"]; !ok && config.Global.TrustXRealIP {
+		if real := r.Header.Get("X-Real-IP"); real != "" {
+			return real
+		}
+	}
+	hops := strings.Split(requestIPHops(r), ",")
+	for i := len(hops) - 1; i > 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if !ipInList(net.ParseIP(hop), config.Global.TrustedProxies) {
+			return hop
+		}
+	}
+	return strings.TrimSpace(hops[0])
+}
+
+// accessControlIP is the client IP that access control goes by. Unless
+// trusted proxies are configured, that's the peer's, as the forwarding
+// headers could be set by anyone. It's nil if the IP doesn't parse.
+func accessControlIP(r *http.Request) net.IP {
+	return net.ParseIP(trustedRequestIP(r))
+}
+
 func requestIPHops(r *http.Request) string {
 	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/TykTechnologies/leakybucket"
 	"github.com/TykTechnologies/leakybucket/memorycache"
+	"github.com/TykTechnologies/tyk/apidef"
 	"github.com/TykTechnologies/tyk/config"
 	"github.com/TykTechnologies/tyk/storage"
 	"github.com/TykTechnologies/tyk/user"
@@ -12,9 +15,11 @@ import (
 
 type PublicSession struct {
 	Quota struct {
-		QuotaMax       int64 `json:"quota_max"`
-		QuotaRemaining int64 `json:"quota_remaining"`
-		QuotaRenews    int64 `json:"quota_renews"`
+		QuotaMax       int64  `json:"quota_max"`
+		QuotaRemaining int64  `json:"quota_remaining"`
+		QuotaRenews    int64  `json:"quota_renews"`
+		QuotaSchedule  string `json:"quota_schedule,omitempty"`
+		QuotaTimezone  string `json:"quota_timezone,omitempty"`
 	} `json:"quota"`
 	RateLimit struct {
 		Rate float64 `json:"requests"`
@@ -33,14 +38,54 @@ type SessionLimiter struct {
 	bucketStore leakybucket.Storage
 }
 
-func (l *SessionLimiter) doRollingWindowWrite(key, rateLimiterKey, rateLimiterSentinelKey string, currentSession *user.SessionState, store storage.Handler) bool {
+// rollingWindowCostStorage is implemented by the stores that can add a
+// costly request to a rolling window in one go.
+type rollingWindowCostStorage interface {
+	SetRollingWindowCost(keyName string, per, cost int64, pipeline bool) (int, []interface{})
+}
+
+func (l *SessionLimiter) doRollingWindowWrite(key, rateLimiterKey, rateLimiterSentinelKey string, rate, per float64, cost int64, store storage.Handler) (bool, *rateLimitState) {
 	log.Debug("[RATELIMIT] Inbound raw key is: ", key)
 	log.Debug("[RATELIMIT] Rate limiter key is: ", rateLimiterKey)
 	pipeline := config.Global.EnableNonTransactionalRateLimiter
-	ratePerPeriodNow, _ := store.SetRollingWindow(rateLimiterKey, int64(currentSession.Per), "-1", pipeline)
+	now := time.Now()
+	// A costly request takes up as many places in the window as it
+	// costs, each with a member of its own. Past the rate the window is
+	// full either way, so no more are added.
+	places := cost
+	if limit := int64(rate) + 1; places > limit {
+		places = limit
+	}
+	var ratePerPeriodNow int
+	var window []interface{}
+	if costStore, ok := store.(rollingWindowCostStorage); ok {
+		ratePerPeriodNow, window = costStore.SetRollingWindowCost(rateLimiterKey, int64(per), places, pipeline)
+	} else {
+		ratePerPeriodNow, window = store.SetRollingWindow(rateLimiterKey, int64(per), "-1", pipeline)
+		for i := int64(1); i < places; i++ {
+			store.SetRollingWindow(rateLimiterKey, int64(per), strconv.FormatInt(now.UnixNano(), 10)+"-"+strconv.FormatInt(i, 10), pipeline)
+		}
+	}
 
 	//log.Info("Num Requests: ", ratePerPeriodNow)
 
+	// The window frees up as its oldest request drops out of it
+	state := &rateLimitState{
+		limit:     int64(rate),
+		remaining: int64(rate) - int64(ratePerPeriodNow) - cost,
+		reset:     now.Add(time.Duration(per) * time.Second),
+	}
+	if len(window) > 0 {
+		if oldest, ok := window[0].([]byte); ok {
+			if nanos, err := strconv.ParseInt(string(oldest), 10, 64); err == nil {
+				state.reset = time.Unix(0, nanos).Add(time.Duration(per) * time.Second)
+			}
+		}
+	}
+	if state.remaining < 0 {
+		state.remaining = 0
+	}
+
 	// Subtract by 1 because of the delayed add in the window
 	subtractor := 1
 	if config.Global.EnableSentinelRateLImiter {
@@ -48,17 +93,18 @@ func (l *SessionLimiter) doRollingWindowWrite(key, rateLimiterKey, rateLimiterSe
 		subtractor = 2
 	}
 
-	//log.Info("break: ", (int(currentSession.Rate) - subtractor))
+	//log.Info("break: ", (int(rate) - subtractor))
 
-	if ratePerPeriodNow > int(currentSession.Rate)-subtractor {
+	if int64(ratePerPeriodNow)+cost-1 > int64(rate)-int64(subtractor) {
 		// Set a sentinel value with expire
 		if config.Global.EnableSentinelRateLImiter {
-			store.SetRawKey(rateLimiterSentinelKey, "1", int64(currentSession.Per))
+			store.SetRawKey(rateLimiterSentinelKey, "1", int64(per))
 		}
-		return true
+		state.remaining = 0
+		return true, state
 	}
 
-	return false
+	return false, state
 }
 
 type sessionFailReason uint
@@ -69,75 +115,261 @@ const (
 	sessionFailQuota
 )
 
+// rateLimitState is where a key stands against one of its limits after
+// a request, for the rate limit response headers. A negative remaining
+// count or a zero reset time mean they aren't known.
+type rateLimitState struct {
+	limit     int64
+	remaining int64
+	reset     time.Time
+}
+
+// tighter returns whichever of two limit states has fewer requests
+// remaining, ignoring those whose remaining count isn't known.
+func (s *rateLimitState) tighter(other *rateLimitState) *rateLimitState {
+	switch {
+	case s == nil || s.remaining < 0:
+		return other
+	case other == nil || other.remaining < 0:
+		return s
+	case other.remaining < s.remaining:
+		return other
+	}
+	return s
+}
+
+// endpointLimit is an access spec with limits of its own, matched by a
+// request to the API it belongs to.
+type endpointLimit struct {
+	apiID string
+	spec  *user.AccessSpec
+}
+
+// counterSuffix tells the Redis counters and buckets of an endpoint
+// apart from the session's own and from each other.
+func (e *endpointLimit) counterSuffix() string {
+	return "-" + storage.HashStr(e.apiID+":"+e.spec.URL+":"+strings.Join(e.spec.Methods, ","))
+}
+
+// limitOptions are the optional parts of a ForwardMessage call.
+type limitOptions struct {
+	// limiter picks the rate limiting algorithm.
+	limiter apidef.RateLimiterConfig
+	// endpoint, if set, has its rate limit and quota enforced as well,
+	// with counters of their own.
+	endpoint *endpointLimit
+	// cost is how much of the rate allowance and quota the request
+	// takes up. Zero counts as one.
+	cost int64
+	// force counts the cost even where it goes over a limit, for costs
+	// only known once the request has been served.
+	force bool
+}
+
+func (o limitOptions) requestCost() int64 {
+	if o.cost < 1 {
+		return 1
+	}
+	return o.cost
+}
+
 // ForwardMessage will enforce rate limiting, returning a non-zero
 // sessionFailReason if session limits have been exceeded.
 // Key values to manage rate are Rate and Per, e.g. Rate of 10 messages
 // Per 10 seconds
-func (l *SessionLimiter) ForwardMessage(currentSession *user.SessionState, key string, store storage.Handler, enableRL, enableQ bool) sessionFailReason {
-	rateLimiterKey := RateLimitKeyPrefix + storage.HashKey(key)
-	rateLimiterSentinelKey := RateLimitKeyPrefix + storage.HashKey(key) + ".BLOCKED"
-
+// The returned state is that of the limit which was exceeded, or else of
+// the one closest to being exceeded. It is nil if no limit applied.
+func (l *SessionLimiter) ForwardMessage(currentSession *user.SessionState, key string, store storage.Handler, enableRL, enableQ bool, opts limitOptions) (sessionFailReason, *rateLimitState) {
+	var state *rateLimitState
+	endpoint := opts.endpoint
 	if enableRL {
-		if config.Global.EnableSentinelRateLImiter {
-			go l.doRollingWindowWrite(key, rateLimiterKey, rateLimiterSentinelKey, currentSession, store)
-
-			// Check sentinel
-			_, sentinelActive := store.GetRawKey(rateLimiterSentinelKey)
-			if sentinelActive == nil {
-				// Sentinel is set, fail
-				return sessionFailRateLimit
+		rateLimiterKey := RateLimitKeyPrefix + storage.HashKey(key)
+		// If a token has been updated, we must ensure we dont use
+		// an old bucket an let the cache deal with it
+		bucketKey := key + ":" + currentSession.LastUpdated
+		exceeded, rlState := l.rateLimitExceeded(key, rateLimiterKey, bucketKey, currentSession.Rate, currentSession.Per, opts, store)
+		if exceeded && !opts.force {
+			return sessionFailRateLimit, rlState
+		}
+		state = state.tighter(rlState)
+		if endpoint != nil && endpoint.spec.HasRateLimit() {
+			suffix := endpoint.counterSuffix()
+			exceeded, rlState := l.rateLimitExceeded(key, rateLimiterKey+suffix, bucketKey+suffix, endpoint.spec.Rate, endpoint.spec.Per, opts, store)
+			if exceeded && !opts.force {
+				return sessionFailRateLimit, rlState
 			}
-		} else if config.Global.EnableRedisRollingLimiter {
-			if l.doRollingWindowWrite(key, rateLimiterKey, rateLimiterSentinelKey, currentSession, store) {
-				return sessionFailRateLimit
+			state = state.tighter(rlState)
+		}
+	}
+
+	if enableQ {
+		if config.Global.LegacyEnableAllowanceCountdown {
+			currentSession.Allowance -= float64(opts.requestCost())
+		}
+
+		exceeded := l.RedisQuotaExceeded(currentSession, key, store, opts)
+		var qState *rateLimitState
+		if currentSession.QuotaMax > 0 {
+			qState = &rateLimitState{
+				limit:     currentSession.QuotaMax,
+				remaining: currentSession.QuotaRemaining,
+				reset:     time.Unix(currentSession.QuotaRenews, 0),
 			}
-		} else {
-			// In-memory limiter
-			if l.bucketStore == nil {
-				l.bucketStore = memorycache.New()
+		}
+		if exceeded && !opts.force {
+			return sessionFailQuota, qState
+		}
+		state = state.tighter(qState)
+		if endpoint != nil && endpoint.spec.HasQuota() {
+			exceeded, qState := l.endpointQuotaExceeded(endpoint, key, store, opts)
+			if exceeded && !opts.force {
+				return sessionFailQuota, qState
 			}
+			state = state.tighter(qState)
+		}
+	}
 
-			// If a token has been updated, we must ensure we dont use
-			// an old bucket an let the cache deal with it
-			bucketKey := key + ":" + currentSession.LastUpdated
+	return sessionFailNone, state
 
-			// DRL will always overflow with more servers on low rates
-			rate := uint(currentSession.Rate * float64(DRLManager.RequestTokenValue))
-			if rate < uint(DRLManager.CurrentTokenValue) {
-				rate = uint(DRLManager.CurrentTokenValue)
-			}
+}
 
-			userBucket, err := l.bucketStore.Create(bucketKey,
-				rate,
-				time.Duration(currentSession.Per)*time.Second)
-			if err != nil {
-				log.Error("Failed to create bucket!")
-				return sessionFailRateLimit
-			}
+// rateLimitExceeded adds a request to a rate limit of rate requests per
+// per seconds, reporting whether it went over.
+func (l *SessionLimiter) rateLimitExceeded(key, rateLimiterKey, bucketKey string, rate, per float64, opts limitOptions, store storage.Handler) (bool, *rateLimitState) {
+	cost := opts.requestCost()
+	switch opts.limiter.Type {
+	case apidef.RateLimiterGCRA, apidef.RateLimiterGCRAHybrid:
+		gcraStore, ok := store.(gcraStorage)
+		if !ok {
+			log.Debug("[RATELIMIT] Store doesn't support GCRA, using the default limiter")
+			break
+		}
+		if opts.limiter.Type == apidef.RateLimiterGCRAHybrid {
+			return l.hybridGCRAExceeded(rateLimiterKey+gcraKeySuffix, rate, per, cost, opts.force, opts.limiter, gcraStore)
+		}
+		return l.gcraExceeded(rateLimiterKey+gcraKeySuffix, rate, per, cost, opts.force, gcraStore)
+	}
 
-			_, errF := userBucket.Add(uint(DRLManager.CurrentTokenValue))
+	rateLimiterSentinelKey := rateLimiterKey + ".BLOCKED"
 
-			if errF != nil {
-				return sessionFailRateLimit
+	if config.Global.EnableSentinelRateLImiter {
+		go l.doRollingWindowWrite(key, rateLimiterKey, rateLimiterSentinelKey, rate, per, cost, store)
+
+		// Check sentinel
+		_, sentinelActive := store.GetRawKey(rateLimiterSentinelKey)
+		if sentinelActive == nil {
+			// Sentinel is set, fail
+			return true, &rateLimitState{
+				limit: int64(rate),
+				reset: time.Now().Add(time.Duration(per) * time.Second),
 			}
 		}
+		// the window is written in the background, so there's no
+		// telling how much of it is left
+		return false, &rateLimitState{limit: int64(rate), remaining: -1}
+	}
+	if config.Global.EnableRedisRollingLimiter {
+		return l.doRollingWindowWrite(key, rateLimiterKey, rateLimiterSentinelKey, rate, per, cost, store)
 	}
 
-	if enableQ {
-		if config.Global.LegacyEnableAllowanceCountdown {
-			currentSession.Allowance--
-		}
+	// In-memory limiter
+	if l.bucketStore == nil {
+		l.bucketStore = memorycache.New()
+	}
 
-		if l.RedisQuotaExceeded(currentSession, key, store) {
-			return sessionFailQuota
-		}
+	// DRL will always overflow with more servers on low rates
+	bucketRate := uint(rate * float64(DRLManager.RequestTokenValue))
+	if bucketRate < uint(DRLManager.CurrentTokenValue) {
+		bucketRate = uint(DRLManager.CurrentTokenValue)
+	}
+
+	userBucket, err := l.bucketStore.Create(bucketKey,
+		bucketRate,
+		time.Duration(per)*time.Second)
+	if err != nil {
+		log.Error("Failed to create bucket!")
+		return true, nil
+	}
+
+	bucketState, errF := userBucket.Add(uint(cost) * uint(DRLManager.CurrentTokenValue))
+	state := &rateLimitState{limit: int64(rate), remaining: -1, reset: bucketState.Reset}
+	if tokens := uint(DRLManager.CurrentTokenValue); tokens > 0 {
+		state.remaining = int64(bucketState.Remaining / tokens)
 	}
+	if errF != nil {
+		state.remaining = 0
+		return true, state
+	}
+	return false, state
+}
+
+// incrementByStorage is implemented by the stores that can add more
+// than one to a counter in a single call.
+type incrementByStorage interface {
+	IncrementByWithExpire(keyName string, by, expire int64) int64
+}
+
+// incrementWithExpire adds by to a raw counter key, setting its expiry
+// if the key is new.
+func incrementWithExpire(store storage.Handler, keyName string, by, expire int64) int64 {
+	if by == 1 {
+		return store.IncrememntWithExpire(keyName, expire)
+	}
+	if incStore, ok := store.(incrementByStorage); ok {
+		return incStore.IncrementByWithExpire(keyName, by, expire)
+	}
+	var val int64
+	for i := int64(0); i < by; i++ {
+		val = store.IncrememntWithExpire(keyName, expire)
+	}
+	return val
+}
+
+// endpointQuotaExceeded counts a request against an endpoint quota.
+func (l *SessionLimiter) endpointQuotaExceeded(endpoint *endpointLimit, key string, store storage.Handler, opts limitOptions) (bool, *rateLimitState) {
+	rawKey := QuotaKeyPrefix + storage.HashKey(key) + endpoint.counterSuffix()
+	log.Debug("[QUOTA] Endpoint quota limiter key is: ", rawKey)
+	return l.counterQuotaExceeded(rawKey, endpoint.spec.QuotaMax, endpoint.spec.QuotaRenewalRate, store, opts)
+}
 
-	return sessionFailNone
+// counterQuotaExceeded counts a request against a quota that isn't kept
+// in a session. The counter expires when the quota renews, so there's no
+// renewal date to keep. That also means the reset time is only known at
+// the start of a quota period, the renewal rate is the most it can be.
+func (l *SessionLimiter) counterQuotaExceeded(rawKey string, quotaMax, renewalRate int64, store storage.Handler, opts limitOptions) (bool, *rateLimitState) {
+	cost := opts.requestCost()
+	qInt := incrementWithExpire(store, rawKey, cost, renewalRate)
+	state := &rateLimitState{
+		limit:     quotaMax,
+		remaining: quotaMax - qInt,
+		reset:     time.Now().Add(time.Duration(renewalRate) * time.Second),
+	}
+	if state.remaining < 0 {
+		state.remaining = 0
+	}
+	if qInt > quotaMax {
+		if !opts.force {
+			refundCost(store, rawKey, cost)
+		}
+		return true, state
+	}
+	return false, state
+}
 
+// refundCost takes the cost of a rejected request back off a quota
+// counter, so that a request too costly to go through doesn't use up
+// what is left for cheaper ones.
+func refundCost(store storage.Handler, keyName string, cost int64) {
+	if cost < 2 {
+		return
+	}
+	if incStore, ok := store.(incrementByStorage); ok {
+		incStore.IncrementByWithExpire(keyName, -cost, 0)
+	}
 }
 
-func (l *SessionLimiter) RedisQuotaExceeded(currentSession *user.SessionState, key string, store storage.Handler) bool {
+// RedisQuotaExceeded counts the request cost against the session quota.
+func (l *SessionLimiter) RedisQuotaExceeded(currentSession *user.SessionState, key string, store storage.Handler, opts limitOptions) bool {
 	// Are they unlimited?
 	if currentSession.QuotaMax == -1 {
 		// No quota set
@@ -148,12 +380,17 @@ func (l *SessionLimiter) RedisQuotaExceeded(currentSession *user.SessionState, k
 	log.Debug("[QUOTA] Inbound raw key is: ", key)
 	rawKey := QuotaKeyPrefix + storage.HashKey(key)
 	log.Debug("[QUOTA] Quota limiter key is: ", rawKey)
-	log.Debug("Renewing with TTL: ", currentSession.QuotaRenewalRate)
-	// INCR the key (If it equals 1 - set EXPIRE)
-	qInt := store.IncrememntWithExpire(rawKey, currentSession.QuotaRenewalRate)
-
-	// if the returned val is >= quota: block
-	if qInt-1 >= currentSession.QuotaMax {
+	// Scheduled quotas renew on the turn of the period, not a fixed
+	// time after the first request
+	now := time.Now()
+	periodLeft := currentSession.QuotaPeriodLeft(now)
+	log.Debug("Renewing with TTL: ", periodLeft)
+	// INCR the key (If it equals the cost - set EXPIRE)
+	cost := opts.requestCost()
+	qInt := incrementWithExpire(store, rawKey, cost, periodLeft)
+
+	// if the returned val is > quota: block
+	if qInt > currentSession.QuotaMax {
 		renewalDate := time.Unix(currentSession.QuotaRenews, 0)
 		log.Debug("Renewal Date is: ", renewalDate)
 		log.Debug("As epoch: ", currentSession.QuotaRenews)
@@ -164,18 +401,20 @@ func (l *SessionLimiter) RedisQuotaExceeded(currentSession *user.SessionState, k
 			// Also, this fixes legacy issues where there is no TTL on quota buckets
 			log.Warning("Incorrect key expiry setting detected, correcting")
 			go store.DeleteRawKey(rawKey)
-			qInt = 1
+			qInt = cost
 		} else {
 			// Renewal date is in the future and the quota is exceeded
+			if !opts.force {
+				refundCost(store, rawKey, cost)
+			}
 			return true
 		}
 
 	}
 
 	// If this is a new Quota period, ensure we let the end user know
-	if qInt == 1 {
-		current := time.Now().Unix()
-		currentSession.QuotaRenews = current + currentSession.QuotaRenewalRate
+	if qInt == cost {
+		currentSession.QuotaRenews = currentSession.NextQuotaRenewal(now).Unix()
 	}
 
 	// If not, pass and set the values of the session to quotamax - counter
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/TykTechnologies/tyk/apidef"
+)
+
+const (
+	soap11Namespace = "http://schemas.xmlsoap.org/soap/envelope/"
+	soap12Namespace = "http://www.w3.org/2003/05/soap-envelope"
+	xsiNamespace    = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+var xmlNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.\-]*$`)
+
+func soapNamespace(meta *apidef.SOAPMeta) string {
+	if meta.Version == "1.2" {
+		return soap12Namespace
+	}
+	return soap11Namespace
+}
+
+// soapEnvelope builds the SOAP request for an operation out of a JSON
+// object, with an element for each of its fields in the order they're
+// in, as SOAP services tend to want them in the order of their schema.
+func soapEnvelope(meta *apidef.SOAPMeta, body io.Reader) ([]byte, error) {
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, errors.New("request body must be a JSON object")
+	}
+
+	var buf bytes.Buffer
+	soapEnvelopeStart(&buf, meta)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeXMLValue(&buf, dec, t.(string)); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	soapEnvelopeEnd(&buf, meta)
+	return buf.Bytes(), nil
+}
+
+// soapEnvelopeFromQuery builds the SOAP request for an operation out of
+// query string parameters, for requests without a body.
+func soapEnvelopeFromQuery(meta *apidef.SOAPMeta, query url.Values) ([]byte, error) {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	soapEnvelopeStart(&buf, meta)
+	for _, name := range names {
+		if !xmlNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid element name %q", name)
+		}
+		for _, value := range query[name] {
+			writeXMLText(&buf, name, value)
+		}
+	}
+	soapEnvelopeEnd(&buf, meta)
+	return buf.Bytes(), nil
+}
+
+func soapEnvelopeStart(buf *bytes.Buffer, meta *apidef.SOAPMeta) {
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<soap:Envelope xmlns:soap="` + soapNamespace(meta) + `"><soap:Body><` + meta.Operation)
+	if meta.Namespace != "" {
+		buf.WriteString(` xmlns="`)
+		xml.EscapeText(buf, []byte(meta.Namespace))
+		buf.WriteString(`"`)
+	}
+	buf.WriteString(">")
+}
+
+func soapEnvelopeEnd(buf *bytes.Buffer, meta *apidef.SOAPMeta) {
+	buf.WriteString("</" + meta.Operation + "></soap:Body></soap:Envelope>")
+}
+
+func writeXMLText(buf *bytes.Buffer, name, text string) {
+	buf.WriteString("<" + name + ">")
+	xml.EscapeText(buf, []byte(text))
+	buf.WriteString("</" + name + ">")
+}
+
+// writeXMLValue writes the next JSON value out as the named element.
+// Arrays are written out as one element for each of their values.
+func writeXMLValue(buf *bytes.Buffer, dec *json.Decoder, name string) error {
+	if !xmlNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid element name %q", name)
+	}
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch v := t.(type) {
+	case json.Delim:
+		if v == '[' {
+			for dec.More() {
+				if err := writeXMLValue(buf, dec, name); err != nil {
+					return err
+				}
+			}
+			_, err := dec.Token()
+			return err
+		}
+		buf.WriteString("<" + name + ">")
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			if err := writeXMLValue(buf, dec, t.(string)); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		buf.WriteString("</" + name + ">")
+	case nil:
+		buf.WriteString("<" + name + "/>")
+	default:
+		writeXMLText(buf, name, fmt.Sprint(v))
+	}
+	return nil
+}
+
+// xmlNode is an element of a parsed XML document.
+type xmlNode struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*xmlNode
+	text     string
+}
+
+func parseXML(r io.Reader) (*xmlNode, error) {
+	dec := xml.NewDecoder(r)
+	dec.CharsetReader = WrappedCharsetReader
+	var stack []*xmlNode
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: t.Name, attrs: t.Attr}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			n := stack[len(stack)-1]
+			n.text = strings.TrimSpace(n.text)
+			if stack = stack[:len(stack)-1]; len(stack) == 0 {
+				return n, nil
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		}
+	}
+}
+
+func (n *xmlNode) child(local string) *xmlNode {
+	for _, c := range n.children {
+		if c.name.Local == local {
+			return c
+		}
+	}
+	return nil
+}
+
+// soapFault is a fault a SOAP service responded with.
+type soapFault struct {
+	Code   string
+	Reason string
+	Detail *xmlNode
+}
+
+// soapBody returns the element in a SOAP response's body, or the fault
+// in it if there is one.
+func soapBody(envelope *xmlNode) (*xmlNode, *soapFault, error) {
+	if envelope.name.Local != "Envelope" {
+		return nil, nil, errors.New("response is not a SOAP envelope")
+	}
+	body := envelope.child("Body")
+	if body == nil || len(body.children) == 0 {
+		return nil, nil, errors.New("SOAP response has no body")
+	}
+	content := body.children[0]
+	if content.name.Local != "Fault" || (content.name.Space != soap11Namespace && content.name.Space != soap12Namespace) {
+		return content, nil, nil
+	}
+
+	fault := &soapFault{}
+	if c := content.child("faultcode"); c != nil {
+		// SOAP 1.1
+		fault.Code = c.text
+		if r := content.child("faultstring"); r != nil {
+			fault.Reason = r.text
+		}
+		fault.Detail = content.child("detail")
+	} else {
+		if c := content.child("Code"); c != nil {
+			if v := c.child("Value"); v != nil {
+				fault.Code = v.text
+			}
+		}
+		if r := content.child("Reason"); r != nil {
+			if t := r.child("Text"); t != nil {
+				fault.Reason = t.text
+			}
+		}
+		fault.Detail = content.child("Detail")
+	}
+	if i := strings.LastIndex(fault.Code, ":"); i >= 0 {
+		fault.Code = fault.Code[i+1:]
+	}
+	return nil, fault, nil
+}
+
+// faultStatus is the status code clients get for a SOAP fault. Faults
+// blamed on the request are client errors unless set otherwise.
+func faultStatus(meta *apidef.SOAPMeta, code string) int {
+	if status, ok := meta.FaultCodes[code]; ok {
+		return status
+	}
+	switch code {
+	case "Client", "Sender":
+		return 400
+	}
+	return 500
+}
+
+// xmlToJSON turns XML elements into JSON values, following mxj in naming
+// attributes "-name" and text "#text" when an element has attributes.
+type xmlToJSON struct {
+	meta *apidef.SOAPMeta
+}
+
+func (x xmlToJSON) key(name xml.Name) string {
+	if prefix := x.meta.Namespaces[name.Space]; prefix != "" {
+		return prefix + ":" + name.Local
+	}
+	return name.Local
+}
+
+func (x xmlToJSON) isArray(key string) bool {
+	for _, name := range x.meta.ArrayElements {
+		if name == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (x xmlToJSON) value(n *xmlNode) interface{} {
+	obj := make(map[string]interface{})
+	for _, attr := range n.attrs {
+		switch {
+		case attr.Name.Space == "xmlns", attr.Name.Space == "" && attr.Name.Local == "xmlns":
+		case attr.Name.Space == xsiNamespace && attr.Name.Local == "nil":
+			if attr.Value == "true" {
+				return nil
+			}
+		default:
+			obj["-"+x.key(attr.Name)] = attr.Value
+		}
+	}
+	if len(n.children) == 0 && len(obj) == 0 {
+		return n.text
+	}
+
+	arrays := make(map[string]bool)
+	for _, c := range n.children {
+		key := x.key(c.name)
+		v := x.value(c)
+		switch existing, ok := obj[key]; {
+		case arrays[key]:
+			obj[key] = append(existing.([]interface{}), v)
+		case ok:
+			obj[key] = []interface{}{existing, v}
+			arrays[key] = true
+		case x.isArray(key):
+			obj[key] = []interface{}{v}
+			arrays[key] = true
+		default:
+			obj[key] = v
+		}
+	}
+	if n.text != "" {
+		obj["#text"] = n.text
+	}
+	return obj
+}
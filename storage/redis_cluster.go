@@ -246,6 +246,23 @@ func (r RedisCluster) IncrememntWithExpire(keyName string, expire int64) int64 {
 	return val
 }
 
+// IncrementByWithExpire adds by to a raw key, setting its expiry if the
+// key is new.
+func (r RedisCluster) IncrementByWithExpire(keyName string, by, expire int64) int64 {
+	log.Debug("Incrementing raw key: ", keyName, " by: ", by)
+	r.ensureConnection()
+	val, err := redis.Int64(r.singleton().Do("INCRBY", keyName, by))
+	log.Debug("Incremented key: ", keyName, ", val is: ", val)
+	if val == by {
+		log.Debug("--> Setting Expire")
+		r.singleton().Do("EXPIRE", keyName, expire)
+	}
+	if err != nil {
+		log.Error("Error trying to increment value:", err)
+	}
+	return val
+}
+
 // GetKeys will return all keys according to the filter (filter is a prefix - e.g. tyk.keys.*)
 func (r RedisCluster) GetKeys(filter string) []string {
 	r.ensureConnection()
@@ -547,10 +564,30 @@ func (r RedisCluster) IsMemberOfSet(keyName, value string) bool {
 
 // SetRollingWindow will append to a sorted set in redis and extract a timed window of values
 func (r RedisCluster) SetRollingWindow(keyName string, per int64, value_override string, pipeline bool) (int, []interface{}) {
+	now := time.Now()
+	member := value_override
+	if value_override == "-1" {
+		member = strconv.Itoa(int(now.UnixNano()))
+	}
+	return r.setRollingWindow(keyName, per, now, []interface{}{now.UnixNano(), member}, pipeline)
+}
+
+// SetRollingWindowCost is SetRollingWindow for a request that takes up
+// cost places in the window, all added with a single ZADD.
+func (r RedisCluster) SetRollingWindowCost(keyName string, per, cost int64, pipeline bool) (int, []interface{}) {
+	now := time.Now()
+	member := strconv.FormatInt(now.UnixNano(), 10)
+	members := []interface{}{now.UnixNano(), member}
+	for i := int64(1); i < cost; i++ {
+		members = append(members, now.UnixNano(), member+"-"+strconv.FormatInt(i, 10))
+	}
+	return r.setRollingWindow(keyName, per, now, members, pipeline)
+}
+
+func (r RedisCluster) setRollingWindow(keyName string, per int64, now time.Time, members []interface{}, pipeline bool) (int, []interface{}) {
 	log.Debug("Incrementing raw key: ", keyName)
 	r.ensureConnection()
 	log.Debug("keyName is: ", keyName)
-	now := time.Now()
 	log.Debug("Now is:", now)
 	onePeriodAgo := now.Add(time.Duration(-1*per) * time.Second)
 	log.Debug("Then is: ", onePeriodAgo)
@@ -565,12 +602,7 @@ func (r RedisCluster) SetRollingWindow(keyName string, per int64, value_override
 
 	ZADD := rediscluster.ClusterTransaction{}
 	ZADD.Cmd = "ZADD"
-
-	if value_override != "-1" {
-		ZADD.Args = []interface{}{keyName, now.UnixNano(), value_override}
-	} else {
-		ZADD.Args = []interface{}{keyName, now.UnixNano(), strconv.Itoa(int(now.UnixNano()))}
-	}
+	ZADD.Args = append([]interface{}{keyName}, members...)
 
 	EXPIRE := rediscluster.ClusterTransaction{}
 	EXPIRE.Cmd = "EXPIRE"
@@ -604,3 +636,181 @@ func (r RedisCluster) SetRollingWindow(keyName string, per int64, value_override
 
 	return intVal, redVal[1].([]interface{})
 }
+
+// gcraScript runs the generic cell rate algorithm on the theoretical
+// arrival time (TAT) stored in KEYS[1], in microseconds of server time.
+// ARGV holds the emission interval, the burst tolerance, the number of
+// requests to count, and whether to count them even if over the limit.
+var gcraScript = redis.NewScript(1, `
+local key = KEYS[1]
+local emission = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+local quantity = tonumber(ARGV[3])
+local force = ARGV[4] == "1"
+
+pcall(redis.replicate_commands)
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local tat = tonumber(redis.call("GET", key))
+if not tat or tat < now then
+	tat = now
+end
+
+local newTat = tat + emission * quantity
+local diff = now - (newTat - tolerance)
+local allowed = diff >= 0
+if (allowed or force) and newTat > now then
+	redis.call("SET", key, string.format("%.0f", newTat), "PX", math.ceil((newTat - now) / 1000))
+	tat = newTat
+end
+
+local remaining = 0
+if diff > 0 then
+	remaining = math.floor(diff / emission)
+end
+local retryAfter = 0
+if not allowed then
+	retryAfter = -diff
+end
+return {allowed and 1 or 0, remaining, retryAfter, tat - now}
+`)
+
+// GCRAResult is the outcome of counting requests against a GCRA limit.
+type GCRAResult struct {
+	Allowed   bool
+	Remaining int64
+	// RetryAfter is how long until the requests would be allowed.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the limit is fully replenished.
+	ResetAfter time.Duration
+}
+
+// GCRA counts quantity requests against a limit of rate requests per
+// per seconds, allowing bursts of up to rate requests, with a single
+// atomic script. If force is set, the requests are counted even if they
+// go over the limit, as is needed for requests that were already let
+// through elsewhere.
+func (r RedisCluster) GCRA(keyName string, rate, per float64, quantity int64, force bool) (GCRAResult, error) {
+	r.ensureConnection()
+	emission := per / rate * 1e6
+	forceArg := "0"
+	if force {
+		forceArg = "1"
+	}
+
+	// route by key, as cluster commands are routed by their first
+	// argument, which for scripts isn't the key
+	conn := r.singleton().HandleForKey(keyName).GetRedisConn()
+	defer conn.Close()
+	reply, err := redis.Values(gcraScript.Do(conn, keyName, int64(emission), int64(emission*rate), quantity, forceArg))
+	if err != nil {
+		log.Error("GCRA script failed: ", err)
+		return GCRAResult{}, err
+	}
+	if len(reply) < 4 {
+		return GCRAResult{}, errors.New("GCRA script returned too few values")
+	}
+	values := make([]int64, len(reply))
+	for i := range reply {
+		if values[i], err = redis.Int64(reply[i], nil); err != nil {
+			return GCRAResult{}, err
+		}
+	}
+	return GCRAResult{
+		Allowed:    values[0] == 1,
+		Remaining:  values[1],
+		RetryAfter: time.Duration(values[2]) * time.Microsecond,
+		ResetAfter: time.Duration(values[3]) * time.Microsecond,
+	}, nil
+}
+
+// leaseScript takes one of ARGV[2] slots in the sorted set of leases in
+// KEYS[1], for ARGV[3] milliseconds of server time. Leases are scored by
+// when they expire, so that slots held by a node which went away are
+// freed without it. ARGV[1] is the lease's own name.
+var leaseScript = redis.NewScript(1, `
+local key = KEYS[1]
+local lease = ARGV[1]
+local max = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+
+pcall(redis.replicate_commands)
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now)
+local held = redis.call("ZSCORE", key, lease)
+local count = redis.call("ZCARD", key)
+if not held and max >= 0 and count >= max then
+	return {0, count}
+end
+if not held then
+	count = count + 1
+end
+redis.call("ZADD", key, now + ttl, lease)
+redis.call("PEXPIRE", key, ttl)
+return {1, count}
+`)
+
+// AcquireLease takes one of max slots in the set of leases at keyName
+// for ttl, reporting whether there was one free. Acquiring a lease that
+// is already held renews it, whatever the number of slots taken.
+func (r RedisCluster) AcquireLease(keyName, lease string, max int64, ttl time.Duration) (bool, error) {
+	r.ensureConnection()
+	conn := r.singleton().HandleForKey(keyName).GetRedisConn()
+	defer conn.Close()
+	reply, err := redis.Values(leaseScript.Do(conn, keyName, lease, max, int64(ttl/time.Millisecond)))
+	if err != nil {
+		log.Error("Lease script failed: ", err)
+		return false, err
+	}
+	if len(reply) < 2 {
+		return false, errors.New("lease script returned too few values")
+	}
+	acquired, err := redis.Int64(reply[0], nil)
+	return acquired == 1, err
+}
+
+// ReleaseLease frees the slot held by a lease.
+func (r RedisCluster) ReleaseLease(keyName, lease string) {
+	r.ensureConnection()
+	if _, err := r.singleton().Do("ZREM", keyName, lease); err != nil {
+		log.Error("Error trying to release lease: ", err)
+	}
+}
+
+// AddToSortedSet adds value to the sorted set at keyName with score, or
+// updates its score if it's already in it.
+func (r RedisCluster) AddToSortedSet(keyName, value string, score float64) {
+	r.ensureConnection()
+	if _, err := r.singleton().Do("ZADD", keyName, score, value); err != nil {
+		log.Error("Error trying to add to sorted set: ", err)
+	}
+}
+
+// GetSortedSetRange returns the values in the sorted set at keyName with
+// scores from scoreFrom to scoreTo, lowest first. Either can be -inf or
+// +inf.
+func (r RedisCluster) GetSortedSetRange(keyName, scoreFrom, scoreTo string) ([]string, error) {
+	r.ensureConnection()
+	values, err := redis.Strings(r.singleton().Do("ZRANGEBYSCORE", keyName, scoreFrom, scoreTo))
+	if err != nil {
+		log.Error("Error trying to get sorted set range: ", err)
+		return nil, err
+	}
+	return values, nil
+}
+
+// RemoveFromSortedSet removes value from the sorted set at keyName,
+// reporting whether it was there. Only one of several callers removing
+// the same value gets true.
+func (r RedisCluster) RemoveFromSortedSet(keyName, value string) bool {
+	r.ensureConnection()
+	removed, err := redis.Int64(r.singleton().Do("ZREM", keyName, value))
+	if err != nil {
+		log.Error("Error trying to remove from sorted set: ", err)
+		return false
+	}
+	return removed == 1
+}
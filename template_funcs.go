@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// templateFuncs are the functions body transform templates can use, so
+// that simple reshaping doesn't need a plugin. Numbers in JSON bodies are
+// floats, so the math functions take and return floats, which templates
+// print without a fraction when they're whole.
+var templateFuncs = template.FuncMap{
+	// JSON
+	"jsonMarshal": func(v interface{}) (string, error) {
+		bs, err := json.Marshal(v)
+		return string(bs), err
+	},
+	"jsonUnmarshal": func(s string) (interface{}, error) {
+		var v interface{}
+		err := json.Unmarshal([]byte(s), &v)
+		return v, err
+	},
+
+	// strings
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"title":      strings.Title,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       templateJoin,
+	"repeat":     func(n int, s string) string { return strings.Repeat(s, n) },
+	"substr":     templateSubstr,
+	"toString":   func(v interface{}) string { return fmt.Sprint(v) },
+	"urlEncode":  url.QueryEscape,
+
+	// math
+	"add": func(a, b interface{}) (float64, error) {
+		return templateMath(a, b, func(x, y float64) float64 { return x + y })
+	},
+	"sub": func(a, b interface{}) (float64, error) {
+		return templateMath(a, b, func(x, y float64) float64 { return x - y })
+	},
+	"mul": func(a, b interface{}) (float64, error) {
+		return templateMath(a, b, func(x, y float64) float64 { return x * y })
+	},
+	"div": templateDiv,
+	"mod": func(a, b interface{}) (float64, error) { return templateMath(a, b, math.Mod) },
+	"max": func(a, b interface{}) (float64, error) { return templateMath(a, b, math.Max) },
+	"min": func(a, b interface{}) (float64, error) { return templateMath(a, b, math.Min) },
+	"round": func(v interface{}) (float64, error) {
+		return templateMath(v, 0, func(x, _ float64) float64 { return math.Floor(x + 0.5) })
+	},
+	"toNumber": func(v interface{}) (float64, error) {
+		return templateMath(v, 0, func(x, _ float64) float64 { return x })
+	},
+
+	// dates
+	"now":        time.Now,
+	"formatDate": templateFormatDate,
+	"unixTime": func(v interface{}) (int64, error) {
+		t, err := templateTime(v)
+		return t.Unix(), err
+	},
+
+	// encoding and hashing
+	"base64Encode": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"base64Decode": func(s string) (string, error) {
+		bs, err := base64.StdEncoding.DecodeString(s)
+		return string(bs), err
+	},
+	"md5":    func(s string) string { return templateHash(md5.New(), s) },
+	"sha1":   func(s string) string { return templateHash(sha1.New(), s) },
+	"sha256": func(s string) string { return templateHash(sha256.New(), s) },
+	"hmacSHA256": func(key, s string) string {
+		return templateHash(hmac.New(sha256.New, []byte(key)), s)
+	},
+
+	// defaults
+	"default":  func(def, v interface{}) interface{} { return templateCoalesce(v, def) },
+	"coalesce": templateCoalesce,
+	"empty":    templateEmpty,
+
+	"uuid": func() string { return uuid.NewV4().String() },
+}
+
+func templateJoin(sep string, v interface{}) string {
+	switch list := v.(type) {
+	case []string:
+		return strings.Join(list, sep)
+	case []interface{}:
+		parts := make([]string, len(list))
+		for i, item := range list {
+			parts[i] = fmt.Sprint(item)
+		}
+		return strings.Join(parts, sep)
+	}
+	return fmt.Sprint(v)
+}
+
+// templateSubstr returns the runes of s from start up to end, counting
+// from the end of s if end is negative.
+func templateSubstr(start, end int, s string) string {
+	runes := []rune(s)
+	if end < 0 {
+		end += len(runes) + 1
+	}
+	if end > len(runes) {
+		end = len(runes)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start >= end {
+		return ""
+	}
+	return string(runes[start:end])
+}
+
+func templateNumber(v interface{}) (float64, error) {
+	switch x := v.(type) {
+	case float64:
+		return x, nil
+	case json.Number:
+		return x.Float64()
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(x), 64)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.Float32:
+		return rv.Float(), nil
+	}
+	return 0, fmt.Errorf("not a number: %v", v)
+}
+
+func templateMath(a, b interface{}, op func(x, y float64) float64) (float64, error) {
+	x, err := templateNumber(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := templateNumber(b)
+	if err != nil {
+		return 0, err
+	}
+	return op(x, y), nil
+}
+
+func templateDiv(a, b interface{}) (float64, error) {
+	y, err := templateNumber(b)
+	if err != nil {
+		return 0, err
+	}
+	if y == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	return templateMath(a, y, func(x, y float64) float64 { return x / y })
+}
+
+// templateTime reads a time from a time.Time, Unix seconds or an RFC 3339
+// string.
+func templateTime(v interface{}) (time.Time, error) {
+	switch x := v.(type) {
+	case time.Time:
+		return x, nil
+	case string:
+		if t, err := time.Parse(time.RFC3339, x); err == nil {
+			return t, nil
+		}
+	}
+	secs, err := templateNumber(v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("not a time: %v", v)
+	}
+	return time.Unix(int64(secs), 0).UTC(), nil
+}
+
+// templateFormatDate formats a time with a Go time layout, or one of
+// "rfc3339", "rfc1123" and "unix".
+func templateFormatDate(layout string, v interface{}) (string, error) {
+	t, err := templateTime(v)
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(layout) {
+	case "rfc3339":
+		layout = time.RFC3339
+	case "rfc1123":
+		layout = time.RFC1123
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10), nil
+	}
+	return t.Format(layout), nil
+}
+
+func templateHash(h hash.Hash, s string) string {
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// templateEmpty reports whether a value is missing, or the zero value of
+// its type.
+func templateEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.String:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	}
+	return reflect.DeepEqual(v, reflect.Zero(rv.Type()).Interface())
+}
+
+// templateCoalesce returns the first of the values which isn't empty.
+func templateCoalesce(values ...interface{}) interface{} {
+	for _, v := range values {
+		if !templateEmpty(v) {
+			return v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	data := map[string]interface{}{
+		"name":    " Foo Bar ",
+		"price":   12.5,
+		"qty":     float64(3),
+		"tags":    []interface{}{"a", "b"},
+		"created": float64(1514764800),
+		"json":    `{"id":1}`,
+		"empty":   "",
+	}
+	tests := []struct {
+		tmpl, want string
+	}{
+		{`{{.name | trim | upper}}`, "FOO BAR"},
+		{`{{.name | trim | replace " " "_" | lower}}`, "foo_bar"},
+		{`{{join "," .tags}}`, "a,b"},
+		{`{{.name | trim | substr 0 3}}`, "Foo"},
+		{`{{mul .price .qty}}`, "37.5"},
+		{`{{add .qty 1}} {{sub .qty 1}} {{div .qty 2}} {{mod 7 .qty}}`, "4 2 1.5 1"},
+		{`{{round 2.5}} {{max .qty 5}} {{toNumber "4"}}`, "3 5 4"},
+		{`{{formatDate "rfc3339" .created}}`, "2018-01-01T00:00:00Z"},
+		{`{{formatDate "2006-01-02" "2018-01-01T10:00:00Z"}}`, "2018-01-01"},
+		{`{{with jsonUnmarshal .json}}{{.id}}{{end}}`, "1"},
+		{`{{jsonMarshal .tags}}`, `["a","b"]`},
+		{`{{base64Encode "tyk"}} {{base64Decode "dHlr"}}`, "dHlr tyk"},
+		{`{{sha256 "tyk"}}`, "371d680fb461df46ae80222ed70a271ede287e7396f63326acd31ae6e64b649b"},
+		{`{{.empty | default "none"}} {{.missing | default "none"}} {{.qty | default 0}}`, "none none 3"},
+		{`{{coalesce .empty .missing .name}}`, " Foo Bar "},
+		{`{{if empty .tags}}empty{{else}}full{{end}}`, "full"},
+	}
+	for _, tc := range tests {
+		tmpl, err := apiTemplate.New("").Parse(tc.tmpl)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.tmpl, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			t.Fatalf("%s: %v", tc.tmpl, err)
+		}
+		if got := buf.String(); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.tmpl, got, tc.want)
+		}
+	}
+
+	tmpl := template.Must(apiTemplate.New("").Parse(`{{uuid}} {{now | unixTime}}`))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Fields(buf.String())
+	if len(parts) != 2 || len(parts[0]) != 36 {
+		t.Fatalf("Wanted a UUID and a Unix time, got %q", buf.String())
+	}
+	if unix, _ := strconv.ParseInt(parts[1], 10, 64); time.Now().Unix()-unix > 1 {
+		t.Errorf("Wanted a UUID and the Unix time, got %q", buf.String())
+	}
+}
@@ -0,0 +1,49 @@
+// This is the Go plugin the goplugin driver's tests load.
+package main
+
+import (
+	"net/http"
+
+	"github.com/TykTechnologies/tyk/ctx"
+	"github.com/TykTechnologies/tyk/user"
+)
+
+// AddHeader tells the upstream which API the request came through.
+func AddHeader(w http.ResponseWriter, r *http.Request) {
+	r.Header.Set("X-Go-Plugin", ctx.GetDefinition(r).APIID)
+}
+
+// Respond handles the request itself.
+func Respond(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Go-Plugin", "responded")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("handled"))
+}
+
+// Auth lets in requests with the key "go-key".
+func Auth(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "go-key" {
+		return
+	}
+	ctx.SetSession(r, &user.SessionState{Rate: 1000, Per: 1, QuotaMax: -1}, "go-key")
+}
+
+// AddKey tells the upstream which key the request came with.
+func AddKey(w http.ResponseWriter, r *http.Request) {
+	if ctx.GetSession(r) != nil {
+		r.Header.Set("X-Go-Plugin-Key", ctx.GetAuthToken(r))
+	}
+}
+
+// Rewrite replaces the upstream's response.
+func Rewrite(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Go-Plugin", "rewritten")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("rewritten"))
+}
+
+func Panic(w http.ResponseWriter, r *http.Request) {
+	panic("oops")
+}
+
+var NotAHook = 1
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/itchyny/gojq"
+)
+
+// jqTimeout caps how long a jq transform may run for a request, so that
+// a runaway expression can't hold up the gateway.
+const jqTimeout = 5 * time.Second
+
+// jqVariables are the values a jq transform can use besides the body,
+// which it gets as its input. $_tyk_response is only set for response
+// transforms.
+var jqVariables = []string{"$_tyk_context", "$_tyk_meta", "$_tyk_response", "$_tyk_state"}
+
+func compileJQ(source string) (*gojq.Code, error) {
+	query, err := gojq.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	return gojq.Compile(query, gojq.WithVariables(jqVariables))
+}
+
+// jqValue turns a value into the plain JSON types jq works on, as the
+// context data and session meta data may hold any type.
+func jqValue(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	err = json.Unmarshal(b, &out)
+	return out, err
+}
+
+// applyJQ runs a jq transform on the body data and writes the first value
+// it yields out as JSON.
+func applyJQ(w io.Writer, code *gojq.Code, bodyData map[string]interface{}, vars map[string]interface{}) error {
+	input, err := jqValue(bodyData)
+	if err != nil {
+		return err
+	}
+	values := make([]interface{}, len(jqVariables))
+	for i, name := range jqVariables {
+		if values[i], err = jqValue(vars[name[1:]]); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jqTimeout)
+	defer cancel()
+	out, ok := code.RunWithContext(ctx, input, values...).Next()
+	if !ok {
+		return errors.New("jq transform yielded no value")
+	}
+	if err, ok := out.(error); ok {
+		return err
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,273 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/TykTechnologies/tyk/config"
+)
+
+const (
+	defaultBCryptCost    = 10
+	defaultArgon2Time    = 1
+	defaultArgon2Memory  = 64 * 1024 // KiB
+	defaultArgon2Threads = 4
+	defaultScryptN       = 32768
+	defaultScryptR       = 8
+	defaultScryptP       = 1
+
+	passwordSaltLen = 16
+	passwordKeyLen  = 32
+)
+
+var errMalformedHash = errors.New("malformed password hash")
+
+type argon2Params struct {
+	time, memory uint32
+	threads      uint8
+}
+
+type scryptParams struct {
+	n, r, p int
+}
+
+// validate checks the parameters argon2.IDKey would panic on.
+func (p argon2Params) validate() error {
+	if p.time < 1 || p.memory < 1 || p.threads < 1 {
+		return errors.New("argon2 parameters must be at least 1")
+	}
+	return nil
+}
+
+// validate applies the same limits as scrypt.Key.
+func (p scryptParams) validate() error {
+	const maxInt = int(^uint(0) >> 1)
+	if p.n <= 1 || p.n&(p.n-1) != 0 {
+		return errors.New("scrypt N must be a power of two greater than 1")
+	}
+	if p.r < 1 || p.p < 1 {
+		return errors.New("scrypt r and p must be at least 1")
+	}
+	if uint64(p.r)*uint64(p.p) >= 1<<30 || p.r > maxInt/128/p.p || p.r > maxInt/256 || p.n > maxInt/128/p.r {
+		return errors.New("scrypt parameters are too large")
+	}
+	return nil
+}
+
+func currentArgon2Params() argon2Params {
+	conf := config.Global.BasicAuthHashing
+	p := argon2Params{defaultArgon2Time, defaultArgon2Memory, defaultArgon2Threads}
+	if conf.Argon2Time > 0 {
+		p.time = conf.Argon2Time
+	}
+	if conf.Argon2Memory > 0 {
+		p.memory = conf.Argon2Memory
+	}
+	if conf.Argon2Threads > 0 {
+		p.threads = conf.Argon2Threads
+	}
+	return p
+}
+
+func currentScryptParams() scryptParams {
+	return confScryptParams(config.Global.BasicAuthHashing)
+}
+
+func confScryptParams(conf config.BasicAuthHashConf) scryptParams {
+	p := scryptParams{defaultScryptN, defaultScryptR, defaultScryptP}
+	if conf.ScryptN > 0 {
+		p.n = conf.ScryptN
+	}
+	if conf.ScryptR > 0 {
+		p.r = conf.ScryptR
+	}
+	if conf.ScryptP > 0 {
+		p.p = conf.ScryptP
+	}
+	return p
+}
+
+func currentBCryptCost() int {
+	if cost := config.Global.BasicAuthHashing.BCryptCost; cost > 0 {
+		return cost
+	}
+	return defaultBCryptCost
+}
+
+// DefaultHashType is the hash type new passwords are stored with.
+func DefaultHashType() HashType {
+	switch t := HashType(config.Global.BasicAuthHashing.HashType); t {
+	case HashArgon2ID, HashScrypt:
+		return t
+	}
+	return HashBCrypt
+}
+
+// ValidateHashConf checks that passwords can be hashed with the given
+// settings, so that a bad config is caught when it's loaded rather
+// than when a key is saved.
+func ValidateHashConf(conf config.BasicAuthHashConf) error {
+	switch HashType(conf.HashType) {
+	case HashPlainText, HashBCrypt, HashArgon2ID, HashScrypt:
+	default:
+		return fmt.Errorf("unknown hash type %q", conf.HashType)
+	}
+	if conf.BCryptCost != 0 && (conf.BCryptCost < bcrypt.MinCost || conf.BCryptCost > bcrypt.MaxCost) {
+		return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if HashType(conf.HashType) != HashScrypt {
+		return nil
+	}
+	if conf.ScryptN < 0 || conf.ScryptR < 0 || conf.ScryptP < 0 {
+		return errors.New("scrypt parameters must not be negative")
+	}
+	return confScryptParams(conf).validate()
+}
+
+// HashPassword hashes a password with the given hash type, using the
+// cost parameters from the global config. The parameters are stored
+// along with the hash, so changing them doesn't break existing ones.
+func HashPassword(hashType HashType, password string) (string, error) {
+	switch hashType {
+	case HashPlainText:
+		return password, nil
+	case HashBCrypt:
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), currentBCryptCost())
+		return string(hashed), err
+	}
+
+	salt := make([]byte, passwordSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	switch hashType {
+	case HashArgon2ID:
+		p := currentArgon2Params()
+		key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, passwordKeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+			p.memory, p.time, p.threads, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+	case HashScrypt:
+		p := currentScryptParams()
+		key, err := scrypt.Key([]byte(password), salt, p.n, p.r, p.p, passwordKeyLen)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("$scrypt$n=%d,r=%d,p=%d$%s$%s",
+			p.n, p.r, p.p, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+	}
+	return "", fmt.Errorf("unknown hash type %q", hashType)
+}
+
+// splitHash splits a "$type$[v=..$]params$salt$key" hash into its
+// parameters, salt and key.
+func splitHash(hashed, prefix string) (params string, salt, key []byte, err error) {
+	if !strings.HasPrefix(hashed, prefix) {
+		return "", nil, nil, errMalformedHash
+	}
+	parts := strings.Split(strings.TrimPrefix(hashed, prefix), "$")
+	if len(parts) < 3 {
+		return "", nil, nil, errMalformedHash
+	}
+	n := len(parts)
+	enc := base64.RawStdEncoding
+	if salt, err = enc.DecodeString(parts[n-2]); err != nil {
+		return "", nil, nil, errMalformedHash
+	}
+	// an empty key would match any password
+	if key, err = enc.DecodeString(parts[n-1]); err != nil || len(key) != passwordKeyLen {
+		return "", nil, nil, errMalformedHash
+	}
+	return strings.Join(parts[:n-2], "$"), salt, key, nil
+}
+
+func parseArgon2Hash(hashed string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+	params, salt, key, err := splitHash(hashed, "$argon2id$")
+	if err != nil {
+		return p, nil, nil, err
+	}
+	var version int
+	if _, err := fmt.Sscanf(params, "v=%d$m=%d,t=%d,p=%d", &version, &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, errMalformedHash
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	if err := p.validate(); err != nil {
+		return p, nil, nil, err
+	}
+	return p, salt, key, nil
+}
+
+func parseScryptHash(hashed string) (scryptParams, []byte, []byte, error) {
+	var p scryptParams
+	params, salt, key, err := splitHash(hashed, "$scrypt$")
+	if err != nil {
+		return p, nil, nil, err
+	}
+	if _, err := fmt.Sscanf(params, "n=%d,r=%d,p=%d", &p.n, &p.r, &p.p); err != nil {
+		return p, nil, nil, errMalformedHash
+	}
+	if err := p.validate(); err != nil {
+		return p, nil, nil, err
+	}
+	return p, salt, key, nil
+}
+
+// CheckPassword reports whether a password matches the stored one.
+func CheckPassword(hashType HashType, hashed, password string) bool {
+	switch hashType {
+	case HashPlainText:
+		return subtle.ConstantTimeCompare([]byte(hashed), []byte(password)) == 1
+	case HashBCrypt:
+		return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)) == nil
+	case HashArgon2ID:
+		p, salt, key, err := parseArgon2Hash(hashed)
+		if err != nil {
+			log.Error("Could not check argon2id password: ", err)
+			return false
+		}
+		got := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(got, key) == 1
+	case HashScrypt:
+		p, salt, key, err := parseScryptHash(hashed)
+		if err != nil {
+			log.Error("Could not check scrypt password: ", err)
+			return false
+		}
+		got, err := scrypt.Key([]byte(password), salt, p.n, p.r, p.p, len(key))
+		return err == nil && subtle.ConstantTimeCompare(got, key) == 1
+	}
+	return false
+}
+
+// NeedsRehash reports whether a stored password uses a different hash
+// type or weaker cost parameters than new passwords would get.
+func NeedsRehash(hashType HashType, hashed string) bool {
+	if hashType != DefaultHashType() {
+		return true
+	}
+	switch hashType {
+	case HashBCrypt:
+		cost, err := bcrypt.Cost([]byte(hashed))
+		return err == nil && cost < currentBCryptCost()
+	case HashArgon2ID:
+		p, _, _, err := parseArgon2Hash(hashed)
+		cur := currentArgon2Params()
+		return err == nil && (p.time < cur.time || p.memory < cur.memory || p.threads != cur.threads)
+	case HashScrypt:
+		p, _, _, err := parseScryptHash(hashed)
+		cur := currentScryptParams()
+		return err == nil && (p.n < cur.n || p.r < cur.r || p.p < cur.p)
+	}
+	return false
+}
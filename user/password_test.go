@@ -0,0 +1,136 @@
+package user
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/TykTechnologies/tyk/config"
+)
+
+// fastHashConf keeps the hashes cheap enough for tests.
+func fastHashConf(hashType HashType) config.BasicAuthHashConf {
+	return config.BasicAuthHashConf{
+		HashType:     string(hashType),
+		BCryptCost:   bcrypt.MinCost,
+		Argon2Memory: 1024,
+		ScryptN:      1024,
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	defer func() { config.Global.BasicAuthHashing = config.BasicAuthHashConf{} }()
+
+	prefixes := map[HashType]string{
+		HashBCrypt:   "$2a$",
+		HashArgon2ID: "$argon2id$",
+		HashScrypt:   "$scrypt$",
+	}
+	for hashType, prefix := range prefixes {
+		config.Global.BasicAuthHashing = fastHashConf(hashType)
+		if got := DefaultHashType(); got != hashType {
+			t.Fatalf("Wanted default hash type %q, got %q", hashType, got)
+		}
+		hashed, err := HashPassword(hashType, "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(hashed, prefix) {
+			t.Errorf("%s hash should start with %q, got %q", hashType, prefix, hashed)
+		}
+		if !CheckPassword(hashType, hashed, "secret") {
+			t.Errorf("%s hash should match its password", hashType)
+		}
+		if CheckPassword(hashType, hashed, "WRONG") {
+			t.Errorf("%s hash should not match a wrong password", hashType)
+		}
+		if NeedsRehash(hashType, hashed) {
+			t.Errorf("%s hash with the current settings should not need a rehash", hashType)
+		}
+	}
+
+	config.Global.BasicAuthHashing = fastHashConf(HashScrypt)
+	config.Global.BasicAuthHashing.ScryptN = 1000
+	if hashed, err := HashPassword(HashScrypt, "secret"); err == nil {
+		t.Errorf("Hashing with a bad scrypt N should fail, got %q", hashed)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	defer func() { config.Global.BasicAuthHashing = config.BasicAuthHashConf{} }()
+
+	config.Global.BasicAuthHashing = fastHashConf(HashScrypt)
+	weak, _ := HashPassword(HashScrypt, "secret")
+	config.Global.BasicAuthHashing.ScryptN = 2048
+	if !NeedsRehash(HashScrypt, weak) {
+		t.Error("Hash with a lower scrypt N should need a rehash")
+	}
+	if !NeedsRehash(HashPlainText, "secret") {
+		t.Error("Plain text password should need a rehash")
+	}
+
+	config.Global.BasicAuthHashing.HashType = "not-a-hash"
+	if got := DefaultHashType(); got != HashBCrypt {
+		t.Errorf("Unknown hash type should fall back to bcrypt, got %q", got)
+	}
+}
+
+func TestValidateHashConf(t *testing.T) {
+	tests := []struct {
+		name  string
+		conf  config.BasicAuthHashConf
+		valid bool
+	}{
+		{"Default", config.BasicAuthHashConf{}, true},
+		{"BCrypt", fastHashConf(HashBCrypt), true},
+		{"Argon2ID", fastHashConf(HashArgon2ID), true},
+		{"Scrypt", fastHashConf(HashScrypt), true},
+		{"UnknownType", config.BasicAuthHashConf{HashType: "scrypt2"}, false},
+		{"BCryptCostTooHigh", config.BasicAuthHashConf{BCryptCost: bcrypt.MaxCost + 1}, false},
+		{"ScryptNNotPowerOfTwo", config.BasicAuthHashConf{HashType: "scrypt", ScryptN: 1000}, false},
+		{"ScryptNegative", config.BasicAuthHashConf{HashType: "scrypt", ScryptR: -1}, false},
+		{"ScryptTooLarge", config.BasicAuthHashConf{HashType: "scrypt", ScryptR: 1 << 15, ScryptP: 1 << 15}, false},
+		// only checked when scrypt is used
+		{"UnusedScrypt", config.BasicAuthHashConf{HashType: "argon2id", ScryptN: 1000}, true},
+	}
+	for _, tc := range tests {
+		err := ValidateHashConf(tc.conf)
+		if tc.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: wanted an error", tc.name)
+		}
+	}
+}
+
+func TestCheckMalformedHash(t *testing.T) {
+	const (
+		salt = "c2FsdHNhbHRzYWx0c2FsdA"                      // 16 bytes
+		key  = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA" // 32 bytes
+	)
+	tests := []struct {
+		name     string
+		hashType HashType
+		hashed   string
+	}{
+		{"ScryptEmptyKey", HashScrypt, "$scrypt$n=16384,r=8,p=1$" + salt + "$"},
+		{"ScryptShortKey", HashScrypt, "$scrypt$n=16384,r=8,p=1$" + salt + "$" + salt},
+		{"ScryptNOne", HashScrypt, "$scrypt$n=1,r=8,p=1$" + salt + "$" + key},
+		{"ScryptNNotPowerOfTwo", HashScrypt, "$scrypt$n=1000,r=8,p=1$" + salt + "$" + key},
+		{"ScryptZeroR", HashScrypt, "$scrypt$n=16384,r=0,p=1$" + salt + "$" + key},
+		{"ScryptZeroP", HashScrypt, "$scrypt$n=16384,r=8,p=0$" + salt + "$" + key},
+		{"Argon2EmptyKey", HashArgon2ID, "$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$"},
+		{"Argon2ZeroTime", HashArgon2ID, "$argon2id$v=19$m=1024,t=0,p=1$" + salt + "$" + key},
+		{"Argon2ZeroMemory", HashArgon2ID, "$argon2id$v=19$m=0,t=1,p=1$" + salt + "$" + key},
+		{"Argon2ZeroThreads", HashArgon2ID, "$argon2id$v=19$m=1024,t=1,p=0$" + salt + "$" + key},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if CheckPassword(tc.hashType, tc.hashed, "anything") {
+				t.Error("Malformed hash should not match any password")
+			}
+		})
+	}
+}
@@ -10,6 +10,9 @@ type Policy struct {
 	Per              float64                     `bson:"per" json:"per"`
 	QuotaMax         int64                       `bson:"quota_max" json:"quota_max"`
 	QuotaRenewalRate int64                       `bson:"quota_renewal_rate" json:"quota_renewal_rate"`
+	QuotaSchedule    QuotaSchedule               `bson:"quota_schedule" json:"quota_schedule"`
+	QuotaTimezone    string                      `bson:"quota_timezone" json:"quota_timezone"`
+	MaxInFlight      int64                       `bson:"max_in_flight" json:"max_in_flight"`
 	AccessRights     map[string]AccessDefinition `bson:"access_rights" json:"access_rights"`
 	HMACEnabled      bool                        `bson:"hmac_enabled" json:"hmac_enabled"`
 	Active           bool                        `bson:"active" json:"active"`
@@ -0,0 +1,79 @@
+package user
+
+import (
+	"sync"
+	"time"
+)
+
+// QuotaSchedule sets when a quota renews. The default renews it
+// QuotaRenewalRate seconds after the first request of each period, the
+// others on the turn of the hour, day, week (on Monday) or month in the
+// key's QuotaTimezone, so that all keys renew together.
+type QuotaSchedule string
+
+const (
+	QuotaScheduleRolling QuotaSchedule = ""
+	QuotaScheduleHourly  QuotaSchedule = "hourly"
+	QuotaScheduleDaily   QuotaSchedule = "daily"
+	QuotaScheduleWeekly  QuotaSchedule = "weekly"
+	QuotaScheduleMonthly QuotaSchedule = "monthly"
+)
+
+var (
+	quotaLocationsMu sync.Mutex
+	quotaLocations   = map[string]*time.Location{}
+)
+
+// quotaLocation loads a timezone once, as loading reads it from disk.
+// Unknown timezones fall back to UTC.
+func quotaLocation(name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+	quotaLocationsMu.Lock()
+	defer quotaLocationsMu.Unlock()
+	if loc, ok := quotaLocations[name]; ok {
+		return loc
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Warning("Unknown quota timezone ", name, ", using UTC: ", err)
+		loc = time.UTC
+	}
+	quotaLocations[name] = loc
+	return loc
+}
+
+// NextQuotaRenewal returns when a quota period starting at now renews.
+func (s *SessionState) NextQuotaRenewal(now time.Time) time.Time {
+	t := now.In(quotaLocation(s.QuotaTimezone))
+	year, month, day := t.Date()
+	switch s.QuotaSchedule {
+	case QuotaScheduleHourly:
+		return time.Date(year, month, day, t.Hour()+1, 0, 0, 0, t.Location())
+	case QuotaScheduleDaily:
+		return time.Date(year, month, day+1, 0, 0, 0, 0, t.Location())
+	case QuotaScheduleWeekly:
+		days := (8 - int(t.Weekday())) % 7
+		if days == 0 {
+			days = 7
+		}
+		return time.Date(year, month, day+days, 0, 0, 0, 0, t.Location())
+	case QuotaScheduleMonthly:
+		return time.Date(year, month+1, 1, 0, 0, 0, 0, t.Location())
+	}
+	return now.Add(time.Duration(s.QuotaRenewalRate) * time.Second)
+}
+
+// QuotaPeriodLeft returns how many seconds are left of a quota period
+// starting at now, which is at least one.
+func (s *SessionState) QuotaPeriodLeft(now time.Time) int64 {
+	if s.QuotaSchedule == QuotaScheduleRolling {
+		return s.QuotaRenewalRate
+	}
+	left := int64(s.NextQuotaRenewal(now).Sub(now) / time.Second)
+	if left < 1 {
+		left = 1
+	}
+	return left
+}
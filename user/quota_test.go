@@ -0,0 +1,38 @@
+package user
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextQuotaRenewal(t *testing.T) {
+	// a Wednesday afternoon in New York, which is a Thursday in UTC
+	now := time.Date(2018, time.January, 31, 22, 30, 15, 0, time.UTC)
+	tests := []struct {
+		schedule QuotaSchedule
+		timezone string
+		want     string
+	}{
+		{QuotaScheduleRolling, "", "2018-01-31T23:30:15Z"},
+		{QuotaScheduleHourly, "", "2018-01-31T23:00:00Z"},
+		{QuotaScheduleHourly, "Asia/Kolkata", "2018-02-01T05:00:00+05:30"},
+		{QuotaScheduleDaily, "", "2018-02-01T00:00:00Z"},
+		{QuotaScheduleDaily, "America/New_York", "2018-02-01T00:00:00-05:00"},
+		{QuotaScheduleWeekly, "", "2018-02-05T00:00:00Z"},
+		{QuotaScheduleMonthly, "", "2018-02-01T00:00:00Z"},
+		{QuotaScheduleMonthly, "America/New_York", "2018-02-01T00:00:00-05:00"},
+		{QuotaScheduleMonthly, "Not/AZone", "2018-02-01T00:00:00Z"},
+	}
+	for _, tc := range tests {
+		s := &SessionState{QuotaRenewalRate: 3600, QuotaSchedule: tc.schedule, QuotaTimezone: tc.timezone}
+		if got := s.NextQuotaRenewal(now).Format(time.RFC3339); got != tc.want {
+			t.Errorf("%q in %q: wanted %s, got %s", tc.schedule, tc.timezone, tc.want, got)
+		}
+	}
+
+	s := &SessionState{QuotaSchedule: QuotaScheduleWeekly}
+	monday := time.Date(2018, time.February, 5, 0, 0, 0, 0, time.UTC)
+	if got := s.NextQuotaRenewal(monday); !got.Equal(monday.AddDate(0, 0, 7)) {
+		t.Error("Wanted a week's quota from the start of Monday, got", got)
+	}
+}
@@ -17,12 +17,33 @@ type HashType string
 const (
 	HashPlainText HashType = ""
 	HashBCrypt    HashType = "bcrypt"
+	HashArgon2ID  HashType = "argon2id"
+	HashScrypt    HashType = "scrypt"
 )
 
 // AccessSpecs define what URLS a user has access to an what methods are enabled
+//
+// An access spec can also carry its own rate limit and quota, which
+// requests to the endpoint are held to on top of the session's own
+// limits. A zero Rate or QuotaMax means the endpoint has no limit of
+// its own.
 type AccessSpec struct {
-	URL     string   `json:"url" msg:"url"`
-	Methods []string `json:"methods" msg:"methods"`
+	URL              string   `json:"url" msg:"url"`
+	Methods          []string `json:"methods" msg:"methods"`
+	Rate             float64  `json:"rate,omitempty" msg:"rate,omitempty"`
+	Per              float64  `json:"per,omitempty" msg:"per,omitempty"`
+	QuotaMax         int64    `json:"quota_max,omitempty" msg:"quota_max,omitempty"`
+	QuotaRenewalRate int64    `json:"quota_renewal_rate,omitempty" msg:"quota_renewal_rate,omitempty"`
+}
+
+// HasRateLimit reports whether the endpoint has a rate limit of its own.
+func (a *AccessSpec) HasRateLimit() bool {
+	return a.Rate > 0 && a.Per > 0
+}
+
+// HasQuota reports whether the endpoint has a quota of its own.
+func (a *AccessSpec) HasQuota() bool {
+	return a.QuotaMax > 0 && a.QuotaRenewalRate > 0
 }
 
 // AccessDefinition defines which versions of an API a key has access to
@@ -44,6 +65,9 @@ type SessionState struct {
 	QuotaRenews      int64                       `json:"quota_renews" msg:"quota_renews"`
 	QuotaRemaining   int64                       `json:"quota_remaining" msg:"quota_remaining"`
 	QuotaRenewalRate int64                       `json:"quota_renewal_rate" msg:"quota_renewal_rate"`
+	QuotaSchedule    QuotaSchedule               `json:"quota_schedule" msg:"quota_schedule"`
+	QuotaTimezone    string                      `json:"quota_timezone" msg:"quota_timezone"`
+	MaxInFlight      int64                       `json:"max_in_flight" msg:"max_in_flight"`
 	AccessRights     map[string]AccessDefinition `json:"access_rights" msg:"access_rights"`
 	OrgID            string                      `json:"org_id" msg:"org_id"`
 	OauthClientID    string                      `json:"oauth_client_id" msg:"oauth_client_id"`
@@ -0,0 +1,470 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	maxPages  = 65536
+	maxLocals = 50000
+)
+
+// instr is a compiled instruction. Immediates are decoded up front, and
+// branches carry the pc they jump to and how much of the operand stack
+// they keep, so that running a function doesn't have to look for the end
+// of blocks.
+type instr struct {
+	op uint16
+	a  uint32 // index, or branch arity
+	b  uint32 // branch height
+	c  uint64 // constant, memory offset, or branch target
+}
+
+type branch struct {
+	target int
+	height int
+	arity  int
+}
+
+// opcodes as in the binary format; the 0xfc prefixed ones are numbered
+// from 0x100.
+const (
+	opUnreachable  = 0x00
+	opNop          = 0x01
+	opBlock        = 0x02
+	opLoop         = 0x03
+	opIf           = 0x04
+	opElse         = 0x05
+	opEnd          = 0x0b
+	opBr           = 0x0c
+	opBrIf         = 0x0d
+	opBrTable      = 0x0e
+	opReturn       = 0x0f
+	opCall         = 0x10
+	opCallIndirect = 0x11
+	opDrop         = 0x1a
+	opSelect       = 0x1b
+	opSelectT      = 0x1c
+	opLocalGet     = 0x20
+	opLocalSet     = 0x21
+	opLocalTee     = 0x22
+	opGlobalGet    = 0x23
+	opGlobalSet    = 0x24
+	opI32Load      = 0x28
+	opI64Load32U   = 0x35
+	opI32Store     = 0x36
+	opI64Store32   = 0x3e
+	opMemorySize   = 0x3f
+	opMemoryGrow   = 0x40
+	opI32Const     = 0x41
+	opI64Const     = 0x42
+	opF32Const     = 0x43
+	opF64Const     = 0x44
+	opRefNull      = 0xd0
+	opRefIsNull    = 0xd1
+	opRefFunc      = 0xd2
+	opPrefix       = 0xfc
+
+	opTruncSatFirst = 0x100
+	opTruncSatLast  = 0x107
+	opMemoryInit    = 0x108
+	opDataDrop      = 0x109
+	opMemoryCopy    = 0x10a
+	opMemoryFill    = 0x10b
+
+	// opJump is an internal unconditional jump that leaves the
+	// operand stack alone, used at the else of an if.
+	opJump = 0x200
+)
+
+type fixup struct {
+	instr        int // -1 for a br_table entry
+	table, entry int
+}
+
+type ctrl struct {
+	op              byte
+	start           int
+	height          int
+	params, results int
+	ifInstr         int
+	fixups          []fixup
+	unreachable     bool
+}
+
+func (c *ctrl) arity() int {
+	if c.op == opLoop {
+		return c.params
+	}
+	return c.results
+}
+
+type compiler struct {
+	m      *Module
+	f      *function
+	r      *reader
+	code   []instr
+	frames []ctrl
+	height int
+}
+
+var errUnderflow = errors.New("operand stack underflow")
+
+func (c *compiler) top() *ctrl { return &c.frames[len(c.frames)-1] }
+
+func (c *compiler) pop(n int) {
+	fr := c.top()
+	if c.height-n < fr.height {
+		if !fr.unreachable {
+			panic(decodeError{errUnderflow.Error()})
+		}
+		c.height = fr.height
+		return
+	}
+	c.height -= n
+}
+
+func (c *compiler) push(n int) {
+	c.height += n
+	if c.height > c.f.maxOps {
+		c.f.maxOps = c.height
+	}
+}
+
+func (c *compiler) emit(in instr) int {
+	c.code = append(c.code, in)
+	return len(c.code) - 1
+}
+
+func (c *compiler) setUnreachable() {
+	fr := c.top()
+	fr.unreachable = true
+	c.height = fr.height
+}
+
+// branchTo emits a branch to the label at depth, to be patched once the
+// label's end is known if it's a forward branch.
+func (c *compiler) branchTo(op uint16, depth uint32) {
+	if int(depth) >= len(c.frames) {
+		fail("unknown label %d", depth)
+	}
+	fr := &c.frames[len(c.frames)-1-int(depth)]
+	c.pop(fr.arity())
+	if op == opBrIf {
+		c.push(fr.arity())
+	}
+	i := c.emit(instr{op: op, a: uint32(fr.arity()), b: uint32(fr.height)})
+	if fr.op == opLoop {
+		c.code[i].c = uint64(fr.start)
+	} else {
+		fr.fixups = append(fr.fixups, fixup{instr: i})
+	}
+}
+
+func (c *compiler) blockType() (params, results int) {
+	if c.r.eof() {
+		fail("unexpected end")
+	}
+	switch b := c.r.b[c.r.pos]; {
+	case b == 0x40:
+		c.r.pos++
+		return 0, 0
+	case b >= 0x6f && b <= 0x7f:
+		c.r.valueType()
+		return 0, 1
+	}
+	i := c.r.sleb(33)
+	if i < 0 {
+		fail("bad block type")
+	}
+	t := c.m.typeAt(uint32(i))
+	return len(t.Params), len(t.Results)
+}
+
+func (c *compiler) memarg() uint64 {
+	if c.m.memory == nil {
+		fail("unknown memory")
+	}
+	c.r.u32() // alignment
+	return uint64(c.r.u32())
+}
+
+func (c *compiler) local() uint32 {
+	i := c.r.u32()
+	if int(i) >= len(c.f.typ.Params)+c.f.nlocals {
+		fail("unknown local %d", i)
+	}
+	return i
+}
+
+func (m *Module) compile(f *function) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			de, ok := r.(decodeError)
+			if !ok {
+				panic(r)
+			}
+			err = errors.New(de.msg)
+		}
+	}()
+	c := &compiler{m: m, f: f, r: &reader{b: f.body}}
+	c.frames = []ctrl{{op: opBlock, results: len(f.typ.Results), ifInstr: -1}}
+	for len(c.frames) > 0 {
+		c.instr()
+	}
+	if !c.r.eof() {
+		fail("code after the end of the function")
+	}
+	f.code = c.code
+	return nil
+}
+
+func (c *compiler) instr() {
+	r := c.r
+	op := r.byte()
+	switch {
+	case op == opUnreachable:
+		c.emit(instr{op: opUnreachable})
+		c.setUnreachable()
+	case op == opNop:
+	case op == opBlock, op == opLoop, op == opIf:
+		if op == opIf {
+			c.pop(1)
+		}
+		params, results := c.blockType()
+		c.pop(params)
+		fr := ctrl{
+			op: op, start: len(c.code), height: c.height,
+			params: params, results: results, ifInstr: -1,
+		}
+		if op == opIf {
+			fr.ifInstr = c.emit(instr{op: opIf})
+		}
+		c.push(params)
+		c.frames = append(c.frames, fr)
+	case op == opElse:
+		fr := c.top()
+		if fr.op != opIf || fr.ifInstr < 0 {
+			fail("else without if")
+		}
+		c.endCheck(fr)
+		fr.fixups = append(fr.fixups, fixup{instr: c.emit(instr{op: opJump})})
+		c.code[fr.ifInstr].c = uint64(len(c.code))
+		fr.ifInstr = -1
+		fr.unreachable = false
+		c.height = fr.height
+		c.push(fr.params)
+	case op == opEnd:
+		fr := c.top()
+		c.endCheck(fr)
+		if fr.ifInstr >= 0 {
+			if fr.params != fr.results {
+				fail("if without else must leave its parameters")
+			}
+			c.code[fr.ifInstr].c = uint64(len(c.code))
+		}
+		end := len(c.code)
+		for _, fx := range fr.fixups {
+			if fx.instr >= 0 {
+				c.code[fx.instr].c = uint64(end)
+			} else {
+				c.f.tables[fx.table][fx.entry].target = end
+			}
+		}
+		c.height = fr.height
+		results := fr.results
+		c.frames = c.frames[:len(c.frames)-1]
+		if len(c.frames) == 0 {
+			c.emit(instr{op: opReturn, a: uint32(results)})
+			return
+		}
+		c.push(results)
+	case op == opBr:
+		c.branchTo(opBr, r.u32())
+		c.setUnreachable()
+	case op == opBrIf:
+		c.pop(1)
+		c.branchTo(opBrIf, r.u32())
+	case op == opBrTable:
+		c.pop(1)
+		n := r.count()
+		ti := len(c.f.tables)
+		table := make([]branch, 0, n+1)
+		for k := uint32(0); k <= n; k++ {
+			depth := r.u32()
+			if int(depth) >= len(c.frames) {
+				fail("unknown label %d", depth)
+			}
+			fr := &c.frames[len(c.frames)-1-int(depth)]
+			if k > 0 && fr.arity() != table[0].arity {
+				fail("br_table labels have different arities")
+			}
+			table = append(table, branch{target: fr.start, height: fr.height, arity: fr.arity()})
+			if fr.op != opLoop {
+				fr.fixups = append(fr.fixups, fixup{instr: -1, table: ti, entry: int(k)})
+			}
+		}
+		c.pop(table[0].arity)
+		c.f.tables = append(c.f.tables, table)
+		c.emit(instr{op: opBrTable, a: uint32(ti)})
+		c.setUnreachable()
+	case op == opReturn:
+		c.pop(len(c.f.typ.Results))
+		c.emit(instr{op: opReturn, a: uint32(len(c.f.typ.Results))})
+		c.setUnreachable()
+	case op == opCall:
+		fi := r.u32()
+		if int(fi) >= c.m.numFuncs() {
+			fail("unknown function %d", fi)
+		}
+		t := c.m.funcType(fi)
+		c.pop(len(t.Params))
+		c.emit(instr{op: opCall, a: fi})
+		c.push(len(t.Results))
+	case op == opCallIndirect:
+		ti := r.u32()
+		t := c.m.typeAt(ti)
+		if r.u32() != 0 || c.m.table == nil {
+			fail("unknown table")
+		}
+		c.pop(1 + len(t.Params))
+		c.emit(instr{op: opCallIndirect, a: ti})
+		c.push(len(t.Results))
+	case op == opDrop:
+		c.pop(1)
+		c.emit(instr{op: opDrop})
+	case op == opSelect, op == opSelectT:
+		if op == opSelectT {
+			for n := r.count(); n > 0; n-- {
+				r.valueType()
+			}
+		}
+		c.pop(3)
+		c.emit(instr{op: opSelect})
+		c.push(1)
+	case op == opLocalGet:
+		c.emit(instr{op: opLocalGet, a: c.local()})
+		c.push(1)
+	case op == opLocalSet:
+		c.emit(instr{op: opLocalSet, a: c.local()})
+		c.pop(1)
+	case op == opLocalTee:
+		c.emit(instr{op: opLocalTee, a: c.local()})
+		c.pop(1)
+		c.push(1)
+	case op == opGlobalGet, op == opGlobalSet:
+		gi := r.u32()
+		if int(gi) >= len(c.m.globals) {
+			fail("unknown global %d", gi)
+		}
+		if op == opGlobalSet {
+			if !c.m.globals[gi].mutable {
+				fail("global %d is immutable", gi)
+			}
+			c.pop(1)
+		} else {
+			c.push(1)
+		}
+		c.emit(instr{op: uint16(op), a: gi})
+	case op >= opI32Load && op <= opI64Load32U:
+		c.emit(instr{op: uint16(op), c: c.memarg()})
+		c.pop(1)
+		c.push(1)
+	case op >= opI32Store && op <= opI64Store32:
+		c.emit(instr{op: uint16(op), c: c.memarg()})
+		c.pop(2)
+	case op == opMemorySize, op == opMemoryGrow:
+		if c.m.memory == nil || r.byte() != 0 {
+			fail("unknown memory")
+		}
+		if op == opMemoryGrow {
+			c.pop(1)
+		}
+		c.emit(instr{op: uint16(op)})
+		c.push(1)
+	case op == opI32Const:
+		c.emit(instr{op: opI32Const, c: uint64(uint32(r.sleb(32)))})
+		c.push(1)
+	case op == opI64Const:
+		c.emit(instr{op: opI64Const, c: uint64(r.sleb(64))})
+		c.push(1)
+	case op == opF32Const:
+		c.emit(instr{op: opI32Const, c: uint64(binary.LittleEndian.Uint32(r.bytes(4)))})
+		c.push(1)
+	case op == opF64Const:
+		c.emit(instr{op: opI64Const, c: binary.LittleEndian.Uint64(r.bytes(8))})
+		c.push(1)
+	case op == opRefNull:
+		r.valueType()
+		c.emit(instr{op: opI64Const, c: math.MaxUint64})
+		c.push(1)
+	case op == opRefIsNull:
+		c.pop(1)
+		c.emit(instr{op: opRefIsNull})
+		c.push(1)
+	case op == opRefFunc:
+		fi := r.u32()
+		if int(fi) >= c.m.numFuncs() {
+			fail("unknown function %d", fi)
+		}
+		c.emit(instr{op: opI64Const, c: uint64(fi)})
+		c.push(1)
+	case op == 0x45 || op == 0x50 || (op >= 0x67 && op <= 0x69) || (op >= 0x79 && op <= 0x7b) ||
+		(op >= 0x8b && op <= 0x91) || (op >= 0x99 && op <= 0x9f) || (op >= 0xa7 && op <= 0xc4):
+		// unary operators and conversions
+		c.pop(1)
+		c.emit(instr{op: uint16(op)})
+		c.push(1)
+	case op >= 0x46 && op <= 0xa6:
+		// binary operators and comparisons
+		c.pop(2)
+		c.emit(instr{op: uint16(op)})
+		c.push(1)
+	case op == opPrefix:
+		c.prefixed(r.u32())
+	default:
+		fail("unsupported opcode %#x", op)
+	}
+}
+
+func (c *compiler) prefixed(sub uint32) {
+	op := uint16(0x100 + sub)
+	switch {
+	case op >= opTruncSatFirst && op <= opTruncSatLast:
+		c.pop(1)
+		c.emit(instr{op: op})
+		c.push(1)
+	case op == opMemoryInit, op == opDataDrop:
+		di := c.r.u32()
+		if int(di) >= len(c.m.data) {
+			fail("unknown data segment %d", di)
+		}
+		if op == opMemoryInit {
+			if c.m.memory == nil || c.r.byte() != 0 {
+				fail("unknown memory")
+			}
+			c.pop(3)
+		}
+		c.emit(instr{op: op, a: di})
+	case op == opMemoryCopy, op == opMemoryFill:
+		if c.m.memory == nil || c.r.byte() != 0 {
+			fail("unknown memory")
+		}
+		if op == opMemoryCopy && c.r.byte() != 0 {
+			fail("unknown memory")
+		}
+		c.pop(3)
+		c.emit(instr{op: op})
+	default:
+		fail("unsupported opcode 0xfc %d", sub)
+	}
+}
+
+// endCheck checks that a block leaves just its results on the stack.
+func (c *compiler) endCheck(fr *ctrl) {
+	if !fr.unreachable && c.height != fr.height+fr.results {
+		fail("block leaves %d values on the stack, expected %d", c.height-fr.height, fr.results)
+	}
+}
@@ -0,0 +1,669 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+func b2u(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func f32(v uint64) float32 { return math.Float32frombits(uint32(v)) }
+func f64(v uint64) float64 { return math.Float64frombits(v) }
+func u32(f float32) uint64 { return uint64(math.Float32bits(f)) }
+func u64(f float64) uint64 { return math.Float64bits(f) }
+
+// exec runs a function whose arguments start at base.
+func (in *Instance) exec(f *function, base int) {
+	nparams := len(f.typ.Params)
+	ops := base + nparams + f.nlocals
+	in.ensure(ops + f.maxOps)
+	s := in.stack
+	for i := base + nparams; i < ops; i++ {
+		s[i] = 0
+	}
+	sp := ops
+	code := f.code
+	le := binary.LittleEndian
+	for pc := 0; ; {
+		if in.fuel--; in.fuel < 0 {
+			panic(trap{ErrFuelExhausted})
+		}
+		ins := &code[pc]
+		pc++
+		switch ins.op {
+		case opUnreachable:
+			trapf("unreachable")
+		case opIf:
+			sp--
+			if uint32(s[sp]) == 0 {
+				pc = int(ins.c)
+			}
+		case opJump:
+			pc = int(ins.c)
+		case opBr:
+			sp = branch{int(ins.c), int(ins.b), int(ins.a)}.take(s, sp, ops)
+			pc = int(ins.c)
+		case opBrIf:
+			sp--
+			if uint32(s[sp]) != 0 {
+				sp = branch{int(ins.c), int(ins.b), int(ins.a)}.take(s, sp, ops)
+				pc = int(ins.c)
+			}
+		case opBrTable:
+			sp--
+			table := f.tables[ins.a]
+			i := uint64(uint32(s[sp]))
+			if i >= uint64(len(table)) {
+				i = uint64(len(table) - 1)
+			}
+			sp = table[i].take(s, sp, ops)
+			pc = table[i].target
+		case opReturn:
+			n := int(ins.a)
+			copy(s[base:], s[sp-n:sp])
+			return
+		case opCall:
+			sp = in.invoke(int(ins.a), sp)
+			s = in.stack
+		case opCallIndirect:
+			sp--
+			fi := in.indirect(ins.a, uint64(uint32(s[sp])))
+			sp = in.invoke(fi, sp)
+			s = in.stack
+		case opDrop:
+			sp--
+		case opSelect:
+			sp -= 2
+			if uint32(s[sp+1]) == 0 {
+				s[sp-1] = s[sp]
+			}
+		case opLocalGet:
+			s[sp] = s[base+int(ins.a)]
+			sp++
+		case opLocalSet:
+			sp--
+			s[base+int(ins.a)] = s[sp]
+		case opLocalTee:
+			s[base+int(ins.a)] = s[sp-1]
+		case opGlobalGet:
+			s[sp] = in.globals[ins.a]
+			sp++
+		case opGlobalSet:
+			sp--
+			in.globals[ins.a] = s[sp]
+
+		// memory
+		case 0x28: // i32.load
+			a := in.addr(s[sp-1], ins.c, 4)
+			s[sp-1] = uint64(le.Uint32(in.mem[a:]))
+		case 0x29: // i64.load
+			a := in.addr(s[sp-1], ins.c, 8)
+			s[sp-1] = le.Uint64(in.mem[a:])
+		case 0x2a: // f32.load
+			a := in.addr(s[sp-1], ins.c, 4)
+			s[sp-1] = uint64(le.Uint32(in.mem[a:]))
+		case 0x2b: // f64.load
+			a := in.addr(s[sp-1], ins.c, 8)
+			s[sp-1] = le.Uint64(in.mem[a:])
+		case 0x2c: // i32.load8_s
+			a := in.addr(s[sp-1], ins.c, 1)
+			s[sp-1] = uint64(uint32(int32(int8(in.mem[a]))))
+		case 0x2d: // i32.load8_u
+			a := in.addr(s[sp-1], ins.c, 1)
+			s[sp-1] = uint64(in.mem[a])
+		case 0x2e: // i32.load16_s
+			a := in.addr(s[sp-1], ins.c, 2)
+			s[sp-1] = uint64(uint32(int32(int16(le.Uint16(in.mem[a:])))))
+		case 0x2f: // i32.load16_u
+			a := in.addr(s[sp-1], ins.c, 2)
+			s[sp-1] = uint64(le.Uint16(in.mem[a:]))
+		case 0x30: // i64.load8_s
+			a := in.addr(s[sp-1], ins.c, 1)
+			s[sp-1] = uint64(int64(int8(in.mem[a])))
+		case 0x31: // i64.load8_u
+			a := in.addr(s[sp-1], ins.c, 1)
+			s[sp-1] = uint64(in.mem[a])
+		case 0x32: // i64.load16_s
+			a := in.addr(s[sp-1], ins.c, 2)
+			s[sp-1] = uint64(int64(int16(le.Uint16(in.mem[a:]))))
+		case 0x33: // i64.load16_u
+			a := in.addr(s[sp-1], ins.c, 2)
+			s[sp-1] = uint64(le.Uint16(in.mem[a:]))
+		case 0x34: // i64.load32_s
+			a := in.addr(s[sp-1], ins.c, 4)
+			s[sp-1] = uint64(int64(int32(le.Uint32(in.mem[a:]))))
+		case 0x35: // i64.load32_u
+			a := in.addr(s[sp-1], ins.c, 4)
+			s[sp-1] = uint64(le.Uint32(in.mem[a:]))
+		case 0x36, 0x38: // i32.store, f32.store
+			sp -= 2
+			a := in.addr(s[sp], ins.c, 4)
+			le.PutUint32(in.mem[a:], uint32(s[sp+1]))
+		case 0x37, 0x39: // i64.store, f64.store
+			sp -= 2
+			a := in.addr(s[sp], ins.c, 8)
+			le.PutUint64(in.mem[a:], s[sp+1])
+		case 0x3a, 0x3c: // i32.store8, i64.store8
+			sp -= 2
+			a := in.addr(s[sp], ins.c, 1)
+			in.mem[a] = byte(s[sp+1])
+		case 0x3b, 0x3d: // i32.store16, i64.store16
+			sp -= 2
+			a := in.addr(s[sp], ins.c, 2)
+			le.PutUint16(in.mem[a:], uint16(s[sp+1]))
+		case 0x3e: // i64.store32
+			sp -= 2
+			a := in.addr(s[sp], ins.c, 4)
+			le.PutUint32(in.mem[a:], uint32(s[sp+1]))
+		case opMemorySize:
+			s[sp] = uint64(len(in.mem) / pageSize)
+			sp++
+		case opMemoryGrow:
+			s[sp-1] = in.grow(uint32(s[sp-1]))
+		case opMemoryInit:
+			sp -= 3
+			seg := in.segment(ins.a)
+			dst, src, n := uint64(uint32(s[sp])), uint64(uint32(s[sp+1])), uint64(uint32(s[sp+2]))
+			if src+n > uint64(len(seg)) || dst+n > uint64(len(in.mem)) {
+				trapf("out of bounds memory access")
+			}
+			copy(in.mem[dst:], seg[src:src+n])
+		case opDataDrop:
+			in.dropped[ins.a] = true
+		case opMemoryCopy:
+			sp -= 3
+			dst, src, n := uint64(uint32(s[sp])), uint64(uint32(s[sp+1])), uint64(uint32(s[sp+2]))
+			if src+n > uint64(len(in.mem)) || dst+n > uint64(len(in.mem)) {
+				trapf("out of bounds memory access")
+			}
+			copy(in.mem[dst:dst+n], in.mem[src:src+n])
+		case opMemoryFill:
+			sp -= 3
+			dst, val, n := uint64(uint32(s[sp])), byte(s[sp+1]), uint64(uint32(s[sp+2]))
+			if dst+n > uint64(len(in.mem)) {
+				trapf("out of bounds memory access")
+			}
+			mem := in.mem[dst : dst+n]
+			for i := range mem {
+				mem[i] = val
+			}
+
+		case opI32Const, opI64Const:
+			s[sp] = ins.c
+			sp++
+		case opRefIsNull:
+			s[sp-1] = b2u(s[sp-1] == math.MaxUint64)
+
+		// i32 comparisons
+		case 0x45:
+			s[sp-1] = b2u(uint32(s[sp-1]) == 0)
+		case 0x46:
+			sp--
+			s[sp-1] = b2u(uint32(s[sp-1]) == uint32(s[sp]))
+		case 0x47:
+			sp--
+			s[sp-1] = b2u(uint32(s[sp-1]) != uint32(s[sp]))
+		case 0x48:
+			sp--
+			s[sp-1] = b2u(int32(s[sp-1]) < int32(s[sp]))
+		case 0x49:
+			sp--
+			s[sp-1] = b2u(uint32(s[sp-1]) < uint32(s[sp]))
+		case 0x4a:
+			sp--
+			s[sp-1] = b2u(int32(s[sp-1]) > int32(s[sp]))
+		case 0x4b:
+			sp--
+			s[sp-1] = b2u(uint32(s[sp-1]) > uint32(s[sp]))
+		case 0x4c:
+			sp--
+			s[sp-1] = b2u(int32(s[sp-1]) <= int32(s[sp]))
+		case 0x4d:
+			sp--
+			s[sp-1] = b2u(uint32(s[sp-1]) <= uint32(s[sp]))
+		case 0x4e:
+			sp--
+			s[sp-1] = b2u(int32(s[sp-1]) >= int32(s[sp]))
+		case 0x4f:
+			sp--
+			s[sp-1] = b2u(uint32(s[sp-1]) >= uint32(s[sp]))
+
+		// i64 comparisons
+		case 0x50:
+			s[sp-1] = b2u(s[sp-1] == 0)
+		case 0x51:
+			sp--
+			s[sp-1] = b2u(s[sp-1] == s[sp])
+		case 0x52:
+			sp--
+			s[sp-1] = b2u(s[sp-1] != s[sp])
+		case 0x53:
+			sp--
+			s[sp-1] = b2u(int64(s[sp-1]) < int64(s[sp]))
+		case 0x54:
+			sp--
+			s[sp-1] = b2u(s[sp-1] < s[sp])
+		case 0x55:
+			sp--
+			s[sp-1] = b2u(int64(s[sp-1]) > int64(s[sp]))
+		case 0x56:
+			sp--
+			s[sp-1] = b2u(s[sp-1] > s[sp])
+		case 0x57:
+			sp--
+			s[sp-1] = b2u(int64(s[sp-1]) <= int64(s[sp]))
+		case 0x58:
+			sp--
+			s[sp-1] = b2u(s[sp-1] <= s[sp])
+		case 0x59:
+			sp--
+			s[sp-1] = b2u(int64(s[sp-1]) >= int64(s[sp]))
+		case 0x5a:
+			sp--
+			s[sp-1] = b2u(s[sp-1] >= s[sp])
+
+		// float comparisons
+		case 0x5b:
+			sp--
+			s[sp-1] = b2u(f32(s[sp-1]) == f32(s[sp]))
+		case 0x5c:
+			sp--
+			s[sp-1] = b2u(f32(s[sp-1]) != f32(s[sp]))
+		case 0x5d:
+			sp--
+			s[sp-1] = b2u(f32(s[sp-1]) < f32(s[sp]))
+		case 0x5e:
+			sp--
+			s[sp-1] = b2u(f32(s[sp-1]) > f32(s[sp]))
+		case 0x5f:
+			sp--
+			s[sp-1] = b2u(f32(s[sp-1]) <= f32(s[sp]))
+		case 0x60:
+			sp--
+			s[sp-1] = b2u(f32(s[sp-1]) >= f32(s[sp]))
+		case 0x61:
+			sp--
+			s[sp-1] = b2u(f64(s[sp-1]) == f64(s[sp]))
+		case 0x62:
+			sp--
+			s[sp-1] = b2u(f64(s[sp-1]) != f64(s[sp]))
+		case 0x63:
+			sp--
+			s[sp-1] = b2u(f64(s[sp-1]) < f64(s[sp]))
+		case 0x64:
+			sp--
+			s[sp-1] = b2u(f64(s[sp-1]) > f64(s[sp]))
+		case 0x65:
+			sp--
+			s[sp-1] = b2u(f64(s[sp-1]) <= f64(s[sp]))
+		case 0x66:
+			sp--
+			s[sp-1] = b2u(f64(s[sp-1]) >= f64(s[sp]))
+
+		// i32 arithmetic
+		case 0x67:
+			s[sp-1] = uint64(bits.LeadingZeros32(uint32(s[sp-1])))
+		case 0x68:
+			s[sp-1] = uint64(bits.TrailingZeros32(uint32(s[sp-1])))
+		case 0x69:
+			s[sp-1] = uint64(bits.OnesCount32(uint32(s[sp-1])))
+		case 0x6a:
+			sp--
+			s[sp-1] = uint64(uint32(s[sp-1]) + uint32(s[sp]))
+		case 0x6b:
+			sp--
+			s[sp-1] = uint64(uint32(s[sp-1]) - uint32(s[sp]))
+		case 0x6c:
+			sp--
+			s[sp-1] = uint64(uint32(s[sp-1]) * uint32(s[sp]))
+		case 0x6d:
+			sp--
+			a, b := int32(s[sp-1]), int32(s[sp])
+			if b == 0 {
+				trapf("integer divide by zero")
+			}
+			if a == math.MinInt32 && b == -1 {
+				trapf("integer overflow")
+			}
+			s[sp-1] = uint64(uint32(a / b))
+		case 0x6e:
+			sp--
+			if uint32(s[sp]) == 0 {
+				trapf("integer divide by zero")
+			}
+			s[sp-1] = uint64(uint32(s[sp-1]) / uint32(s[sp]))
+		case 0x6f:
+			sp--
+			a, b := int32(s[sp-1]), int32(s[sp])
+			if b == 0 {
+				trapf("integer divide by zero")
+			}
+			s[sp-1] = uint64(uint32(a % b))
+		case 0x70:
+			sp--
+			if uint32(s[sp]) == 0 {
+				trapf("integer divide by zero")
+			}
+			s[sp-1] = uint64(uint32(s[sp-1]) % uint32(s[sp]))
+		case 0x71:
+			sp--
+			s[sp-1] = uint64(uint32(s[sp-1]) & uint32(s[sp]))
+		case 0x72:
+			sp--
+			s[sp-1] = uint64(uint32(s[sp-1]) | uint32(s[sp]))
+		case 0x73:
+			sp--
+			s[sp-1] = uint64(uint32(s[sp-1]) ^ uint32(s[sp]))
+		case 0x74:
+			sp--
+			s[sp-1] = uint64(uint32(s[sp-1]) << (uint32(s[sp]) & 31))
+		case 0x75:
+			sp--
+			s[sp-1] = uint64(uint32(int32(s[sp-1]) >> (uint32(s[sp]) & 31)))
+		case 0x76:
+			sp--
+			s[sp-1] = uint64(uint32(s[sp-1]) >> (uint32(s[sp]) & 31))
+		case 0x77:
+			sp--
+			s[sp-1] = uint64(bits.RotateLeft32(uint32(s[sp-1]), int(uint32(s[sp])&31)))
+		case 0x78:
+			sp--
+			s[sp-1] = uint64(bits.RotateLeft32(uint32(s[sp-1]), -int(uint32(s[sp])&31)))
+
+		// i64 arithmetic
+		case 0x79:
+			s[sp-1] = uint64(bits.LeadingZeros64(s[sp-1]))
+		case 0x7a:
+			s[sp-1] = uint64(bits.TrailingZeros64(s[sp-1]))
+		case 0x7b:
+			s[sp-1] = uint64(bits.OnesCount64(s[sp-1]))
+		case 0x7c:
+			sp--
+			s[sp-1] += s[sp]
+		case 0x7d:
+			sp--
+			s[sp-1] -= s[sp]
+		case 0x7e:
+			sp--
+			s[sp-1] *= s[sp]
+		case 0x7f:
+			sp--
+			a, b := int64(s[sp-1]), int64(s[sp])
+			if b == 0 {
+				trapf("integer divide by zero")
+			}
+			if a == math.MinInt64 && b == -1 {
+				trapf("integer overflow")
+			}
+			s[sp-1] = uint64(a / b)
+		case 0x80:
+			sp--
+			if s[sp] == 0 {
+				trapf("integer divide by zero")
+			}
+			s[sp-1] /= s[sp]
+		case 0x81:
+			sp--
+			a, b := int64(s[sp-1]), int64(s[sp])
+			if b == 0 {
+				trapf("integer divide by zero")
+			}
+			s[sp-1] = uint64(a % b)
+		case 0x82:
+			sp--
+			if s[sp] == 0 {
+				trapf("integer divide by zero")
+			}
+			s[sp-1] %= s[sp]
+		case 0x83:
+			sp--
+			s[sp-1] &= s[sp]
+		case 0x84:
+			sp--
+			s[sp-1] |= s[sp]
+		case 0x85:
+			sp--
+			s[sp-1] ^= s[sp]
+		case 0x86:
+			sp--
+			s[sp-1] <<= s[sp] & 63
+		case 0x87:
+			sp--
+			s[sp-1] = uint64(int64(s[sp-1]) >> (s[sp] & 63))
+		case 0x88:
+			sp--
+			s[sp-1] >>= s[sp] & 63
+		case 0x89:
+			sp--
+			s[sp-1] = bits.RotateLeft64(s[sp-1], int(s[sp]&63))
+		case 0x8a:
+			sp--
+			s[sp-1] = bits.RotateLeft64(s[sp-1], -int(s[sp]&63))
+
+		// f32 arithmetic
+		case 0x8b:
+			s[sp-1] &^= 1 << 31
+		case 0x8c:
+			s[sp-1] = uint64(uint32(s[sp-1]) ^ 1<<31)
+		case 0x8d:
+			s[sp-1] = u32(float32(math.Ceil(float64(f32(s[sp-1])))))
+		case 0x8e:
+			s[sp-1] = u32(float32(math.Floor(float64(f32(s[sp-1])))))
+		case 0x8f:
+			s[sp-1] = u32(float32(math.Trunc(float64(f32(s[sp-1])))))
+		case 0x90:
+			s[sp-1] = u32(float32(math.RoundToEven(float64(f32(s[sp-1])))))
+		case 0x91:
+			s[sp-1] = u32(float32(math.Sqrt(float64(f32(s[sp-1])))))
+		case 0x92:
+			sp--
+			s[sp-1] = u32(f32(s[sp-1]) + f32(s[sp]))
+		case 0x93:
+			sp--
+			s[sp-1] = u32(f32(s[sp-1]) - f32(s[sp]))
+		case 0x94:
+			sp--
+			s[sp-1] = u32(f32(s[sp-1]) * f32(s[sp]))
+		case 0x95:
+			sp--
+			s[sp-1] = u32(f32(s[sp-1]) / f32(s[sp]))
+		case 0x96:
+			sp--
+			s[sp-1] = u32(float32(math.Min(float64(f32(s[sp-1])), float64(f32(s[sp])))))
+		case 0x97:
+			sp--
+			s[sp-1] = u32(float32(math.Max(float64(f32(s[sp-1])), float64(f32(s[sp])))))
+		case 0x98:
+			sp--
+			s[sp-1] = s[sp-1]&^(1<<31) | s[sp]&(1<<31)
+
+		// f64 arithmetic
+		case 0x99:
+			s[sp-1] &^= 1 << 63
+		case 0x9a:
+			s[sp-1] ^= 1 << 63
+		case 0x9b:
+			s[sp-1] = u64(math.Ceil(f64(s[sp-1])))
+		case 0x9c:
+			s[sp-1] = u64(math.Floor(f64(s[sp-1])))
+		case 0x9d:
+			s[sp-1] = u64(math.Trunc(f64(s[sp-1])))
+		case 0x9e:
+			s[sp-1] = u64(math.RoundToEven(f64(s[sp-1])))
+		case 0x9f:
+			s[sp-1] = u64(math.Sqrt(f64(s[sp-1])))
+		case 0xa0:
+			sp--
+			s[sp-1] = u64(f64(s[sp-1]) + f64(s[sp]))
+		case 0xa1:
+			sp--
+			s[sp-1] = u64(f64(s[sp-1]) - f64(s[sp]))
+		case 0xa2:
+			sp--
+			s[sp-1] = u64(f64(s[sp-1]) * f64(s[sp]))
+		case 0xa3:
+			sp--
+			s[sp-1] = u64(f64(s[sp-1]) / f64(s[sp]))
+		case 0xa4:
+			sp--
+			s[sp-1] = u64(math.Min(f64(s[sp-1]), f64(s[sp])))
+		case 0xa5:
+			sp--
+			s[sp-1] = u64(math.Max(f64(s[sp-1]), f64(s[sp])))
+		case 0xa6:
+			sp--
+			s[sp-1] = s[sp-1]&^(1<<63) | s[sp]&(1<<63)
+
+		// conversions
+		case 0xa7:
+			s[sp-1] = uint64(uint32(s[sp-1]))
+		case 0xa8:
+			s[sp-1] = uint64(uint32(int32(truncS(float64(f32(s[sp-1])), 32))))
+		case 0xa9:
+			s[sp-1] = uint64(uint32(truncU(float64(f32(s[sp-1])), 32)))
+		case 0xaa:
+			s[sp-1] = uint64(uint32(int32(truncS(f64(s[sp-1]), 32))))
+		case 0xab:
+			s[sp-1] = uint64(uint32(truncU(f64(s[sp-1]), 32)))
+		case 0xac:
+			s[sp-1] = uint64(int64(int32(s[sp-1])))
+		case 0xad:
+			s[sp-1] = uint64(uint32(s[sp-1]))
+		case 0xae:
+			s[sp-1] = uint64(truncS(float64(f32(s[sp-1])), 64))
+		case 0xaf:
+			s[sp-1] = truncU(float64(f32(s[sp-1])), 64)
+		case 0xb0:
+			s[sp-1] = uint64(truncS(f64(s[sp-1]), 64))
+		case 0xb1:
+			s[sp-1] = truncU(f64(s[sp-1]), 64)
+		case 0xb2:
+			s[sp-1] = u32(float32(int32(s[sp-1])))
+		case 0xb3:
+			s[sp-1] = u32(float32(uint32(s[sp-1])))
+		case 0xb4:
+			s[sp-1] = u32(float32(int64(s[sp-1])))
+		case 0xb5:
+			s[sp-1] = u32(float32(s[sp-1]))
+		case 0xb6:
+			s[sp-1] = u32(float32(f64(s[sp-1])))
+		case 0xb7:
+			s[sp-1] = u64(float64(int32(s[sp-1])))
+		case 0xb8:
+			s[sp-1] = u64(float64(uint32(s[sp-1])))
+		case 0xb9:
+			s[sp-1] = u64(float64(int64(s[sp-1])))
+		case 0xba:
+			s[sp-1] = u64(float64(s[sp-1]))
+		case 0xbb:
+			s[sp-1] = u64(float64(f32(s[sp-1])))
+		case 0xbc, 0xbd, 0xbe, 0xbf:
+			// reinterpretations don't change the bits
+		case 0xc0:
+			s[sp-1] = uint64(uint32(int32(int8(s[sp-1]))))
+		case 0xc1:
+			s[sp-1] = uint64(uint32(int32(int16(s[sp-1]))))
+		case 0xc2:
+			s[sp-1] = uint64(int64(int8(s[sp-1])))
+		case 0xc3:
+			s[sp-1] = uint64(int64(int16(s[sp-1])))
+		case 0xc4:
+			s[sp-1] = uint64(int64(int32(s[sp-1])))
+
+		// saturating conversions
+		case 0x100:
+			s[sp-1] = uint64(uint32(int32(satS(float64(f32(s[sp-1])), 32))))
+		case 0x101:
+			s[sp-1] = uint64(uint32(satU(float64(f32(s[sp-1])), 32)))
+		case 0x102:
+			s[sp-1] = uint64(uint32(int32(satS(f64(s[sp-1]), 32))))
+		case 0x103:
+			s[sp-1] = uint64(uint32(satU(f64(s[sp-1]), 32)))
+		case 0x104:
+			s[sp-1] = uint64(satS(float64(f32(s[sp-1])), 64))
+		case 0x105:
+			s[sp-1] = satU(float64(f32(s[sp-1])), 64)
+		case 0x106:
+			s[sp-1] = uint64(satS(f64(s[sp-1]), 64))
+		case 0x107:
+			s[sp-1] = satU(f64(s[sp-1]), 64)
+		}
+	}
+}
+
+// take moves the branch's results down to its height, dropping whatever
+// is between them, and returns the new top of the stack.
+func (b branch) take(s []uint64, sp, ops int) int {
+	dst := ops + b.height
+	if dst != sp-b.arity {
+		copy(s[dst:], s[sp-b.arity:sp])
+	}
+	return dst + b.arity
+}
+
+// the bounds of the integer types, as floats
+func intBounds(size uint, signed bool) (min, max float64) {
+	if signed {
+		return -math.Ldexp(1, int(size)-1), math.Ldexp(1, int(size)-1)
+	}
+	return -1, math.Ldexp(1, int(size))
+}
+
+func truncS(f float64, size uint) int64 {
+	if f != f {
+		trapf("invalid conversion to integer")
+	}
+	t := math.Trunc(f)
+	min, max := intBounds(size, true)
+	if t < min || t >= max {
+		trapf("integer overflow")
+	}
+	return int64(t)
+}
+
+func truncU(f float64, size uint) uint64 {
+	if f != f {
+		trapf("invalid conversion to integer")
+	}
+	t := math.Trunc(f)
+	min, max := intBounds(size, false)
+	if t <= min || t >= max {
+		trapf("integer overflow")
+	}
+	return uint64(t)
+}
+
+func satS(f float64, size uint) int64 {
+	t := math.Trunc(f)
+	min, max := intBounds(size, true)
+	switch {
+	case f != f:
+		return 0
+	case t < min:
+		return int64(min)
+	case t >= max:
+		return int64(uint64(1)<<(size-1) - 1)
+	}
+	return int64(t)
+}
+
+func satU(f float64, size uint) uint64 {
+	t := math.Trunc(f)
+	_, max := intBounds(size, false)
+	switch {
+	case f != f, t <= -1:
+		return 0
+	case t >= max:
+		if size == 64 {
+			return math.MaxUint64
+		}
+		return 1<<size - 1
+	}
+	return uint64(t)
+}
@@ -0,0 +1,118 @@
+//go:build go1.18
+// +build go1.18
+
+package wasm
+
+import (
+	"strings"
+	"testing"
+)
+
+// fuzzConfig keeps what a fuzzed module may use small, so that each
+// input runs quickly.
+var fuzzConfig = Config{MaxMemoryPages: 4, Fuel: 100000, MaxCallDepth: 100}
+
+// FuzzModule decodes, validates and runs arbitrary modules. Whatever the
+// input, the interpreter must return an error rather than panic, and a
+// module that validates must only ever fail with one of its traps, never
+// with a runtime error of its own. Run it with
+//
+//	go test -fuzz FuzzModule ./wasm
+func FuzzModule(f *testing.F) {
+	for i, tm := range fuzzSeeds() {
+		bin := tm.bytes()
+		if _, err := Compile(bin); err != nil {
+			f.Fatalf("seed %d: %v", i, err)
+		}
+		f.Add(bin)
+	}
+	f.Fuzz(func(t *testing.T, bin []byte) {
+		m, err := Compile(bin)
+		if err != nil {
+			checkFuzzError(t, "compiling", err)
+			return
+		}
+		// any imports are resolved to functions that return zeros
+		imports := make(map[string]map[string]HostFunc)
+		for _, imp := range m.Imports {
+			if imports[imp.Module] == nil {
+				imports[imp.Module] = make(map[string]HostFunc)
+			}
+			n := len(imp.Type.Results)
+			imports[imp.Module][imp.Name] = HostFunc{
+				Type: imp.Type,
+				Func: func(*Instance, []uint64) ([]uint64, error) {
+					return make([]uint64, n), nil
+				},
+			}
+		}
+		in, err := m.Instantiate(imports, fuzzConfig)
+		if err != nil {
+			checkFuzzError(t, "instantiating", err)
+			return
+		}
+		for _, name := range m.Exports() {
+			typ, _ := m.ExportType(name)
+			results, err := in.Call(name, make([]uint64, len(typ.Params))...)
+			if err != nil {
+				checkFuzzError(t, name, err)
+			} else if len(results) != len(typ.Results) {
+				t.Fatalf("%s returned %d results, its type has %d", name, len(results), len(typ.Results))
+			}
+			if pages := len(in.Memory()) / pageSize; pages > int(fuzzConfig.MaxMemoryPages) {
+				t.Fatalf("memory grew to %d pages, over the limit", pages)
+			}
+		}
+	})
+}
+
+func checkFuzzError(t *testing.T, what string, err error) {
+	if !strings.HasPrefix(err.Error(), "wasm: ") || strings.Contains(err.Error(), "runtime error") {
+		t.Fatalf("%s: the interpreter failed: %v", what, err)
+	}
+}
+
+// fuzzSeeds are valid modules covering most of the instruction set, for
+// the fuzzer to mutate.
+func fuzzSeeds() []*testModule {
+	start := uint32(1)
+	return []*testModule{
+		controlFlowModule(),
+		{
+			memory:  &limits{min: 1, max: 2, hasMax: true},
+			data:    []byte("hello"),
+			passive: []byte("world"),
+			table:   []uint32{1, 2},
+			imports: []Import{{Module: "env", Name: "f", Type: i32i32}},
+			start:   &start,
+			funcs: []testFunc{
+				{typ: void, body: code(i32c(0), i32c(1), opI32Store, 2, 0)},
+				{name: "mem", typ: i32i32, locals: []ValueType{I64}, body: code(
+					opLocalGet, 0, opI32Load, 2, 4,
+					opLocalGet, 0, i64c(-1), opI64Store32, 2, 0,
+					opLocalGet, 0, opI64Load32U, 2, 0, opLocalSet, 1,
+					i32c(1), opMemoryGrow, 0, opDrop,
+					i32c(8), i32c(0), i32c(5), opPrefix, 8, 1, 0,
+					opPrefix, 9, 1,
+					i32c(16), i32c(8), i32c(4), opPrefix, 10, 0, 0,
+					i32c(0), i32c(0), i32c(3), opPrefix, 11, 0,
+					opLocalGet, 0, opCall, 0, opI32Add,
+				)},
+				{name: "indirect", typ: i32i32, body: code(
+					opLocalGet, 0, opLocalGet, 0, i32c(1), 0x71, // i32.and
+					opCallIndirect, 0, 0,
+					opLocalGet, 0, opLocalGet, 0, opI32Eqz, opSelect,
+				)},
+				// i64.trunc_f64_s, i64.trunc_sat_f64_s, i64.div_s,
+				// i64.shl and i64.extend32_s
+				{name: "numeric", typ: FuncType{Results: []ValueType{I64}}, body: code(
+					f64c(-2.5), 0xb0,
+					f64c(1e30), opPrefix, 6,
+					0x7f,
+					i64c(3), 0x86,
+					0xc4,
+				)},
+			},
+		},
+	}
+}
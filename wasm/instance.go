@@ -0,0 +1,300 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+)
+
+const pageSize = 65536
+
+var (
+	// ErrFuelExhausted is returned when a call runs more instructions
+	// than its fuel allows.
+	ErrFuelExhausted = errors.New("wasm: out of fuel")
+
+	// ErrMemoryLimit is returned when a call fails after the instance
+	// was refused memory because of its configured limit.
+	ErrMemoryLimit = errors.New("wasm: exceeded the memory limit")
+
+	// ErrCallStackExhausted is returned when calls nest too deeply.
+	ErrCallStackExhausted = errors.New("wasm: call stack exhausted")
+)
+
+// Config limits what an instance may use.
+type Config struct {
+	// MaxMemoryPages is the most 64KiB pages of memory the instance
+	// may have. Zero means the module's own limit.
+	MaxMemoryPages uint32
+
+	// Fuel is how many instructions a single call may run. Zero
+	// means no limit.
+	Fuel int64
+
+	// MaxCallDepth is how deeply calls may nest, 1000 by default.
+	MaxCallDepth int
+}
+
+// HostFunc is a function the embedder provides as an import.
+type HostFunc struct {
+	Type FuncType
+	Func func(in *Instance, args []uint64) ([]uint64, error)
+}
+
+// Instance is an instantiated module. It isn't safe for concurrent use.
+type Instance struct {
+	m     *Module
+	hosts []HostFunc
+	cfg   Config
+
+	mem      []byte
+	maxPages uint32
+	refused  bool // a memory.grow went over the configured limit
+
+	globals []uint64
+	table   []int
+	dropped []bool
+
+	stack   []uint64
+	fuel    int64
+	depth   int
+	running bool
+}
+
+type trap struct{ err error }
+
+type hostError struct{ err error }
+
+func trapf(format string, args ...interface{}) {
+	panic(trap{fmt.Errorf("wasm: trap: "+format, args...)})
+}
+
+// Instantiate creates an instance of the module, resolving its imports
+// from the given host functions and running its start function if it
+// has one.
+func (m *Module) Instantiate(imports map[string]map[string]HostFunc, cfg Config) (*Instance, error) {
+	if cfg.MaxCallDepth <= 0 {
+		cfg.MaxCallDepth = 1000
+	}
+	in := &Instance{m: m, cfg: cfg}
+	for _, imp := range m.Imports {
+		hf, ok := imports[imp.Module][imp.Name]
+		if !ok {
+			return nil, fmt.Errorf("wasm: unresolved import %s.%s", imp.Module, imp.Name)
+		}
+		if !hf.Type.Equal(imp.Type) {
+			return nil, fmt.Errorf("wasm: import %s.%s has type %v, expected %v",
+				imp.Module, imp.Name, hf.Type, imp.Type)
+		}
+		in.hosts = append(in.hosts, hf)
+	}
+	if l := m.memory; l != nil {
+		in.maxPages = maxPages
+		if l.hasMax {
+			in.maxPages = l.max
+		}
+		if cfg.MaxMemoryPages > 0 && cfg.MaxMemoryPages < in.maxPages {
+			in.maxPages = cfg.MaxMemoryPages
+		}
+		if l.min > in.maxPages {
+			return nil, fmt.Errorf("%v: module needs %d pages, the limit is %d",
+				ErrMemoryLimit, l.min, in.maxPages)
+		}
+		in.mem = make([]byte, int(l.min)*pageSize)
+	}
+	for _, g := range m.globals {
+		in.globals = append(in.globals, g.init)
+	}
+	if l := m.table; l != nil {
+		in.table = make([]int, l.min)
+		for i := range in.table {
+			in.table[i] = -1
+		}
+	}
+	for _, e := range m.elements {
+		if !e.active {
+			continue
+		}
+		if uint64(e.offset)+uint64(len(e.funcs)) > uint64(len(in.table)) {
+			return nil, errors.New("wasm: element segment doesn't fit in the table")
+		}
+		copy(in.table[e.offset:], e.funcs)
+	}
+	in.dropped = make([]bool, len(m.data))
+	for i, d := range m.data {
+		if !d.active {
+			continue
+		}
+		if uint64(d.offset)+uint64(len(d.init)) > uint64(len(in.mem)) {
+			return nil, errors.New("wasm: data segment doesn't fit in memory")
+		}
+		copy(in.mem[d.offset:], d.init)
+		in.dropped[i] = true
+	}
+	if m.start >= 0 {
+		if _, err := in.call(m.start, nil); err != nil {
+			return nil, err
+		}
+	}
+	return in, nil
+}
+
+// Memory returns the instance's memory. The slice is only valid until
+// the memory next grows, which may happen during any call.
+func (in *Instance) Memory() []byte {
+	return in.mem
+}
+
+// Call calls an exported function. Values are passed as their bit
+// patterns: i32 and f32 values take the low 32 bits.
+func (in *Instance) Call(name string, args ...uint64) ([]uint64, error) {
+	e, ok := in.m.exports[name]
+	if !ok || e.kind != externFunc {
+		return nil, fmt.Errorf("wasm: no exported function %q", name)
+	}
+	if in.running {
+		return nil, errors.New("wasm: instance is already running a call")
+	}
+	return in.call(int(e.index), args)
+}
+
+func (in *Instance) call(fi int, args []uint64) (results []uint64, err error) {
+	t := in.m.funcType(uint32(fi))
+	if len(args) != len(t.Params) {
+		return nil, fmt.Errorf("wasm: function takes %d arguments, got %d", len(t.Params), len(args))
+	}
+	in.fuel = in.cfg.Fuel
+	if in.fuel <= 0 {
+		in.fuel = math.MaxInt64
+	}
+	in.refused = false
+	in.running = true
+	defer func() {
+		in.running = false
+		in.depth = 0
+		r := recover()
+		if r == nil {
+			return
+		}
+		switch x := r.(type) {
+		case trap:
+			err = x.err
+			if in.refused && err != ErrFuelExhausted {
+				err = ErrMemoryLimit
+			}
+		case hostError:
+			err = x.err
+		case runtime.Error:
+			err = fmt.Errorf("wasm: trap: %v", x)
+		default:
+			panic(r)
+		}
+	}()
+	in.ensure(len(args))
+	copy(in.stack, args)
+	in.invoke(fi, len(args))
+	return append([]uint64(nil), in.stack[:len(t.Results)]...), nil
+}
+
+// maxStack bounds the value stack, at 8MiB.
+const maxStack = 1 << 20
+
+func (in *Instance) ensure(n int) {
+	if n <= len(in.stack) {
+		return
+	}
+	if n > maxStack {
+		panic(trap{ErrCallStackExhausted})
+	}
+	size := 2 * len(in.stack)
+	if size < n {
+		size = n
+	}
+	if size < 1024 {
+		size = 1024
+	}
+	stack := make([]uint64, size)
+	copy(stack, in.stack)
+	in.stack = stack
+}
+
+// invoke calls a function whose arguments are at the top of the stack,
+// which ends at sp. It leaves the results where the arguments started,
+// and returns where they end.
+func (in *Instance) invoke(fi int, sp int) int {
+	t := in.m.funcType(uint32(fi))
+	base := sp - len(t.Params)
+	if fi < len(in.hosts) {
+		hf := in.hosts[fi]
+		args := append([]uint64(nil), in.stack[base:sp]...)
+		results, err := hf.Func(in, args)
+		if err != nil {
+			panic(hostError{err})
+		}
+		if len(results) != len(t.Results) {
+			panic(hostError{fmt.Errorf("wasm: host function returned %d results, expected %d",
+				len(results), len(t.Results))})
+		}
+		in.ensure(base + len(results))
+		copy(in.stack[base:], results)
+		return base + len(results)
+	}
+	in.depth++
+	if in.depth > in.cfg.MaxCallDepth {
+		panic(trap{ErrCallStackExhausted})
+	}
+	in.exec(in.m.funcs[fi-len(in.hosts)], base)
+	in.depth--
+	return base + len(t.Results)
+}
+
+func (in *Instance) addr(v uint64, offset uint64, size uint64) uint64 {
+	a := uint64(uint32(v)) + offset
+	if a+size > uint64(len(in.mem)) {
+		trapf("out of bounds memory access")
+	}
+	return a
+}
+
+func (in *Instance) grow(delta uint32) uint64 {
+	old := uint32(len(in.mem) / pageSize)
+	if uint64(old)+uint64(delta) > uint64(in.maxPages) {
+		if uint64(old)+uint64(delta) <= uint64(in.maxPagesOfModule()) {
+			in.refused = true
+		}
+		return uint64(math.MaxUint32)
+	}
+	if delta > 0 {
+		in.mem = append(in.mem, make([]byte, int(delta)*pageSize)...)
+	}
+	return uint64(old)
+}
+
+func (in *Instance) maxPagesOfModule() uint32 {
+	if l := in.m.memory; l != nil && l.hasMax {
+		return l.max
+	}
+	return maxPages
+}
+
+func (in *Instance) indirect(typeIndex uint32, elem uint64) int {
+	if elem >= uint64(len(in.table)) {
+		trapf("undefined element")
+	}
+	fi := in.table[elem]
+	if fi < 0 {
+		trapf("uninitialized element")
+	}
+	if !in.m.funcType(uint32(fi)).Equal(in.m.types[typeIndex]) {
+		trapf("indirect call type mismatch")
+	}
+	return fi
+}
+
+func (in *Instance) segment(di uint32) []byte {
+	if in.dropped[di] {
+		return nil
+	}
+	return in.m.data[di].init
+}
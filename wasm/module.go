@@ -0,0 +1,544 @@
+// Package wasm is a small WebAssembly interpreter, written in pure Go so
+// that the gateway can run plugins in-process without cgo. It supports
+// the MVP instruction set, plus the sign extension, non-trapping float to
+// int conversion, bulk memory and multi-value proposals, which covers
+// what Rust and TinyGo emit by default. SIMD, threads and the reference
+// types table instructions aren't supported.
+//
+// Calls are metered: each instruction takes a unit of fuel, and a call
+// that runs out of it is stopped, as is one that needs more memory than
+// its instance may have.
+package wasm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// ValueType is the type of a WebAssembly value.
+type ValueType byte
+
+const (
+	I32       ValueType = 0x7f
+	I64       ValueType = 0x7e
+	F32       ValueType = 0x7d
+	F64       ValueType = 0x7c
+	FuncRef   ValueType = 0x70
+	ExternRef ValueType = 0x6f
+)
+
+// FuncType is the signature of a function.
+type FuncType struct {
+	Params, Results []ValueType
+}
+
+// Equal reports whether two signatures are the same.
+func (t FuncType) Equal(other FuncType) bool {
+	if len(t.Params) != len(other.Params) || len(t.Results) != len(other.Results) {
+		return false
+	}
+	for i, p := range t.Params {
+		if other.Params[i] != p {
+			return false
+		}
+	}
+	for i, r := range t.Results {
+		if other.Results[i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+func (t FuncType) String() string {
+	return fmt.Sprintf("%v -> %v", t.Params, t.Results)
+}
+
+// Import is a function a module imports.
+type Import struct {
+	Module, Name string
+	Type         FuncType
+}
+
+type limits struct {
+	min, max uint32
+	hasMax   bool
+}
+
+type global struct {
+	mutable bool
+	init    uint64
+}
+
+type export struct {
+	kind  byte
+	index uint32
+}
+
+const (
+	externFunc   = 0
+	externTable  = 1
+	externMemory = 2
+	externGlobal = 3
+)
+
+type elemSegment struct {
+	active bool
+	offset uint32
+	funcs  []int // -1 for null
+}
+
+type dataSegment struct {
+	active bool
+	offset uint32
+	init   []byte
+}
+
+// Module is a decoded and compiled WebAssembly module, ready to be
+// instantiated any number of times.
+type Module struct {
+	Imports []Import
+
+	types    []FuncType
+	funcs    []*function // the module's own functions, after the imports
+	table    *limits
+	memory   *limits
+	globals  []global
+	exports  map[string]export
+	start    int // -1 if there's none
+	elements []elemSegment
+	data     []dataSegment
+}
+
+type function struct {
+	typ     FuncType
+	nlocals int // not counting the params
+	body    []byte
+	code    []instr
+	tables  [][]branch
+	maxOps  int // most operands on the stack at once
+}
+
+// ErrMalformed is returned for modules that can't be decoded.
+var ErrMalformed = errors.New("wasm: malformed module")
+
+type decodeError struct{ msg string }
+
+// Compile decodes a WebAssembly binary module and prepares its functions
+// to be run.
+func Compile(bin []byte) (m *Module, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if de, ok := r.(decodeError); ok {
+				err = fmt.Errorf("%v: %s", ErrMalformed, de.msg)
+				return
+			}
+			err = fmt.Errorf("%v: %v", ErrMalformed, r)
+		}
+	}()
+	m = decode(&reader{b: bin})
+	for i, f := range m.funcs {
+		if err := m.compile(f); err != nil {
+			return nil, fmt.Errorf("wasm: function %d: %v", len(m.Imports)+i, err)
+		}
+		f.body = nil
+	}
+	return m, nil
+}
+
+// Exports returns the names of the functions the module exports.
+func (m *Module) Exports() []string {
+	var names []string
+	for name, e := range m.exports {
+		if e.kind == externFunc {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ExportType returns the signature of an exported function.
+func (m *Module) ExportType(name string) (FuncType, bool) {
+	e, ok := m.exports[name]
+	if !ok || e.kind != externFunc {
+		return FuncType{}, false
+	}
+	return m.funcType(e.index), true
+}
+
+func (m *Module) funcType(index uint32) FuncType {
+	if int(index) < len(m.Imports) {
+		return m.Imports[index].Type
+	}
+	return m.funcs[int(index)-len(m.Imports)].typ
+}
+
+func (m *Module) numFuncs() int {
+	return len(m.Imports) + len(m.funcs)
+}
+
+type reader struct {
+	b   []byte
+	pos int
+}
+
+func fail(format string, args ...interface{}) {
+	panic(decodeError{fmt.Sprintf(format, args...)})
+}
+
+func (r *reader) eof() bool { return r.pos >= len(r.b) }
+
+func (r *reader) byte() byte {
+	if r.pos >= len(r.b) {
+		fail("unexpected end")
+	}
+	b := r.b[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *reader) bytes(n uint32) []byte {
+	if uint64(r.pos)+uint64(n) > uint64(len(r.b)) {
+		fail("unexpected end")
+	}
+	b := r.b[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b
+}
+
+func (r *reader) uleb(bits uint) uint64 {
+	var v uint64
+	var shift uint
+	for {
+		b := r.byte()
+		v |= uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+		if shift >= bits+7 {
+			fail("integer too long")
+		}
+	}
+	if bits < 64 && v>>bits != 0 {
+		fail("integer too large")
+	}
+	return v
+}
+
+func (r *reader) sleb(bits uint) int64 {
+	var v int64
+	var shift uint
+	var b byte
+	for {
+		b = r.byte()
+		v |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+		if shift >= bits+7 {
+			fail("integer too long")
+		}
+	}
+	if shift < 64 && b&0x40 != 0 {
+		v |= -1 << shift
+	}
+	return v
+}
+
+func (r *reader) u32() uint32 { return uint32(r.uleb(32)) }
+
+// count reads the length of a vector, checking that it isn't larger than
+// what's left of the module, so that a bogus count can't make us
+// allocate a lot.
+func (r *reader) count() uint32 {
+	n := r.u32()
+	if int(n) > len(r.b)-r.pos {
+		fail("vector too long")
+	}
+	return n
+}
+
+func (r *reader) name() string {
+	b := r.bytes(r.u32())
+	if !utf8.Valid(b) {
+		fail("invalid UTF-8 name")
+	}
+	return string(b)
+}
+
+func (r *reader) valueType() ValueType {
+	t := ValueType(r.byte())
+	switch t {
+	case I32, I64, F32, F64, FuncRef, ExternRef:
+		return t
+	}
+	fail("unknown value type %#x", byte(t))
+	return 0
+}
+
+func (r *reader) limits() limits {
+	var l limits
+	switch flags := r.byte(); flags {
+	case 0:
+		l.min = r.u32()
+	case 1:
+		l.min, l.max, l.hasMax = r.u32(), r.u32(), true
+		if l.max < l.min {
+			fail("limits maximum is below the minimum")
+		}
+	default:
+		fail("unsupported limits flags %#x", flags)
+	}
+	return l
+}
+
+// constExpr evaluates a constant expression, as used for global initial
+// values and segment offsets.
+func (r *reader) constExpr(m *Module) uint64 {
+	var v uint64
+	switch op := r.byte(); op {
+	case opI32Const:
+		v = uint64(uint32(r.sleb(32)))
+	case opI64Const:
+		v = uint64(r.sleb(64))
+	case opF32Const:
+		v = uint64(binary.LittleEndian.Uint32(r.bytes(4)))
+	case opF64Const:
+		v = binary.LittleEndian.Uint64(r.bytes(8))
+	case opGlobalGet:
+		i := r.u32()
+		if int(i) >= len(m.globals) {
+			fail("unknown global %d in constant expression", i)
+		}
+		v = m.globals[i].init
+	case opRefNull:
+		r.byte()
+		v = math.MaxUint64
+	case opRefFunc:
+		v = uint64(r.u32())
+	default:
+		fail("unsupported constant expression opcode %#x", op)
+	}
+	if r.byte() != opEnd {
+		fail("constant expression too long")
+	}
+	return v
+}
+
+const (
+	sectionCustom = iota
+	sectionType
+	sectionImport
+	sectionFunction
+	sectionTable
+	sectionMemory
+	sectionGlobal
+	sectionExport
+	sectionStart
+	sectionElement
+	sectionCode
+	sectionData
+	sectionDataCount
+)
+
+func decode(r *reader) *Module {
+	if string(r.bytes(4)) != "\x00asm" {
+		fail("not a WebAssembly module")
+	}
+	if v := binary.LittleEndian.Uint32(r.bytes(4)); v != 1 {
+		fail("unsupported version %d", v)
+	}
+	m := &Module{exports: make(map[string]export), start: -1}
+	var funcTypes []uint32
+	for !r.eof() {
+		id := r.byte()
+		s := &reader{b: r.bytes(r.u32())}
+		switch id {
+		case sectionCustom:
+			continue
+		case sectionType:
+			for n := s.count(); n > 0; n-- {
+				if s.byte() != 0x60 {
+					fail("bad function type")
+				}
+				var t FuncType
+				for k := s.count(); k > 0; k-- {
+					t.Params = append(t.Params, s.valueType())
+				}
+				for k := s.count(); k > 0; k-- {
+					t.Results = append(t.Results, s.valueType())
+				}
+				m.types = append(m.types, t)
+			}
+		case sectionImport:
+			for n := s.count(); n > 0; n-- {
+				imp := Import{Module: s.name(), Name: s.name()}
+				if kind := s.byte(); kind != externFunc {
+					fail("import %s.%s: only functions can be imported", imp.Module, imp.Name)
+				}
+				imp.Type = m.typeAt(s.u32())
+				m.Imports = append(m.Imports, imp)
+			}
+		case sectionFunction:
+			for n := s.count(); n > 0; n-- {
+				funcTypes = append(funcTypes, s.u32())
+			}
+		case sectionTable:
+			for n := s.count(); n > 0; n-- {
+				if m.table != nil {
+					fail("multiple tables")
+				}
+				if t := s.valueType(); t != FuncRef {
+					fail("only funcref tables are supported")
+				}
+				l := s.limits()
+				m.table = &l
+			}
+		case sectionMemory:
+			for n := s.count(); n > 0; n-- {
+				if m.memory != nil {
+					fail("multiple memories")
+				}
+				l := s.limits()
+				if l.min > maxPages || (l.hasMax && l.max > maxPages) {
+					fail("memory too large")
+				}
+				m.memory = &l
+			}
+		case sectionGlobal:
+			for n := s.count(); n > 0; n-- {
+				s.valueType()
+				g := global{mutable: s.byte() == 1}
+				g.init = s.constExpr(m)
+				m.globals = append(m.globals, g)
+			}
+		case sectionExport:
+			for n := s.count(); n > 0; n-- {
+				name := s.name()
+				e := export{kind: s.byte(), index: s.u32()}
+				if _, dup := m.exports[name]; dup {
+					fail("duplicate export %q", name)
+				}
+				m.exports[name] = e
+			}
+		case sectionStart:
+			m.start = int(s.u32())
+		case sectionElement:
+			for n := s.count(); n > 0; n-- {
+				m.elements = append(m.elements, s.elemSegment(m))
+			}
+		case sectionCode:
+			if int(s.count()) != len(funcTypes) {
+				fail("function and code section counts differ")
+			}
+			for _, ti := range funcTypes {
+				body := &reader{b: s.bytes(s.u32())}
+				f := &function{typ: m.typeAt(ti)}
+				for k := body.count(); k > 0; k-- {
+					c := body.u32()
+					body.valueType()
+					f.nlocals += int(c)
+					if f.nlocals > maxLocals {
+						fail("too many locals")
+					}
+				}
+				f.body = body.b[body.pos:]
+				m.funcs = append(m.funcs, f)
+			}
+		case sectionData:
+			for n := s.count(); n > 0; n-- {
+				var d dataSegment
+				switch flags := s.u32(); flags {
+				case 0:
+					d.active, d.offset = true, uint32(s.constExpr(m))
+				case 1:
+				case 2:
+					if s.u32() != 0 {
+						fail("unknown memory")
+					}
+					d.active, d.offset = true, uint32(s.constExpr(m))
+				default:
+					fail("bad data segment flags %d", flags)
+				}
+				d.init = s.bytes(s.u32())
+				m.data = append(m.data, d)
+			}
+		case sectionDataCount:
+			s.u32()
+		default:
+			fail("unknown section %d", id)
+		}
+		if id != sectionCustom && !s.eof() {
+			fail("section %d is longer than its contents", id)
+		}
+	}
+	if len(funcTypes) != len(m.funcs) {
+		fail("function and code section counts differ")
+	}
+	for name, e := range m.exports {
+		if e.kind == externFunc && int(e.index) >= m.numFuncs() {
+			fail("export %q of unknown function %d", name, e.index)
+		}
+	}
+	for _, e := range m.elements {
+		for _, f := range e.funcs {
+			if f >= m.numFuncs() {
+				fail("unknown function %d in element segment", f)
+			}
+		}
+	}
+	if m.start >= m.numFuncs() {
+		fail("unknown start function %d", m.start)
+	}
+	return m
+}
+
+func (m *Module) typeAt(i uint32) FuncType {
+	if int(i) >= len(m.types) {
+		fail("unknown type %d", i)
+	}
+	return m.types[i]
+}
+
+func (s *reader) elemSegment(m *Module) elemSegment {
+	var e elemSegment
+	flags := s.u32()
+	if flags > 7 {
+		fail("bad element segment flags %d", flags)
+	}
+	switch {
+	case flags&1 == 0:
+		e.active = true
+		if flags&2 != 0 && s.u32() != 0 {
+			fail("unknown table")
+		}
+		e.offset = uint32(s.constExpr(m))
+	}
+	exprs := flags&4 != 0
+	if flags&3 != 0 {
+		// the element kind or reference type
+		s.byte()
+	}
+	for n := s.count(); n > 0; n-- {
+		var f uint64
+		if exprs {
+			f = s.constExpr(m)
+		} else {
+			f = uint64(s.u32())
+		}
+		if f == math.MaxUint64 {
+			e.funcs = append(e.funcs, -1)
+			continue
+		}
+		if f > math.MaxInt32 {
+			fail("unknown function %d in element segment", f)
+		}
+		e.funcs = append(e.funcs, int(f))
+	}
+	return e
+}
@@ -0,0 +1,347 @@
+package wasm
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Cases in the style of the WebAssembly spec test suite's assert_return
+// and assert_trap, for the parts of the interpreter that are easiest to
+// get subtly wrong.
+
+const (
+	opI32Eq     = 0x46
+	opI32Add    = 0x6a
+	opI32Sub    = 0x6b
+	opI64Load   = 0x29
+	opI32Load8U = 0x2d
+)
+
+type specCase struct {
+	fn   string
+	args []uint64
+	want []uint64 // the results, if it doesn't trap
+	trap string   // part of the error, if it does
+}
+
+func runSpec(t *testing.T, in *Instance, cases []specCase) {
+	t.Helper()
+	for _, tc := range cases {
+		got, err := in.Call(tc.fn, tc.args...)
+		switch {
+		case tc.trap != "":
+			if err == nil || !strings.Contains(err.Error(), tc.trap) {
+				t.Errorf("%s%v: got %v, %v, want a %q trap", tc.fn, tc.args, got, err, tc.trap)
+			}
+		case err != nil:
+			t.Errorf("%s%v: %v", tc.fn, tc.args, err)
+		case len(got) != len(tc.want):
+			t.Errorf("%s%v = %v, want %v", tc.fn, tc.args, got, tc.want)
+		default:
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("%s%v = %v, want %v", tc.fn, tc.args, got, tc.want)
+					break
+				}
+			}
+		}
+	}
+}
+
+func u(vs ...uint64) []uint64 { return vs }
+
+var (
+	noneI32    = FuncType{Results: []ValueType{I32}}
+	noneI32x2  = FuncType{Results: []ValueType{I32, I32}}
+	i32x2I32x2 = FuncType{Params: []ValueType{I32, I32}, Results: []ValueType{I32, I32}}
+)
+
+func controlFlowModule() *testModule {
+	tm := &testModule{}
+	// block types with parameters or several results are type indexes
+	i32i32Block := sleb(int64(tm.typeIndex(i32i32)))
+	pairBlock := sleb(int64(tm.typeIndex(noneI32x2)))
+	tm.funcs = []testFunc{
+		{name: "block", typ: noneI32, body: code(opBlock, I32, i32c(1), opEnd)},
+		// a br out of two blocks skips the add
+		{name: "nested-br", typ: noneI32, body: code(
+			opBlock, I32,
+			opBlock, I32, i32c(2), opBr, 1, opEnd,
+			i32c(3), opI32Add,
+			opEnd,
+		)},
+		{name: "br-if", typ: i32i32, body: code(
+			opBlock, I32,
+			i32c(10), opLocalGet, 0, opBrIf, 0,
+			opDrop, i32c(20),
+			opEnd,
+		)},
+		// sums n..1, keeping the sum as the loop's parameter
+		{name: "loop-params", typ: i32i32, body: code(
+			i32c(0),
+			opLoop, i32i32Block,
+			opLocalGet, 0, opI32Add,
+			opLocalGet, 0, i32c(1), opI32Sub, opLocalTee, 0,
+			opBrIf, 0,
+			opEnd,
+		)},
+		{name: "if", typ: i32i32, locals: []ValueType{I32}, body: code(
+			opLocalGet, 0,
+			opIf, 0x40, i32c(7), opLocalSet, 1, opEnd,
+			opLocalGet, 1,
+		)},
+		{name: "if-else", typ: i32i32, body: code(
+			opLocalGet, 0,
+			opIf, I32,
+			opLocalGet, 0, i32c(1), opI32Eq,
+			opIf, I32, i32c(10), opElse, i32c(20), opEnd,
+			opElse,
+			i32c(30),
+			opEnd,
+		)},
+		{name: "if-params", typ: i32i32, body: code(
+			i32c(6), opLocalGet, 0,
+			opIf, i32i32Block, i32c(1), opI32Add,
+			opElse, i32c(1), opI32Sub,
+			opEnd,
+		)},
+		// 0 and anything out of range go to the inner block, and 1
+		// to the outer one
+		{name: "br-table", typ: i32i32, body: code(
+			opBlock, I32,
+			opBlock, I32,
+			i32c(100), opLocalGet, 0, opBrTable, 2, 0, 1, 0,
+			opEnd,
+			i32c(1), opI32Add,
+			opEnd,
+		)},
+		{name: "return-loop", typ: noneI32, body: code(
+			opLoop, 0x40, i32c(42), opReturn, opEnd,
+			i32c(0),
+		)},
+		// a br to the function's own label unwinds the blocks inside
+		// it, and what they left on the stack
+		{name: "unwind", typ: noneI32, body: code(
+			opBlock, I32,
+			i32c(1), i32c(2),
+			opLoop, I32, i32c(3), opBr, 2, opEnd,
+			opI32Add, opI32Add,
+			opEnd,
+		)},
+		{name: "swap", typ: i32x2I32x2, body: code(opLocalGet, 1, opLocalGet, 0)},
+		{name: "call-multi", typ: noneI32, body: code(
+			i32c(1), i32c(5), opCall, 10, opI32Sub,
+		)},
+		// a br keeps the top values of the stack, dropping the ones
+		// below
+		{name: "br-multi", typ: noneI32, body: code(
+			opBlock, pairBlock,
+			i32c(1), i32c(2), i32c(3), opBr, 0,
+			opEnd,
+			opI32Sub,
+		)},
+	}
+	return tm
+}
+
+func TestSpecControlFlow(t *testing.T) {
+	in := controlFlowModule().instantiate(t, Config{}, nil)
+	runSpec(t, in, []specCase{
+		{fn: "block", want: u(1)},
+		{fn: "nested-br", want: u(2)},
+		{fn: "br-if", args: u(1), want: u(10)},
+		{fn: "br-if", args: u(0), want: u(20)},
+		{fn: "loop-params", args: u(4), want: u(10)},
+		{fn: "loop-params", args: u(1), want: u(1)},
+		{fn: "if", args: u(0), want: u(0)},
+		{fn: "if", args: u(3), want: u(7)},
+		{fn: "if-else", args: u(0), want: u(30)},
+		{fn: "if-else", args: u(1), want: u(10)},
+		{fn: "if-else", args: u(5), want: u(20)},
+		{fn: "if-params", args: u(1), want: u(7)},
+		{fn: "if-params", args: u(0), want: u(5)},
+		{fn: "br-table", args: u(0), want: u(101)},
+		{fn: "br-table", args: u(1), want: u(100)},
+		{fn: "br-table", args: u(7), want: u(101)},
+		{fn: "br-table", args: u(math.MaxUint32), want: u(101)},
+		{fn: "return-loop", want: u(42)},
+		{fn: "unwind", want: u(3)},
+		{fn: "swap", args: u(1, 2), want: u(2, 1)},
+		{fn: "call-multi", want: u(4)},
+		{fn: "br-multi", want: u(math.MaxUint32)},
+	})
+}
+
+func TestSpecMemoryBounds(t *testing.T) {
+	i32void := FuncType{Params: []ValueType{I32}}
+	tm := &testModule{
+		memory: &limits{min: 1, max: 2, hasMax: true},
+		data:   []byte("abcdef"),
+		funcs: []testFunc{
+			{name: "load8", typ: i32i32, body: code(opLocalGet, 0, opI32Load8U, 0, 0)},
+			{name: "load-offset", typ: i32i32, body: code(opLocalGet, 0, opI32Load, 2, 1)},
+			{name: "load64", typ: FuncType{Params: []ValueType{I32}, Results: []ValueType{I64}}, body: code(
+				opLocalGet, 0, opI64Load, 3, 0,
+			)},
+			// the offset is added without wrapping around
+			{name: "load-max-offset", typ: i32i32, body: code(
+				opLocalGet, 0, opI32Load, 2, uleb(math.MaxUint32),
+			)},
+			{name: "store", typ: i32void, body: code(opLocalGet, 0, i32c(-1), opI32Store, 2, 0)},
+			{name: "fill", typ: FuncType{Params: []ValueType{I32, I32}}, body: code(
+				opLocalGet, 0, i32c(0x55), opLocalGet, 1, opPrefix, 11, 0,
+			)},
+			{name: "copy", typ: FuncType{Params: []ValueType{I32, I32, I32}}, body: code(
+				opLocalGet, 0, opLocalGet, 1, opLocalGet, 2, opPrefix, 10, 0, 0,
+			)},
+			{name: "grow", typ: i32i32, body: code(opLocalGet, 0, opMemoryGrow, 0)},
+		},
+	}
+	in := tm.instantiate(t, Config{}, nil)
+	const oob = "out of bounds memory access"
+	runSpec(t, in, []specCase{
+		{fn: "load8", args: u(pageSize - 1), want: u(0)},
+		{fn: "load8", args: u(pageSize), trap: oob},
+		{fn: "load8", args: u(math.MaxUint32), trap: oob},
+		{fn: "load-offset", args: u(pageSize - 5), want: u(0)},
+		{fn: "load-offset", args: u(pageSize - 4), trap: oob},
+		{fn: "load64", args: u(pageSize - 8), want: u(0)},
+		{fn: "load64", args: u(pageSize - 7), trap: oob},
+		{fn: "load-max-offset", args: u(0), trap: oob},
+		{fn: "load-max-offset", args: u(1), trap: oob},
+		{fn: "store", args: u(pageSize - 3), trap: oob},
+	})
+	// a store that traps doesn't write anything
+	if got := in.Memory()[pageSize-3:]; !bytes.Equal(got, []byte{0, 0, 0}) {
+		t.Fatalf("memory after a trapped store: %v", got)
+	}
+	runSpec(t, in, []specCase{
+		{fn: "store", args: u(pageSize - 4), want: u()},
+		{fn: "load8", args: u(pageSize - 1), want: u(0xff)},
+		// bulk operations may be empty at the very end of memory,
+		// but not past it
+		{fn: "fill", args: u(pageSize-1, 1), want: u()},
+		{fn: "fill", args: u(pageSize-1, 2), trap: oob},
+		{fn: "fill", args: u(pageSize, 0), want: u()},
+		{fn: "fill", args: u(pageSize+1, 0), trap: oob},
+		{fn: "copy", args: u(0, pageSize-1, 2), trap: oob},
+		{fn: "copy", args: u(pageSize-1, 0, 2), trap: oob},
+		{fn: "copy", args: u(1, 0, 5), want: u()},
+	})
+	if got := string(in.Memory()[:6]); got != "aabcde" {
+		t.Fatalf("overlapping copy: got %q", got)
+	}
+	if got := in.Memory()[pageSize-1]; got != 0x55 {
+		t.Fatalf("fill: got %#x", got)
+	}
+	// grown memory is addressable, and zeroed
+	runSpec(t, in, []specCase{
+		{fn: "grow", args: u(1), want: u(1)},
+		{fn: "load8", args: u(pageSize), want: u(0)},
+		{fn: "load64", args: u(2*pageSize - 8), want: u(0)},
+		{fn: "load8", args: u(2 * pageSize), trap: oob},
+		{fn: "grow", args: u(1), want: u(math.MaxUint32)},
+		{fn: "grow", args: u(0), want: u(2)},
+	})
+}
+
+// fuelNeeded returns the least fuel a call succeeds with.
+func fuelNeeded(t *testing.T, in *Instance, fn string, args ...uint64) int64 {
+	t.Helper()
+	for fuel := int64(1); fuel < 100000; fuel++ {
+		in.cfg.Fuel = fuel
+		_, err := in.Call(fn, args...)
+		switch err {
+		case nil:
+			return fuel
+		case ErrFuelExhausted:
+		default:
+			t.Fatalf("%s%v: %v", fn, args, err)
+		}
+	}
+	t.Fatalf("%s%v never finished", fn, args)
+	return 0
+}
+
+func TestSpecFuel(t *testing.T) {
+	tm := &testModule{
+		memory:  &limits{min: 1},
+		imports: []Import{{Module: "env", Name: "work", Type: void}},
+		funcs: []testFunc{
+			{name: "add", typ: noneI32, body: code(i32c(1), i32c(2), opI32Add)},
+			// structure alone costs nothing
+			{name: "blocks", typ: void, body: code(
+				opNop, opBlock, 0x40, opLoop, 0x40, opNop, opEnd, opEnd,
+			)},
+			{name: "count", typ: FuncType{Params: []ValueType{I32}}, body: code(
+				opBlock, 0x40,
+				opLoop, 0x40,
+				opLocalGet, 0, opI32Eqz, opBrIf, 1,
+				opLocalGet, 0, i32c(1), opI32Sub, opLocalSet, 0,
+				opBr, 0,
+				opEnd,
+				opEnd,
+			)},
+			{name: "call", typ: noneI32, body: code(opCall, 1)},
+			{name: "host", typ: void, body: code(opCall, 0)},
+			// asks for more memory than it may have, then spins
+			{name: "grow-spin", typ: void, body: code(
+				i32c(10), opMemoryGrow, 0, opDrop,
+				opLoop, 0x40, opBr, 0, opEnd,
+			)},
+		},
+	}
+	imports := map[string]map[string]HostFunc{"env": {"work": {
+		Type: void,
+		Func: func(*Instance, []uint64) ([]uint64, error) { return nil, nil },
+	}}}
+	in := tm.instantiate(t, Config{MaxMemoryPages: 2}, imports)
+
+	// three instructions and the return at the end
+	if got := fuelNeeded(t, in, "add"); got != 4 {
+		t.Errorf("add needs %d fuel, want 4", got)
+	}
+	if got := fuelNeeded(t, in, "blocks"); got != 1 {
+		t.Errorf("blocks need %d fuel, want 1", got)
+	}
+	// a call costs one, plus what the callee uses
+	if got := fuelNeeded(t, in, "call"); got != 6 {
+		t.Errorf("call needs %d fuel, want 6", got)
+	}
+	// host functions aren't metered
+	if got := fuelNeeded(t, in, "host"); got != 2 {
+		t.Errorf("host call needs %d fuel, want 2", got)
+	}
+	// loops cost the same on every iteration
+	base := fuelNeeded(t, in, "count", 0)
+	each := fuelNeeded(t, in, "count", 1) - base
+	if got, want := fuelNeeded(t, in, "count", 50), base+50*each; got != want {
+		t.Errorf("count(50) needs %d fuel, want %d", got, want)
+	}
+
+	// running out of fuel is reported as such, even after memory was
+	// refused
+	in.cfg.Fuel = 1000
+	if _, err := in.Call("grow-spin"); err != ErrFuelExhausted {
+		t.Fatalf("got %v, want %v", err, ErrFuelExhausted)
+	}
+	if got, err := in.Call("add"); err != nil || got[0] != 3 {
+		t.Fatalf("add = %v, %v after running out of fuel", got, err)
+	}
+
+	// the start function is metered too
+	start := uint32(0)
+	spin := &testModule{
+		start: &start,
+		funcs: []testFunc{{typ: void, body: code(opLoop, 0x40, opBr, 0, opEnd)}},
+	}
+	m, err := Compile(spin.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Instantiate(nil, Config{Fuel: 1000}); err != ErrFuelExhausted {
+		t.Fatalf("got %v, want %v", err, ErrFuelExhausted)
+	}
+}
@@ -0,0 +1,482 @@
+package wasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+// testModule builds a binary module from function bodies written as
+// opcodes.
+type testModule struct {
+	types   []FuncType
+	imports []Import
+	funcs   []testFunc
+	memory  *limits
+	table   []uint32 // function indexes in an active element segment
+	data    []byte   // an active data segment at 0
+	passive []byte   // a passive data segment
+	start   *uint32  // the start function's index, if any
+}
+
+type testFunc struct {
+	name   string
+	typ    FuncType
+	locals []ValueType
+	body   []byte
+}
+
+func uleb(v uint64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+func sleb(v int64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+const (
+	opI32Eqz = 0x45
+	opI64Eqz = 0x50
+)
+
+func i32c(v int32) []byte { return append([]byte{opI32Const}, sleb(int64(v))...) }
+func i64c(v int64) []byte { return append([]byte{opI64Const}, sleb(v)...) }
+
+func f64c(v float64) []byte {
+	b := make([]byte, 9)
+	b[0] = opF64Const
+	binary.LittleEndian.PutUint64(b[1:], math.Float64bits(v))
+	return b
+}
+
+func code(parts ...interface{}) []byte {
+	var b []byte
+	for _, p := range parts {
+		switch x := p.(type) {
+		case int:
+			b = append(b, byte(x))
+		case ValueType:
+			b = append(b, byte(x))
+		case []byte:
+			b = append(b, x...)
+		}
+	}
+	return b
+}
+
+func vec(items [][]byte) []byte {
+	b := uleb(uint64(len(items)))
+	for _, it := range items {
+		b = append(b, it...)
+	}
+	return b
+}
+
+func str(s string) []byte { return append(uleb(uint64(len(s))), s...) }
+
+func (tm *testModule) typeIndex(t FuncType) int {
+	for i, other := range tm.types {
+		if other.Equal(t) {
+			return i
+		}
+	}
+	tm.types = append(tm.types, t)
+	return len(tm.types) - 1
+}
+
+func (tm *testModule) bytes() []byte {
+	out := []byte("\x00asm\x01\x00\x00\x00")
+	section := func(id byte, body []byte) {
+		out = append(out, id)
+		out = append(out, uleb(uint64(len(body)))...)
+		out = append(out, body...)
+	}
+	var imports, funcs, codes [][]byte
+	for _, imp := range tm.imports {
+		b := append(str(imp.Module), str(imp.Name)...)
+		b = append(b, externFunc)
+		imports = append(imports, append(b, uleb(uint64(tm.typeIndex(imp.Type)))...))
+	}
+	var exports [][]byte
+	for i, f := range tm.funcs {
+		funcs = append(funcs, uleb(uint64(tm.typeIndex(f.typ))))
+		var locals [][]byte
+		for _, l := range f.locals {
+			locals = append(locals, []byte{1, byte(l)})
+		}
+		body := append(vec(locals), f.body...)
+		body = append(body, opEnd)
+		codes = append(codes, append(uleb(uint64(len(body))), body...))
+		if f.name != "" {
+			e := append(str(f.name), externFunc)
+			exports = append(exports, append(e, uleb(uint64(len(tm.imports)+i))...))
+		}
+	}
+	var types [][]byte
+	for _, t := range tm.types {
+		b := []byte{0x60}
+		b = append(b, uleb(uint64(len(t.Params)))...)
+		for _, p := range t.Params {
+			b = append(b, byte(p))
+		}
+		b = append(b, uleb(uint64(len(t.Results)))...)
+		for _, r := range t.Results {
+			b = append(b, byte(r))
+		}
+		types = append(types, b)
+	}
+	section(sectionType, vec(types))
+	if len(imports) > 0 {
+		section(sectionImport, vec(imports))
+	}
+	section(sectionFunction, vec(funcs))
+	if tm.table != nil {
+		section(sectionTable, code(1, FuncRef, 0, uleb(uint64(len(tm.table)))))
+	}
+	if tm.memory != nil {
+		if tm.memory.hasMax {
+			section(sectionMemory, code(1, 1, uleb(uint64(tm.memory.min)), uleb(uint64(tm.memory.max))))
+		} else {
+			section(sectionMemory, code(1, 0, uleb(uint64(tm.memory.min))))
+		}
+		exports = append(exports, append(str("memory"), externMemory, 0))
+	}
+	section(sectionExport, vec(exports))
+	if tm.start != nil {
+		section(sectionStart, uleb(uint64(*tm.start)))
+	}
+	if tm.table != nil {
+		var elems [][]byte
+		for _, fi := range tm.table {
+			elems = append(elems, uleb(uint64(fi)))
+		}
+		section(sectionElement, code(1, 0, i32c(0), opEnd, vec(elems)))
+	}
+	section(sectionCode, vec(codes))
+	var data [][]byte
+	if tm.data != nil {
+		data = append(data, code(0, i32c(0), opEnd, str(string(tm.data))))
+	}
+	if tm.passive != nil {
+		data = append(data, code(1, str(string(tm.passive))))
+	}
+	if data != nil {
+		section(sectionData, vec(data))
+	}
+	return out
+}
+
+func (tm *testModule) instantiate(t *testing.T, cfg Config, imports map[string]map[string]HostFunc) *Instance {
+	t.Helper()
+	m, err := Compile(tm.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := m.Instantiate(imports, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
+
+var (
+	i32i32   = FuncType{Params: []ValueType{I32}, Results: []ValueType{I32}}
+	i64i64   = FuncType{Params: []ValueType{I64}, Results: []ValueType{I64}}
+	i32x2i32 = FuncType{Params: []ValueType{I32, I32}, Results: []ValueType{I32}}
+	void     = FuncType{}
+)
+
+func TestArithmetic(t *testing.T) {
+	tm := &testModule{funcs: []testFunc{
+		{name: "fact", typ: i64i64, body: code(
+			opLocalGet, 0, opI64Eqz,
+			opIf, I64, i64c(1),
+			opElse,
+			opLocalGet, 0,
+			opLocalGet, 0, i64c(1), 0x7d, // i64.sub
+			opCall, 0,
+			0x7e, // i64.mul
+			opEnd,
+		)},
+		// sums 1..n with a loop
+		{name: "sum", typ: i32i32, locals: []ValueType{I32}, body: code(
+			opBlock, 0x40,
+			opLoop, 0x40,
+			opLocalGet, 0, opI32Eqz, opBrIf, 1,
+			opLocalGet, 1, opLocalGet, 0, 0x6a, opLocalSet, 1,
+			opLocalGet, 0, i32c(1), 0x6b, opLocalSet, 0,
+			opBr, 0,
+			opEnd,
+			opEnd,
+			opLocalGet, 1,
+		)},
+		// maps 0, 1, 2 and anything else to 10, 20, 30 and 40
+		{name: "switch", typ: i32i32, body: code(
+			opBlock, 0x40,
+			opBlock, 0x40,
+			opBlock, 0x40,
+			opBlock, 0x40,
+			opLocalGet, 0,
+			opBrTable, 3, 0, 1, 2, 3,
+			opEnd,
+			i32c(10), opReturn,
+			opEnd,
+			i32c(20), opReturn,
+			opEnd,
+			i32c(30), opReturn,
+			opEnd,
+			i32c(40),
+		)},
+		// a block with a result, left early by a br with junk below
+		{name: "early", typ: i32i32, body: code(
+			opBlock, I32,
+			i32c(7), i32c(8),
+			opLocalGet, 0, opBrIf, 0,
+			opDrop,
+			opEnd,
+		)},
+		{name: "divs", typ: i32x2i32, body: code(opLocalGet, 0, opLocalGet, 1, 0x6d)},
+		{name: "trunc", typ: FuncType{Results: []ValueType{I32}}, body: code(f64c(-3.9), 0xaa)},
+		{name: "sat", typ: FuncType{Results: []ValueType{I32}}, body: code(f64c(1e20), opPrefix, 2)},
+		{name: "rotl", typ: i32x2i32, body: code(opLocalGet, 0, opLocalGet, 1, 0x77)},
+	}}
+	in := tm.instantiate(t, Config{}, nil)
+	tests := []struct {
+		fn   string
+		args []uint64
+		want uint64
+	}{
+		{"fact", []uint64{0}, 1},
+		{"fact", []uint64{20}, 2432902008176640000},
+		{"sum", []uint64{100}, 5050},
+		{"switch", []uint64{0}, 10},
+		{"switch", []uint64{2}, 30},
+		{"switch", []uint64{1000}, 40},
+		{"early", []uint64{1}, 8},
+		{"early", []uint64{0}, 7},
+		{"divs", []uint64{uint64(uint32(0xfffffff9)), 2}, uint64(uint32(0xfffffffd))},
+		{"trunc", nil, uint64(uint32(0xfffffffd))},
+		{"sat", nil, math.MaxInt32},
+		{"rotl", []uint64{0x80000001, 1}, 3},
+	}
+	for _, tc := range tests {
+		got, err := in.Call(tc.fn, tc.args...)
+		if err != nil {
+			t.Errorf("%s%v: %v", tc.fn, tc.args, err)
+			continue
+		}
+		if len(got) != 1 || got[0] != tc.want {
+			t.Errorf("%s%v = %v, want %d", tc.fn, tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestTraps(t *testing.T) {
+	tm := &testModule{
+		memory: &limits{min: 1},
+		table:  []uint32{0},
+		funcs: []testFunc{
+			{name: "divs", typ: i32x2i32, body: code(opLocalGet, 0, opLocalGet, 1, 0x6d)},
+			{name: "load", typ: i32i32, body: code(opLocalGet, 0, opI32Load, 2, 0)},
+			{name: "indirect", typ: void, body: code(i32c(0), opCallIndirect, 0, 0)},
+			{name: "unreachable", typ: void, body: code(opUnreachable)},
+			{name: "recurse", typ: void, body: code(opCall, 4)},
+		},
+	}
+	tm.typeIndex(void) // type 0, which the table's function doesn't have
+	in := tm.instantiate(t, Config{}, nil)
+	tests := []struct {
+		fn   string
+		args []uint64
+		want string
+	}{
+		{"divs", []uint64{1, 0}, "integer divide by zero"},
+		{"divs", []uint64{0x80000000, 0xffffffff}, "integer overflow"},
+		{"load", []uint64{pageSize - 2}, "out of bounds memory access"},
+		{"indirect", nil, "indirect call type mismatch"},
+		{"unreachable", nil, "unreachable"},
+		{"recurse", nil, ErrCallStackExhausted.Error()},
+	}
+	for _, tc := range tests {
+		_, err := in.Call(tc.fn, tc.args...)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s%v: got error %v, want %q", tc.fn, tc.args, err, tc.want)
+		}
+	}
+	// the instance is still usable after a trap
+	if got, err := in.Call("divs", 9, 3); err != nil || got[0] != 3 {
+		t.Errorf("divs(9, 3) = %v, %v after traps", got, err)
+	}
+}
+
+func TestFuel(t *testing.T) {
+	tm := &testModule{funcs: []testFunc{
+		{name: "spin", typ: void, body: code(opLoop, 0x40, opBr, 0, opEnd)},
+		{name: "sum", typ: i32i32, locals: []ValueType{I32}, body: code(
+			opBlock, 0x40,
+			opLoop, 0x40,
+			opLocalGet, 0, opI32Eqz, opBrIf, 1,
+			opLocalGet, 1, opLocalGet, 0, 0x6a, opLocalSet, 1,
+			opLocalGet, 0, i32c(1), 0x6b, opLocalSet, 0,
+			opBr, 0,
+			opEnd,
+			opEnd,
+			opLocalGet, 1,
+		)},
+	}}
+	in := tm.instantiate(t, Config{Fuel: 10000}, nil)
+	if _, err := in.Call("spin"); err != ErrFuelExhausted {
+		t.Fatalf("got %v, want %v", err, ErrFuelExhausted)
+	}
+	// fuel is per call
+	for i := 0; i < 3; i++ {
+		if got, err := in.Call("sum", 100); err != nil || got[0] != 5050 {
+			t.Fatalf("sum(100) = %v, %v", got, err)
+		}
+	}
+	if _, err := in.Call("sum", 10000); err != ErrFuelExhausted {
+		t.Fatalf("got %v, want %v", err, ErrFuelExhausted)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	tm := &testModule{
+		memory:  &limits{min: 1},
+		data:    []byte("hello"),
+		passive: []byte("world"),
+		funcs: []testFunc{
+			{name: "grow", typ: i32i32, body: code(opLocalGet, 0, opMemoryGrow, 0)},
+			{name: "size", typ: FuncType{Results: []ValueType{I32}}, body: code(opMemorySize, 0)},
+			// grows memory, or traps like allocators do when
+			// that fails
+			{name: "alloc", typ: i32i32, body: code(
+				opLocalGet, 0, opMemoryGrow, 0,
+				i32c(-1), 0x46,
+				opIf, 0x40, opUnreachable, opEnd,
+				opMemorySize, 0,
+			)},
+			{name: "init", typ: void, body: code(
+				i32c(5), i32c(0), i32c(5), opPrefix, 8, 1, 0,
+				i32c(10), i32c(0), i32c(10), opPrefix, 10, 0, 0,
+				i32c(4), i32c('!'), i32c(1), opPrefix, 11, 0,
+			)},
+			{name: "store", typ: void, body: code(i32c(20), i64c(-2), 0x3d, 1, 0)},
+		},
+	}
+	in := tm.instantiate(t, Config{MaxMemoryPages: 3}, nil)
+	if got := string(in.Memory()[:5]); got != "hello" {
+		t.Fatalf("data segment: got %q", got)
+	}
+	if _, err := in.Call("init"); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(in.Memory()[:20]); got != "hell!worldhelloworld" {
+		t.Fatalf("bulk memory: got %q", got)
+	}
+	if _, err := in.Call("store"); err != nil {
+		t.Fatal(err)
+	}
+	if got := in.Memory()[20:23]; !bytes.Equal(got, []byte{0xfe, 0xff, 0}) {
+		t.Fatalf("i64.store16: got %v", got)
+	}
+	if got, err := in.Call("grow", 1); err != nil || got[0] != 1 {
+		t.Fatalf("grow(1) = %v, %v", got, err)
+	}
+	if got, err := in.Call("grow", 2); err != nil || uint32(got[0]) != math.MaxUint32 {
+		t.Fatalf("grow(2) = %v, %v, want -1", got, err)
+	}
+	if got, err := in.Call("size"); err != nil || got[0] != 2 {
+		t.Fatalf("size = %v, %v", got, err)
+	}
+	if _, err := in.Call("alloc", 5); err != ErrMemoryLimit {
+		t.Fatalf("got %v, want %v", err, ErrMemoryLimit)
+	}
+
+	tm.memory.min = 4
+	m, err := Compile(tm.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Instantiate(nil, Config{MaxMemoryPages: 3}); err == nil {
+		t.Fatal("expected instantiating over the memory limit to fail")
+	}
+}
+
+func TestHostFunc(t *testing.T) {
+	var logged string
+	tm := &testModule{
+		memory:  &limits{min: 1},
+		data:    []byte("from wasm"),
+		imports: []Import{{Module: "env", Name: "log", Type: FuncType{Params: []ValueType{I32, I32}, Results: []ValueType{I32}}}},
+		funcs: []testFunc{
+			{name: "run", typ: FuncType{Results: []ValueType{I32}}, body: code(
+				i32c(0), i32c(9), opCall, 0, i32c(1), 0x6a,
+			)},
+		},
+	}
+	imports := map[string]map[string]HostFunc{"env": {"log": {
+		Type: tm.imports[0].Type,
+		Func: func(in *Instance, args []uint64) ([]uint64, error) {
+			logged = string(in.Memory()[args[0] : args[0]+args[1]])
+			return []uint64{41}, nil
+		},
+	}}}
+	in := tm.instantiate(t, Config{}, imports)
+	if got, err := in.Call("run"); err != nil || got[0] != 42 {
+		t.Fatalf("run = %v, %v", got, err)
+	}
+	if logged != "from wasm" {
+		t.Fatalf("host got %q", logged)
+	}
+
+	m, _ := Compile(tm.bytes())
+	if _, err := m.Instantiate(nil, Config{}); err == nil {
+		t.Fatal("expected a missing import to fail")
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	good := (&testModule{funcs: []testFunc{{name: "f", typ: i32i32, body: code(opLocalGet, 0)}}}).bytes()
+	bad := map[string][]byte{
+		"empty":     nil,
+		"magic":     []byte("\x00wasm\x01\x00\x00\x00"),
+		"truncated": good[:len(good)-3],
+		"underflow": (&testModule{funcs: []testFunc{{typ: i32i32, body: code(0x6a)}}}).bytes(),
+		"leftover":  (&testModule{funcs: []testFunc{{typ: i32i32, body: code(opLocalGet, 0, opLocalGet, 0)}}}).bytes(),
+		"local":     (&testModule{funcs: []testFunc{{typ: i32i32, body: code(opLocalGet, 5)}}}).bytes(),
+		"label":     (&testModule{funcs: []testFunc{{typ: void, body: code(opBr, 3)}}}).bytes(),
+		"br":        (&testModule{funcs: []testFunc{{typ: void, body: code(opBlock, I32, opBr, 0, opEnd, opDrop)}}}).bytes(),
+		"return":    (&testModule{funcs: []testFunc{{typ: i64i64, body: code(opReturn)}}}).bytes(),
+		"br_table":  (&testModule{funcs: []testFunc{{typ: void, body: code(opBlock, I32, opBlock, 0x40, i32c(0), opBrTable, 1, 0, 1, opEnd, i32c(1), opEnd, opDrop)}}}).bytes(),
+		"memory":    (&testModule{funcs: []testFunc{{typ: i32i32, body: code(opLocalGet, 0, opI32Load, 2, 0)}}}).bytes(),
+	}
+	for name, b := range bad {
+		if _, err := Compile(b); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := Compile(good); err != nil {
+		t.Fatal(err)
+	}
+}
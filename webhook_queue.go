@@ -0,0 +1,345 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+
+	"github.com/TykTechnologies/tyk/apidef"
+	"github.com/TykTechnologies/tyk/storage"
+)
+
+const (
+	webhookDeliveryPrefix = "webhook.delivery."
+	webhookQueueKey       = "webhook.queue"
+	webhookDeadLetterKey  = "webhook.deadletter"
+
+	webhookDefaultMaxRetryAge = 24 * 60 * 60 // seconds
+	webhookDeadLetterTTL      = 7 * 24 * 60 * 60
+	// webhookDefaultTimeout is how long an attempt may take, unless
+	// the handler's request_timeout says otherwise.
+	webhookDefaultTimeout = 10 // seconds
+
+	// webhookMaxConcurrentRetries is how many retries are sent at once.
+	webhookMaxConcurrentRetries = 10
+)
+
+var (
+	// webhookRetryInterval is how long the first retry of a delivery
+	// waits, doubling for each one after up to webhookMaxRetryInterval.
+	webhookRetryInterval    = 5 * time.Second
+	webhookMaxRetryInterval = time.Hour
+	webhookPollInterval     = time.Second
+)
+
+// webhookStorage is implemented by the stores that can keep the
+// webhook deliveries that are yet to be made.
+type webhookStorage interface {
+	GetRawKey(string) (string, error)
+	SetRawKey(string, string, int64) error
+	DeleteRawKey(string) bool
+	AddToSortedSet(keyName, value string, score float64)
+	GetSortedSetRange(keyName, scoreFrom, scoreTo string) ([]string, error)
+	RemoveFromSortedSet(keyName, value string) bool
+}
+
+// WebHookDelivery is a webhook request, kept in storage until it's made.
+// Those that still fail once they're MaxAge seconds old are moved to the
+// dead letters, where they can be replayed from the REST API.
+type WebHookDelivery struct {
+	ID          string            `json:"id"`
+	Event       apidef.TykEvent   `json:"event"`
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	Headers     map[string]string `json:"headers"`
+	Body        string            `json:"body"`
+	Created     time.Time         `json:"created"`
+	MaxAge      int64             `json:"max_age"`
+	Timeout     int64             `json:"timeout"`
+	RetryUntil  time.Time         `json:"retry_until"`
+	Attempts    int               `json:"attempts"`
+	NextAttempt time.Time         `json:"next_attempt"`
+	LastError   string            `json:"last_error,omitempty"`
+}
+
+// signWebHook signs a delivery's ID and body, joined by a dot, so that
+// receivers can tell it's from us and that it's not a replay of one they
+// already had.
+func signWebHook(secret, id, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, id+"."+body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookQueue makes webhook deliveries, retrying those that fail. Any
+// gateway using the same storage may retry a delivery, and the one that
+// takes it off the queue does.
+type webhookQueue struct {
+	store webhookStorage
+
+	startOnce sync.Once
+}
+
+var webhooks = &webhookQueue{store: storage.RedisCluster{}}
+
+// start runs the retries in the background, once.
+func (q *webhookQueue) start() {
+	q.startOnce.Do(func() {
+		go func() {
+			for range time.Tick(webhookPollInterval) {
+				q.retryDue(time.Now())
+			}
+		}()
+	})
+}
+
+func (q *webhookQueue) save(d *WebHookDelivery, ttl int64) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return q.store.SetRawKey(webhookDeliveryPrefix+d.ID, string(b), ttl)
+}
+
+func (q *webhookQueue) load(id string) (*WebHookDelivery, error) {
+	s, err := q.store.GetRawKey(webhookDeliveryPrefix + id)
+	if err != nil {
+		return nil, err
+	}
+	d := &WebHookDelivery{}
+	if err := json.Unmarshal([]byte(s), d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// schedule keeps a delivery for its next attempt.
+func (q *webhookQueue) schedule(d *WebHookDelivery) error {
+	ttl := int64(d.RetryUntil.Sub(time.Now())/time.Second) + webhookDeadLetterTTL
+	if err := q.save(d, ttl); err != nil {
+		return err
+	}
+	q.store.AddToSortedSet(webhookQueueKey, d.ID, float64(d.NextAttempt.UnixNano()/int64(time.Millisecond)))
+	return nil
+}
+
+// deliver makes a new delivery. It's stored first, due for its first
+// retry, so that it's retried even if this gateway goes away during the
+// attempt. An attempt that takes longer than that may be made twice,
+// which receivers can tell by the delivery's ID.
+func (q *webhookQueue) deliver(d *WebHookDelivery) {
+	d.NextAttempt = time.Now().Add(webhookRetryInterval)
+	if err := q.schedule(d); err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "webhooks",
+		}).Error("Failed to store webhook delivery, it won't be retried: ", err)
+	}
+	q.attempt(d)
+}
+
+// retryDue makes the attempts that are due by now, a few at a time, and
+// returns once they're done.
+func (q *webhookQueue) retryDue(now time.Time) {
+	ids, err := q.store.GetSortedSetRange(webhookQueueKey, "-inf", strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10))
+	if err != nil {
+		return
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	sem := make(chan struct{}, webhookMaxConcurrentRetries)
+	for _, id := range ids {
+		// whoever removes it from the queue makes the attempt
+		if !q.store.RemoveFromSortedSet(webhookQueueKey, id) {
+			continue
+		}
+		d, err := q.load(id)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "webhooks",
+			}).Error("Failed to load webhook delivery ", id, ": ", err)
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			q.attempt(d)
+		}()
+	}
+}
+
+// attempt sends a delivery, and then forgets it, schedules its next
+// attempt or moves it to the dead letters.
+func (q *webhookQueue) attempt(d *WebHookDelivery) {
+	d.Attempts++
+	err := sendWebHook(d)
+	if err == nil {
+		q.store.RemoveFromSortedSet(webhookQueueKey, d.ID)
+		q.store.DeleteRawKey(webhookDeliveryPrefix + d.ID)
+		return
+	}
+	d.LastError = err.Error()
+
+	now := time.Now()
+	backoff := webhookRetryInterval << uint(d.Attempts-1)
+	if backoff > webhookMaxRetryInterval || backoff <= 0 {
+		backoff = webhookMaxRetryInterval
+	}
+	d.NextAttempt = now.Add(backoff)
+	if d.NextAttempt.After(d.RetryUntil) {
+		log.WithFields(logrus.Fields{
+			"prefix": "webhooks",
+			"target": d.URL,
+		}).Error("Webhook delivery ", d.ID, " failed for good after ", d.Attempts, " attempts: ", err)
+		q.deadLetter(d, now)
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"prefix": "webhooks",
+		"target": d.URL,
+	}).Warning("Webhook delivery ", d.ID, " failed, retrying in ", backoff, ": ", err)
+	if err := q.schedule(d); err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "webhooks",
+		}).Error("Failed to store webhook delivery, it won't be retried: ", err)
+	}
+}
+
+func (q *webhookQueue) deadLetter(d *WebHookDelivery, now time.Time) {
+	q.store.RemoveFromSortedSet(webhookQueueKey, d.ID)
+	if err := q.save(d, webhookDeadLetterTTL); err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "webhooks",
+		}).Error("Failed to store webhook dead letter: ", err)
+		return
+	}
+	q.store.AddToSortedSet(webhookDeadLetterKey, d.ID, float64(now.UnixNano()/int64(time.Millisecond)))
+}
+
+// replay takes a dead letter back to the queue, with a new retry window.
+func (q *webhookQueue) replay(id string) (*WebHookDelivery, error) {
+	d, err := q.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if !q.store.RemoveFromSortedSet(webhookDeadLetterKey, id) {
+		return nil, storage.ErrKeyNotFound
+	}
+	now := time.Now()
+	d.Attempts = 0
+	d.RetryUntil = now.Add(time.Duration(d.MaxAge) * time.Second)
+	d.NextAttempt = now
+	if err := q.schedule(d); err != nil {
+		return nil, err
+	}
+	q.start()
+	return d, nil
+}
+
+// list returns the deliveries in the queue, by when they're due, or the
+// dead letters, by when they failed. Those whose details expired are
+// dropped.
+func (q *webhookQueue) list(key string) []*WebHookDelivery {
+	ids, _ := q.store.GetSortedSetRange(key, "-inf", "+inf")
+	deliveries := []*WebHookDelivery{}
+	for _, id := range ids {
+		d, err := q.load(id)
+		if err != nil {
+			q.store.RemoveFromSortedSet(key, id)
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries
+}
+
+func sendWebHook(d *WebHookDelivery) error {
+	req, err := http.NewRequest(d.Method, d.URL, strings.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+	for key, val := range d.Headers {
+		req.Header.Set(key, val)
+	}
+	req.Header.Set("X-Tyk-Delivery-Attempt", strconv.Itoa(d.Attempts))
+
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = webhookDefaultTimeout
+	}
+	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(resp.Body)
+	log.WithFields(logrus.Fields{
+		"prefix": "webhooks",
+	}).Debug(string(content))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook target returned code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// webhookQueueKeys maps the lists in the REST API to their keys.
+var webhookQueueKeys = map[string]string{
+	"queue":      webhookQueueKey,
+	"deadletter": webhookDeadLetterKey,
+}
+
+// webhookHandler lists the deliveries waiting to be retried or the dead
+// letters, gets one, or drops one.
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := webhookQueueKeys[vars["list"]]
+	id := vars["deliveryID"]
+
+	switch r.Method {
+	case "GET":
+		if id == "" {
+			doJSONWrite(w, 200, webhooks.list(key))
+			return
+		}
+		d, err := webhooks.load(id)
+		if err != nil {
+			doJSONWrite(w, 404, apiError("Delivery not found"))
+			return
+		}
+		doJSONWrite(w, 200, d)
+	case "DELETE":
+		if !webhooks.store.RemoveFromSortedSet(key, id) {
+			doJSONWrite(w, 404, apiError("Delivery not found"))
+			return
+		}
+		webhooks.store.DeleteRawKey(webhookDeliveryPrefix + id)
+		doJSONWrite(w, 200, apiOk("removed"))
+	}
+}
+
+// webhookReplayHandler sends a dead letter again, retrying it as if it
+// were new.
+func webhookReplayHandler(w http.ResponseWriter, r *http.Request) {
+	d, err := webhooks.replay(mux.Vars(r)["deliveryID"])
+	if err != nil {
+		doJSONWrite(w, 404, apiError("Delivery not found"))
+		return
+	}
+	doJSONWrite(w, 200, d)
+}